@@ -10,6 +10,8 @@ import (
 type Config struct {
 	DbStorage  string `yaml:"database_connection_string" end-required:"true"`
 	HttpServer `yaml:"http_server"`
+	Auth       `yaml:"auth"`
+	Language   string `yaml:"language" env:"QUESTS_LANGUAGE" env-default:"ru"` // язык сообщений API для запросов без Accept-Language: ru или en
 }
 
 type HttpServer struct {
@@ -18,6 +20,15 @@ type HttpServer struct {
 	IdleTimeout    time.Duration `yaml:"idle_timeout" end-default:"60s"`
 }
 
+// Auth настройки авторизации
+type Auth struct {
+	BasicAuthEnabled bool          `yaml:"basic_auth_enabled" env-default:"true"`  // разрешить BasicAuth для обратной совместимости со скриптами
+	TokenSecret      string        `yaml:"-" env:"QUESTS_TOKEN_SECRET"`            // ключ подписи access токенов, только из переменной окружения
+	AccessTokenTTL   time.Duration `yaml:"access_token_ttl" env-default:"15m"`     // время жизни access токена
+	RefreshTokenTTL  time.Duration `yaml:"refresh_token_ttl" env-default:"720h"`   // время жизни refresh токена
+	RevokedSyncEvery time.Duration `yaml:"revoked_sync_interval" env-default:"1m"` // период обновления списка отозванных токенов из БД
+}
+
 func MustLoad() *Config {
 	//Путь до конфига берет из переменной окружения
 	currentPath, err := os.Getwd()
@@ -20,9 +20,12 @@ const docTemplate = `{
                 "security": [
                     {
                         "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
-                "description": "Устанавливает признак выполнения шага у пользователя",
+                "description": "Устанавливает признак выполнения шагов у пользователей в одной транзакции.\nПо умолчанию (mode=all) при отклонении хотя бы одного шага не записывается ничего, mode=partial записывает все шаги, прошедшие проверки.\nОтвет содержит статус по каждому переданному шагу. Авторизованный пользователь сохраняется в истории как записавший выполнение.\nПовторный запрос с тем же idempotency_key для того же пользователя не создает новую запись и возвращает статус duplicate.",
                 "consumes": [
                     "application/json"
                 ],
@@ -33,20 +36,48 @@ const docTemplate = `{
                 "operationId": "CompleteSteps",
                 "parameters": [
                     {
-                        "description": "обновленная информация о шагах задания",
+                        "description": "шаги, выполненные пользователями",
                         "name": "input",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/storage.NewQuestSteps"
+                            "$ref": "#/definitions/storage.NewCompleteSteps"
                         }
+                    },
+                    {
+                        "enum": [
+                            "all",
+                            "partial"
+                        ],
+                        "type": "string",
+                        "description": "режим выполнения",
+                        "name": "mode",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/storage.NewQuestStep"
+                            "$ref": "#/definitions/history.CompleteStepsResult"
+                        }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "шаги отклонены, изменения не записаны",
+                        "schema": {
+                            "$ref": "#/definitions/history.CompleteStepsResult"
+                        }
+                    },
+                    "422": {
+                        "description": "неверные входные данные, изменения не записаны",
+                        "schema": {
+                            "$ref": "#/definitions/history.CompleteStepsResult"
                         }
                     }
                 }
@@ -57,9 +88,12 @@ const docTemplate = `{
                 "security": [
                     {
                         "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
-                "description": "Создает новое задание",
+                "description": "Создает новое задание. Шаг может требовать выполнения других шагов задания (RequiresSteps - имена шагов),\nзадание - завершения других заданий (RequiresQuests). Цикл в предварительных условиях не допускается",
                 "consumes": [
                     "application/json"
                 ],
@@ -85,6 +119,24 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/storage.NewQuest"
                         }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Задание или шаг с таким именем уже существует или ошибка в предварительных условиях",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
                     }
                 }
             }
@@ -94,9 +146,12 @@ const docTemplate = `{
                 "security": [
                     {
                         "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
-                "description": "Добавляет новые шаги к заданию",
+                "description": "Добавляет новые шаги к заданию. RequiresSteps - имена шагов того же задания, в том числе добавляемых этим запросом,\nкоторые нужно выполнить перед шагом. Цикл в предварительных условиях не допускается",
                 "consumes": [
                     "application/json"
                 ],
@@ -122,6 +177,24 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/storage.NewQuestStep"
                         }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Шаг уже существует, задание не найдено или ошибка в предварительных условиях",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
                     }
                 }
             }
@@ -131,9 +204,12 @@ const docTemplate = `{
                 "security": [
                     {
                         "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
-                "description": "Создает нового пользователя приложения",
+                "description": "Создает нового пользователя приложения. Устарел, используйте POST /users",
                 "consumes": [
                     "application/json"
                 ],
@@ -142,6 +218,7 @@ const docTemplate = `{
                 ],
                 "summary": "Создать пользователя",
                 "operationId": "CreateUser",
+                "deprecated": true,
                 "parameters": [
                     {
                         "description": "Информация о пользователе",
@@ -153,7 +230,20 @@ const docTemplate = `{
                         }
                     }
                 ],
-                "responses": {}
+                "responses": {
+                    "409": {
+                        "description": "Пользователь уже существует",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
             }
         },
         "/DeleteUser": {
@@ -161,9 +251,12 @@ const docTemplate = `{
                 "security": [
                     {
                         "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
-                "description": "Удаляет пользователя приложения",
+                "description": "Удаляет пользователя приложения. Последнего администратора удалить нельзя. Устарел, используйте DELETE /users/{id}",
                 "consumes": [
                     "application/json"
                 ],
@@ -172,6 +265,7 @@ const docTemplate = `{
                 ],
                 "summary": "Удалить пользователя",
                 "operationId": "DeleteUser",
+                "deprecated": true,
                 "parameters": [
                     {
                         "description": "Идентификатор пользователя",
@@ -183,7 +277,20 @@ const docTemplate = `{
                         }
                     }
                 ],
-                "responses": {}
+                "responses": {
+                    "404": {
+                        "description": "Пользователь не найден",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Нельзя удалить последнего администратора",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
             }
         },
         "/GetAllUsers": {
@@ -191,9 +298,12 @@ const docTemplate = `{
                 "security": [
                     {
                         "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
-                "description": "Возвращает всех пользователей приложения",
+                "description": "Возвращает всех пользователей приложения. Устарел, используйте GET /users",
                 "consumes": [
                     "application/json"
                 ],
@@ -202,6 +312,7 @@ const docTemplate = `{
                 ],
                 "summary": "получить пользователей",
                 "operationId": "GetAllUsers",
+                "deprecated": true,
                 "responses": {
                     "200": {
                         "description": "ok",
@@ -217,23 +328,88 @@ const docTemplate = `{
                 "security": [
                     {
                         "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
-                "description": "Создает новое задание",
+                "description": "Возвращает страницу заданий, в которых участвовал пользователь, с записями о выполнении шагов и его общий бонусный счет.\nПараметры from и to ограничивают учитываемые выполнения, общий бонусный счет - текущий баланс по журналу бонусов.\nВместо userid можно передать teamid: тогда возвращается совместная история участников команды - выполнения и бонусы,\nполученные в ее составе, а общий бонусный счет - сумма бонусов, заработанных командой\nБонусы берутся из журнала: за шаг начисляется бонус, действовавший в момент выполнения, с учетом отмен начислений.\nБонус за завершение задания начисляется один раз, когда выполнены все действующие шаги задания.\nBadges - значки, выданные пользователю",
                 "consumes": [
                     "application/json"
                 ],
                 "tags": [
                     "history"
                 ],
-                "summary": "Обновить шаг к заданию",
+                "summary": "История пользователя",
                 "operationId": "GetHistory",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор пользователя",
+                        "name": "userid",
+                        "in": "header"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "идентификатор команды, если userid не указан",
+                        "name": "teamid",
+                        "in": "header"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "кол-во заданий на странице, по умолчанию 50, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "курсор следующей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "-id",
+                            "name",
+                            "-name"
+                        ],
+                        "type": "string",
+                        "description": "сортировка заданий, '-' в начале - по убыванию",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "имя задания содержит строку",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "выполнения не раньше (2006-01-02 или RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "выполнения раньше (2006-01-02 или RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/history.UserBonus"
                         }
+                    },
+                    "400": {
+                        "description": "userid или teamid не указан или не является целым числом больше 0",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
                     }
                 }
             }
@@ -243,22 +419,185 @@ const docTemplate = `{
                 "security": [
                     {
                         "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
-                "description": "Создает новое задание",
+                "description": "Возвращает страницу заданий с шагами. Архивные задания и шаги возвращаются только с параметром archived=true.\nactive=true возвращает только задания, окно проведения которых включает текущий момент.\nДля получения следующей страницы передайте next_cursor из ответа в параметре cursor, остальные параметры не меняйте",
                 "consumes": [
                     "application/json"
                 ],
                 "tags": [
                     "quests"
                 ],
-                "summary": "Обновить шаг к заданию",
+                "summary": "Получить задания",
                 "operationId": "GetQuests",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "кол-во заданий на странице, по умолчанию 50, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "курсор следующей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "-id",
+                            "name",
+                            "-name",
+                            "created",
+                            "-created"
+                        ],
+                        "type": "string",
+                        "description": "сортировка, '-' в начале - по убыванию",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "имя задания содержит строку",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "есть (true) или нет (false) шагов с многократным выполнением",
+                        "name": "multi",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "включить архивные задания и шаги",
+                        "name": "archived",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "только задания, которые проводятся сейчас (true) или не проводятся (false)",
+                        "name": "active",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "создано не раньше (2006-01-02 или RFC3339)",
+                        "name": "created_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "создано раньше (2006-01-02 или RFC3339)",
+                        "name": "created_to",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/quest.Quests"
+                            "$ref": "#/definitions/storage.Page-quest_Quests"
+                        }
+                    },
+                    "400": {
+                        "description": "неверные параметры запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/GetRoles": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает все роли и их разрешения",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "roles"
+                ],
+                "summary": "Получить роли",
+                "operationId": "GetRoles",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/storage.Role"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/SetUserRoles": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Заменяет роли пользователя переданным списком. Роль admin также устанавливает признак userIsAdmin.\nРоль admin нельзя забрать у последнего администратора.\nВыданные пользователю access токены отзываются, новые роли действуют после входа или обновления токена",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "roles"
+                ],
+                "summary": "Назначить роли пользователю",
+                "operationId": "SetUserRoles",
+                "parameters": [
+                    {
+                        "description": "пользователь и его роли",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/role.SetUserRolesRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "пользователь не найден",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "у пользователя забирается роль последнего администратора",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "роль не существует",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
                         }
                     }
                 }
@@ -269,9 +608,12 @@ const docTemplate = `{
                 "security": [
                     {
                         "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
                     }
                 ],
-                "description": "Создает новое задание",
+                "description": "Обновляет бонус и правила выполнения шагов. Значение 0 в MaxCompletions, CooldownSeconds и GlobalLimit снимает ограничение.\nАрхивный шаг и шаг архивного задания изменить нельзя.\nШаги обновляются в одной транзакции: если хотя бы один шаг не прошел проверку, не найден или в архиве, изменения не записываются",
                 "consumes": [
                     "application/json"
                 ],
@@ -287,7 +629,7 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/storage.NewQuestSteps"
+                            "$ref": "#/definitions/storage.UpdateQuestSteps"
                         }
                     }
                 ],
@@ -297,110 +639,3550 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/storage.NewQuestStep"
                         }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "шаг не найден, изменения не записаны",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "шаг или его задание в архиве, изменения не записаны",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "history.UserBonus": {
-            "description": "UserBonus json для получения история выполнения заданий и их шагов",
-            "type": "object",
-            "properties": {
-                "ComplitedQuests": {
-                    "description": "Список заданий в которых участвовал пользователь",
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/history.UserCompletedQuest"
-                    }
-                },
-                "TotalBonus": {
-                    "description": "Общий бонусный счет пользователя",
-                    "type": "integer"
-                }
-            }
         },
-        "history.UserCompletedQuest": {
-            "type": "object",
-            "properties": {
-                "AllStepsCount": {
-                    "description": "Кол-во шагов, доступное в задании",
-                    "type": "integer"
-                },
-                "Bonus": {
-                    "description": "Сумма Бонусов за выполненные задания",
-                    "type": "integer"
-                },
-                "CompletedSteps": {
-                    "description": "Выполненные шаги пользователем",
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/history.UserCompletedSteps"
+        "/auth/login": {
+            "post": {
+                "description": "Обменивает логин и пароль на короткоживущий access токен и refresh токен",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Вход",
+                "operationId": "Login",
+                "parameters": [
+                    {
+                        "description": "логин и пароль",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/auth.LoginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/auth.TokenPair"
+                        }
+                    },
+                    "401": {
+                        "description": "неверный логин/пароль",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
                     }
-                },
-                "CompletedStepsCount": {
-                    "description": "Кол-во выполненных шагов заданий пользователем",
-                    "type": "integer"
-                },
-                "QuestId": {
-                    "description": "ИД задания",
-                    "type": "string"
-                },
-                "QuestName": {
-                    "description": "Имя выполненного задания пользователем",
-                    "type": "string"
                 }
             }
         },
-        "history.UserCompletedSteps": {
-            "type": "object",
-            "properties": {
-                "Count": {
-                    "description": "Кол-во выполнений шага",
-                    "type": "integer"
-                },
-                "StepName": {
-                    "description": "Имя выполненного шага",
-                    "type": "string"
-                },
-                "UserBonusStep": {
-                    "description": "Бонус пользователя за выполнение шага",
-                    "type": "integer"
+        "/auth/logout": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Отзывает access токен из заголовка Authorization и переданный refresh токен",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Выход",
+                "operationId": "Logout",
+                "parameters": [
+                    {
+                        "description": "refresh токен",
+                        "name": "input",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/auth.RefreshRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "токен недействителен",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
                 }
             }
         },
-        "quest.Quests": {
-            "description": "Quests json информация о заданиях и их шагов",
-            "type": "object",
-            "properties": {
-                "Id": {
-                    "description": "ИД задания",
-                    "type": "string"
-                },
-                "QuestName": {
+        "/auth/refresh": {
+            "post": {
+                "description": "Обменивает refresh токен на новую пару токенов. Использованный refresh токен становится недействительным",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Обновить токены",
+                "operationId": "Refresh",
+                "parameters": [
+                    {
+                        "description": "refresh токен",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/auth.RefreshRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/auth.TokenPair"
+                        }
+                    },
+                    "401": {
+                        "description": "refresh токен недействителен",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/badges": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает страницу значков с правилами выдачи и кол-вом получивших их пользователей.\nАрхивные значки показываются только с archived=true",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "badges"
+                ],
+                "summary": "Список значков",
+                "operationId": "ListBadges",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "кол-во значков на странице, по умолчанию 50, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "курсор следующей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "-id",
+                            "name",
+                            "-name"
+                        ],
+                        "type": "string",
+                        "description": "сортировка, '-' в начале - по убыванию",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "включить архивные значки",
+                        "name": "archived",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Page-badge_Badge"
+                        }
+                    },
+                    "400": {
+                        "description": "неверные параметры запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Создает значок с правилом выдачи. Значок выдается при следующем выполнении шага пользователем, для которого выполнено правило.\nПроверить правило по существующей истории можно методом DryRunBadge",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "badges"
+                ],
+                "summary": "Создать значок",
+                "operationId": "CreateBadge",
+                "parameters": [
+                    {
+                        "description": "значок",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/badge.NewBadge"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/badge.Badge"
+                        }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "задание или шаг не найдены",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "значок с таким названием существует",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/badges/dry-run": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Проверяет правило значка по существующей истории и возвращает пользователей, которые получили бы значок.\nЗначок не создается и не выдается, название в запросе не обязательно",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "badges"
+                ],
+                "summary": "Пробная проверка правила",
+                "operationId": "DryRunBadge",
+                "parameters": [
+                    {
+                        "description": "правило значка",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/badge.NewBadge"
+                        }
+                    },
+                    {
+                        "type": "integer",
+                        "description": "кол-во пользователей в ответе, по умолчанию 50, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/badge.BadgeDryRun"
+                        }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/badges/{id}/archive": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Прекращает выдачу значка. Пользователи, получившие значок, сохраняют его",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "badges"
+                ],
+                "summary": "Архивировать значок",
+                "operationId": "ArchiveBadge",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор значка",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/badge.Badge"
+                        }
+                    },
+                    "404": {
+                        "description": "значок не найден",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/leaderboard": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает первые места рейтинга пользователей по бонусам за шаги и завершение заданий и место авторизованного пользователя.\nРейтинг за неделю и месяц строится по периоду, содержащему момент at (по умолчанию - текущий). Списания бонусов не уменьшают очки",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "leaderboard"
+                ],
+                "summary": "Общий рейтинг",
+                "operationId": "GetLeaderboard",
+                "parameters": [
+                    {
+                        "enum": [
+                            "all",
+                            "week",
+                            "month"
+                        ],
+                        "type": "string",
+                        "description": "период, по умолчанию all",
+                        "name": "period",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "момент внутри периода (2006-01-02 или RFC3339)",
+                        "name": "at",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "кол-во мест, по умолчанию 10, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/leaderboard.Leaderboard"
+                        }
+                    },
+                    "400": {
+                        "description": "неверные параметры запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/leaderboard/teams": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает первые места рейтинга команд по бонусам, заработанным участниками в составе команды, и место команды\nавторизованного пользователя. С quest_id - рейтинг по бонусам задания. Остальные параметры такие же, как в GetLeaderboard",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "leaderboard"
+                ],
+                "summary": "Рейтинг команд",
+                "operationId": "GetTeamLeaderboard",
+                "parameters": [
+                    {
+                        "enum": [
+                            "all",
+                            "week",
+                            "month"
+                        ],
+                        "type": "string",
+                        "description": "период, по умолчанию all",
+                        "name": "period",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "момент внутри периода (2006-01-02 или RFC3339)",
+                        "name": "at",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "кол-во мест, по умолчанию 10, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "идентификатор задания",
+                        "name": "quest_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/leaderboard.TeamLeaderboard"
+                        }
+                    },
+                    "400": {
+                        "description": "неверные параметры запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/ledger/{id}/reverse": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Добавляет запись reversal с противоположной суммой. Исходная запись не меняется.\nКаждую запись можно отменить один раз, отмену отменить нельзя. Отмена начисления может сделать баланс отрицательным.\nСписание за награду (redemption) отменить нельзя, бонусы за него возвращает отмена заявки",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bonus"
+                ],
+                "summary": "Отменить запись журнала бонусов",
+                "operationId": "ReverseLedgerEntry",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор записи журнала",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "причина отмены",
+                        "name": "input",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/bonus.ReverseRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/storage.LedgerEntry"
+                        }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "запись не найдена",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "запись уже отменена, сама является отменой или списанием за награду",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/me": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает информацию об авторизованном пользователе",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "summary": "Мой профиль",
+                "operationId": "GetMe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/users.User"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/CompleteSteps": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Отмечает выполнение шагов авторизованным пользователем. Поле userid в запросе игнорируется.\nРежимы mode=all и mode=partial работают так же, как в CompleteSteps",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "summary": "Выполнить шаг от своего имени",
+                "operationId": "CompleteMySteps",
+                "parameters": [
+                    {
+                        "description": "выполненные шаги",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/storage.NewCompleteSteps"
+                        }
+                    },
+                    {
+                        "enum": [
+                            "all",
+                            "partial"
+                        ],
+                        "type": "string",
+                        "description": "режим выполнения",
+                        "name": "mode",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/history.CompleteStepsResult"
+                        }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "шаги отклонены, изменения не записаны",
+                        "schema": {
+                            "$ref": "#/definitions/history.CompleteStepsResult"
+                        }
+                    },
+                    "422": {
+                        "description": "неверные входные данные, изменения не записаны",
+                        "schema": {
+                            "$ref": "#/definitions/history.CompleteStepsResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/badges": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает значки авторизованного пользователя в порядке выдачи",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "summary": "Мои значки",
+                "operationId": "GetMyBadges",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/storage.UserBadge"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/me/balance": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает баланс авторизованного пользователя по журналу бонусов",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "summary": "Мой бонусный баланс",
+                "operationId": "GetMyBalance",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/bonus.UserBalance"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/history": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает историю выполнения заданий и бонусный счет авторизованного пользователя. Параметры такие же, как в GetHistory",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "summary": "Моя история",
+                "operationId": "GetMyHistory",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "кол-во заданий на странице, по умолчанию 50, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "курсор следующей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "-id",
+                            "name",
+                            "-name"
+                        ],
+                        "type": "string",
+                        "description": "сортировка заданий, '-' в начале - по убыванию",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "имя задания содержит строку",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "выполнения не раньше (2006-01-02 или RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "выполнения раньше (2006-01-02 или RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/history.UserBonus"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/ledger": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает страницу записей журнала бонусов авторизованного пользователя. Параметры такие же, как в GetUserLedger",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "summary": "Мой журнал бонусов",
+                "operationId": "GetMyLedger",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "кол-во записей на странице, по умолчанию 50, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "курсор следующей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "-id"
+                        ],
+                        "type": "string",
+                        "description": "сортировка, '-' в начале - по убыванию",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "step",
+                            "quest",
+                            "redemption",
+                            "adjustment",
+                            "reversal"
+                        ],
+                        "type": "string",
+                        "description": "вид записи",
+                        "name": "kind",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Page-storage_LedgerEntry"
+                        }
+                    },
+                    "400": {
+                        "description": "неверные параметры запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/quests": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает страницу заданий, которые проводятся сейчас и в которых у авторизованного пользователя есть шаги, доступные для выполнения.\nШаг доступен, если не исчерпаны ограничения числа выполнений (у периодического задания - за текущий период)\nи общее ограничение, после предыдущего выполнения прошел CooldownSeconds и выполнены предварительные условия",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "summary": "Доступные задания",
+                "operationId": "GetMyQuests",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "кол-во заданий на странице, по умолчанию 50, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "курсор следующей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "-id",
+                            "name",
+                            "-name",
+                            "created",
+                            "-created"
+                        ],
+                        "type": "string",
+                        "description": "сортировка, '-' в начале - по убыванию",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "имя задания содержит строку",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "есть (true) или нет (false) шагов с многократным выполнением",
+                        "name": "multi",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "создано не раньше (2006-01-02 или RFC3339)",
+                        "name": "created_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "создано раньше (2006-01-02 или RFC3339)",
+                        "name": "created_to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Page-quest_AvailableQuest"
+                        }
+                    },
+                    "400": {
+                        "description": "неверные параметры запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/me/redemptions": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает страницу заявок авторизованного пользователя. Параметры такие же, как в ListRedemptions, кроме user_id",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "me"
+                ],
+                "summary": "Мои заявки на награды",
+                "operationId": "GetMyRedemptions",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "кол-во заявок на странице, по умолчанию 50, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "курсор следующей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "-id"
+                        ],
+                        "type": "string",
+                        "description": "сортировка, '-' в начале - по убыванию",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "pending",
+                            "fulfilled",
+                            "cancelled"
+                        ],
+                        "type": "string",
+                        "description": "статус заявки",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Page-reward_Redemption"
+                        }
+                    },
+                    "400": {
+                        "description": "неверные параметры запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/quests/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Удаляет задание вместе с шагами. Задание, по шагам которого есть история выполнения, удалить нельзя - его нужно архивировать",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "quests"
+                ],
+                "summary": "Удалить задание",
+                "operationId": "DeleteQuest",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор задания",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "задание не найдено",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "по заданию есть история выполнения",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Меняет имя, описание, время проведения, периодичность и бонус за завершение задания. Архивное задание изменить нельзя.\nStartsAt и EndsAt со значением null снимают ограничение по времени.\nRequiresQuests заменяет список заданий, которые нужно завершить перед выполнением шагов; цикл не допускается",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "quests"
+                ],
+                "summary": "Изменить задание",
+                "operationId": "UpdateQuest",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор задания",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "изменяемые поля",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/quest.UpdateQuestRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/quest.Quests"
+                        }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса или время проведения",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "задание не найдено",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "имя занято, задание в архиве или ошибка в предварительных условиях",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/quests/{id}/archive": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Переводит задание в архив. Шаги архивного задания нельзя выполнить, история и начисленные бонусы сохраняются",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "quests"
+                ],
+                "summary": "Архивировать задание",
+                "operationId": "ArchiveQuest",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор задания",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/quest.Quests"
+                        }
+                    },
+                    "404": {
+                        "description": "задание не найдено",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/quests/{id}/leaderboard": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает рейтинг по бонусам, заработанным в задании. Параметры такие же, как в GetLeaderboard",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "leaderboard"
+                ],
+                "summary": "Рейтинг задания",
+                "operationId": "GetQuestLeaderboard",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор задания",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "all",
+                            "week",
+                            "month"
+                        ],
+                        "type": "string",
+                        "description": "период, по умолчанию all",
+                        "name": "period",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "момент внутри периода (2006-01-02 или RFC3339)",
+                        "name": "at",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "кол-во мест, по умолчанию 10, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/leaderboard.Leaderboard"
+                        }
+                    },
+                    "400": {
+                        "description": "неверные параметры запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "задание не найдено",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/redemptions": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает страницу заявок всех пользователей",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rewards"
+                ],
+                "summary": "Заявки на награды",
+                "operationId": "ListRedemptions",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "кол-во заявок на странице, по умолчанию 50, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "курсор следующей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "-id"
+                        ],
+                        "type": "string",
+                        "description": "сортировка, '-' в начале - по убыванию",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "pending",
+                            "fulfilled",
+                            "cancelled"
+                        ],
+                        "type": "string",
+                        "description": "статус заявки",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "идентификатор пользователя",
+                        "name": "user_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Page-reward_Redemption"
+                        }
+                    },
+                    "400": {
+                        "description": "неверные параметры запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/redemptions/{id}/cancel": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Отменяет заявку в статусе pending: бонусы возвращаются записью журнала, отменяющей списание, остаток награды увеличивается.\nПользователь может отменить свою заявку, заявки других пользователей отменяет пользователь с разрешением rewards.manage",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rewards"
+                ],
+                "summary": "Отменить заявку",
+                "operationId": "CancelRedemption",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор заявки",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "причина отмены",
+                        "name": "input",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/reward.ResolveRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/reward.Redemption"
+                        }
+                    },
+                    "404": {
+                        "description": "заявка не найдена",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "заявка уже выполнена или отменена",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/redemptions/{id}/fulfill": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Отмечает, что награда выдана. Выполнить можно только заявку в статусе pending",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rewards"
+                ],
+                "summary": "Выполнить заявку",
+                "operationId": "FulfillRedemption",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор заявки",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "комментарий",
+                        "name": "input",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/reward.ResolveRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/reward.Redemption"
+                        }
+                    },
+                    "404": {
+                        "description": "заявка не найдена",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "заявка уже выполнена или отменена",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/rewards": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает страницу каталога наград. Архивные награды показываются только с archived=true",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rewards"
+                ],
+                "summary": "Каталог наград",
+                "operationId": "ListRewards",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "кол-во наград на странице, по умолчанию 50, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "курсор следующей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "-id",
+                            "name",
+                            "-name",
+                            "cost",
+                            "-cost"
+                        ],
+                        "type": "string",
+                        "description": "сортировка, '-' в начале - по убыванию",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "название содержит строку",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "только награды в наличии (true) или только закончившиеся (false)",
+                        "name": "available",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "включить архивные награды",
+                        "name": "archived",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Page-reward_Reward"
+                        }
+                    },
+                    "400": {
+                        "description": "неверные параметры запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Добавляет награду в каталог",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rewards"
+                ],
+                "summary": "Создать награду",
+                "operationId": "CreateReward",
+                "parameters": [
+                    {
+                        "description": "награда",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/reward.NewReward"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/reward.Reward"
+                        }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "награда с таким названием существует",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/rewards/{id}": {
+            "patch": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Изменяет название, описание, стоимость или остаток награды. Архивную награду изменить нельзя",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rewards"
+                ],
+                "summary": "Изменить награду",
+                "operationId": "UpdateReward",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор награды",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "изменяемые поля",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/reward.UpdateRewardRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/reward.Reward"
+                        }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "награда не найдена",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "награда в архиве или с таким названием существует",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/rewards/{id}/archive": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Убирает награду из каталога. Созданные заявки на нее можно выполнить или отменить",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rewards"
+                ],
+                "summary": "Архивировать награду",
+                "operationId": "ArchiveReward",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор награды",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/reward.Reward"
+                        }
+                    },
+                    "404": {
+                        "description": "награда не найдена",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/rewards/{id}/redeem": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Создает заявку на награду от имени авторизованного пользователя в статусе pending.\nСтоимость награды списывается с бонусного счета, остаток награды уменьшается на 1",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rewards"
+                ],
+                "summary": "Получить награду",
+                "operationId": "RedeemReward",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор награды",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/reward.Redemption"
+                        }
+                    },
+                    "404": {
+                        "description": "награда не найдена",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "награда в архиве, закончилась или недостаточно бонусов",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/steps/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Удаляет шаг. Шаг, по которому есть история выполнения, удалить нельзя - его нужно архивировать",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "quests"
+                ],
+                "summary": "Удалить шаг",
+                "operationId": "DeleteStep",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор шага",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "шаг не найден",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "по шагу есть история выполнения",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Меняет имя, бонус и правила выполнения шага. Архивный шаг и шаг архивного задания изменить нельзя.\nЗначение 0 в MaxCompletions, CooldownSeconds и GlobalLimit снимает ограничение.\nRequiresSteps заменяет список шагов, которые нужно выполнить перед этим шагом; цикл в предварительных условиях не допускается",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "quests"
+                ],
+                "summary": "Изменить шаг",
+                "operationId": "UpdateStep",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор шага",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "изменяемые поля",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/quest.UpdateStepRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/quest.Steps"
+                        }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "шаг не найден",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "имя занято, шаг в архиве или ошибка в предварительных условиях",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/steps/{id}/archive": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Переводит шаг в архив. Архивный шаг нельзя выполнить, история и начисленные бонусы сохраняются",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "quests"
+                ],
+                "summary": "Архивировать шаг",
+                "operationId": "ArchiveStep",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор шага",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/quest.Steps"
+                        }
+                    },
+                    "404": {
+                        "description": "шаг не найден",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/teams": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает страницу команд с кол-вом участников и суммой бонусов, заработанных в составе команды",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "teams"
+                ],
+                "summary": "Список команд",
+                "operationId": "ListTeams",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "кол-во команд на странице, по умолчанию 50, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "курсор следующей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "-id",
+                            "name",
+                            "-name"
+                        ],
+                        "type": "string",
+                        "description": "сортировка, '-' в начале - по убыванию",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "название содержит строку",
+                        "name": "name",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Page-team_Team"
+                        }
+                    },
+                    "400": {
+                        "description": "неверные параметры запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Создает команду без участников",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "teams"
+                ],
+                "summary": "Создать команду",
+                "operationId": "CreateTeam",
+                "parameters": [
+                    {
+                        "description": "команда",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/team.NewTeam"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/team.TeamDetails"
+                        }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "команда с таким названием существует",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/teams/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает команду с участниками и суммой бонусов, заработанных в ее составе",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "teams"
+                ],
+                "summary": "Получить команду",
+                "operationId": "GetTeam",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор команды",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/team.TeamDetails"
+                        }
+                    },
+                    "404": {
+                        "description": "команда не найдена",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Удаляет команду и ее состав. Команду, участники которой выполняли шаги в ее составе, удалить нельзя",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "teams"
+                ],
+                "summary": "Удалить команду",
+                "operationId": "DeleteTeam",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор команды",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "команда не найдена",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "у команды есть история выполнения заданий",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/teams/{id}/members": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Добавляет пользователя в команду. Пользователь может состоять только в одной команде.\nВыполнения до вступления в команду ей не засчитываются",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "teams"
+                ],
+                "summary": "Добавить участника",
+                "operationId": "AddTeamMember",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор команды",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "участник",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/team.AddMemberRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/team.TeamDetails"
+                        }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "команда или пользователь не найдены",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "пользователь уже состоит в команде",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/teams/{id}/members/{user_id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Исключает пользователя из команды. Выполнения и бонусы, полученные в составе команды, остаются за ней",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "teams"
+                ],
+                "summary": "Исключить участника",
+                "operationId": "RemoveTeamMember",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор команды",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "идентификатор пользователя",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/team.TeamDetails"
+                        }
+                    },
+                    "404": {
+                        "description": "команда не найдена или пользователь не состоит в ней",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/users": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает страницу пользователей приложения.\nДля получения следующей страницы передайте next_cursor из ответа в параметре cursor, остальные параметры не меняйте",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Список пользователей",
+                "operationId": "ListUsers",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "кол-во пользователей на странице, по умолчанию 50, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "курсор следующей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "-id",
+                            "name",
+                            "-name",
+                            "created",
+                            "-created"
+                        ],
+                        "type": "string",
+                        "description": "сортировка, '-' в начале - по убыванию",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "имя пользователя содержит строку",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "только администраторы (true) или только не администраторы (false)",
+                        "name": "admin",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "создан не раньше (2006-01-02 или RFC3339)",
+                        "name": "created_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "создан раньше (2006-01-02 или RFC3339)",
+                        "name": "created_to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Page-users_User"
+                        }
+                    },
+                    "400": {
+                        "description": "неверные параметры запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Создает пользователя. userIsAdmin=true назначает роль admin, остальные получают роль player",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Создать пользователя",
+                "operationId": "PostUser",
+                "parameters": [
+                    {
+                        "description": "Информация о пользователе",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.User"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/users.User"
+                        }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "пользователь уже существует",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает пользователя по идентификатору",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Получить пользователя",
+                "operationId": "GetUserById",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор пользователя",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/users.User"
+                        }
+                    },
+                    "404": {
+                        "description": "пользователь не найден",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Удаляет пользователя. Последнего администратора удалить нельзя",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Удалить пользователя",
+                "operationId": "RemoveUser",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор пользователя",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "пользователь не найден",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "нельзя удалить последнего администратора",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Меняет имя пользователя и/или выдает и забирает роль admin. Роль admin нельзя забрать у последнего администратора",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Изменить пользователя",
+                "operationId": "PatchUser",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор пользователя",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "изменяемые поля",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.UpdateUserRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/users.User"
+                        }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "пользователь не найден",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "имя занято или пользователь последний администратор",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/badges": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает значки, выданные пользователю, в порядке выдачи",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "badges"
+                ],
+                "summary": "Значки пользователя",
+                "operationId": "GetUserBadges",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор пользователя",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/storage.UserBadge"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "пользователь не найден",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/balance": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает баланс пользователя по журналу бонусов",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bonus"
+                ],
+                "summary": "Бонусный баланс пользователя",
+                "operationId": "GetUserBalance",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор пользователя",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/bonus.UserBalance"
+                        }
+                    },
+                    "404": {
+                        "description": "пользователь не найден",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/ledger": {
+            "get": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Возвращает страницу записей журнала бонусов пользователя: начисления, списания, корректировки и отмены",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bonus"
+                ],
+                "summary": "Журнал бонусов пользователя",
+                "operationId": "GetUserLedger",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор пользователя",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "кол-во записей на странице, по умолчанию 50, не больше 200",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "курсор следующей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "-id"
+                        ],
+                        "type": "string",
+                        "description": "сортировка, '-' в начале - по убыванию",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "step",
+                            "quest",
+                            "redemption",
+                            "adjustment",
+                            "reversal"
+                        ],
+                        "type": "string",
+                        "description": "вид записи",
+                        "name": "kind",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/storage.Page-storage_LedgerEntry"
+                        }
+                    },
+                    "400": {
+                        "description": "неверные параметры запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "пользователь не найден",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Добавляет в журнал ручное начисление (amount больше 0) или списание (amount меньше 0).\nСписание не может сделать баланс отрицательным",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bonus"
+                ],
+                "summary": "Корректировка баланса",
+                "operationId": "AdjustBalance",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор пользователя",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "сумма и причина корректировки",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/bonus.AdjustmentRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/storage.LedgerEntry"
+                        }
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "пользователь не найден",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "недостаточно бонусов для списания",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/password": {
+            "post": {
+                "security": [
+                    {
+                        "BasicAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Задает пользователю новый пароль и отзывает его refresh токены.\nПользователь без разрешения users.write может сменить только свой пароль, указав текущий",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Сменить пароль",
+                "operationId": "ChangePassword",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "идентификатор пользователя",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "новый пароль",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.ChangePasswordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "неверный формат запроса",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "недостаточно прав или неверный текущий пароль",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "пользователь не найден",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "входные данные не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/response.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "auth.LoginRequest": {
+            "description": "LoginRequest логин и пароль пользователя",
+            "type": "object",
+            "properties": {
+                "password": {
+                    "description": "Пароль пользователя",
+                    "type": "string"
+                },
+                "username": {
+                    "description": "Имя пользователя",
+                    "type": "string"
+                }
+            }
+        },
+        "auth.RefreshRequest": {
+            "description": "RefreshRequest refresh токен",
+            "type": "object",
+            "properties": {
+                "refresh_token": {
+                    "description": "Refresh токен, полученный при входе или предыдущем обновлении",
+                    "type": "string"
+                }
+            }
+        },
+        "auth.TokenPair": {
+            "description": "TokenPair access и refresh токены",
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "description": "Подписанный access токен, передается в заголовке Authorization: Bearer \u003ctoken\u003e",
+                    "type": "string"
+                },
+                "expires_in": {
+                    "description": "Время жизни access токена в секундах",
+                    "type": "integer"
+                },
+                "refresh_token": {
+                    "description": "Токен для получения новой пары токенов",
+                    "type": "string"
+                },
+                "token_type": {
+                    "description": "Тип токена, всегда Bearer",
+                    "type": "string"
+                }
+            }
+        },
+        "badge.Badge": {
+            "description": "Badge значок и правило его выдачи",
+            "type": "object",
+            "properties": {
+                "archived_at": {
+                    "description": "Время архивирования, пусто для действующего значка",
+                    "type": "string"
+                },
+                "awarded_count": {
+                    "description": "Кол-во пользователей, получивших значок",
+                    "type": "integer"
+                },
+                "created_at": {
+                    "description": "Время создания",
+                    "type": "string"
+                },
+                "description": {
+                    "description": "Описание",
+                    "type": "string"
+                },
+                "id": {
+                    "description": "Идентификатор значка",
+                    "type": "integer"
+                },
+                "name": {
+                    "description": "Название",
+                    "type": "string"
+                },
+                "quest_id": {
+                    "description": "Задание для first_to_finish",
+                    "type": "integer"
+                },
+                "rule_type": {
+                    "description": "Тип правила: quests_completed, step_completions или first_to_finish",
+                    "type": "string"
+                },
+                "step_id": {
+                    "description": "Шаг для step_completions, пусто - любые шаги",
+                    "type": "integer"
+                },
+                "threshold": {
+                    "description": "Порог для quests_completed и step_completions",
+                    "type": "integer"
+                }
+            }
+        },
+        "badge.BadgeCandidate": {
+            "type": "object",
+            "properties": {
+                "user_id": {
+                    "description": "Идентификатор пользователя",
+                    "type": "integer"
+                },
+                "username": {
+                    "description": "Имя пользователя",
+                    "type": "string"
+                }
+            }
+        },
+        "badge.BadgeDryRun": {
+            "description": "BadgeDryRun результат пробной проверки правила по существующей истории",
+            "type": "object",
+            "properties": {
+                "matched_count": {
+                    "description": "Кол-во пользователей, для которых выполнено правило",
+                    "type": "integer"
+                },
+                "users": {
+                    "description": "Первые пользователи, для которых выполнено правило",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/badge.BadgeCandidate"
+                    }
+                }
+            }
+        },
+        "badge.NewBadge": {
+            "description": "NewBadge json для создания значка или пробной проверки правила",
+            "type": "object",
+            "properties": {
+                "description": {
+                    "description": "Описание",
+                    "type": "string"
+                },
+                "name": {
+                    "description": "Название, уникальное среди значков",
+                    "type": "string"
+                },
+                "quest_id": {
+                    "description": "Задание, обязательно для first_to_finish",
+                    "type": "integer"
+                },
+                "rule_type": {
+                    "description": "Тип правила: quests_completed, step_completions или first_to_finish",
+                    "type": "string"
+                },
+                "step_id": {
+                    "description": "Шаг для step_completions, не указан - любые шаги",
+                    "type": "integer"
+                },
+                "threshold": {
+                    "description": "Порог для quests_completed и step_completions, больше 0",
+                    "type": "integer"
+                }
+            }
+        },
+        "bonus.AdjustmentRequest": {
+            "description": "AdjustmentRequest json для ручной корректировки баланса",
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "description": "Сумма: больше 0 - начисление, меньше 0 - списание",
+                    "type": "integer"
+                },
+                "comment": {
+                    "description": "Причина корректировки, обязательна",
+                    "type": "string"
+                }
+            }
+        },
+        "bonus.ReverseRequest": {
+            "description": "ReverseRequest json для отмены записи журнала бонусов",
+            "type": "object",
+            "properties": {
+                "comment": {
+                    "description": "Причина отмены",
+                    "type": "string"
+                }
+            }
+        },
+        "bonus.UserBalance": {
+            "description": "UserBalance бонусный баланс пользователя",
+            "type": "object",
+            "properties": {
+                "balance": {
+                    "description": "Баланс: сумма начислений за вычетом списаний",
+                    "type": "integer"
+                },
+                "user_id": {
+                    "description": "Идентификатор пользователя",
+                    "type": "integer"
+                }
+            }
+        },
+        "history.CompleteStepResult": {
+            "type": "object",
+            "properties": {
+                "badges": {
+                    "description": "Значки, выданные пользователю за это выполнение",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/storage.UserBadge"
+                    }
+                },
+                "errors": {
+                    "description": "Ошибки валидации",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/response.FieldError"
+                    }
+                },
+                "quest_completed": {
+                    "description": "Выполнение шага завершило задание, бонус за завершение начислен",
+                    "type": "boolean"
+                },
+                "status": {
+                    "description": "Статус обработки шага",
+                    "type": "string"
+                },
+                "stepid": {
+                    "description": "Идентификатор шага",
+                    "type": "integer"
+                },
+                "userid": {
+                    "description": "Идентификатор пользователя",
+                    "type": "integer"
+                }
+            }
+        },
+        "history.CompleteStepsResult": {
+            "description": "CompleteStepsResult результат выполнения шагов с информацией по каждому шагу",
+            "type": "object",
+            "properties": {
+                "committed": {
+                    "description": "Признак того, что изменения записаны в БД",
+                    "type": "boolean"
+                },
+                "error": {
+                    "description": "Ошибка, если изменения не записаны. Подробности - в items",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/response.ErrorBody"
+                        }
+                    ]
+                },
+                "items": {
+                    "description": "Результат по каждому переданному шагу в порядке запроса",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/history.CompleteStepResult"
+                    }
+                },
+                "mode": {
+                    "description": "Режим выполнения: all или partial",
+                    "type": "string"
+                }
+            }
+        },
+        "history.HistoryRecord": {
+            "type": "object",
+            "properties": {
+                "CompletedAt": {
+                    "description": "Время выполнения",
+                    "type": "string"
+                },
+                "Id": {
+                    "description": "Идентификатор записи",
+                    "type": "integer"
+                },
+                "IdempotencyKey": {
+                    "description": "Ключ идемпотентности",
+                    "type": "string"
+                },
+                "RecordedBy": {
+                    "description": "Пользователь, записавший выполнение, пусто для записей без автора",
+                    "type": "integer"
+                },
+                "Source": {
+                    "description": "Источник отметки о выполнении",
+                    "type": "string"
+                }
+            }
+        },
+        "history.UserBonus": {
+            "description": "UserBonus json для получения история выполнения заданий и их шагов",
+            "type": "object",
+            "properties": {
+                "Badges": {
+                    "description": "Значки пользователя, отсутствуют в истории команды",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/storage.UserBadge"
+                    }
+                },
+                "ComplitedQuests": {
+                    "description": "Страница списка заданий в которых участвовал пользователь, пустой массив, если истории нет",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/history.UserCompletedQuest"
+                    }
+                },
+                "TotalBonus": {
+                    "description": "Бонусный баланс пользователя по журналу бонусов: начисления за вычетом списаний",
+                    "type": "integer"
+                },
+                "next_cursor": {
+                    "description": "Курсор следующей страницы заданий, null на последней странице",
+                    "type": "string"
+                }
+            }
+        },
+        "history.UserCompletedQuest": {
+            "type": "object",
+            "properties": {
+                "AllStepsCount": {
+                    "description": "Кол-во шагов, доступное в задании",
+                    "type": "integer"
+                },
+                "Bonus": {
+                    "description": "Сумма начисленных бонусов за выполненные шаги и завершение задания",
+                    "type": "integer"
+                },
+                "CompletedAt": {
+                    "description": "Время завершения задания",
+                    "type": "string"
+                },
+                "CompletedSteps": {
+                    "description": "Выполненные шаги пользователем",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/history.UserCompletedSteps"
+                    }
+                },
+                "CompletedStepsCount": {
+                    "description": "Кол-во выполненных пользователем шагов задания, не считая архивных",
+                    "type": "integer"
+                },
+                "CompletionBonus": {
+                    "description": "Бонус за завершение задания",
+                    "type": "integer"
+                },
+                "QuestId": {
+                    "description": "ИД задания",
+                    "type": "string"
+                },
+                "QuestName": {
+                    "description": "Имя выполненного задания пользователем",
+                    "type": "string"
+                },
+                "Status": {
+                    "description": "Состояние задания: in_progress или completed",
+                    "type": "string"
+                }
+            }
+        },
+        "history.UserCompletedSteps": {
+            "type": "object",
+            "properties": {
+                "Completions": {
+                    "description": "Записи о выполнении шага",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/history.HistoryRecord"
+                    }
+                },
+                "Count": {
+                    "description": "Кол-во выполнений шага",
+                    "type": "integer"
+                },
+                "StepName": {
+                    "description": "Имя выполненного шага",
+                    "type": "string"
+                },
+                "UserBonusStep": {
+                    "description": "Бонус, начисленный пользователю за выполнения шага",
+                    "type": "integer"
+                }
+            }
+        },
+        "leaderboard.Leaderboard": {
+            "description": "Leaderboard рейтинг пользователей за период",
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "description": "Первые места рейтинга",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/leaderboard.LeaderboardEntry"
+                    }
+                },
+                "me": {
+                    "description": "Место авторизованного пользователя, отсутствует, если он не набрал очков",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/leaderboard.LeaderboardEntry"
+                        }
+                    ]
+                },
+                "period": {
+                    "description": "Период: all, week или month",
+                    "type": "string"
+                },
+                "period_start": {
+                    "description": "Начало периода, отсутствует для all",
+                    "type": "string"
+                },
+                "quest_id": {
+                    "description": "Задание, отсутствует для общего рейтинга",
+                    "type": "integer"
+                }
+            }
+        },
+        "leaderboard.LeaderboardEntry": {
+            "type": "object",
+            "properties": {
+                "achieved_at": {
+                    "description": "Время последнего увеличения очков",
+                    "type": "string"
+                },
+                "rank": {
+                    "description": "Место",
+                    "type": "integer"
+                },
+                "score": {
+                    "description": "Очки: бонусы за шаги и завершение заданий за период",
+                    "type": "integer"
+                },
+                "user_id": {
+                    "description": "Идентификатор пользователя",
+                    "type": "integer"
+                },
+                "username": {
+                    "description": "Имя пользователя",
+                    "type": "string"
+                }
+            }
+        },
+        "leaderboard.TeamLeaderboard": {
+            "description": "TeamLeaderboard рейтинг команд за период",
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "description": "Первые места рейтинга",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/leaderboard.TeamLeaderboardEntry"
+                    }
+                },
+                "me": {
+                    "description": "Место команды авторизованного пользователя, отсутствует, если команда не набрала очков",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/leaderboard.TeamLeaderboardEntry"
+                        }
+                    ]
+                },
+                "period": {
+                    "description": "Период: all, week или month",
+                    "type": "string"
+                },
+                "period_start": {
+                    "description": "Начало периода, отсутствует для all",
+                    "type": "string"
+                },
+                "quest_id": {
+                    "description": "Задание, отсутствует для общего рейтинга",
+                    "type": "integer"
+                }
+            }
+        },
+        "leaderboard.TeamLeaderboardEntry": {
+            "type": "object",
+            "properties": {
+                "achieved_at": {
+                    "description": "Время последнего увеличения очков",
+                    "type": "string"
+                },
+                "name": {
+                    "description": "Название команды",
+                    "type": "string"
+                },
+                "rank": {
+                    "description": "Место",
+                    "type": "integer"
+                },
+                "score": {
+                    "description": "Очки: бонусы, заработанные участниками в составе команды за период",
+                    "type": "integer"
+                },
+                "team_id": {
+                    "description": "Идентификатор команды",
+                    "type": "integer"
+                }
+            }
+        },
+        "quest.AvailableQuest": {
+            "description": "AvailableQuest задание с шагами и отметкой о доступности шагов пользователю",
+            "type": "object",
+            "properties": {
+                "CreatedAt": {
+                    "description": "Время создания задания",
+                    "type": "string"
+                },
+                "Id": {
+                    "description": "ИД задания",
+                    "type": "integer"
+                },
+                "QuestName": {
+                    "description": "Имя задания",
+                    "type": "string"
+                },
+                "Status": {
+                    "description": "Состояние задания у пользователя: not_started или in_progress",
+                    "type": "string"
+                },
+                "Steps": {
+                    "description": "Шаги задания",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/quest.AvailableStep"
+                    }
+                }
+            }
+        },
+        "quest.AvailableStep": {
+            "type": "object",
+            "properties": {
+                "ArchivedAt": {
+                    "description": "Время архивирования шага",
+                    "type": "string"
+                },
+                "Available": {
+                    "description": "Признак того, что шаг можно выполнить сейчас",
+                    "type": "boolean"
+                },
+                "Bonus": {
+                    "description": "Бонус за выполнение шага",
+                    "type": "integer"
+                },
+                "CompletedCount": {
+                    "description": "Сколько раз пользователь выполнил шаг за все время",
+                    "type": "integer"
+                },
+                "CooldownSeconds": {
+                    "description": "Минимальный интервал между выполнениями шага пользователем в секундах",
+                    "type": "integer"
+                },
+                "GlobalLimit": {
+                    "description": "Сколько раз шаг могут выполнить все пользователи вместе",
+                    "type": "integer"
+                },
+                "Id": {
+                    "description": "ИД шага",
+                    "type": "integer"
+                },
+                "MaxCompletions": {
+                    "description": "Сколько раз пользователь может выполнить шаг (за период), пусто - без ограничения",
+                    "type": "integer"
+                },
+                "Position": {
+                    "description": "Порядковый номер шага в задании",
+                    "type": "integer"
+                },
+                "RequiresSteps": {
+                    "description": "Шаги задания, которые нужно выполнить перед этим шагом",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "StepName": {
+                    "description": "Имя шага",
+                    "type": "string"
+                },
+                "isMulti": {
+                    "description": "Признак того, что шаг можно выполнять повторно",
+                    "type": "boolean"
+                }
+            }
+        },
+        "quest.Quests": {
+            "description": "Quests json информация о заданиях и их шагов",
+            "type": "object",
+            "properties": {
+                "ArchivedAt": {
+                    "description": "Время архивирования задания",
+                    "type": "string"
+                },
+                "Cost": {
+                    "description": "Бонус за завершение задания",
+                    "type": "integer"
+                },
+                "CreatedAt": {
+                    "description": "Время создания задания",
+                    "type": "string"
+                },
+                "Description": {
+                    "description": "Описание задания",
+                    "type": "string"
+                },
+                "EndsAt": {
+                    "description": "Окончание проведения задания",
+                    "type": "string"
+                },
+                "Id": {
+                    "description": "ИД задания",
+                    "type": "string"
+                },
+                "QuestName": {
                     "description": "Имя выполненного задания пользователем",
                     "type": "string"
                 },
+                "Recurrence": {
+                    "description": "Периодичность: none, daily или weekly",
+                    "type": "string"
+                },
+                "RequiresQuests": {
+                    "description": "Задания, которые нужно завершить перед выполнением шагов",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "StartsAt": {
+                    "description": "Начало проведения задания",
+                    "type": "string"
+                },
                 "Steps": {
                     "description": "Шаги задания",
                     "type": "array",
                     "items": {
                         "$ref": "#/definitions/quest.Steps"
                     }
+                },
+                "TeamScoped": {
+                    "description": "Командное задание: выполнение шага участником засчитывается всей команде",
+                    "type": "boolean"
                 }
             }
         },
         "quest.Steps": {
             "type": "object",
             "properties": {
+                "ArchivedAt": {
+                    "description": "Время архивирования шага",
+                    "type": "string"
+                },
                 "Bonus": {
                     "description": "Бонус за выполнение шага",
                     "type": "integer"
                 },
+                "CooldownSeconds": {
+                    "description": "Минимальный интервал между выполнениями шага пользователем в секундах",
+                    "type": "integer"
+                },
+                "GlobalLimit": {
+                    "description": "Сколько раз шаг могут выполнить все пользователи вместе",
+                    "type": "integer"
+                },
                 "Id": {
                     "description": "ИД шага",
                     "type": "integer"
                 },
+                "MaxCompletions": {
+                    "description": "Сколько раз пользователь может выполнить шаг (за период), пусто - без ограничения",
+                    "type": "integer"
+                },
+                "Position": {
+                    "description": "Порядковый номер шага в задании",
+                    "type": "integer"
+                },
+                "RequiresSteps": {
+                    "description": "Шаги задания, которые нужно выполнить перед этим шагом",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
                 "StepName": {
                     "description": "Имя шага",
                     "type": "string"
@@ -411,10 +4193,478 @@ const docTemplate = `{
                 }
             }
         },
+        "quest.UpdateQuestRequest": {
+            "description": "UpdateQuestRequest json для изменения задания. Незаполненные поля не меняются",
+            "type": "object",
+            "properties": {
+                "Cost": {
+                    "description": "Бонус за завершение задания, уже начисленные бонусы не меняются",
+                    "type": "integer"
+                },
+                "Description": {
+                    "description": "Новое описание задания",
+                    "type": "string"
+                },
+                "EndsAt": {
+                    "description": "Окончание проведения, null снимает ограничение",
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "Name": {
+                    "description": "Новое имя задания",
+                    "type": "string"
+                },
+                "Recurrence": {
+                    "description": "Периодичность: none, daily или weekly",
+                    "type": "string"
+                },
+                "RequiresQuests": {
+                    "description": "Задания, которые нужно завершить перед выполнением шагов, заменяют текущий список",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "StartsAt": {
+                    "description": "Начало проведения, null снимает ограничение",
+                    "type": "string",
+                    "format": "date-time"
+                },
+                "TeamScoped": {
+                    "description": "Командное задание, уже записанная история не меняется",
+                    "type": "boolean"
+                }
+            }
+        },
+        "quest.UpdateStepRequest": {
+            "description": "UpdateStepRequest json для изменения шага. Незаполненные поля не меняются",
+            "type": "object",
+            "properties": {
+                "Bonus": {
+                    "description": "Бонус за выполнение шага",
+                    "type": "integer"
+                },
+                "CooldownSeconds": {
+                    "description": "Минимальный интервал между выполнениями шага одним пользователем в секундах",
+                    "type": "integer"
+                },
+                "GlobalLimit": {
+                    "description": "Сколько раз шаг могут выполнить все пользователи вместе",
+                    "type": "integer"
+                },
+                "IsMulti": {
+                    "description": "Признак того, что шаг можно выполнять несколько раз",
+                    "type": "boolean"
+                },
+                "MaxCompletions": {
+                    "description": "Сколько раз пользователь может выполнить шаг (в периодическом задании - за период). Задает и IsMulti: IsMulti = MaxCompletions != 1",
+                    "type": "integer"
+                },
+                "Position": {
+                    "description": "Порядковый номер шага в задании",
+                    "type": "integer"
+                },
+                "RequiresSteps": {
+                    "description": "Имена шагов задания, которые нужно выполнить перед этим шагом, заменяют текущий список",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "StepName": {
+                    "description": "Новое имя шага",
+                    "type": "string"
+                }
+            }
+        },
+        "response.ErrorBody": {
+            "description": "ErrorBody описание ошибки",
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "Машиночитаемый код ошибки",
+                    "type": "string",
+                    "enum": [
+                        "malformed_request",
+                        "invalid_parameter",
+                        "validation_failed",
+                        "unauthorized",
+                        "forbidden",
+                        "not_found",
+                        "method_not_allowed",
+                        "internal_error",
+                        "invalid_credentials",
+                        "invalid_refresh_token",
+                        "wrong_password",
+                        "user_not_found",
+                        "user_exists",
+                        "last_admin",
+                        "unknown_roles",
+                        "quest_not_found",
+                        "quest_exists",
+                        "quest_archived",
+                        "quest_has_history",
+                        "step_not_found",
+                        "step_exists",
+                        "step_archived",
+                        "step_has_history",
+                        "invalid_prerequisite",
+                        "steps_rejected",
+                        "ledger_entry_not_found",
+                        "already_reversed",
+                        "insufficient_bonus",
+                        "reward_not_found",
+                        "reward_exists",
+                        "reward_archived",
+                        "reward_out_of_stock",
+                        "redemption_not_found",
+                        "redemption_resolved",
+                        "team_not_found",
+                        "team_exists",
+                        "team_has_history",
+                        "team_member_not_found",
+                        "already_in_team",
+                        "badge_not_found",
+                        "badge_exists"
+                    ]
+                },
+                "details": {
+                    "description": "Ошибки по полям и параметрам запроса",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/response.FieldError"
+                    }
+                },
+                "message": {
+                    "description": "Описание ошибки на языке из Accept-Language",
+                    "type": "string"
+                },
+                "request_id": {
+                    "description": "Идентификатор запроса",
+                    "type": "string"
+                }
+            }
+        },
+        "response.ErrorResponse": {
+            "description": "ErrorResponse ошибка API",
+            "type": "object",
+            "properties": {
+                "error": {
+                    "description": "Описание ошибки",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/response.ErrorBody"
+                        }
+                    ]
+                }
+            }
+        },
+        "response.FieldError": {
+            "description": "FieldError ошибка в поле или параметре запроса",
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "Машиночитаемый код ошибки в поле",
+                    "type": "string",
+                    "enum": [
+                        "required",
+                        "required_one_of",
+                        "length",
+                        "max_length",
+                        "positive",
+                        "not_negative",
+                        "not_zero",
+                        "range",
+                        "one_of",
+                        "after",
+                        "conflicts",
+                        "only_for_rule",
+                        "required_for",
+                        "date_time",
+                        "cursor",
+                        "type",
+                        "syntax",
+                        "unknown",
+                        "rejected",
+                        "cycle",
+                        "key_reused",
+                        "quest_unfinished",
+                        "step_incomplete"
+                    ]
+                },
+                "field": {
+                    "description": "Поле тела или параметр запроса, отсутствует для ошибок, не относящихся к одному полю",
+                    "type": "string"
+                },
+                "message": {
+                    "description": "Описание ошибки на языке из Accept-Language",
+                    "type": "string"
+                }
+            }
+        },
+        "reward.NewReward": {
+            "description": "NewReward json для создания награды",
+            "type": "object",
+            "properties": {
+                "cost": {
+                    "description": "Стоимость в бонусах, больше 0",
+                    "type": "integer"
+                },
+                "description": {
+                    "description": "Описание",
+                    "type": "string"
+                },
+                "name": {
+                    "description": "Название, уникальное в каталоге",
+                    "type": "string"
+                },
+                "stock": {
+                    "description": "Остаток, не указан - без ограничения",
+                    "type": "integer"
+                }
+            }
+        },
+        "reward.Redemption": {
+            "description": "Redemption заявка пользователя на получение награды",
+            "type": "object",
+            "properties": {
+                "comment": {
+                    "description": "Комментарий при выполнении или отмене",
+                    "type": "string"
+                },
+                "cost": {
+                    "description": "Списанная стоимость",
+                    "type": "integer"
+                },
+                "created_at": {
+                    "description": "Время создания",
+                    "type": "string"
+                },
+                "debit_id": {
+                    "description": "Запись журнала бонусов со списанием",
+                    "type": "integer"
+                },
+                "id": {
+                    "description": "Идентификатор заявки",
+                    "type": "integer"
+                },
+                "refund_id": {
+                    "description": "Запись журнала бонусов с возвратом при отмене",
+                    "type": "integer"
+                },
+                "resolved_at": {
+                    "description": "Время выполнения или отмены",
+                    "type": "string"
+                },
+                "resolved_by": {
+                    "description": "Пользователь, выполнивший или отменивший заявку",
+                    "type": "integer"
+                },
+                "reward_id": {
+                    "description": "Награда",
+                    "type": "integer"
+                },
+                "reward_name": {
+                    "description": "Название награды",
+                    "type": "string"
+                },
+                "status": {
+                    "description": "Статус: pending, fulfilled или cancelled",
+                    "type": "string"
+                },
+                "user_id": {
+                    "description": "Пользователь, получающий награду",
+                    "type": "integer"
+                }
+            }
+        },
+        "reward.ResolveRequest": {
+            "description": "ResolveRequest json для выполнения или отмены заявки",
+            "type": "object",
+            "properties": {
+                "comment": {
+                    "description": "Комментарий, не длиннее 200 символов",
+                    "type": "string"
+                }
+            }
+        },
+        "reward.Reward": {
+            "description": "Reward награда из каталога",
+            "type": "object",
+            "properties": {
+                "archived_at": {
+                    "description": "Время архивирования, пусто для действующей награды",
+                    "type": "string"
+                },
+                "cost": {
+                    "description": "Стоимость в бонусах",
+                    "type": "integer"
+                },
+                "created_at": {
+                    "description": "Время создания",
+                    "type": "string"
+                },
+                "description": {
+                    "description": "Описание",
+                    "type": "string"
+                },
+                "id": {
+                    "description": "Идентификатор награды",
+                    "type": "integer"
+                },
+                "name": {
+                    "description": "Название",
+                    "type": "string"
+                },
+                "stock": {
+                    "description": "Остаток, пусто - без ограничения",
+                    "type": "integer"
+                }
+            }
+        },
+        "reward.UpdateRewardRequest": {
+            "description": "UpdateRewardRequest json для изменения награды. Незаполненные поля не меняются",
+            "type": "object",
+            "properties": {
+                "cost": {
+                    "description": "Новая стоимость, уже созданные заявки не меняются",
+                    "type": "integer"
+                },
+                "description": {
+                    "description": "Новое описание",
+                    "type": "string"
+                },
+                "name": {
+                    "description": "Новое название",
+                    "type": "string"
+                },
+                "stock": {
+                    "description": "Новый остаток, null снимает ограничение",
+                    "type": "integer"
+                }
+            }
+        },
+        "role.SetUserRolesRequest": {
+            "description": "SetUserRolesRequest json для назначения ролей пользователю",
+            "type": "object",
+            "properties": {
+                "roles": {
+                    "description": "Полный список ролей пользователя, текущие роли будут заменены",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "userid": {
+                    "description": "Идентификатор пользователя",
+                    "type": "integer"
+                }
+            }
+        },
+        "storage.CompleteStep": {
+            "type": "object",
+            "properties": {
+                "idempotency_key": {
+                    "description": "Ключ идемпотентности в пределах пользователя, повторный запрос с тем же ключом не создает новую запись. Необязательно",
+                    "type": "string"
+                },
+                "source": {
+                    "description": "Источник отметки о выполнении, необязательно",
+                    "type": "string"
+                },
+                "stepid": {
+                    "description": "Идентификатор шага",
+                    "type": "integer"
+                },
+                "userid": {
+                    "description": "Идентификатор пользователя выполневшего шаг. В /me/CompleteSteps игнорируется и берется из авторизации",
+                    "type": "integer"
+                }
+            }
+        },
+        "storage.LedgerEntry": {
+            "description": "LedgerEntry запись журнала бонусов: положительная сумма - начисление, отрицательная - списание",
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "description": "Сумма: больше 0 - начисление, меньше 0 - списание",
+                    "type": "integer"
+                },
+                "comment": {
+                    "description": "Комментарий",
+                    "type": "string"
+                },
+                "created_at": {
+                    "description": "Время создания записи",
+                    "type": "string"
+                },
+                "created_by": {
+                    "description": "Пользователь, создавший запись, пусто для автоматических записей",
+                    "type": "integer"
+                },
+                "history_id": {
+                    "description": "Запись истории выполнения шага",
+                    "type": "integer"
+                },
+                "id": {
+                    "description": "Идентификатор записи",
+                    "type": "integer"
+                },
+                "kind": {
+                    "description": "Вид записи: step, quest, redemption, adjustment или reversal",
+                    "type": "string"
+                },
+                "quest_id": {
+                    "description": "Завершенное задание",
+                    "type": "integer"
+                },
+                "reversed_by": {
+                    "description": "Запись, которой отменена эта запись",
+                    "type": "integer"
+                },
+                "reverses_id": {
+                    "description": "Запись, которую отменяет эта запись",
+                    "type": "integer"
+                },
+                "team_id": {
+                    "description": "Команда пользователя на момент начисления",
+                    "type": "integer"
+                },
+                "user_id": {
+                    "description": "Пользователь, счет которого изменяется",
+                    "type": "integer"
+                }
+            }
+        },
+        "storage.NewCompleteSteps": {
+            "description": "NewCompleteSteps  json для отметки о выполнении шага задания пользователем",
+            "type": "object",
+            "properties": {
+                "CompleteSteps": {
+                    "description": "Идентификатор задания",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/storage.CompleteStep"
+                    }
+                }
+            }
+        },
         "storage.NewQuest": {
             "description": "NewQuest json для создания задания с шагами",
             "type": "object",
             "properties": {
+                "Cost": {
+                    "description": "Бонус за завершение задания, начисляется один раз после выполнения всех шагов",
+                    "type": "integer"
+                },
+                "Description": {
+                    "description": "Описание задания",
+                    "type": "string"
+                },
+                "EndsAt": {
+                    "description": "Окончание проведения задания, если не указано - задание доступно бессрочно",
+                    "type": "string"
+                },
                 "Name": {
                     "description": "Имя задания",
                     "type": "string"
@@ -426,6 +4676,25 @@ const docTemplate = `{
                         "$ref": "#/definitions/storage.NewQuestStep"
                     }
                 },
+                "Recurrence": {
+                    "description": "Периодичность: none (по умолчанию), daily или weekly",
+                    "type": "string"
+                },
+                "RequiresQuests": {
+                    "description": "Идентификаторы заданий, которые пользователь должен завершить перед выполнением шагов задания",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "StartsAt": {
+                    "description": "Начало проведения задания, если не указано - задание доступно сразу",
+                    "type": "string"
+                },
+                "TeamScoped": {
+                    "description": "Командное задание: выполнение шага участником засчитывается всей его команде",
+                    "type": "boolean"
+                },
                 "id": {
                     "description": "Идентификатор задания",
                     "type": "integer"
@@ -439,14 +4708,37 @@ const docTemplate = `{
                     "description": "Бонус за задание",
                     "type": "integer"
                 },
+                "CooldownSeconds": {
+                    "description": "Минимальный интервал между выполнениями шага одним пользователем в секундах",
+                    "type": "integer"
+                },
+                "GlobalLimit": {
+                    "description": "Сколько раз шаг могут выполнить все пользователи вместе",
+                    "type": "integer"
+                },
                 "IsMulti": {
                     "description": "Признак того, что шаг можно выполнять несколько раз",
                     "type": "boolean"
                 },
+                "MaxCompletions": {
+                    "description": "Сколько раз пользователь может выполнить шаг (в периодическом задании - за период). Задает и IsMulti: IsMulti = MaxCompletions != 1",
+                    "type": "integer"
+                },
+                "Position": {
+                    "description": "Порядковый номер шага в задании, если не указан - шаг добавляется в конец",
+                    "type": "integer"
+                },
                 "QuestId": {
                     "description": "Идентификатор задания. При создании методом CreateQuest, значение будет проигнорировано, т.к. будет подставляться идентификатор создаваемого задания",
                     "type": "integer"
                 },
+                "RequiresSteps": {
+                    "description": "Имена шагов того же задания, которые нужно выполнить перед этим шагом",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "StepName": {
                     "description": "Описание шага",
                     "type": "string"
@@ -470,6 +4762,333 @@ const docTemplate = `{
                 }
             }
         },
+        "storage.Page-badge_Badge": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "description": "Элементы страницы, пустой массив, если элементов нет",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/badge.Badge"
+                    }
+                },
+                "next_cursor": {
+                    "description": "Курсор следующей страницы, null на последней странице",
+                    "type": "string"
+                }
+            }
+        },
+        "storage.Page-quest_AvailableQuest": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "description": "Элементы страницы, пустой массив, если элементов нет",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/quest.AvailableQuest"
+                    }
+                },
+                "next_cursor": {
+                    "description": "Курсор следующей страницы, null на последней странице",
+                    "type": "string"
+                }
+            }
+        },
+        "storage.Page-quest_Quests": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "description": "Элементы страницы, пустой массив, если элементов нет",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/quest.Quests"
+                    }
+                },
+                "next_cursor": {
+                    "description": "Курсор следующей страницы, null на последней странице",
+                    "type": "string"
+                }
+            }
+        },
+        "storage.Page-reward_Redemption": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "description": "Элементы страницы, пустой массив, если элементов нет",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/reward.Redemption"
+                    }
+                },
+                "next_cursor": {
+                    "description": "Курсор следующей страницы, null на последней странице",
+                    "type": "string"
+                }
+            }
+        },
+        "storage.Page-reward_Reward": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "description": "Элементы страницы, пустой массив, если элементов нет",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/reward.Reward"
+                    }
+                },
+                "next_cursor": {
+                    "description": "Курсор следующей страницы, null на последней странице",
+                    "type": "string"
+                }
+            }
+        },
+        "storage.Page-storage_LedgerEntry": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "description": "Элементы страницы, пустой массив, если элементов нет",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/storage.LedgerEntry"
+                    }
+                },
+                "next_cursor": {
+                    "description": "Курсор следующей страницы, null на последней странице",
+                    "type": "string"
+                }
+            }
+        },
+        "storage.Page-team_Team": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "description": "Элементы страницы, пустой массив, если элементов нет",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/team.Team"
+                    }
+                },
+                "next_cursor": {
+                    "description": "Курсор следующей страницы, null на последней странице",
+                    "type": "string"
+                }
+            }
+        },
+        "storage.Page-users_User": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "description": "Элементы страницы, пустой массив, если элементов нет",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/users.User"
+                    }
+                },
+                "next_cursor": {
+                    "description": "Курсор следующей страницы, null на последней странице",
+                    "type": "string"
+                }
+            }
+        },
+        "storage.Role": {
+            "description": "Role роль и её разрешения",
+            "type": "object",
+            "properties": {
+                "description": {
+                    "description": "Описание роли",
+                    "type": "string"
+                },
+                "id": {
+                    "description": "Идентификатор роли",
+                    "type": "integer"
+                },
+                "name": {
+                    "description": "Имя роли",
+                    "type": "string"
+                },
+                "permissions": {
+                    "description": "Разрешения роли",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "storage.UpdateQuestStep": {
+            "type": "object",
+            "properties": {
+                "Bonus": {
+                    "description": "Бонус за задание",
+                    "type": "integer"
+                },
+                "CooldownSeconds": {
+                    "description": "Минимальный интервал между выполнениями шага одним пользователем в секундах",
+                    "type": "integer"
+                },
+                "GlobalLimit": {
+                    "description": "Сколько раз шаг могут выполнить все пользователи вместе",
+                    "type": "integer"
+                },
+                "IsMulti": {
+                    "description": "Признак того, что шаг можно выполнять несколько раз",
+                    "type": "boolean"
+                },
+                "MaxCompletions": {
+                    "description": "Сколько раз пользователь может выполнить шаг (в периодическом задании - за период). Задает и IsMulti: IsMulti = MaxCompletions != 1",
+                    "type": "integer"
+                },
+                "id": {
+                    "description": "Идентификатор задания",
+                    "type": "integer"
+                }
+            }
+        },
+        "storage.UpdateQuestSteps": {
+            "description": "UpdateQuestSteps json для обновления шагов заданий",
+            "type": "object",
+            "properties": {
+                "QuestSteps": {
+                    "description": "Идентификатор задания",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/storage.UpdateQuestStep"
+                    }
+                }
+            }
+        },
+        "storage.UserBadge": {
+            "description": "UserBadge значок, выданный пользователю",
+            "type": "object",
+            "properties": {
+                "awarded_at": {
+                    "description": "Время выдачи",
+                    "type": "string"
+                },
+                "badge_id": {
+                    "description": "Идентификатор значка",
+                    "type": "integer"
+                },
+                "description": {
+                    "description": "Описание значка",
+                    "type": "string"
+                },
+                "name": {
+                    "description": "Название значка",
+                    "type": "string"
+                }
+            }
+        },
+        "team.AddMemberRequest": {
+            "description": "AddMemberRequest json для добавления участника в команду",
+            "type": "object",
+            "properties": {
+                "user_id": {
+                    "description": "Идентификатор пользователя, не состоящего в другой команде",
+                    "type": "integer"
+                }
+            }
+        },
+        "team.NewTeam": {
+            "description": "NewTeam json для создания команды",
+            "type": "object",
+            "properties": {
+                "name": {
+                    "description": "Название, уникальное среди команд",
+                    "type": "string"
+                }
+            }
+        },
+        "team.Team": {
+            "description": "Team команда пользователей",
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "Время создания",
+                    "type": "string"
+                },
+                "id": {
+                    "description": "Идентификатор команды",
+                    "type": "integer"
+                },
+                "member_count": {
+                    "description": "Кол-во участников",
+                    "type": "integer"
+                },
+                "name": {
+                    "description": "Название",
+                    "type": "string"
+                },
+                "total_bonus": {
+                    "description": "Бонусы, заработанные участниками в составе команды",
+                    "type": "integer"
+                }
+            }
+        },
+        "team.TeamDetails": {
+            "description": "TeamDetails команда с участниками",
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "Время создания",
+                    "type": "string"
+                },
+                "id": {
+                    "description": "Идентификатор команды",
+                    "type": "integer"
+                },
+                "member_count": {
+                    "description": "Кол-во участников",
+                    "type": "integer"
+                },
+                "members": {
+                    "description": "Участники команды",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/team.TeamMember"
+                    }
+                },
+                "name": {
+                    "description": "Название",
+                    "type": "string"
+                },
+                "total_bonus": {
+                    "description": "Бонусы, заработанные участниками в составе команды",
+                    "type": "integer"
+                }
+            }
+        },
+        "team.TeamMember": {
+            "type": "object",
+            "properties": {
+                "joined_at": {
+                    "description": "Время вступления в команду",
+                    "type": "string"
+                },
+                "user_id": {
+                    "description": "Идентификатор пользователя",
+                    "type": "integer"
+                },
+                "username": {
+                    "description": "Имя пользователя",
+                    "type": "string"
+                }
+            }
+        },
+        "users.ChangePasswordRequest": {
+            "description": "ChangePasswordRequest json для смены пароля",
+            "type": "object",
+            "properties": {
+                "current_password": {
+                    "description": "текущий пароль, обязателен при смене своего пароля без разрешения users.write",
+                    "type": "string"
+                },
+                "password": {
+                    "description": "новый пароль",
+                    "type": "string"
+                }
+            }
+        },
         "users.DeleteUserStruct": {
             "type": "object",
             "properties": {
@@ -478,10 +5097,28 @@ const docTemplate = `{
                 }
             }
         },
+        "users.UpdateUserRequest": {
+            "description": "UpdateUserRequest json для изменения пользователя. Незаполненные поля не меняются",
+            "type": "object",
+            "properties": {
+                "userIsAdmin": {
+                    "description": "выдать или забрать роль admin",
+                    "type": "boolean"
+                },
+                "username": {
+                    "description": "новое имя пользователя",
+                    "type": "string"
+                }
+            }
+        },
         "users.User": {
             "description": "User информация о пользователе",
             "type": "object",
             "properties": {
+                "createdAt": {
+                    "description": "время создания пользователя",
+                    "type": "string"
+                },
                 "id": {
                     "description": "идентификатор пользователя",
                     "type": "integer"
@@ -504,6 +5141,12 @@ const docTemplate = `{
     "securityDefinitions": {
         "BasicAuth": {
             "type": "basic"
+        },
+        "BearerAuth": {
+            "description": "Access токен из /auth/login в формате \"Bearer \u003ctoken\u003e\"",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
@@ -515,7 +5158,7 @@ var SwaggerInfo = &swag.Spec{
 	BasePath:         "",
 	Schemes:          []string{},
 	Title:            "Задания пользователей API",
-	Description:      "Фильмотека",
+	Description:      "Фильмотека\nОшибки возвращаются в формате response.ErrorResponse: code - машиночитаемый код ошибки (список - в описании модели),\nmessage - описание ошибки, details - ошибки в полях запроса со своими кодами.\nЯзык сообщений (ru или en) выбирается по заголовку Accept-Language, без него используется язык по умолчанию из конфига (language)",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
 	LeftDelim:        "{{",
@@ -1,52 +1,234 @@
 package auth
 
 import (
+	"encoding/json"
+	"log/slog"
 	"net/http"
+	"strings"
+	"techno-test_quests/quests/config"
 	users "techno-test_quests/quests/handlers/user"
+	"techno-test_quests/quests/response"
 	"techno-test_quests/quests/storage"
 )
 
-// NonPage handler для пустой страницы
-func NonPage(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
+// Auth проверяет авторизацию запросов: по access токену или, если разрешено настройками, по BasicAuth
+type Auth struct {
+	storage          *storage.Storage
+	tokens           *Tokens
+	basicAuthEnabled bool
+}
+
+// New возвращает сервис авторизации
+func New(cfg config.Auth, storage *storage.Storage) (*Auth, error) {
+	tokens, err := NewTokens(cfg, storage)
+	if err != nil {
+		return nil, err
 	}
-	w.Write([]byte("Страница не существует"))
+	return &Auth{storage: storage, tokens: tokens, basicAuthEnabled: cfg.BasicAuthEnabled}, nil
 }
 
-// AdminAuth Авторизация администратора
-func AdminAuth(next http.HandlerFunc, storage *storage.Storage) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// Tokens возвращает сервис токенов
+func (auth *Auth) Tokens() *Tokens {
+	return auth.tokens
+}
+
+// NonPage handler для несуществующей страницы, в том числе корня: отвечает ошибкой 404 в общем формате
+func NonPage(w http.ResponseWriter, r *http.Request) {
+	response.Error(w, r, http.StatusNotFound, response.CodeNotFound)
+}
+
+// authenticate возвращает пользователя, выполняющего запрос, и признак успешной авторизации
+func (auth *Auth) authenticate(r *http.Request) (storage.Principal, bool) {
+	header := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		claims, err := auth.tokens.Parse(strings.TrimSpace(token))
+		if err != nil {
+			return storage.Principal{}, false
+		}
+		principal, err := claims.principal()
+		if err != nil {
+			return storage.Principal{}, false
+		}
+		return principal, true
+	}
+
+	if auth.basicAuthEnabled {
 		username, password, ok := r.BasicAuth()
 		if ok {
-			user, err := users.GetUser(username, password, storage)
+			user, err := users.GetUser(username, password, auth.storage)
+			if err != nil {
+				return storage.Principal{}, false
+			}
+			access, err := storage.GetUserAccess(auth.storage.DB, user.Id)
+			if err != nil {
+				return storage.Principal{}, false
+			}
+			return storage.Principal{UserId: user.Id, Username: user.Username, Roles: access.Roles, Permissions: access.Permissions}, true
+		}
+	}
+	return storage.Principal{}, false
+}
+
+func (auth *Auth) unauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("WWW-Authenticate", `Bearer realm="quests"`)
+	if auth.basicAuthEnabled {
+		w.Header().Add("WWW-Authenticate", `Basic realm="quests"`)
+	}
+	response.Error(w, r, http.StatusUnauthorized, response.CodeUnauthorized)
+}
+
+// Require Авторизация пользователя, у которого есть разрешение permission
+func (auth *Auth) Require(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := auth.authenticate(r)
+		if !ok {
+			auth.unauthorized(w, r)
+			return
+		}
+		if !principal.HasPermission(permission) {
+			response.Error(w, r, http.StatusForbidden, response.CodeForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(storage.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// LoginRequest model info
+// @Description LoginRequest логин и пароль пользователя
+type LoginRequest struct {
+	Username string `json:"username"` //Имя пользователя
+	Password string `json:"password"` //Пароль пользователя
+}
+
+// RefreshRequest model info
+// @Description RefreshRequest refresh токен
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"` //Refresh токен, полученный при входе или предыдущем обновлении
+}
+
+// @Summary Вход
+// @Tags auth
+// @Description Обменивает логин и пароль на короткоживущий access токен и refresh токен
+// @id Login
+// @Accept json
+// @Procedure json
+// @router /auth/login [post]
+// @param input body LoginRequest true "логин и пароль"
+// @Success 200 {object} TokenPair
+// @Failure 401 {object} response.ErrorResponse "неверный логин/пароль"
+func (auth *Auth) Login(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var login LoginRequest
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			err := decoder.Decode(&login)
+			if err != nil || login.Username == "" {
+				response.Error(w, r, http.StatusBadRequest, response.CodeMalformedRequest)
+				return
+			}
+
+			user, err := users.GetUser(login.Username, login.Password, auth.storage)
 			if err != nil {
-				http.Error(w, "Ошибка при проверки пользователя", http.StatusBadRequest)
+				response.Error(w, r, http.StatusUnauthorized, response.CodeInvalidCredentials)
 				return
 			}
-			if user.Isadmin {
-				next.ServeHTTP(w, r)
+
+			pair, err := auth.tokens.Issue(user)
+			if err != nil {
+				logger.Error("issue tokens failed", "error", err.Error())
+				response.Internal(w, r)
 				return
 			}
+			response.JSON(w, http.StatusOK, pair)
+		} else {
+			response.MethodNotAllowed(w, r, http.MethodPost)
 		}
-		http.Error(w, "Введен неверный логин/пароль", http.StatusUnauthorized)
-	})
+	}
 }
 
-// UserAuth Авторизация любого пользователя
-func UserAuth(next http.HandlerFunc, storage *storage.Storage) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		username, password, ok := r.BasicAuth()
-		if ok {
-			_, err := users.GetUser(username, password, storage)
+// @Summary Обновить токены
+// @Tags auth
+// @Description Обменивает refresh токен на новую пару токенов. Использованный refresh токен становится недействительным
+// @id Refresh
+// @Accept json
+// @Procedure json
+// @router /auth/refresh [post]
+// @param input body RefreshRequest true "refresh токен"
+// @Success 200 {object} TokenPair
+// @Failure 401 {object} response.ErrorResponse "refresh токен недействителен"
+func (auth *Auth) Refresh(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var refresh RefreshRequest
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			err := decoder.Decode(&refresh)
+			if err != nil || refresh.RefreshToken == "" {
+				response.Error(w, r, http.StatusBadRequest, response.CodeMalformedRequest)
+				return
+			}
+
+			pair, err := auth.tokens.Refresh(refresh.RefreshToken)
+			if err == errInvalidToken {
+				response.Error(w, r, http.StatusUnauthorized, response.CodeInvalidRefreshToken)
+				return
+			}
 			if err != nil {
-				http.Error(w, "Ошибка при проверки пользователя", http.StatusBadRequest)
+				logger.Error("refresh tokens failed", "error", err.Error())
+				response.Internal(w, r)
 				return
 			}
-			next.ServeHTTP(w, r)
-			return
+			response.JSON(w, http.StatusOK, pair)
+		} else {
+			response.MethodNotAllowed(w, r, http.MethodPost)
 		}
-		http.Error(w, "Введен неверный логин/пароль", http.StatusUnauthorized)
-	})
+	}
+}
+
+// @Summary Выход
+// @Tags auth
+// @Description Отзывает access токен из заголовка Authorization и переданный refresh токен
+// @id Logout
+// @Accept json
+// @Procedure json
+// @router /auth/logout [post]
+// @param input body RefreshRequest false "refresh токен"
+// @Success 200 {string} string "ok"
+// @Failure 401 {object} response.ErrorResponse "токен недействителен"
+// @Security BearerAuth
+func (auth *Auth) Logout(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				auth.unauthorized(w, r)
+				return
+			}
+			claims, err := auth.tokens.Parse(strings.TrimSpace(token))
+			if err != nil {
+				auth.unauthorized(w, r)
+				return
+			}
+
+			var refresh RefreshRequest
+			if r.ContentLength != 0 {
+				decoder := json.NewDecoder(r.Body)
+				decoder.DisallowUnknownFields()
+				if err = decoder.Decode(&refresh); err != nil {
+					response.Error(w, r, http.StatusBadRequest, response.CodeMalformedRequest)
+					return
+				}
+			}
+
+			if err = auth.tokens.Revoke(claims, refresh.RefreshToken); err != nil {
+				logger.Error("revoke tokens failed", "error", err.Error())
+				response.Internal(w, r)
+				return
+			}
+			response.Message(w, r, http.StatusOK, response.MessageSuccess)
+		} else {
+			response.MethodNotAllowed(w, r, http.MethodPost)
+		}
+	}
 }
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+)
+
+// requireStatus выполняет запрос с access токеном к методу, требующему разрешения permission, и возвращает код ответа
+func requireStatus(t *testing.T, auth *Auth, permission, accessToken string) int {
+	t.Helper()
+	handler := auth.Require(permission, func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := storages.PrincipalFromContext(r.Context())
+		if !ok || !principal.HasPermission(permission) {
+			t.Errorf("principal without %s passed to handler: %+v", permission, principal)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.Header.Set("Authorization", "Bearer "+accessToken)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Code == http.StatusForbidden {
+		var body response.ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Code != response.CodeForbidden {
+			t.Fatalf("forbidden body %s, want code %s", w.Body.String(), response.CodeForbidden)
+		}
+	}
+	return w.Code
+}
+
+func (f *testFixture) setRoles(t *testing.T, roles ...string) {
+	t.Helper()
+	if _, err := storages.SetUserRoles(f.storage.DB, f.user.Id, roles); err != nil {
+		t.Fatalf("set user roles: %s", err)
+	}
+}
+
+func TestRequireRevokesTokensOnRoleChange(t *testing.T) {
+	f := newTestFixture(t)
+	auth, err := New(testAuthConfig, f.storage)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+	f.setRoles(t, storages.RolePlayer)
+	pair, err := auth.tokens.Issue(f.user)
+	if err != nil {
+		t.Fatalf("Issue: %s", err)
+	}
+
+	//разрешения берутся из токена
+	if code := requireStatus(t, auth, storages.PermUsersRead, pair.AccessToken); code != http.StatusForbidden {
+		t.Fatalf("player: status %d, want %d", code, http.StatusForbidden)
+	}
+
+	//изменение ролей отзывает выданный токен, новые роли действуют в токене после обновления
+	f.setRoles(t, storages.RoleOperator)
+	if err = auth.tokens.SyncRevoked(); err != nil {
+		t.Fatalf("SyncRevoked: %s", err)
+	}
+	if code := requireStatus(t, auth, storages.PermSelf, pair.AccessToken); code != http.StatusUnauthorized {
+		t.Fatalf("token issued before role change: status %d, want %d", code, http.StatusUnauthorized)
+	}
+	pair, err = auth.tokens.Refresh(pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %s", err)
+	}
+	if code := requireStatus(t, auth, storages.PermUsersRead, pair.AccessToken); code != http.StatusOK {
+		t.Fatalf("operator: status %d, want %d", code, http.StatusOK)
+	}
+
+	//токен удаленного пользователя недействителен
+	if err = f.storage.DeleteUser(f.user.Id); err != nil {
+		t.Fatalf("DeleteUser: %s", err)
+	}
+	if err = auth.tokens.SyncRevoked(); err != nil {
+		t.Fatalf("SyncRevoked: %s", err)
+	}
+	if code := requireStatus(t, auth, storages.PermSelf, pair.AccessToken); code != http.StatusUnauthorized {
+		t.Fatalf("deleted user: status %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireRejectsMissingToken(t *testing.T) {
+	f := newTestFixture(t)
+	auth, err := New(testAuthConfig, f.storage)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	for _, header := range []string{"", "Bearer ", "Bearer not.a.token", "Basic bm9ib2R5Ondyb25n"} {
+		handler := auth.Require(storages.PermSelf, func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("header %q passed to handler", header)
+		})
+		r := httptest.NewRequest(http.MethodGet, "/me", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("header %q: status %d, want %d", header, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestNonPageRespondsNotFound(t *testing.T) {
+	for _, target := range []string{"/", "/unknown", "/users/1/unknown"} {
+		w := httptest.NewRecorder()
+		NonPage(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+		var body response.ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusNotFound || body.Error.Code != response.CodeNotFound {
+			t.Fatalf("%s: status %d, body %s, want 404 %s", target, w.Code, w.Body.String(), response.CodeNotFound)
+		}
+	}
+}
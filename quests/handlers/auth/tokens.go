@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/golang-jwt/jwt/v5"
+	"techno-test_quests/quests/config"
+	users "techno-test_quests/quests/handlers/user"
+	storages "techno-test_quests/quests/storage"
+)
+
+const (
+	minTokenSecretLength = 32                        //минимальная длина ключа подписи для HS256
+	defaultTokenSecret   = "change-me-in-production" //ключ из прежней версии config.yml
+)
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errRevokedToken = errors.New("token is revoked")
+)
+
+// TokenPair model info
+// @Description TokenPair access и refresh токены
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`  //Подписанный access токен, передается в заголовке Authorization: Bearer <token>
+	RefreshToken string `json:"refresh_token"` //Токен для получения новой пары токенов
+	TokenType    string `json:"token_type"`    //Тип токена, всегда Bearer
+	ExpiresIn    int    `json:"expires_in"`    //Время жизни access токена в секундах
+}
+
+// accessClaims содержимое access токена
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Username    string   `json:"username"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"perms"`
+}
+
+// Tokens выпускает и проверяет токены. Проверка access токена не обращается к БД:
+// подпись проверяется ключом, а отозванные токены хранятся в памяти и периодически подтягиваются из БД
+type Tokens struct {
+	storage    *storages.Storage
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+
+	mu      sync.RWMutex
+	revoked map[string]time.Time //jti -> время истечения токена
+}
+
+// NewTokens создает сервис токенов и загружает список отозванных токенов
+func NewTokens(cfg config.Auth, storage *storages.Storage) (*Tokens, error) {
+	if err := checkTokenSecret(cfg.TokenSecret); err != nil {
+		return nil, err
+	}
+	tokens := &Tokens{
+		storage:    storage,
+		secret:     []byte(cfg.TokenSecret),
+		accessTTL:  cfg.AccessTokenTTL,
+		refreshTTL: cfg.RefreshTokenTTL,
+		revoked:    make(map[string]time.Time),
+	}
+	return tokens, tokens.SyncRevoked()
+}
+
+// checkTokenSecret не дает запустить сервис с пустым, слишком коротким или известным по старому конфигу ключом подписи
+func checkTokenSecret(secret string) error {
+	switch {
+	case secret == "":
+		return errors.New("token secret is not set, use QUESTS_TOKEN_SECRET")
+	case secret == defaultTokenSecret:
+		return errors.New("token secret is the default from config.yml, set your own in QUESTS_TOKEN_SECRET")
+	case len(secret) < minTokenSecretLength:
+		return fmt.Errorf("token secret must be at least %d bytes long", minTokenSecretLength)
+	}
+	return nil
+}
+
+// SyncRevoked перечитывает из БД список отозванных и еще не истекших access токенов
+func (tokens *Tokens) SyncRevoked() error {
+	var rows []struct {
+		Jti       string    `db:"jti"`
+		ExpiresAt time.Time `db:"expires_at"`
+	}
+	err := tokens.storage.DB.Select("jti", "expires_at").From("revoked_tokens").
+		Where(dbx.NewExp("expires_at > now()")).All(&rows)
+	if err != nil {
+		return fmt.Errorf("select script 'revoked_tokens' complete with error: %s", err)
+	}
+
+	revoked := make(map[string]time.Time, len(rows))
+	for _, row := range rows {
+		revoked[row.Jti] = row.ExpiresAt
+	}
+	tokens.mu.Lock()
+	tokens.revoked = revoked
+	tokens.mu.Unlock()
+	return nil
+}
+
+// Issue выпускает новую пару токенов для пользователя
+func (tokens *Tokens) Issue(user users.User) (TokenPair, error) {
+	return tokens.issue(tokens.storage.DB, user)
+}
+
+func (tokens *Tokens) issue(db dbx.Builder, user users.User) (TokenPair, error) {
+	//роли и разрешения записываются в токен, чтобы не обращаться к БД при каждом запросе.
+	//При их изменении токены пользователя отзываются по access_jti (storages.RevokeAccessTokens)
+	access, err := storages.GetUserAccess(db, user.Id)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	now := time.Now()
+	jti, err := randomToken(16)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	claims := accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   fmt.Sprint(user.Id),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(tokens.accessTTL)),
+		},
+		Username:    user.Username,
+		Roles:       access.Roles,
+		Permissions: access.Permissions,
+	}
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(tokens.secret)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	_, err = db.Insert("refresh_tokens", dbx.Params{
+		"user_id":           user.Id,
+		"token_hash":        hashToken(refreshToken),
+		"expires_at":        now.Add(tokens.refreshTTL),
+		"access_jti":        jti,
+		"access_expires_at": claims.ExpiresAt.Time,
+	}).Execute()
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("insert script 'refresh_tokens' complete with error: %s", err)
+	}
+
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(tokens.accessTTL.Seconds()),
+	}, nil
+}
+
+// Refresh обменивает действующий refresh токен на новую пару токенов. Использованный refresh токен отзывается
+func (tokens *Tokens) Refresh(refreshToken string) (TokenPair, error) {
+	var pair TokenPair
+	err := tokens.storage.DB.Transactional(func(tx *dbx.Tx) error {
+		var userId int
+		err := tx.NewQuery(`UPDATE refresh_tokens SET revoked_at = now()
+							WHERE token_hash = {:hash} AND revoked_at IS NULL AND expires_at > now()
+							RETURNING user_id`).
+			Bind(dbx.Params{"hash": hashToken(refreshToken)}).Row(&userId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		var user users.User
+		err = tx.Select().From(user.TableName()).Where(dbx.HashExp{"id": userId}).One(&user)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		pair, err = tokens.issue(tx, user)
+		return err
+	})
+	return pair, err
+}
+
+// Revoke отзывает access токен и, если передан, refresh токен. Список отозванных токенов в памяти
+// обновляется только после фиксации транзакции
+func (tokens *Tokens) Revoke(claims *accessClaims, refreshToken string) error {
+	err := tokens.storage.DB.Transactional(func(tx *dbx.Tx) error {
+		_, err := tx.NewQuery(`INSERT INTO revoked_tokens (jti, expires_at) VALUES ({:jti}, {:expires})
+								ON CONFLICT (jti) DO NOTHING`).
+			Bind(dbx.Params{"jti": claims.ID, "expires": claims.ExpiresAt.Time}).Execute()
+		if err != nil {
+			return err
+		}
+		if refreshToken != "" {
+			_, err = tx.NewQuery(`UPDATE refresh_tokens SET revoked_at = now()
+								WHERE token_hash = {:hash} AND user_id = {:userid} AND revoked_at IS NULL`).
+				Bind(dbx.Params{"hash": hashToken(refreshToken), "userid": claims.Subject}).Execute()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tokens.mu.Lock()
+	tokens.revoked[claims.ID] = claims.ExpiresAt.Time
+	tokens.mu.Unlock()
+	return nil
+}
+
+// Parse проверяет подпись, срок действия и отзыв access токена
+func (tokens *Tokens) Parse(accessToken string) (*accessClaims, error) {
+	claims := &accessClaims{}
+	_, err := jwt.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return tokens.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	tokens.mu.RLock()
+	_, revoked := tokens.revoked[claims.ID]
+	tokens.mu.RUnlock()
+	if revoked {
+		return nil, errRevokedToken
+	}
+	return claims, nil
+}
+
+// principal возвращает авторизованного пользователя по содержимому токена
+func (claims *accessClaims) principal() (storages.Principal, error) {
+	var userId int
+	if _, err := fmt.Sscan(claims.Subject, &userId); err != nil {
+		return storages.Principal{}, errInvalidToken
+	}
+	return storages.Principal{UserId: userId, Username: claims.Username, Roles: claims.Roles, Permissions: claims.Permissions}, nil
+}
+
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/golang-jwt/jwt/v5"
+	_ "github.com/lib/pq"
+	"techno-test_quests/quests/config"
+	users "techno-test_quests/quests/handlers/user"
+	storages "techno-test_quests/quests/storage"
+)
+
+// Тесты с БД выполняются против тестовой БД, строка подключения задается переменной окружения QUESTS_TEST_DB.
+// Пример: QUESTS_TEST_DB="host=localhost port=5432 user=adminPG password=1Qwerty2$ dbname=quests_test sslmode=disable"
+
+const testSecret = "test-secret-that-is-long-enough-for-hs256"
+
+var testAuthConfig = config.Auth{
+	BasicAuthEnabled: true,
+	TokenSecret:      testSecret,
+	AccessTokenTTL:   time.Minute,
+	RefreshTokenTTL:  time.Hour,
+}
+
+// newUnitTokens возвращает сервис токенов без БД, достаточный для проверки access токенов
+func newUnitTokens() *Tokens {
+	return &Tokens{secret: []byte(testSecret), accessTTL: time.Minute, revoked: make(map[string]time.Time)}
+}
+
+// signTestToken подписывает access токен пользователя 1 методом method и ключом secret
+func signTestToken(t *testing.T, method jwt.SigningMethod, secret any, jti string, expiresAt *jwt.NumericDate) string {
+	t.Helper()
+	claims := accessClaims{RegisteredClaims: jwt.RegisteredClaims{ID: jti, Subject: "1", ExpiresAt: expiresAt}}
+	token, err := jwt.NewWithClaims(method, claims).SignedString(secret)
+	if err != nil {
+		t.Fatalf("sign token: %s", err)
+	}
+	return token
+}
+
+func TestParseChecksSignatureAndExpiration(t *testing.T) {
+	tokens := newUnitTokens()
+	inMinute := jwt.NewNumericDate(time.Now().Add(time.Minute))
+
+	claims, err := tokens.Parse(signTestToken(t, jwt.SigningMethodHS256, []byte(testSecret), "valid", inMinute))
+	if err != nil {
+		t.Fatalf("valid token: %s", err)
+	}
+	if principal, err := claims.principal(); err != nil || principal.UserId != 1 {
+		t.Fatalf("principal %+v, %v, want user 1", principal, err)
+	}
+
+	for name, token := range map[string]string{
+		"wrong secret": signTestToken(t, jwt.SigningMethodHS256, []byte("another-secret-that-is-long-enough"), "a", inMinute),
+		"other method": signTestToken(t, jwt.SigningMethodHS512, []byte(testSecret), "b", inMinute),
+		"unsigned":     signTestToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "c", inMinute),
+		"expired":      signTestToken(t, jwt.SigningMethodHS256, []byte(testSecret), "d", jwt.NewNumericDate(time.Now().Add(-time.Minute))),
+		"no expiry":    signTestToken(t, jwt.SigningMethodHS256, []byte(testSecret), "e", nil),
+		"garbage":      "not.a.token",
+	} {
+		if _, err := tokens.Parse(token); !errors.Is(err, errInvalidToken) {
+			t.Errorf("%s: error %v, want %v", name, err, errInvalidToken)
+		}
+	}
+}
+
+func TestParseRejectsRevokedToken(t *testing.T) {
+	tokens := newUnitTokens()
+	token := signTestToken(t, jwt.SigningMethodHS256, []byte(testSecret), "revoked-jti", jwt.NewNumericDate(time.Now().Add(time.Minute)))
+	tokens.revoked["revoked-jti"] = time.Now().Add(time.Minute)
+
+	if _, err := tokens.Parse(token); !errors.Is(err, errRevokedToken) {
+		t.Fatalf("error %v, want %v", err, errRevokedToken)
+	}
+}
+
+func TestCheckTokenSecret(t *testing.T) {
+	for secret, valid := range map[string]bool{
+		"":                       false,
+		defaultTokenSecret:       false,
+		"short":                  false,
+		testSecret:               true,
+		string(make([]byte, 32)): true,
+	} {
+		if err := checkTokenSecret(secret); (err == nil) != valid {
+			t.Errorf("secret %q: error %v, want valid=%v", secret, err, valid)
+		}
+	}
+}
+
+type testFixture struct {
+	storage *storages.Storage
+	user    users.User
+}
+
+func newTestFixture(t testing.TB) *testFixture {
+	t.Helper()
+	dsn := os.Getenv("QUESTS_TEST_DB")
+	if dsn == "" {
+		t.Skip("QUESTS_TEST_DB is not set")
+	}
+
+	storage, err := storages.New(dsn)
+	if err != nil {
+		t.Fatalf("connect to test database: %s", err)
+	}
+	if err = storage.Init(); err != nil {
+		t.Fatalf("init test database: %s", err)
+	}
+
+	f := &testFixture{storage: storage}
+	t.Cleanup(func() {
+		storage.DB.Close()
+	})
+	f.user = f.addUser(t, "t")
+	return f
+}
+
+// addUser добавляет пользователя без ролей, имя пользователя - prefix с уникальным суффиксом
+func (f *testFixture) addUser(t testing.TB, prefix string) users.User {
+	t.Helper()
+	user := users.User{Username: prefix + strconv.FormatInt(time.Now().UnixNano()%1e12, 36)}
+	err := f.storage.DB.NewQuery("INSERT INTO users (username, password, isadmin) VALUES ({:username}, '-', false) RETURNING id").
+		Bind(dbx.Params{"username": user.Username}).Row(&user.Id)
+	if err != nil {
+		t.Fatalf("insert user: %s", err)
+	}
+	t.Cleanup(func() {
+		f.storage.DB.Delete("users", dbx.HashExp{"id": user.Id}).Execute()
+	})
+	return user
+}
+
+func TestTokensIssueRefreshRevoke(t *testing.T) {
+	f := newTestFixture(t)
+	tokens, err := NewTokens(testAuthConfig, f.storage)
+	if err != nil {
+		t.Fatalf("NewTokens: %s", err)
+	}
+
+	pair, err := tokens.Issue(f.user)
+	if err != nil {
+		t.Fatalf("Issue: %s", err)
+	}
+	if _, err = tokens.Parse(pair.AccessToken); err != nil {
+		t.Fatalf("Parse issued token: %s", err)
+	}
+
+	//refresh токен одноразовый
+	refreshed, err := tokens.Refresh(pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %s", err)
+	}
+	if _, err = tokens.Refresh(pair.RefreshToken); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("reused refresh token: error %v, want %v", err, errInvalidToken)
+	}
+
+	claims, err := tokens.Parse(refreshed.AccessToken)
+	if err != nil {
+		t.Fatalf("Parse refreshed token: %s", err)
+	}
+	t.Cleanup(func() {
+		f.storage.DB.Delete("revoked_tokens", dbx.HashExp{"jti": claims.ID}).Execute()
+	})
+	if err = tokens.Revoke(claims, refreshed.RefreshToken); err != nil {
+		t.Fatalf("Revoke: %s", err)
+	}
+	if _, err = tokens.Parse(refreshed.AccessToken); !errors.Is(err, errRevokedToken) {
+		t.Fatalf("revoked access token: error %v, want %v", err, errRevokedToken)
+	}
+	if _, err = tokens.Refresh(refreshed.RefreshToken); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("revoked refresh token: error %v, want %v", err, errInvalidToken)
+	}
+
+	//другой экземпляр сервиса получает отозванный токен из БД
+	other, err := NewTokens(testAuthConfig, f.storage)
+	if err != nil {
+		t.Fatalf("NewTokens: %s", err)
+	}
+	if _, err = other.Parse(refreshed.AccessToken); !errors.Is(err, errRevokedToken) {
+		t.Fatalf("revoked access token in another instance: error %v, want %v", err, errRevokedToken)
+	}
+}
@@ -0,0 +1,415 @@
+package badge
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+	"time"
+	"unicode/utf8"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Значки выдаются автоматически при записи выполнения шага (storage.AwardBadges), если выполнено правило значка.
+// Правила хранятся в таблице badges и проверяются функцией badge_rule_met, поэтому одно и то же правило
+// используется при выдаче значков и при пробной проверке по существующей истории. Завершение командного задания
+// проверяет правила у всех участников команды, а first_to_finish командного задания получает участник, выполнивший последний шаг.
+// Значки не удаляются, а архивируются: архивный значок больше не выдается, но остается у получивших его
+
+var errBadgeNotExists = errors.New("значок не существует")
+
+// Размер списка пользователей при пробной проверке правила по умолчанию
+const defaultDryRunLimit = 50
+
+// Badge model info
+// @Description Badge значок и правило его выдачи
+type Badge struct {
+	Id           int        `json:"id" db:"id"`                       //Идентификатор значка
+	Name         string     `json:"name" db:"name"`                   //Название
+	Description  string     `json:"description" db:"description"`     //Описание
+	RuleType     string     `json:"rule_type" db:"rule_type"`         //Тип правила: quests_completed, step_completions или first_to_finish
+	Threshold    int        `json:"threshold" db:"threshold"`         //Порог для quests_completed и step_completions
+	QuestId      *int       `json:"quest_id" db:"quest_id"`           //Задание для first_to_finish
+	StepId       *int       `json:"step_id" db:"step_id"`             //Шаг для step_completions, пусто - любые шаги
+	AwardedCount int        `json:"awarded_count" db:"awarded_count"` //Кол-во пользователей, получивших значок
+	ArchivedAt   *time.Time `json:"archived_at" db:"archived_at"`     //Время архивирования, пусто для действующего значка
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`       //Время создания
+}
+
+// NewBadge model info
+// @Description NewBadge json для создания значка или пробной проверки правила
+type NewBadge struct {
+	Name        string `json:"name"`        //Название, уникальное среди значков
+	Description string `json:"description"` //Описание
+	RuleType    string `json:"rule_type"`   //Тип правила: quests_completed, step_completions или first_to_finish
+	Threshold   int    `json:"threshold"`   //Порог для quests_completed и step_completions, больше 0
+	QuestId     *int   `json:"quest_id"`    //Задание, обязательно для first_to_finish
+	StepId      *int   `json:"step_id"`     //Шаг для step_completions, не указан - любые шаги
+}
+
+// BadgeDryRun model info
+// @Description BadgeDryRun результат пробной проверки правила по существующей истории
+type BadgeDryRun struct {
+	MatchedCount int              `json:"matched_count"` //Кол-во пользователей, для которых выполнено правило
+	Users        []BadgeCandidate `json:"users"`         //Первые пользователи, для которых выполнено правило
+}
+
+// BadgeCandidate пользователь, для которого выполнено правило значка
+type BadgeCandidate struct {
+	UserId   int    `json:"user_id" db:"user_id"`   //Идентификатор пользователя
+	Username string `json:"username" db:"username"` //Имя пользователя
+}
+
+// badgeSortColumns ключи сортировки списка значков
+var badgeSortColumns = map[string]string{
+	"id":   "id",
+	"name": "name",
+}
+
+// pathId возвращает идентификатор из пути запроса
+func pathId(r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// validateRule возвращает ошибки правила значка
+func (badge *NewBadge) validateRule() []response.FieldError {
+	var errlist []response.FieldError
+	switch badge.RuleType {
+	case storages.BadgeRuleQuestsCompleted, storages.BadgeRuleStepCompletions:
+		if badge.Threshold <= 0 {
+			errlist = append(errlist, response.Field("threshold", response.RulePositive))
+		}
+		if badge.QuestId != nil {
+			errlist = append(errlist, response.Field("quest_id", response.RuleOnlyForRule, storages.BadgeRuleFirstToFinish))
+		}
+		if badge.RuleType == storages.BadgeRuleQuestsCompleted && badge.StepId != nil {
+			errlist = append(errlist, response.Field("step_id", response.RuleOnlyForRule, storages.BadgeRuleStepCompletions))
+		}
+	case storages.BadgeRuleFirstToFinish:
+		if badge.QuestId == nil {
+			errlist = append(errlist, response.Field("quest_id", response.RuleRequiredFor, storages.BadgeRuleFirstToFinish))
+		}
+		if badge.StepId != nil {
+			errlist = append(errlist, response.Field("step_id", response.RuleOnlyForRule, storages.BadgeRuleStepCompletions))
+		}
+		badge.Threshold = 1
+	default:
+		errlist = append(errlist, response.Field("rule_type", response.RuleOneOf, "quests_completed, step_completions, first_to_finish"))
+	}
+	return errlist
+}
+
+// validate возвращает ошибки входных данных нового значка
+func (badge *NewBadge) validate() []response.FieldError {
+	var errlist []response.FieldError
+	if length := utf8.RuneCountInString(badge.Name); length == 0 || length > 200 {
+		errlist = append(errlist, response.Field("name", response.RuleLength, 1, 200))
+	}
+	return append(errlist, badge.validateRule()...)
+}
+
+// badgeColumns колонки значка с кол-вом получивших его пользователей
+func badgeColumns(db dbx.Builder) *dbx.SelectQuery {
+	return db.Select("b.id", "b.name", "b.description", "b.rule_type", "b.threshold", "b.quest_id", "b.step_id",
+		"b.archived_at", "b.created_at", "(SELECT count(*) FROM user_badges AS ub WHERE ub.badge_id = b.id) AS awarded_count").
+		From("badges AS b")
+}
+
+// findBadge возвращает значок, включая архивный
+func findBadge(db dbx.Builder, badgeId int) (Badge, error) {
+	var badge Badge
+	err := badgeColumns(db).Where(dbx.NewExp("b.id = {:id}", dbx.Params{"id": badgeId})).One(&badge)
+	if errors.Is(err, sql.ErrNoRows) {
+		return badge, errBadgeNotExists
+	}
+	return badge, err
+}
+
+// writeBadgeError отвечает на ошибку изменения значка
+func writeBadgeError(w http.ResponseWriter, r *http.Request, logger *slog.Logger, err error, message string) {
+	switch {
+	case errors.Is(err, errBadgeNotExists):
+		response.Error(w, r, http.StatusNotFound, response.CodeBadgeNotFound)
+	case storages.ConstraintName(err) == "badges_name_key":
+		response.Error(w, r, http.StatusConflict, response.CodeBadgeExists)
+	case storages.ConstraintName(err) == "badges_quest_id_fkey":
+		response.Error(w, r, http.StatusNotFound, response.CodeQuestNotFound)
+	case storages.ConstraintName(err) == "badges_step_id_fkey":
+		response.Error(w, r, http.StatusNotFound, response.CodeStepNotFound)
+	default:
+		logger.Error(message, "error", err.Error())
+		response.Internal(w, r)
+	}
+}
+
+// writeBadges отвечает значками пользователя
+func writeBadges(storage *storages.Storage, logger *slog.Logger, w http.ResponseWriter, r *http.Request, userId int) {
+	badges, err := storages.UserBadges(storage.DB, userId)
+	if err != nil {
+		logger.Error("get user badges failed", "error", err.Error())
+		response.Internal(w, r)
+		return
+	}
+	response.JSON(w, http.StatusOK, badges)
+}
+
+// @Summary Список значков
+// @Tags badges
+// @Description Возвращает страницу значков с правилами выдачи и кол-вом получивших их пользователей.
+// @Description Архивные значки показываются только с archived=true
+// @id ListBadges
+// @Accept json
+// @Procedure json
+// @router /badges [get]
+// @param limit query int false "кол-во значков на странице, по умолчанию 50, не больше 200"
+// @param cursor query string false "курсор следующей страницы"
+// @param sort query string false "сортировка, '-' в начале - по убыванию" Enums(id, -id, name, -name)
+// @param archived query bool false "включить архивные значки"
+// @Success 200 {object} storages.Page[Badge]
+// @Failure 400 {object} response.ErrorResponse "неверные параметры запроса"
+// @Security BasicAuth
+// @Security BearerAuth
+func ListBadges(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		query := r.URL.Query()
+		page, err := storages.ParsePageRequest(query, badgeSortColumns, "id")
+		var archived *bool
+		if err == nil {
+			archived, err = storages.ParseBoolParam(query, "archived")
+		}
+		if err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+
+		q := badgeColumns(storage.DB)
+		if archived == nil || !*archived {
+			q.AndWhere(dbx.NewExp("b.archived_at IS NULL"))
+		}
+
+		var badges []Badge
+		err = page.Apply(q, "b").All(&badges)
+		if err != nil {
+			logger.Error("list badges failed", "error", err.Error())
+			response.Internal(w, r)
+			return
+		}
+		response.JSON(w, http.StatusOK, storages.NewPage(page, badges, func(badge Badge) (string, int) {
+			if page.Sort == "name" {
+				return badge.Name, badge.Id
+			}
+			return "", badge.Id
+		}))
+	}
+}
+
+// @Summary Создать значок
+// @Tags badges
+// @Description Создает значок с правилом выдачи. Значок выдается при следующем выполнении шага пользователем, для которого выполнено правило.
+// @Description Проверить правило по существующей истории можно методом DryRunBadge
+// @id CreateBadge
+// @Accept json
+// @Procedure json
+// @router /badges [post]
+// @param input body NewBadge true "значок"
+// @Success 201 {object} Badge
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Failure 404 {object} response.ErrorResponse "задание или шаг не найдены"
+// @Failure 409 {object} response.ErrorResponse "значок с таким названием существует"
+// @Security BasicAuth
+// @Security BearerAuth
+func CreateBadge(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		var request NewBadge
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+		if errlist := request.validate(); len(errlist) > 0 {
+			response.Invalid(w, r, errlist)
+			return
+		}
+
+		var badgeId int
+		err := storage.DB.NewQuery(`INSERT INTO badges (name, description, rule_type, threshold, quest_id, step_id)
+									VALUES ({:name}, {:description}, {:rule_type}, {:threshold}, {:quest_id}, {:step_id})
+									RETURNING id`).
+			Bind(dbx.Params{"name": request.Name, "description": request.Description, "rule_type": request.RuleType,
+				"threshold": request.Threshold, "quest_id": request.QuestId, "step_id": request.StepId}).Row(&badgeId)
+		var badge Badge
+		if err == nil {
+			badge, err = findBadge(storage.DB, badgeId)
+		}
+		if err != nil {
+			writeBadgeError(w, r, logger, err, "create badge failed")
+			return
+		}
+		response.JSON(w, http.StatusCreated, badge)
+	}
+}
+
+// @Summary Архивировать значок
+// @Tags badges
+// @Description Прекращает выдачу значка. Пользователи, получившие значок, сохраняют его
+// @id ArchiveBadge
+// @Accept json
+// @Procedure json
+// @router /badges/{id}/archive [post]
+// @param id path int true "идентификатор значка"
+// @Success 200 {object} Badge
+// @Failure 404 {object} response.ErrorResponse "значок не найден"
+// @Security BasicAuth
+// @Security BearerAuth
+func ArchiveBadge(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		badgeId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+
+		_, err := storage.DB.NewQuery("UPDATE badges SET archived_at = coalesce(archived_at, now()) WHERE id = {:id}").
+			Bind(dbx.Params{"id": badgeId}).Execute()
+		var badge Badge
+		if err == nil {
+			badge, err = findBadge(storage.DB, badgeId)
+		}
+		if err != nil {
+			writeBadgeError(w, r, logger, err, "archive badge failed")
+			return
+		}
+		response.JSON(w, http.StatusOK, badge)
+	}
+}
+
+// @Summary Пробная проверка правила
+// @Tags badges
+// @Description Проверяет правило значка по существующей истории и возвращает пользователей, которые получили бы значок.
+// @Description Значок не создается и не выдается, название в запросе не обязательно
+// @id DryRunBadge
+// @Accept json
+// @Procedure json
+// @router /badges/dry-run [post]
+// @param input body NewBadge true "правило значка"
+// @param limit query int false "кол-во пользователей в ответе, по умолчанию 50, не больше 200"
+// @Success 200 {object} BadgeDryRun
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Security BasicAuth
+// @Security BearerAuth
+func DryRunBadge(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		limit := defaultDryRunLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			var err error
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit <= 0 || limit > storages.MaxPageLimit {
+				response.InvalidParam(w, r, "limit", response.RuleRange, 1, storages.MaxPageLimit)
+				return
+			}
+		}
+		var request NewBadge
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+		if errlist := request.validateRule(); len(errlist) > 0 {
+			response.Invalid(w, r, errlist)
+			return
+		}
+
+		dryRun := BadgeDryRun{Users: []BadgeCandidate{}}
+		params := dbx.Params{"rule_type": request.RuleType, "threshold": request.Threshold, "quest_id": request.QuestId,
+			"step_id": request.StepId, "limit": limit}
+		var rows []struct {
+			BadgeCandidate
+			Matched int `db:"matched"`
+		}
+		err := storage.DB.NewQuery(`SELECT u.id AS user_id, u.username, count(*) OVER () AS matched
+									FROM users AS u
+									WHERE badge_rule_met({:rule_type}, {:threshold}, {:quest_id}, {:step_id}, u.id)
+									ORDER BY u.id
+									LIMIT {:limit}`).Bind(params).All(&rows)
+		if err != nil {
+			logger.Error("badge dry run failed", "error", err.Error())
+			response.Internal(w, r)
+			return
+		}
+		for _, row := range rows {
+			dryRun.MatchedCount = row.Matched
+			dryRun.Users = append(dryRun.Users, row.BadgeCandidate)
+		}
+		response.JSON(w, http.StatusOK, dryRun)
+	}
+}
+
+// @Summary Значки пользователя
+// @Tags badges
+// @Description Возвращает значки, выданные пользователю, в порядке выдачи
+// @id GetUserBadges
+// @Accept json
+// @Procedure json
+// @router /users/{id}/badges [get]
+// @param id path int true "идентификатор пользователя"
+// @Success 200 {array} storage.UserBadge
+// @Failure 404 {object} response.ErrorResponse "пользователь не найден"
+// @Security BasicAuth
+// @Security BearerAuth
+func GetUserBadges(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		userId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+		var exists bool
+		err := storage.DB.NewQuery("SELECT EXISTS (SELECT 1 FROM users WHERE id = {:id})").Bind(dbx.Params{"id": userId}).Row(&exists)
+		if err != nil {
+			logger.Error("get user badges failed", "error", err.Error())
+			response.Internal(w, r)
+			return
+		}
+		if !exists {
+			response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound)
+			return
+		}
+		writeBadges(storage, logger, w, r, userId)
+	}
+}
+
+// @Summary Мои значки
+// @Tags me
+// @Description Возвращает значки авторизованного пользователя в порядке выдачи
+// @id GetMyBadges
+// @Accept json
+// @Procedure json
+// @router /me/badges [get]
+// @Success 200 {array} storage.UserBadge
+// @Security BasicAuth
+// @Security BearerAuth
+func GetMyBadges(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		principal, ok := storages.PrincipalFromContext(r.Context())
+		if !ok {
+			response.Error(w, r, http.StatusUnauthorized, response.CodeUnauthorized)
+			return
+		}
+		writeBadges(storage, logger, w, r, principal.UserId)
+	}
+}
@@ -0,0 +1,204 @@
+package badge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"techno-test_quests/quests/handlers/history"
+	"techno-test_quests/quests/internal/testdb"
+	storages "techno-test_quests/quests/storage"
+)
+
+// Тесты выполняются против тестовой БД, строка подключения задается переменной окружения QUESTS_TEST_DB (см. testdb)
+
+type testFixture struct {
+	storage *storages.Storage
+	mux     *http.ServeMux
+	suffix  string
+	userId  int
+	otherId int
+	questId int
+	stepId  int
+}
+
+func newTestFixture(t testing.TB) *testFixture {
+	t.Helper()
+	storage := testdb.Open(t)
+
+	//маршруты регистрируются так же, как в main, но без проверки авторизации
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /badges/dry-run", DryRunBadge(storage, logger))
+	mux.HandleFunc("GET /users/{id}/badges", GetUserBadges(storage, logger))
+	mux.HandleFunc("POST /CompleteSteps", history.CompleteSteps(storage, logger))
+
+	f := &testFixture{storage: storage, mux: mux, suffix: testdb.Suffix()}
+	f.userId = testdb.User(t, storage, "u"+f.suffix)
+	f.otherId = testdb.User(t, storage, "o"+f.suffix)
+	f.questId = testdb.Insert(t, storage, "quests", dbx.Params{"questname": "quest " + f.suffix})
+	f.stepId = testdb.Insert(t, storage, "queststeps", dbx.Params{"questid": f.questId, "stepname": "step", "bonus": 10, "ismulti": true})
+	testdb.Delete(t, storage, "history", dbx.HashExp{"stepid": f.stepId})
+	return f
+}
+
+func (f *testFixture) serve(method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	f.mux.ServeHTTP(w, r)
+	return w
+}
+
+// badge добавляет значок с правилом rule, название - prefix с суффиксом теста
+func (f *testFixture) badge(t *testing.T, prefix string, rule dbx.Params) int {
+	t.Helper()
+	rule["name"] = prefix + " " + f.suffix
+	return testdb.Insert(t, f.storage, "badges", rule)
+}
+
+// complete выполняет шаг stepId пользователем userId и возвращает идентификаторы значков, выданных за выполнение
+func (f *testFixture) complete(t *testing.T, stepId, userId int) []int {
+	t.Helper()
+	w := f.serve(http.MethodPost, "/CompleteSteps", fmt.Sprintf(`{"CompleteSteps":[{"stepid":%d,"userid":%d}]}`, stepId, userId))
+	var result history.CompleteStepsResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || !result.Committed || len(result.Items) != 1 {
+		t.Fatalf("CompleteSteps: status %d, body %s", w.Code, w.Body.String())
+	}
+	var badgeIds []int
+	for _, badge := range result.Items[0].Badges {
+		badgeIds = append(badgeIds, badge.BadgeId)
+	}
+	return badgeIds
+}
+
+// userBadges возвращает идентификаторы значков пользователя
+func (f *testFixture) userBadges(t *testing.T, userId int) []int {
+	t.Helper()
+	w := f.serve(http.MethodGet, fmt.Sprintf("/users/%d/badges", userId), "")
+	var badges []storages.UserBadge
+	if err := json.Unmarshal(w.Body.Bytes(), &badges); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GetUserBadges: status %d, body %s", w.Code, w.Body.String())
+	}
+	var badgeIds []int
+	for _, badge := range badges {
+		badgeIds = append(badgeIds, badge.BadgeId)
+	}
+	return badgeIds
+}
+
+func TestCompleteStepsAwardsBadgesOnce(t *testing.T) {
+	f := newTestFixture(t)
+	repeatId := f.badge(t, "repeat", dbx.Params{"rule_type": storages.BadgeRuleStepCompletions, "threshold": 2, "step_id": f.stepId})
+	firstId := f.badge(t, "first", dbx.Params{"rule_type": storages.BadgeRuleFirstToFinish, "quest_id": f.questId})
+
+	for _, test := range []struct {
+		userId int
+		want   []int
+	}{
+		{f.userId, []int{firstId}},
+		{f.userId, []int{repeatId}},
+		{f.userId, nil},
+		{f.otherId, nil},
+		{f.otherId, []int{repeatId}},
+	} {
+		if got := f.complete(t, f.stepId, test.userId); fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Fatalf("user %d: badges %v, want %v", test.userId, got, test.want)
+		}
+	}
+
+	if got := f.userBadges(t, f.userId); len(got) != 2 {
+		t.Fatalf("user badges %v, want 2", got)
+	}
+}
+
+func TestDryRunBadgeMatchesHistory(t *testing.T) {
+	f := newTestFixture(t)
+	f.complete(t, f.stepId, f.userId)
+	f.complete(t, f.stepId, f.userId)
+	f.complete(t, f.stepId, f.otherId)
+
+	for _, test := range []struct {
+		threshold int
+		want      []int
+	}{
+		{1, []int{f.userId, f.otherId}},
+		{2, []int{f.userId}},
+		{3, nil},
+	} {
+		w := f.serve(http.MethodPost, "/badges/dry-run",
+			fmt.Sprintf(`{"rule_type":%q,"threshold":%d,"step_id":%d}`, storages.BadgeRuleStepCompletions, test.threshold, f.stepId))
+		var dryRun BadgeDryRun
+		if err := json.Unmarshal(w.Body.Bytes(), &dryRun); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("DryRunBadge: status %d, body %s", w.Code, w.Body.String())
+		}
+		var got []int
+		for _, user := range dryRun.Users {
+			got = append(got, user.UserId)
+		}
+		if dryRun.MatchedCount != len(test.want) || fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Fatalf("threshold %d: matched %d %v, want %v", test.threshold, dryRun.MatchedCount, got, test.want)
+		}
+	}
+}
+
+func TestTeamQuestAwardsBadgesToMembers(t *testing.T) {
+	f := newTestFixture(t)
+	idleId := testdb.User(t, f.storage, "i"+f.suffix)
+	//команда добавляется раньше задания: история и записи журнала со ссылкой на команду удаляются вместе с шагами и заданием
+	teamId := testdb.Insert(t, f.storage, "teams", dbx.Params{"name": "team " + f.suffix})
+	questId := testdb.Insert(t, f.storage, "quests", dbx.Params{"questname": "team quest " + f.suffix, "team_scoped": true})
+	stepIds := make([]int, 2)
+	for i := range stepIds {
+		stepIds[i] = testdb.Insert(t, f.storage, "queststeps", dbx.Params{"questid": questId, "stepname": "step " + strconv.Itoa(i),
+			"bonus": 10, "ismulti": false, "max_completions": 1})
+	}
+	testdb.Delete(t, f.storage, "history", dbx.HashExp{"team_id": teamId})
+	for _, userId := range []int{f.userId, f.otherId, idleId} {
+		if _, err := f.storage.DB.Insert("team_members", dbx.Params{"team_id": teamId, "user_id": userId}).Execute(); err != nil {
+			t.Fatalf("add team member: %s", err)
+		}
+	}
+	firstId := f.badge(t, "first", dbx.Params{"rule_type": storages.BadgeRuleFirstToFinish, "quest_id": questId})
+	doneId := f.badge(t, "done", dbx.Params{"rule_type": storages.BadgeRuleQuestsCompleted, "threshold": 1})
+
+	//фильтруем значки теста: в тестовой БД могут быть действующие значки других тестов
+	own := func(badgeIds []int) []int {
+		var result []int
+		for _, badgeId := range badgeIds {
+			if badgeId == firstId || badgeId == doneId {
+				result = append(result, badgeId)
+			}
+		}
+		return result
+	}
+
+	if got := own(f.complete(t, stepIds[0], f.userId)); got != nil {
+		t.Fatalf("first step: badges %v, want none", got)
+	}
+	//последний шаг выполняет участник с большим идентификатором: «первым завершил» получает он, а не участник с меньшим
+	if got := own(f.complete(t, stepIds[1], f.otherId)); fmt.Sprint(got) != fmt.Sprint([]int{firstId, doneId}) {
+		t.Fatalf("last step: badges %v, want %v", got, []int{firstId, doneId})
+	}
+	//завершенное командой задание засчитано остальным участникам, в том числе не выполнявшим шагов
+	for _, userId := range []int{f.userId, idleId} {
+		if got := own(f.userBadges(t, userId)); fmt.Sprint(got) != fmt.Sprint([]int{doneId}) {
+			t.Fatalf("member %d: badges %v, want %v", userId, got, []int{doneId})
+		}
+	}
+
+	w := f.serve(http.MethodPost, "/badges/dry-run", fmt.Sprintf(`{"rule_type":%q,"quest_id":%d}`, storages.BadgeRuleFirstToFinish, questId))
+	var dryRun BadgeDryRun
+	if err := json.Unmarshal(w.Body.Bytes(), &dryRun); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("DryRunBadge: status %d, body %s", w.Code, w.Body.String())
+	}
+	if dryRun.MatchedCount != 1 || dryRun.Users[0].UserId != f.otherId {
+		t.Fatalf("first to finish dry run %+v, want only user %d", dryRun, f.otherId)
+	}
+}
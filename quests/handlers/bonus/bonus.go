@@ -0,0 +1,359 @@
+package bonus
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+	"unicode/utf8"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Бонусы начисляются автоматически при выполнении шагов и завершении заданий. Через API журнал только читается,
+// корректируется вручную и дополняется записями, отменяющими ошибочные начисления и списания
+
+// UserBalance model info
+// @Description UserBalance бонусный баланс пользователя
+type UserBalance struct {
+	UserId  int `json:"user_id"` //Идентификатор пользователя
+	Balance int `json:"balance"` //Баланс: сумма начислений за вычетом списаний
+}
+
+// AdjustmentRequest model info
+// @Description AdjustmentRequest json для ручной корректировки баланса
+type AdjustmentRequest struct {
+	Amount  int    `json:"amount"`  //Сумма: больше 0 - начисление, меньше 0 - списание
+	Comment string `json:"comment"` //Причина корректировки, обязательна
+}
+
+// ReverseRequest model info
+// @Description ReverseRequest json для отмены записи журнала бонусов
+type ReverseRequest struct {
+	Comment string `json:"comment"` //Причина отмены
+}
+
+// ledgerSortColumns ключи сортировки журнала бонусов
+var ledgerSortColumns = map[string]string{
+	"id": "id",
+}
+
+// pathId возвращает идентификатор из пути запроса
+func pathId(r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// validComment проверяет длину комментария, в БД под него отведено 200 символов
+func validComment(comment string) bool {
+	return utf8.RuneCountInString(comment) <= 200
+}
+
+// principalId возвращает идентификатор авторизованного пользователя для поля created_by
+func principalId(r *http.Request) *int {
+	if principal, ok := storages.PrincipalFromContext(r.Context()); ok {
+		return &principal.UserId
+	}
+	return nil
+}
+
+// userExists возвращает true, если пользователь существует
+func userExists(db dbx.Builder, userId int) (bool, error) {
+	var exists bool
+	err := db.NewQuery("SELECT EXISTS (SELECT 1 FROM users WHERE id = {:id})").Bind(dbx.Params{"id": userId}).Row(&exists)
+	return exists, err
+}
+
+// @Summary Бонусный баланс пользователя
+// @Tags bonus
+// @Description Возвращает баланс пользователя по журналу бонусов
+// @id GetUserBalance
+// @Accept json
+// @Procedure json
+// @router /users/{id}/balance [get]
+// @param id path int true "идентификатор пользователя"
+// @Success 200 {object} UserBalance
+// @Failure 404 {object} response.ErrorResponse "пользователь не найден"
+// @Security BasicAuth
+// @Security BearerAuth
+func GetUserBalance(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		userId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+		exists, err := userExists(storage.DB, userId)
+		if err != nil {
+			logger.Error("get balance failed", "error", err.Error())
+			response.Internal(w, r)
+			return
+		}
+		if !exists {
+			response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound)
+			return
+		}
+		writeBalance(storage, logger, w, r, userId)
+	}
+}
+
+// @Summary Мой бонусный баланс
+// @Tags me
+// @Description Возвращает баланс авторизованного пользователя по журналу бонусов
+// @id GetMyBalance
+// @Accept json
+// @Procedure json
+// @router /me/balance [get]
+// @Success 200 {object} UserBalance
+// @Security BasicAuth
+// @Security BearerAuth
+func GetMyBalance(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		principal, ok := storages.PrincipalFromContext(r.Context())
+		if !ok {
+			response.Error(w, r, http.StatusUnauthorized, response.CodeUnauthorized)
+			return
+		}
+		writeBalance(storage, logger, w, r, principal.UserId)
+	}
+}
+
+func writeBalance(storage *storages.Storage, logger *slog.Logger, w http.ResponseWriter, r *http.Request, userId int) {
+	balance, err := storages.Balance(storage.DB, userId)
+	if err != nil {
+		logger.Error("get balance failed", "error", err.Error())
+		response.Internal(w, r)
+		return
+	}
+	response.JSON(w, http.StatusOK, UserBalance{UserId: userId, Balance: balance})
+}
+
+// @Summary Журнал бонусов пользователя
+// @Tags bonus
+// @Description Возвращает страницу записей журнала бонусов пользователя: начисления, списания, корректировки и отмены
+// @id GetUserLedger
+// @Accept json
+// @Procedure json
+// @router /users/{id}/ledger [get]
+// @param id path int true "идентификатор пользователя"
+// @param limit query int false "кол-во записей на странице, по умолчанию 50, не больше 200"
+// @param cursor query string false "курсор следующей страницы"
+// @param sort query string false "сортировка, '-' в начале - по убыванию" Enums(id, -id)
+// @param kind query string false "вид записи" Enums(step, quest, redemption, adjustment, reversal)
+// @Success 200 {object} storages.Page[storage.LedgerEntry]
+// @Failure 400 {object} response.ErrorResponse "неверные параметры запроса"
+// @Failure 404 {object} response.ErrorResponse "пользователь не найден"
+// @Security BasicAuth
+// @Security BearerAuth
+func GetUserLedger(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		userId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+		exists, err := userExists(storage.DB, userId)
+		if err != nil {
+			logger.Error("get ledger failed", "error", err.Error())
+			response.Internal(w, r)
+			return
+		}
+		if !exists {
+			response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound)
+			return
+		}
+		writeLedger(storage, logger, w, r, userId)
+	}
+}
+
+// @Summary Мой журнал бонусов
+// @Tags me
+// @Description Возвращает страницу записей журнала бонусов авторизованного пользователя. Параметры такие же, как в GetUserLedger
+// @id GetMyLedger
+// @Accept json
+// @Procedure json
+// @router /me/ledger [get]
+// @param limit query int false "кол-во записей на странице, по умолчанию 50, не больше 200"
+// @param cursor query string false "курсор следующей страницы"
+// @param sort query string false "сортировка, '-' в начале - по убыванию" Enums(id, -id)
+// @param kind query string false "вид записи" Enums(step, quest, redemption, adjustment, reversal)
+// @Success 200 {object} storages.Page[storage.LedgerEntry]
+// @Failure 400 {object} response.ErrorResponse "неверные параметры запроса"
+// @Security BasicAuth
+// @Security BearerAuth
+func GetMyLedger(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		principal, ok := storages.PrincipalFromContext(r.Context())
+		if !ok {
+			response.Error(w, r, http.StatusUnauthorized, response.CodeUnauthorized)
+			return
+		}
+		writeLedger(storage, logger, w, r, principal.UserId)
+	}
+}
+
+func writeLedger(storage *storages.Storage, logger *slog.Logger, w http.ResponseWriter, r *http.Request, userId int) {
+	query := r.URL.Query()
+	page, err := storages.ParsePageRequest(query, ledgerSortColumns, "id")
+	if err != nil {
+		response.BadRequest(w, r, err)
+		return
+	}
+
+	q := storage.DB.Select(storages.LedgerColumns("l")...).From("bonus_ledger AS l").
+		Where(dbx.HashExp{"l.user_id": userId})
+	if kind := query.Get("kind"); kind != "" {
+		q.AndWhere(dbx.HashExp{"l.kind": kind})
+	}
+	var entries []storages.LedgerEntry
+	err = page.Apply(q, "l").All(&entries)
+	if err != nil {
+		logger.Error("get ledger failed", "error", err.Error())
+		response.Internal(w, r)
+		return
+	}
+	response.JSON(w, http.StatusOK, storages.NewPage(page, entries, func(entry storages.LedgerEntry) (string, int) {
+		return "", entry.Id
+	}))
+}
+
+// @Summary Корректировка баланса
+// @Tags bonus
+// @Description Добавляет в журнал ручное начисление (amount больше 0) или списание (amount меньше 0).
+// @Description Списание не может сделать баланс отрицательным
+// @id AdjustBalance
+// @Accept json
+// @Procedure json
+// @router /users/{id}/ledger [post]
+// @param id path int true "идентификатор пользователя"
+// @param input body AdjustmentRequest true "сумма и причина корректировки"
+// @Success 201 {object} storage.LedgerEntry
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Failure 404 {object} response.ErrorResponse "пользователь не найден"
+// @Failure 409 {object} response.ErrorResponse "недостаточно бонусов для списания"
+// @Security BasicAuth
+// @Security BearerAuth
+func AdjustBalance(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		userId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+
+		var request AdjustmentRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+		if request.Amount == 0 {
+			response.Invalid(w, r, []response.FieldError{response.Field("amount", response.RuleNotZero)})
+			return
+		}
+		if request.Comment == "" || !validComment(request.Comment) {
+			response.Invalid(w, r, []response.FieldError{response.Field("comment", response.RuleLength, 1, 200)})
+			return
+		}
+
+		entry := storages.LedgerEntry{
+			UserId:    userId,
+			Amount:    request.Amount,
+			Kind:      storages.LedgerKindAdjustment,
+			Comment:   request.Comment,
+			CreatedBy: principalId(r),
+		}
+		err := storage.DB.Transactional(func(tx *dbx.Tx) error {
+			if entry.Amount < 0 {
+				return storages.Debit(tx, &entry)
+			}
+			err := storages.AddLedgerEntry(tx, &entry)
+			if storages.IsForeignKeyViolation(err) {
+				return storages.ErrUserNotFound
+			}
+			return err
+		})
+		switch {
+		case err == nil:
+			response.JSON(w, http.StatusCreated, entry)
+		case errors.Is(err, storages.ErrUserNotFound):
+			response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound)
+		case errors.Is(err, storages.ErrInsufficientBonus):
+			response.Error(w, r, http.StatusConflict, response.CodeInsufficientBonus)
+		default:
+			logger.Error("adjust balance failed", "error", err.Error())
+			response.Internal(w, r)
+		}
+	}
+}
+
+// @Summary Отменить запись журнала бонусов
+// @Tags bonus
+// @Description Добавляет запись reversal с противоположной суммой. Исходная запись не меняется.
+// @Description Каждую запись можно отменить один раз, отмену отменить нельзя. Отмена начисления может сделать баланс отрицательным.
+// @Description Списание за награду (redemption) отменить нельзя, бонусы за него возвращает отмена заявки
+// @id ReverseLedgerEntry
+// @Accept json
+// @Procedure json
+// @router /ledger/{id}/reverse [post]
+// @param id path int true "идентификатор записи журнала"
+// @param input body ReverseRequest false "причина отмены"
+// @Success 201 {object} storage.LedgerEntry
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Failure 404 {object} response.ErrorResponse "запись не найдена"
+// @Failure 409 {object} response.ErrorResponse "запись уже отменена, сама является отменой или списанием за награду"
+// @Security BasicAuth
+// @Security BearerAuth
+func ReverseLedgerEntry(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		entryId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+
+		var request ReverseRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+			response.BadRequest(w, r, err)
+			return
+		}
+		if !validComment(request.Comment) {
+			response.Invalid(w, r, []response.FieldError{response.Field("comment", response.RuleMaxLength, 200)})
+			return
+		}
+
+		var reversal storages.LedgerEntry
+		err := storage.DB.Transactional(func(tx *dbx.Tx) error {
+			var err error
+			reversal, err = storages.ReverseLedgerEntry(tx, entryId, principalId(r), request.Comment)
+			return err
+		})
+		switch {
+		case err == nil:
+			response.JSON(w, http.StatusCreated, reversal)
+		case errors.Is(err, storages.ErrLedgerEntryNotFound):
+			response.Error(w, r, http.StatusNotFound, response.CodeLedgerEntryNotFound)
+		case errors.Is(err, storages.ErrAlreadyReversed):
+			response.Error(w, r, http.StatusConflict, response.CodeAlreadyReversed)
+		case errors.Is(err, storages.ErrRedemptionEntry):
+			response.Error(w, r, http.StatusConflict, response.CodeRedemptionEntry)
+		default:
+			logger.Error("reverse ledger entry failed", "error", err.Error())
+			response.Internal(w, r)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package history
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/lib/pq"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+)
+
+// Режимы выполнения CompleteSteps
+const (
+	CompleteModeAll     = "all"     //все шаги записываются в одной транзакции, при любой ошибке не записывается ничего
+	CompleteModePartial = "partial" //записываются все шаги, прошедшие проверки
+)
+
+// Статусы обработки шага в CompleteSteps
+const (
+	StepStatusRecorded         = "recorded"              //выполнение шага записано
+	StepStatusDuplicate        = "duplicate"             //выполнение с тем же ключом идемпотентности уже записано, новая запись не создана
+	StepStatusRolledBack       = "rolled_back"           //шаг прошел проверки, но не записан, т.к. отклонены другие шаги пакета
+	StepStatusInvalid          = "invalid"               //неверные входные данные
+	StepStatusUnknownUser      = "unknown_user"          //пользователь не существует
+	StepStatusUnknownStep      = "unknown_step"          //шаг не существует
+	StepStatusAlreadyCompleted = "already_completed"     //пользователь выполнил шаг максимальное число раз
+	StepStatusCooldown         = "cooldown"              //не прошел интервал после предыдущего выполнения шага пользователем
+	StepStatusLimitReached     = "limit_reached"         //шаг выполнен максимальное число раз всеми пользователями
+	StepStatusPrerequisites    = "prerequisites_not_met" //не выполнены шаги или не завершены задания, которые требует шаг
+	StepStatusArchived         = "archived"              //шаг или его задание в архиве
+	StepStatusQuestNotStarted  = "quest_not_started"     //задание еще не началось
+	StepStatusQuestEnded       = "quest_ended"           //задание уже закончилось
+)
+
+// Состояние задания у пользователя
+const (
+	QuestStatusNotStarted = "not_started" //пользователь не выполнял шаги задания
+	QuestStatusInProgress = "in_progress" //выполнена часть шагов
+	QuestStatusCompleted  = "completed"   //выполнены все шаги, бонус за завершение начислен
+)
+
+// CompleteStepsResult model info
+// @Description CompleteStepsResult результат выполнения шагов с информацией по каждому шагу
+type CompleteStepsResult struct {
+	Mode      string               `json:"mode"`      //Режим выполнения: all или partial
+	Committed bool                 `json:"committed"` //Признак того, что изменения записаны в БД
+	Items     []CompleteStepResult `json:"items"`     //Результат по каждому переданному шагу в порядке запроса
+
+	Error *response.ErrorBody `json:"error,omitempty"` //Ошибка, если изменения не записаны. Подробности - в items
+}
+
+type CompleteStepResult struct {
+	Stepid         int                   `json:"stepid"`                    //Идентификатор шага
+	Userid         int                   `json:"userid"`                    //Идентификатор пользователя
+	Status         string                `json:"status"`                    //Статус обработки шага
+	QuestCompleted bool                  `json:"quest_completed,omitempty"` //Выполнение шага завершило задание, бонус за завершение начислен
+	Badges         []storages.UserBadge  `json:"badges,omitempty"`          //Значки, выданные пользователю за это выполнение
+	Errors         []response.FieldError `json:"errors,omitempty"`          //Ошибки валидации
+}
+
+// hasRejected возвращает true, если хотя бы один шаг не прошел проверки
+func (result *CompleteStepsResult) hasRejected() bool {
+	for _, item := range result.Items {
+		if item.Status != StepStatusRecorded && item.Status != StepStatusDuplicate {
+			return true
+		}
+	}
+	return false
+}
+
+// hasInvalid возвращает true, если хотя бы один шаг не прошел валидацию входных данных
+func (result *CompleteStepsResult) hasInvalid() bool {
+	for _, item := range result.Items {
+		if item.Status == StepStatusInvalid {
+			return true
+		}
+	}
+	return false
+}
+
+// completeSteps записывает выполнение шагов в одной транзакции.
+// В режиме all при отклонении хотя бы одного шага транзакция откатывается, в режиме partial записываются прошедшие проверки шаги.
+// recordedBy - авторизованный пользователь, отметивший выполнение, 0 если не известен
+func completeSteps(storage *storages.Storage, steps []storages.CompleteStep, mode string, recordedBy int) (CompleteStepsResult, error) {
+	result := CompleteStepsResult{Mode: mode, Items: make([]CompleteStepResult, len(steps))}
+
+	var userIds []int
+	stepsDB := make([]storages.CompleteStepDB, len(steps))
+	for i, сompleteStep := range steps {
+		result.Items[i] = CompleteStepResult{Stepid: сompleteStep.Stepid, Userid: сompleteStep.Userid}
+		сompleteStepDB, errlist := сompleteStep.ConvertToDB()
+		if len(errlist) > 0 {
+			result.Items[i].Status = StepStatusInvalid
+			result.Items[i].Errors = errlist
+			continue
+		}
+		if recordedBy > 0 {
+			сompleteStepDB.RecordedBy = &recordedBy
+		}
+		stepsDB[i] = сompleteStepDB
+		userIds = append(userIds, сompleteStepDB.Userid)
+	}
+
+	tx, err := storage.DB.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	//блокируем пользователей, чтобы параллельные запросы по одному пользователю выполнялись последовательно
+	knownUsers, err := lockUsers(tx, userIds)
+	if err != nil {
+		return result, err
+	}
+	//и их команды, чтобы участники одной команды не превысили ограничения командного задания
+	userTeams, err := lockUserTeams(tx, userIds)
+	if err != nil {
+		return result, err
+	}
+
+	for i := range steps {
+		if result.Items[i].Status == StepStatusInvalid {
+			continue
+		}
+		if !knownUsers[stepsDB[i].Userid] {
+			result.Items[i].Status = StepStatusUnknownUser
+			continue
+		}
+		//команда сохраняется в истории и журнале только для командного задания, иначе бонус попал бы в счет команды
+		if teamId, ok := userTeams[stepsDB[i].Userid]; ok {
+			teamScoped, err := isTeamScoped(tx, stepsDB[i].Stepid)
+			if err != nil {
+				return result, err
+			}
+			if teamScoped {
+				stepsDB[i].TeamId = &teamId
+			}
+		}
+
+		status, err := checkIdempotencyKey(tx, stepsDB[i])
+		if err != nil {
+			return result, err
+		}
+		if status == StepStatusInvalid {
+			result.Items[i].Status = status
+			result.Items[i].Errors = []response.FieldError{response.Field("idempotency_key", response.RuleKeyReused)}
+			continue
+		}
+		if status == "" {
+			status, result.Items[i].Errors, err = checkCompliteStep(tx, stepsDB[i])
+			if err != nil {
+				return result, err
+			}
+		}
+		if status == StepStatusRecorded {
+			err = tx.Model(&stepsDB[i]).Insert()
+			if err != nil {
+				return result, err
+			}
+			//бонус начисляется по значению на момент выполнения, последующие изменения шага его не меняют
+			if err = storages.CreditStepBonus(tx, stepsDB[i].Id); err != nil {
+				return result, err
+			}
+			result.Items[i].QuestCompleted, err = updateQuestProgress(tx, stepsDB[i])
+			if err != nil {
+				return result, err
+			}
+			result.Items[i].Badges, err = awardBadges(tx, stepsDB[i], result.Items[i].QuestCompleted)
+			if err != nil {
+				return result, err
+			}
+		}
+		result.Items[i].Status = status
+	}
+
+	if mode == CompleteModeAll && result.hasRejected() {
+		for i := range result.Items {
+			if result.Items[i].Status == StepStatusRecorded {
+				result.Items[i].Status = StepStatusRolledBack
+				result.Items[i].QuestCompleted = false
+				result.Items[i].Badges = nil
+			}
+		}
+		return result, nil
+	}
+
+	if err = tx.Commit(); err != nil {
+		return result, err
+	}
+	result.Committed = true
+	return result, nil
+}
+
+// updateQuestProgress отмечает, что пользователь начал задание шага, и завершает задание, если выполнены все его
+// действующие шаги. Бонус за завершение (стоимость задания на момент завершения) начисляется в журнал бонусов один раз.
+// Прогресс командного задания ведется для команды в team_quests, бонус за завершение получает участник, выполнивший последний шаг,
+// он же сохраняется как завершивший задание (completed_by). Возвращает true, если задание завершено этим выполнением
+func updateQuestProgress(tx *dbx.Tx, сompleteStep storages.CompleteStepDB) (bool, error) {
+	var quest struct {
+		Id         int  `db:"id"`
+		TeamScoped bool `db:"team_scoped"`
+	}
+	err := tx.NewQuery("SELECT q.id, q.team_scoped FROM queststeps AS s JOIN quests AS q ON q.id = s.questid WHERE s.id = {:stepid}").
+		Bind(dbx.Params{"stepid": сompleteStep.Stepid}).One(&quest)
+	if err != nil {
+		return false, err
+	}
+
+	table, column, ownerId := "user_quests", "user_id", сompleteStep.Userid
+	completedSet := "status = 'completed', completed_at = now(), bonus = q.cost"
+	if quest.TeamScoped && сompleteStep.TeamId != nil {
+		table, column, ownerId = "team_quests", "team_id", *сompleteStep.TeamId
+		completedSet += ", completed_by = {:userid}"
+	}
+	params := dbx.Params{"questid": quest.Id, "ownerid": ownerId, "userid": сompleteStep.Userid, "teamid": сompleteStep.TeamId}
+	_, err = tx.NewQuery(`INSERT INTO ` + table + ` (` + column + `, quest_id) VALUES ({:ownerid}, {:questid})
+							ON CONFLICT (` + column + `, quest_id) DO NOTHING`).Bind(params).Execute()
+	if err != nil {
+		return false, err
+	}
+
+	var bonus int
+	err = tx.NewQuery(`UPDATE ` + table + ` AS o
+						SET ` + completedSet + `
+						FROM quests AS q
+						WHERE q.id = {:questid} AND o.quest_id = q.id AND o.` + column + ` = {:ownerid}
+							AND o.status <> 'completed' AND quest_finished(q.id, {:userid}, {:teamid})
+						RETURNING o.bonus`).Bind(params).Row(&bonus)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if bonus == 0 {
+		return true, nil
+	}
+	err = storages.AddLedgerEntry(tx, &storages.LedgerEntry{
+		UserId:    сompleteStep.Userid,
+		Amount:    bonus,
+		Kind:      storages.LedgerKindQuest,
+		QuestId:   &quest.Id,
+		TeamId:    сompleteStep.TeamId,
+		CreatedBy: сompleteStep.RecordedBy,
+	})
+	return true, err
+}
+
+// awardBadges выдает значки за выполнение шага и возвращает значки пользователя, выполнившего шаг.
+// Если выполнение завершило командное задание, правила проверяются и для остальных участников команды:
+// завершенное командой задание засчитывается каждому из них
+func awardBadges(tx *dbx.Tx, сompleteStep storages.CompleteStepDB, questCompleted bool) ([]storages.UserBadge, error) {
+	badges, err := storages.AwardBadges(tx, сompleteStep.Userid, сompleteStep.Id)
+	if err != nil || !questCompleted || сompleteStep.TeamId == nil {
+		return badges, err
+	}
+
+	var members []int
+	err = tx.NewQuery(`SELECT tm.user_id
+						FROM team_members AS tm
+						JOIN queststeps AS s ON s.id = {:stepid}
+						JOIN quests AS q ON q.id = s.questid
+						WHERE q.team_scoped AND tm.team_id = {:teamid} AND tm.user_id <> {:userid}
+						ORDER BY tm.user_id`).
+		Bind(dbx.Params{"stepid": сompleteStep.Stepid, "teamid": *сompleteStep.TeamId, "userid": сompleteStep.Userid}).
+		Column(&members)
+	if err != nil {
+		return nil, err
+	}
+	for _, memberId := range members {
+		if _, err = storages.AwardBadges(tx, memberId, сompleteStep.Id); err != nil {
+			return nil, err
+		}
+	}
+	return badges, nil
+}
+
+// isTeamScoped проверяет, что шаг относится к командному заданию. Для несуществующего шага возвращает false
+func isTeamScoped(tx *dbx.Tx, stepId int) (bool, error) {
+	var teamScoped bool
+	err := tx.NewQuery(`SELECT EXISTS (SELECT 1 FROM queststeps AS s JOIN quests AS q ON q.id = s.questid
+								WHERE s.id = {:stepid} AND q.team_scoped)`).
+		Bind(dbx.Params{"stepid": stepId}).Row(&teamScoped)
+	return teamScoped, err
+}
+
+// lockUsers блокирует строки пользователей до конца транзакции и возвращает существующих пользователей
+func lockUsers(tx *dbx.Tx, userIds []int) (map[int]bool, error) {
+	knownUsers := make(map[int]bool)
+	if len(userIds) == 0 {
+		return knownUsers, nil
+	}
+
+	sort.Ints(userIds)
+	var locked []int
+	err := tx.NewQuery("SELECT id FROM users WHERE id = ANY({:ids}) ORDER BY id FOR UPDATE").
+		Bind(dbx.Params{"ids": pq.Array(userIds)}).
+		Column(&locked)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range locked {
+		knownUsers[id] = true
+	}
+	return knownUsers, nil
+}
+
+// lockUserTeams блокирует команды пользователей до конца транзакции и возвращает команду каждого пользователя, состоящего в команде
+func lockUserTeams(tx *dbx.Tx, userIds []int) (map[int]int, error) {
+	userTeams := make(map[int]int)
+	if len(userIds) == 0 {
+		return userTeams, nil
+	}
+
+	var rows []struct {
+		UserId int `db:"user_id"`
+		TeamId int `db:"team_id"`
+	}
+	err := tx.NewQuery(`SELECT tm.user_id, tm.team_id
+						FROM team_members AS tm
+						JOIN teams AS t ON t.id = tm.team_id
+						WHERE tm.user_id = ANY({:ids})
+						ORDER BY t.id
+						FOR UPDATE OF t`).
+		Bind(dbx.Params{"ids": pq.Array(userIds)}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		userTeams[row.UserId] = row.TeamId
+	}
+	return userTeams, nil
+}
+
+// checkIdempotencyKey проверяет, не записано ли выполнение пользователя с тем же ключом идемпотентности. Ключ уникален
+// в пределах пользователя. Возвращает StepStatusDuplicate, если запись по тому же шагу уже есть, StepStatusInvalid,
+// если ключ использован пользователем для другого шага, и пустую строку, если ключ не передан или не использовался
+func checkIdempotencyKey(tx *dbx.Tx, сompleteStep storages.CompleteStepDB) (string, error) {
+	if сompleteStep.IdempotencyKey == nil {
+		return "", nil
+	}
+	var existing storages.CompleteStepDB
+	err := tx.Select("stepid", "userid").From(сompleteStep.TableName()).
+		Where(dbx.HashExp{"userid": сompleteStep.Userid, "idempotency_key": *сompleteStep.IdempotencyKey}).One(&existing)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if existing.Stepid != сompleteStep.Stepid {
+		return StepStatusInvalid, nil
+	}
+	return StepStatusDuplicate, nil
+}
+
+// checkCompliteStep возвращает StepStatusRecorded, если шаг доступен пользователю для выполнения, иначе причину отказа.
+// Ограничение числа выполнений пользователем и предварительные условия по шагам в периодическом задании действуют в пределах периода.
+// В командном задании ограничения и предварительные условия учитывают выполнения всех участников команды.
+// Для невыполненных предварительных условий возвращаются ошибки с именами шагов и заданий
+func checkCompliteStep(tx *dbx.Tx, сompleteStep storages.CompleteStepDB) (string, []response.FieldError, error) {
+	var step struct {
+		Archived       bool `db:"archived"`
+		NotStarted     bool `db:"not_started"`
+		Ended          bool `db:"ended"`
+		MaxCompletions *int `db:"max_completions"`
+		PeriodCount    int  `db:"period_count"`
+		Cooldown       bool `db:"cooldown"`
+		GlobalLimit    *int `db:"global_limit"`
+		Prerequisites  bool `db:"prerequisites_met"`
+	}
+	err := tx.NewQuery(`SELECT s.archived_at IS NOT NULL OR q.archived_at IS NOT NULL AS archived,
+							coalesce(q.starts_at > now(), false) AS not_started,
+							coalesce(q.ends_at <= now(), false) AS ended,
+							s.max_completions, s.global_limit,
+							count(h.id) FILTER (WHERE h.completed_at >= quest_period_start(q.recurrence, q.starts_at, now())) AS period_count,
+							coalesce(max(h.completed_at) > now() - s.cooldown_seconds * interval '1 second', false) AS cooldown,
+							step_prerequisites_met(s.id, {:userid}, quest_period_start(q.recurrence, q.starts_at, now()), {:teamid}) AS prerequisites_met
+						FROM queststeps AS s
+						JOIN quests AS q ON q.id = s.questid
+						LEFT JOIN history AS h ON h.stepid = s.id AND (h.userid = {:userid} OR (q.team_scoped AND h.team_id = {:teamid}))
+						WHERE s.id = {:stepid}
+						GROUP BY s.id, q.id`).
+		Bind(dbx.Params{"stepid": сompleteStep.Stepid, "userid": сompleteStep.Userid, "teamid": сompleteStep.TeamId}).One(&step)
+	if errors.Is(err, sql.ErrNoRows) {
+		return StepStatusUnknownStep, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	switch {
+	case step.Archived:
+		return StepStatusArchived, nil, nil
+	case step.NotStarted:
+		return StepStatusQuestNotStarted, nil, nil
+	case step.Ended:
+		return StepStatusQuestEnded, nil, nil
+	case step.MaxCompletions != nil && step.PeriodCount >= *step.MaxCompletions:
+		return StepStatusAlreadyCompleted, nil, nil
+	case step.Cooldown:
+		return StepStatusCooldown, nil, nil
+	case !step.Prerequisites:
+		errlist, err := unmetPrerequisites(tx, сompleteStep)
+		return StepStatusPrerequisites, errlist, err
+	}
+	if step.GlobalLimit == nil {
+		return StepStatusRecorded, nil, nil
+	}
+
+	//блокируем шаг, чтобы параллельные запросы разных пользователей не превысили общее ограничение
+	var count int
+	err = tx.NewQuery(`SELECT count(h.id)
+						FROM (SELECT id FROM queststeps WHERE id = {:stepid} FOR UPDATE) AS s
+						LEFT JOIN history AS h ON h.stepid = s.id`).
+		Bind(dbx.Params{"stepid": сompleteStep.Stepid}).Row(&count)
+	if err != nil {
+		return "", nil, err
+	}
+	if count >= *step.GlobalLimit {
+		return StepStatusLimitReached, nil, nil
+	}
+	return StepStatusRecorded, nil, nil
+}
+
+// unmetPrerequisites возвращает ошибки с именами шагов, которые пользователь (в командном задании - его команда) еще не выполнил
+// (в периодическом задании - в текущем периоде), и заданий, которые он еще не завершил
+func unmetPrerequisites(tx *dbx.Tx, сompleteStep storages.CompleteStepDB) ([]response.FieldError, error) {
+	var rows []struct {
+		IsQuest bool   `db:"is_quest"`
+		Name    string `db:"name"`
+	}
+	err := tx.NewQuery(`SELECT false AS is_quest, r.stepname AS name
+						FROM queststeps AS s
+						JOIN quests AS q ON q.id = s.questid
+						JOIN step_prerequisites AS sp ON sp.step_id = s.id
+						JOIN queststeps AS r ON r.id = sp.required_step_id AND r.archived_at IS NULL
+						WHERE s.id = {:stepid} AND NOT EXISTS (
+							SELECT 1 FROM history AS h
+							WHERE h.stepid = r.id AND (h.userid = {:userid} OR (q.team_scoped AND h.team_id = {:teamid}))
+								AND h.completed_at >= quest_period_start(q.recurrence, q.starts_at, now()))
+						UNION ALL
+						SELECT true, rq.questname
+						FROM queststeps AS s
+						JOIN quest_prerequisites AS qp ON qp.quest_id = s.questid
+						JOIN quests AS rq ON rq.id = qp.required_quest_id
+						WHERE s.id = {:stepid} AND NOT quest_finished(rq.id, {:userid}, {:teamid})`).
+		Bind(dbx.Params{"stepid": сompleteStep.Stepid, "userid": сompleteStep.Userid, "teamid": сompleteStep.TeamId}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	errlist := make([]response.FieldError, len(rows))
+	for i, row := range rows {
+		if row.IsQuest {
+			errlist[i] = response.Field("stepid", response.RuleQuestUnfinished, row.Name)
+		} else {
+			errlist[i] = response.Field("stepid", response.RuleStepIncomplete, row.Name)
+		}
+	}
+	return errlist, nil
+}
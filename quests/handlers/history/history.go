@@ -2,250 +2,465 @@ package history
 
 import (
 	"encoding/json"
+	dbx "github.com/go-ozzo/ozzo-dbx"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"techno-test_quests/quests/response"
 	storages "techno-test_quests/quests/storage"
+	"time"
 )
 
 // UserBonus model info
 // @Description UserBonus json для получения история выполнения заданий и их шагов
 type UserBonus struct {
-	TotalBonus      int                  `json:"TotalBonus"`      //Общий бонусный счет пользователя
-	CompletedQuests []UserCompletedQuest `json:"ComplitedQuests"` //Список заданий в которых участвовал пользователь
+	TotalBonus      int                  `json:"TotalBonus"`       //Бонусный баланс пользователя по журналу бонусов: начисления за вычетом списаний
+	CompletedQuests []UserCompletedQuest `json:"ComplitedQuests"`  //Страница списка заданий в которых участвовал пользователь, пустой массив, если истории нет
+	NextCursor      *string              `json:"next_cursor"`      //Курсор следующей страницы заданий, null на последней странице
+	Badges          []storages.UserBadge `json:"Badges,omitempty"` //Значки пользователя, отсутствуют в истории команды
 }
 
 type UserCompletedQuest struct {
 	QuestId             string               `json:"QuestId"`             //ИД задания
 	QuestName           string               `json:"QuestName"`           //Имя выполненного задания пользователем
-	Bonus               int                  `json:"Bonus"`               //Сумма Бонусов за выполненные задания
-	CompletedStepsCount int                  `json:"CompletedStepsCount"` //Кол-во выполненных шагов заданий пользователем
+	Bonus               int                  `json:"Bonus"`               //Сумма начисленных бонусов за выполненные шаги и завершение задания
+	Status              string               `json:"Status"`              //Состояние задания: in_progress или completed
+	CompletedAt         *time.Time           `json:"CompletedAt"`         //Время завершения задания
+	CompletionBonus     int                  `json:"CompletionBonus"`     //Бонус за завершение задания
+	CompletedStepsCount int                  `json:"CompletedStepsCount"` //Кол-во выполненных пользователем шагов задания, не считая архивных
 	AllStepsCount       int                  `json:"AllStepsCount"`       //Кол-во шагов, доступное в задании
 	CompletedSteps      []UserCompletedSteps `json:"CompletedSteps"`      //Выполненные шаги пользователем
 }
 type UserCompletedSteps struct {
-	StepName      string `json:"StepName"`      //Имя выполненного шага
-	Count         int    `json:"Count"`         //Кол-во выполнений шага
-	UserBonusStep int    `json:"UserBonusStep"` //Бонус пользователя за выполнение шага
+	StepName      string          `json:"StepName"`      //Имя выполненного шага
+	Count         int             `json:"Count"`         //Кол-во выполнений шага
+	UserBonusStep int             `json:"UserBonusStep"` //Бонус, начисленный пользователю за выполнения шага
+	Completions   []HistoryRecord `json:"Completions"`   //Записи о выполнении шага
 }
 
-// ExicuteCountSumQuery Обёртка предназначена для запросов, которые возвращают одно целое значение
-func ExicuteCountSumQuery(storage *storages.Storage, queryText string) int {
-	result := 0
-	query := storage.DB.NewQuery(queryText)
-	rows, err := query.Rows()
-	if err != nil {
-		return result
-	}
-	for rows.Next() {
-		rows.Scan(&result)
+// HistoryRecord запись о выполнении шага
+type HistoryRecord struct {
+	Id             int       `json:"Id"`                       //Идентификатор записи
+	CompletedAt    time.Time `json:"CompletedAt"`              //Время выполнения
+	RecordedBy     *int      `json:"RecordedBy"`               //Пользователь, записавший выполнение, пусто для записей без автора
+	Source         *string   `json:"Source,omitempty"`         //Источник отметки о выполнении
+	IdempotencyKey *string   `json:"IdempotencyKey,omitempty"` //Ключ идемпотентности
+}
+
+// parseUserId проверяет, что идентификатор пользователя - положительное целое число
+func parseUserId(value string) (int, bool) {
+	userId, err := strconv.Atoi(value)
+	if err != nil || userId <= 0 {
+		return 0, false
 	}
-	return result
+	return userId, true
 }
 
 // @Summary Выполнить шаг
 // @Tags history
-// @Description Устанавливает признак выполнения шага у пользователя
+// @Description Устанавливает признак выполнения шагов у пользователей в одной транзакции.
+// @Description По умолчанию (mode=all) при отклонении хотя бы одного шага не записывается ничего, mode=partial записывает все шаги, прошедшие проверки.
+// @Description Ответ содержит статус по каждому переданному шагу. Авторизованный пользователь сохраняется в истории как записавший выполнение.
+// @Description Повторный запрос с тем же idempotency_key для того же пользователя не создает новую запись и возвращает статус duplicate.
 // @id CompleteSteps
 // @Accept json
 // @Procedure json
 // @router /CompleteSteps [POST]
-// @param input body storage.NewQuestSteps true "обновленная информация о шагах задания"
-// @Success 200 {object} storage.NewQuestStep
+// @param input body storage.NewCompleteSteps true "шаги, выполненные пользователями"
+// @param mode query string false "режим выполнения" Enums(all, partial)
+// @Success 200 {object} CompleteStepsResult
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} CompleteStepsResult "неверные входные данные, изменения не записаны"
+// @Failure 409 {object} CompleteStepsResult "шаги отклонены, изменения не записаны"
 // @Security BasicAuth
+// @Security BearerAuth
 func CompleteSteps(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		storages.RequestTolog(r, logger)
-		if r.Method == http.MethodPost {
-			var сompleteSteps storages.NewCompleteSteps
-			decoder := json.NewDecoder(r.Body)
-			err := decoder.Decode(&сompleteSteps)
+		completeStepsFor(storage, logger, w, r, 0)
+	}
+}
 
-			if err != nil {
-				storages.HttpResponse(w, http.StatusBadRequest, "Неверный формат запроса "+err.Error())
-				return
-			}
+// completeStepsFor обрабатывает запрос на выполнение шагов. Если userId больше 0, все шаги выполняются от имени этого пользователя
+func completeStepsFor(storage *storages.Storage, logger *slog.Logger, w http.ResponseWriter, r *http.Request, userId int) {
+	storages.RequestTolog(r, logger)
+	if r.Method == http.MethodPost {
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = CompleteModeAll
+		}
+		if mode != CompleteModeAll && mode != CompleteModePartial {
+			response.InvalidParam(w, r, "mode", response.RuleOneOf, "all, partial")
+			return
+		}
 
-			for _, сompleteStep := range сompleteSteps.CompleteSteps {
-				сompleteStepDB, errlist := сompleteStep.ConvertToDB()
-				if len(errlist) > 0 {
-					result, _ := json.MarshalIndent(errlist, "", "\t")
-					storages.HttpResponseObject(w, http.StatusInternalServerError, result)
-					return
-				}
+		var сompleteSteps storages.NewCompleteSteps
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&сompleteSteps)
 
-				//Если задание можно выполнить - выполняем, если нет, то просто игнорируем
-				if checkCompliteStep(storage, сompleteStep) {
-					err = storage.DB.Model(&сompleteStepDB).Insert()
-					if err != nil {
-						storages.HttpResponse(w, http.StatusBadRequest, "Не удалось выполнить задание"+err.Error())
-						return
-					}
-				}
+		if err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+		if userId > 0 {
+			for i := range сompleteSteps.CompleteSteps {
+				сompleteSteps.CompleteSteps[i].Userid = userId
 			}
-			storages.HttpResponse(w, http.StatusOK, "Успешно")
-		} else {
-			storages.HttpResponse(w, http.StatusMethodNotAllowed, "Метод не поддерживается, используйте метод POST")
 		}
-	}
-}
-
-// checkCompliteStep возвращает true, если шаг доступен пользователю для выполнения
-func checkCompliteStep(storage *storages.Storage, сompleteStep storages.CompleteStep) bool {
-	//Получаем id всех выполненных заданий
 
-	queryText := "SELECT s.id FROM public.queststeps as s where (s.ismulti = false and s.id not in (select h.stepid from history h where h.userid = " + strconv.Itoa(сompleteStep.Userid) + " )) or s.ismulti = true"
-	query := storage.DB.NewQuery(queryText)
-	rows, err := query.Rows()
-	if err != nil {
+		recordedBy := 0
+		if principal, ok := storages.PrincipalFromContext(r.Context()); ok {
+			recordedBy = principal.UserId
+		}
+		completeResult, err := completeSteps(storage, сompleteSteps.CompleteSteps, mode, recordedBy)
+		if err != nil {
+			logger.Error("complete steps failed", "error", err.Error())
+			response.Internal(w, r)
+			return
+		}
 
+		for i := range completeResult.Items {
+			completeResult.Items[i].Errors = response.Localize(r, completeResult.Items[i].Errors)
+		}
+		status := http.StatusOK
+		if !completeResult.Committed {
+			failure := response.NewError(r, response.CodeStepsRejected, nil)
+			status = http.StatusConflict
+			if completeResult.hasInvalid() {
+				failure = response.NewError(r, response.CodeValidationFailed, nil)
+				status = http.StatusUnprocessableEntity
+			}
+			completeResult.Error = &failure.Error
+		}
+		response.JSON(w, status, completeResult)
+	} else {
+		response.MethodNotAllowed(w, r, http.MethodPost)
 	}
-	var stepIds map[int]bool = make(map[int]bool)
-	for rows.Next() {
-		var id int
-		rows.Scan(&id)
-		stepIds[id] = true
-	}
-	_, inMap := stepIds[сompleteStep.Stepid]
-	return inMap
 }
 
-// @Summary Обновить шаг к заданию
+// @Summary История пользователя
 // @Tags history
-// @Description Создает новое задание
+// @Description Возвращает страницу заданий, в которых участвовал пользователь, с записями о выполнении шагов и его общий бонусный счет.
+// @Description Параметры from и to ограничивают учитываемые выполнения, общий бонусный счет - текущий баланс по журналу бонусов.
+// @Description Вместо userid можно передать teamid: тогда возвращается совместная история участников команды - выполнения и бонусы,
+// @Description полученные в ее составе, а общий бонусный счет - сумма бонусов, заработанных командой
+// @Description Бонусы берутся из журнала: за шаг начисляется бонус, действовавший в момент выполнения, с учетом отмен начислений.
+// @Description Бонус за завершение задания начисляется один раз, когда выполнены все действующие шаги задания.
+// @Description Badges - значки, выданные пользователю
 // @id GetHistory
 // @Accept json
 // @Procedure json
 // @router /GetHistory [GET]
+// @param userid header int false "идентификатор пользователя"
+// @param teamid header int false "идентификатор команды, если userid не указан"
+// @param limit query int false "кол-во заданий на странице, по умолчанию 50, не больше 200"
+// @param cursor query string false "курсор следующей страницы"
+// @param sort query string false "сортировка заданий, '-' в начале - по убыванию" Enums(id, -id, name, -name)
+// @param name query string false "имя задания содержит строку"
+// @param from query string false "выполнения не раньше (2006-01-02 или RFC3339)"
+// @param to query string false "выполнения раньше (2006-01-02 или RFC3339)"
 // @Success 200 {object} UserBonus
+// @Failure 400 {object} response.ErrorResponse "userid или teamid не указан или не является целым числом больше 0"
 // @Security BasicAuth
+// @Security BearerAuth
 func GetHistory(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		storages.RequestTolog(r, logger)
 		if r.Method == http.MethodGet {
-			userId := r.Header.Get("userid")
-			if userId != "" {
-				questIds := getCompletedQuestId(storage, userId)
-				if len(questIds) > 0 {
-					userBonus := UserBonus{}
-
-					for _, questId := range questIds {
-						CompletedQuest := GetCompletedQuestForUser(storage, userId, questId)
-						userBonus.CompletedQuests = append(userBonus.CompletedQuests, CompletedQuest)
-						userBonus.TotalBonus += CompletedQuest.Bonus
-					}
-					result, _ := json.MarshalIndent(userBonus, "", "\t")
-					storages.HttpResponseObject(w, http.StatusInternalServerError, result)
-				} else {
-					storages.HttpResponse(w, http.StatusOK, "Пользователь еще не выполнял задания")
+			var owner historyOwner
+			if value := r.Header.Get("userid"); value != "" || r.Header.Get("teamid") == "" {
+				userId, ok := parseUserId(value)
+				if !ok {
+					response.InvalidParam(w, r, "userid", response.RulePositive)
+					return
 				}
+				owner = userOwner(userId)
 			} else {
-				storages.HttpResponse(w, http.StatusBadRequest, "Неверный формат запроса, укажите 'userid")
+				teamId, ok := parseUserId(r.Header.Get("teamid"))
+				if !ok {
+					response.InvalidParam(w, r, "teamid", response.RulePositive)
+					return
+				}
+				owner = teamOwner(teamId)
+			}
+
+			filter, page, err := parseHistoryPage(r.URL.Query())
+			if err != nil {
+				response.BadRequest(w, r, err)
 				return
 			}
+
+			userBonus, err := getBonusPage(storage, owner, filter, page)
+			if err != nil {
+				logger.Error("get history failed", "error", err.Error())
+				response.Internal(w, r)
+				return
+			}
+			response.JSON(w, http.StatusOK, userBonus)
 		} else {
-			storages.HttpResponse(w, http.StatusMethodNotAllowed, "Метод не поддерживается, используйте метод POST")
+			response.MethodNotAllowed(w, r, http.MethodGet)
 		}
 	}
 }
 
-// getCompletedQuestId возвращает ИД заданий в которых участвовал пользователь
-func getCompletedQuestId(storage *storages.Storage, userId string) []string {
-	var questIds []string
+// @Summary Моя история
+// @Tags me
+// @Description Возвращает историю выполнения заданий и бонусный счет авторизованного пользователя. Параметры такие же, как в GetHistory
+// @id GetMyHistory
+// @Accept json
+// @Procedure json
+// @router /me/history [GET]
+// @param limit query int false "кол-во заданий на странице, по умолчанию 50, не больше 200"
+// @param cursor query string false "курсор следующей страницы"
+// @param sort query string false "сортировка заданий, '-' в начале - по убыванию" Enums(id, -id, name, -name)
+// @param name query string false "имя задания содержит строку"
+// @param from query string false "выполнения не раньше (2006-01-02 или RFC3339)"
+// @param to query string false "выполнения раньше (2006-01-02 или RFC3339)"
+// @Success 200 {object} UserBonus
+// @Security BasicAuth
+// @Security BearerAuth
+func GetMyHistory(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		if r.Method == http.MethodGet {
+			principal, ok := storages.PrincipalFromContext(r.Context())
+			if !ok {
+				response.Error(w, r, http.StatusUnauthorized, response.CodeUnauthorized)
+				return
+			}
 
-	queryText := `SELECT distinct q.id
-						FROM public.queststeps as s
-						left join history as h on s.id = h.stepid
-						left join quests as q on s.questid = q.id
-						where h.userid = ` + userId
-	query := storage.DB.NewQuery(queryText)
-	rows, err := query.Rows()
-	if err != nil {
-		return questIds
-	}
-
-	for rows.Next() {
-		var id string
-		rows.Scan(&id)
-		questIds = append(questIds, id)
-	}
-	return questIds
-}
-
-// GetCompletedQuestForUser Возвращает информацию по заданию для пользователя
-func GetCompletedQuestForUser(storage *storages.Storage, userId, questId string) UserCompletedQuest {
-	UserCompletedQuest := UserCompletedQuest{}
-
-	UserCompletedQuest.QuestId = questId
-	//TODO UserCompletedQuest.QuestName
-
-	//Всего заданий
-	queryText := `	SELECT count(*)
-					FROM public.queststeps
-					where questid = ` + questId
-	UserCompletedQuest.AllStepsCount = ExicuteCountSumQuery(storage, queryText)
-
-	//Всего заданий выполненных пользователей
-	queryText = `SELECT count(*)
-					FROM public.queststeps as s
-					left join history as h on s.id = h.stepid
-					left join quests as q on s.questid = q.id
-					where h.userid = ` + userId + ` and q.id = ` + questId
-	UserCompletedQuest.CompletedStepsCount = ExicuteCountSumQuery(storage, queryText)
-
-	//Сумма бонуса за выполненные шаги задания
-	queryText = `SELECT Sum(s.bonus)
-					FROM public.queststeps as s
-					left join history as h on s.id = h.stepid
-					left join quests as q on s.questid = q.id
-					where h.userid = ` + userId + ` and q.id = ` + questId
-	UserCompletedQuest.Bonus = ExicuteCountSumQuery(storage, queryText)
-
-	//region пройдемся по каждому выполненному шагу пользователя и посчитаем сколько раз был выполнен каждый шаг и сумму бонусов за это
-	queryText = `SELECT distinct (s.id)
-					FROM public.queststeps as s
-					left join history as h on s.id = h.stepid
-					left join quests as q on s.questid = q.id
-					where h.userid = ` + userId + ` and q.id = ` + questId
-	query := storage.DB.NewQuery(queryText)
-	rowsSteps, _ := query.Rows()
-	CompletedStepsCount := 0
-	type stepInfo struct{ count, bonus int }
-	for rowsSteps.Next() {
-		var stepid string
-		rowsSteps.Scan(&stepid)
-
-		queryText = `SELECT s.stepname, s.bonus
-					FROM public.queststeps as s
-					left join history as h on s.id = h.stepid
-					left join quests as q on s.questid = q.id
-					where h.userid = ` + userId + ` and q.id = ` + questId + ` and s.id =` + stepid
-		query = storage.DB.NewQuery(queryText)
-		rows, _ := query.Rows()
-
-		var stepsInfo map[string]stepInfo = make(map[string]stepInfo)
-		for rows.Next() {
-			var stepname string
-			var bonus int
-			rows.Scan(&stepname, &bonus)
-			if value, inMap := stepsInfo[stepname]; inMap {
-				value.count++
-				value.bonus += bonus
-				stepsInfo[stepname] = value
-			} else {
-				stepsInfo[stepname] = stepInfo{1, bonus}
+			filter, page, err := parseHistoryPage(r.URL.Query())
+			if err != nil {
+				response.BadRequest(w, r, err)
+				return
 			}
 
+			userBonus, err := getUserBonusPage(storage, principal.UserId, filter, page)
+			if err != nil {
+				logger.Error("get history failed", "error", err.Error())
+				response.Internal(w, r)
+				return
+			}
+			response.JSON(w, http.StatusOK, userBonus)
+		} else {
+			response.MethodNotAllowed(w, r, http.MethodGet)
 		}
-		for key, value := range stepsInfo {
-			UserCompletedQuest.CompletedSteps = append(UserCompletedQuest.CompletedSteps, UserCompletedSteps{key, value.count, value.bonus})
+	}
+}
+
+// @Summary Выполнить шаг от своего имени
+// @Tags me
+// @Description Отмечает выполнение шагов авторизованным пользователем. Поле userid в запросе игнорируется.
+// @Description Режимы mode=all и mode=partial работают так же, как в CompleteSteps
+// @id CompleteMySteps
+// @Accept json
+// @Procedure json
+// @router /me/CompleteSteps [POST]
+// @param input body storage.NewCompleteSteps true "выполненные шаги"
+// @param mode query string false "режим выполнения" Enums(all, partial)
+// @Success 200 {object} CompleteStepsResult
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} CompleteStepsResult "неверные входные данные, изменения не записаны"
+// @Failure 409 {object} CompleteStepsResult "шаги отклонены, изменения не записаны"
+// @Security BasicAuth
+// @Security BearerAuth
+func CompleteMySteps(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := storages.PrincipalFromContext(r.Context())
+		if !ok {
+			response.Error(w, r, http.StatusUnauthorized, response.CodeUnauthorized)
+			return
 		}
+		completeStepsFor(storage, logger, w, r, principal.UserId)
+	}
+}
+
+// historySortColumns ключи сортировки заданий в истории пользователя
+var historySortColumns = map[string]string{
+	"id":   "id",
+	"name": "questname",
+}
 
-		CompletedStepsCount++
+// historyFilter фильтры истории пользователя
+type historyFilter struct {
+	Name string     //имя задания содержит строку
+	From *time.Time //выполнения не раньше
+	To   *time.Time //выполнения раньше
+}
+
+// parseHistoryPage разбирает фильтры и параметры постраничной выдачи истории
+func parseHistoryPage(query url.Values) (historyFilter, storages.PageRequest, error) {
+	filter := historyFilter{Name: query.Get("name")}
+	page, err := storages.ParsePageRequest(query, historySortColumns, "id")
+	if err != nil {
+		return filter, page, err
+	}
+	if filter.From, err = storages.ParseTimeParam(query, "from"); err != nil {
+		return filter, page, err
 	}
-	//endregion
+	if filter.To, err = storages.ParseTimeParam(query, "to"); err != nil {
+		return filter, page, err
+	}
+	return filter, page, nil
+}
 
-	UserCompletedQuest.CompletedStepsCount = CompletedStepsCount
+// userStepRow строка агрегата истории пользователя: один выполненный шаг задания
+type userStepRow struct {
+	QuestId       int    `db:"questid"`
+	QuestName     string `db:"questname"`
+	AllStepsCount int    `db:"allsteps"`
+	StepName      string `db:"stepname"`
+	Count         int    `db:"cnt"`
+	Bonus         int    `db:"bonus"`
+	Completions   []byte `db:"completions"`
+	Active        bool   `db:"active"` //шаг не в архиве
 
-	return UserCompletedQuest
+	Status          string     `db:"status"`
+	CompletedAt     *time.Time `db:"completed_at"`
+	CompletionBonus int        `db:"completion_bonus"`
+}
+
+// getUserBonus возвращает всю историю выполнения заданий пользователя
+func getUserBonus(storage *storages.Storage, userId int) (UserBonus, error) {
+	return getUserBonusPage(storage, userId, historyFilter{}, storages.PageRequest{})
+}
+
+// historyOwner владелец истории: пользователь или команда
+type historyOwner struct {
+	Id           int
+	Column       string //колонка владельца в history
+	LedgerColumn string //колонка владельца в bonus_ledger
+	QuestsTable  string //таблица состояния заданий владельца
+	QuestsColumn string //колонка владельца в таблице состояния заданий
+}
+
+// userOwner история пользователя
+func userOwner(userId int) historyOwner {
+	return historyOwner{Id: userId, Column: "userid", LedgerColumn: "user_id", QuestsTable: "user_quests", QuestsColumn: "user_id"}
+}
+
+// teamOwner совместная история участников команды: выполнения и бонусы, полученные в составе команды
+func teamOwner(teamId int) historyOwner {
+	return historyOwner{Id: teamId, Column: "team_id", LedgerColumn: "team_id", QuestsTable: "team_quests", QuestsColumn: "team_id"}
+}
+
+// getUserBonusPage возвращает страницу истории выполнения заданий пользователя и бонусный баланс
+func getUserBonusPage(storage *storages.Storage, userId int, filter historyFilter, page storages.PageRequest) (UserBonus, error) {
+	return getBonusPage(storage, userOwner(userId), filter, page)
+}
+
+// getBonusPage возвращает страницу истории выполнения заданий владельца и его бонусный счет: баланс пользователя
+// или сумму бонусов, заработанных участниками команды в ее составе.
+// Данные страницы получаются одним агрегирующим запросом, сгруппированным по заданию и шагу, записи о выполнении шага
+// собираются в json массив. Бонусы суммируются по журналу бонусов, а не по текущему бонусу шага
+func getBonusPage(storage *storages.Storage, owner historyOwner, filter historyFilter, page storages.PageRequest) (UserBonus, error) {
+	userBonus := UserBonus{}
+
+	var err error
+	if owner.Column == "team_id" {
+		userBonus.TotalBonus, err = storages.TeamBonus(storage.DB, owner.Id)
+	} else {
+		userBonus.TotalBonus, err = storages.Balance(storage.DB, owner.Id)
+		if err == nil {
+			userBonus.Badges, err = storages.UserBadges(storage.DB, owner.Id)
+		}
+	}
+	if err != nil {
+		return userBonus, err
+	}
+
+	//страница заданий, в которых пользователь выполнял шаги
+	q := storage.DB.Select("q.id", "q.questname").From("quests AS q").
+		Where(dbx.NewExp("q.id IN (SELECT questid FROM done)"))
+	if filter.Name != "" {
+		q.AndWhere(dbx.NewExp("q.questname ILIKE {:name}", dbx.Params{"name": storages.ContainsPattern(filter.Name)}))
+	}
+	questPage := page.Apply(q, "q").Build()
+
+	params := questPage.Params()
+	params["ownerid"] = owner.Id
+	historyCondition := ""
+	if filter.From != nil {
+		historyCondition += " AND h.completed_at >= {:from}"
+		params["from"] = *filter.From
+	}
+	if filter.To != nil {
+		historyCondition += " AND h.completed_at < {:to}"
+		params["to"] = *filter.To
+	}
+
+	queryText := `WITH done AS (
+						SELECT s.questid, s.id AS stepid, s.stepname, s.archived_at IS NULL AS active,
+							   count(*) AS cnt,
+							   coalesce(sum(l.amount), 0) AS bonus,
+							   json_agg(json_build_object('Id', h.id, 'CompletedAt', h.completed_at, 'RecordedBy', h.recorded_by,
+									'Source', h.source, 'IdempotencyKey', h.idempotency_key) ORDER BY h.completed_at, h.id) AS completions
+						FROM history AS h
+						JOIN queststeps AS s ON s.id = h.stepid
+						LEFT JOIN (
+							SELECT history_id, sum(amount) AS amount
+							FROM bonus_ledger
+							WHERE ` + owner.LedgerColumn + ` = {:ownerid} AND history_id IS NOT NULL
+							GROUP BY history_id
+						) AS l ON l.history_id = h.id
+						WHERE h.` + owner.Column + ` = {:ownerid}` + historyCondition + `
+						GROUP BY s.questid, s.id, s.stepname, s.archived_at
+					), page AS (` + questPage.SQL() + `), total AS (
+						SELECT questid, count(*) FILTER (WHERE archived_at IS NULL) AS allsteps
+						FROM queststeps
+						WHERE questid IN (SELECT id FROM page)
+						GROUP BY questid
+					)
+					SELECT d.questid, p.questname, t.allsteps, d.stepname, d.cnt, d.bonus, d.completions, d.active,
+						coalesce(uq.status, 'in_progress') AS status, uq.completed_at,
+						(SELECT coalesce(sum(amount), 0) FROM bonus_ledger
+							WHERE ` + owner.LedgerColumn + ` = {:ownerid} AND quest_id = d.questid AND history_id IS NULL) AS completion_bonus
+					FROM done AS d
+					JOIN page AS p ON p.id = d.questid
+					JOIN total AS t ON t.questid = d.questid
+					LEFT JOIN ` + owner.QuestsTable + ` AS uq ON uq.quest_id = d.questid AND uq.` + owner.QuestsColumn + ` = {:ownerid}
+					ORDER BY ` + strings.Join(page.OrderBy("p"), ", ") + `, d.stepid`
+	var rows []userStepRow
+	err = storage.DB.NewQuery(queryText).Bind(params).All(&rows)
+	if err != nil {
+		return userBonus, err
+	}
+
+	for _, row := range rows {
+		last := len(userBonus.CompletedQuests) - 1
+		if last < 0 || userBonus.CompletedQuests[last].QuestId != strconv.Itoa(row.QuestId) {
+			userBonus.CompletedQuests = append(userBonus.CompletedQuests, UserCompletedQuest{
+				QuestId:         strconv.Itoa(row.QuestId),
+				QuestName:       row.QuestName,
+				AllStepsCount:   row.AllStepsCount,
+				Status:          row.Status,
+				CompletedAt:     row.CompletedAt,
+				CompletionBonus: row.CompletionBonus,
+				Bonus:           row.CompletionBonus,
+			})
+			last++
+		}
+		quest := &userBonus.CompletedQuests[last]
+		step := UserCompletedSteps{StepName: row.StepName, Count: row.Count, UserBonusStep: row.Bonus}
+		if err = json.Unmarshal(row.Completions, &step.Completions); err != nil {
+			return userBonus, err
+		}
+		quest.CompletedSteps = append(quest.CompletedSteps, step)
+		//архивные шаги остаются в истории, но не входят в AllStepsCount, поэтому не считаются и в выполненных
+		if row.Active {
+			quest.CompletedStepsCount++
+		}
+		quest.Bonus += row.Bonus
+	}
+
+	questsPage := storages.NewPage(page, userBonus.CompletedQuests, func(quest UserCompletedQuest) (string, int) {
+		id, _ := strconv.Atoi(quest.QuestId)
+		if page.Sort == "name" {
+			return quest.QuestName, id
+		}
+		return "", id
+	})
+	userBonus.CompletedQuests, userBonus.NextCursor = questsPage.Items, questsPage.NextCursor
+	return userBonus, nil
 }
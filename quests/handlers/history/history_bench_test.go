@@ -0,0 +1,97 @@
+package history
+
+import (
+	"strconv"
+	"testing"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"techno-test_quests/quests/internal/testdb"
+	storages "techno-test_quests/quests/storage"
+)
+
+// BenchmarkGetHistory сравнивает агрегирующий запрос с прежней реализацией (запросы на каждое задание и шаг)
+func BenchmarkGetHistory(b *testing.B) {
+	storage, userId := newBenchmarkHistory(b, 20, 5, 3)
+
+	b.Run("aggregate", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := getUserBonus(storage, userId); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("legacy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			legacyUserBonus(storage, userId)
+		}
+	})
+}
+
+// newBenchmarkHistory создает пользователя, выполнившего quests заданий по steps шагов, каждый шаг completions раз
+func newBenchmarkHistory(b *testing.B, quests, steps, completions int) (*storages.Storage, int) {
+	f := newTestFixture(b)
+	suffix := testdb.Suffix()
+
+	for q := 0; q < quests; q++ {
+		questId := testdb.Insert(b, f.storage, "quests", dbx.Params{"questname": "bench " + suffix + " " + strconv.Itoa(q)})
+		for s := 0; s < steps; s++ {
+			stepId := testdb.Insert(b, f.storage, "queststeps", dbx.Params{"questid": questId, "stepname": "step " + strconv.Itoa(s), "bonus": s + 1, "ismulti": true})
+			for c := 0; c < completions; c++ {
+				testdb.Insert(b, f.storage, "history", dbx.Params{"stepid": stepId, "userid": f.userId})
+			}
+		}
+	}
+
+	b.ResetTimer()
+	return f.storage, f.userId
+}
+
+// legacyUserBonus прежняя реализация GetHistory: несколько запросов на каждое задание и по запросу на каждый выполненный шаг
+func legacyUserBonus(storage *storages.Storage, userId int) UserBonus {
+	userBonus := UserBonus{}
+
+	var questIds []int
+	storage.DB.NewQuery(`SELECT distinct s.questid
+						FROM public.queststeps as s
+						join history as h on s.id = h.stepid
+						where h.userid = {:userid}`).Bind(dbx.Params{"userid": userId}).Column(&questIds)
+
+	for _, questId := range questIds {
+		quest := UserCompletedQuest{QuestId: strconv.Itoa(questId)}
+		params := dbx.Params{"userid": userId, "questid": questId}
+
+		storage.DB.NewQuery(`SELECT count(*) FROM public.queststeps where questid = {:questid}`).Bind(params).Row(&quest.AllStepsCount)
+		storage.DB.NewQuery(`SELECT coalesce(Sum(s.bonus), 0)
+					FROM public.queststeps as s
+					join history as h on s.id = h.stepid
+					where h.userid = {:userid} and s.questid = {:questid}`).Bind(params).Row(&quest.Bonus)
+
+		var stepIds []int
+		storage.DB.NewQuery(`SELECT distinct (s.id)
+					FROM public.queststeps as s
+					join history as h on s.id = h.stepid
+					where h.userid = {:userid} and s.questid = {:questid}`).Bind(params).Column(&stepIds)
+		for _, stepId := range stepIds {
+			var rows []struct {
+				StepName string `db:"stepname"`
+				Bonus    int    `db:"bonus"`
+			}
+			storage.DB.NewQuery(`SELECT s.stepname, coalesce(s.bonus, 0) as bonus
+					FROM public.queststeps as s
+					join history as h on s.id = h.stepid
+					where h.userid = {:userid} and s.questid = {:questid} and s.id = {:stepid}`).
+				Bind(dbx.Params{"userid": userId, "questid": questId, "stepid": stepId}).All(&rows)
+			step := UserCompletedSteps{}
+			for _, row := range rows {
+				step.StepName = row.StepName
+				step.Count++
+				step.UserBonusStep += row.Bonus
+			}
+			quest.CompletedSteps = append(quest.CompletedSteps, step)
+			quest.CompletedStepsCount++
+		}
+		userBonus.CompletedQuests = append(userBonus.CompletedQuests, quest)
+		userBonus.TotalBonus += quest.Bonus
+	}
+	return userBonus
+}
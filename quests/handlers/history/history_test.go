@@ -0,0 +1,669 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"techno-test_quests/quests/internal/testdb"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+)
+
+// Тесты выполняются против тестовой БД, строка подключения задается переменной окружения QUESTS_TEST_DB (см. testdb)
+
+// injectionPayloads значения, которые раньше попадали в текст SQL запроса без экранирования
+var injectionPayloads = []string{
+	"1 or 1=1",
+	"1; DROP TABLE users",
+	"1 union select id from users",
+	"0) or (1=1",
+	"1--",
+	"'1'",
+	"-1",
+	"0",
+	"abc",
+	"",
+}
+
+type testFixture struct {
+	storage *storages.Storage
+	logger  *slog.Logger
+	userId  int
+	otherId int
+	stepId  int
+}
+
+func newTestFixture(t testing.TB) *testFixture {
+	t.Helper()
+	storage := testdb.Open(t)
+	suffix := testdb.Suffix()
+	f := &testFixture{
+		storage: storage,
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		userId:  testdb.User(t, storage, "u"+suffix),
+		otherId: testdb.User(t, storage, "o"+suffix),
+	}
+	questId := testdb.Insert(t, storage, "quests", dbx.Params{"questname": "quest " + suffix})
+	f.stepId = testdb.Insert(t, storage, "queststeps", dbx.Params{"questid": questId, "stepname": "step", "bonus": 10, "ismulti": true})
+	testdb.Delete(t, storage, "history", dbx.HashExp{"stepid": f.stepId})
+	return f
+}
+
+func (f *testFixture) complete(t *testing.T, userId int) {
+	t.Helper()
+	f.record(t, f.stepId, userId)
+}
+
+// record записывает выполнение шага в обход проверок и начисляет бонус шага в журнал
+func (f *testFixture) record(t *testing.T, stepId, userId int) {
+	t.Helper()
+	historyId := testdb.Insert(t, f.storage, "history", dbx.Params{"stepid": stepId, "userid": userId})
+	if err := storages.CreditStepBonus(f.storage.DB, historyId); err != nil {
+		t.Fatalf("credit step bonus: %s", err)
+	}
+}
+
+func (f *testFixture) usersCount(t *testing.T) int {
+	t.Helper()
+	var count int
+	if err := f.storage.DB.Select("count(*)").From("users").Row(&count); err != nil {
+		t.Fatalf("count users: %s", err)
+	}
+	return count
+}
+
+func TestGetHistoryRejectsInjection(t *testing.T) {
+	f := newTestFixture(t)
+	f.complete(t, f.otherId)
+	usersBefore := f.usersCount(t)
+
+	for _, payload := range append(injectionPayloads, strconv.Itoa(f.userId)+" or 1=1") {
+		t.Run(payload, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/GetHistory", nil)
+			r.Header.Set("userid", payload)
+			w := httptest.NewRecorder()
+			GetHistory(f.storage, f.logger)(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("userid %q: status %d, want %d, body %s", payload, w.Code, http.StatusBadRequest, w.Body.String())
+			}
+		})
+	}
+
+	if usersAfter := f.usersCount(t); usersAfter != usersBefore {
+		t.Fatalf("users count changed: %d -> %d", usersBefore, usersAfter)
+	}
+}
+
+func TestGetHistoryReturnsOnlyRequestedUser(t *testing.T) {
+	f := newTestFixture(t)
+	f.complete(t, f.userId)
+	f.complete(t, f.otherId)
+	f.complete(t, f.otherId)
+
+	r := httptest.NewRequest(http.MethodGet, "/GetHistory", nil)
+	r.Header.Set("userid", strconv.Itoa(f.userId))
+	w := httptest.NewRecorder()
+	GetHistory(f.storage, f.logger)(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d, body %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var userBonus UserBonus
+	if err := json.Unmarshal(w.Body.Bytes(), &userBonus); err != nil {
+		t.Fatalf("decode response: %s", err)
+	}
+	if userBonus.TotalBonus != 10 {
+		t.Fatalf("TotalBonus = %d, want 10", userBonus.TotalBonus)
+	}
+}
+
+func TestCompleteStepsRejectsInjection(t *testing.T) {
+	f := newTestFixture(t)
+	usersBefore := f.usersCount(t)
+
+	bodies := []string{
+		`{"CompleteSteps":[{"stepid":"1 or 1=1","userid":1}]}`,
+		`{"CompleteSteps":[{"stepid":1,"userid":"1; DROP TABLE users"}]}`,
+		fmt.Sprintf(`{"CompleteSteps":[{"stepid":%d,"userid":"%d or 1=1"}]}`, f.stepId, f.userId),
+		`{"CompleteSteps":[{"stepid":1e400,"userid":1}]}`,
+	}
+	for _, body := range bodies {
+		r := httptest.NewRequest(http.MethodPost, "/CompleteSteps", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		CompleteSteps(f.storage, f.logger)(w, r)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("body %s: status %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	for _, payload := range []string{"all' or '1'='1", "partial; DROP TABLE history"} {
+		body := fmt.Sprintf(`{"CompleteSteps":[{"stepid":%d,"userid":%d}]}`, f.stepId, f.userId)
+		r := httptest.NewRequest(http.MethodPost, "/CompleteSteps?mode="+url.QueryEscape(payload), strings.NewReader(body))
+		w := httptest.NewRecorder()
+		CompleteSteps(f.storage, f.logger)(w, r)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("mode %q: status %d, want %d", payload, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	var recorded int
+	f.storage.DB.Select("count(*)").From("history").Where(dbx.HashExp{"stepid": f.stepId}).Row(&recorded)
+	if recorded != 0 {
+		t.Fatalf("history rows recorded for rejected requests: %d", recorded)
+	}
+	if usersAfter := f.usersCount(t); usersAfter != usersBefore {
+		t.Fatalf("users count changed: %d -> %d", usersBefore, usersAfter)
+	}
+}
+
+func TestCompleteStepsRecordsValidRequest(t *testing.T) {
+	f := newTestFixture(t)
+
+	body := fmt.Sprintf(`{"CompleteSteps":[{"stepid":%d,"userid":%d}]}`, f.stepId, f.userId)
+	r := httptest.NewRequest(http.MethodPost, "/CompleteSteps", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	CompleteSteps(f.storage, f.logger)(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d, body %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var result CompleteStepsResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode response: %s", err)
+	}
+	if !result.Committed || len(result.Items) != 1 || result.Items[0].Status != StepStatusRecorded {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestGetHistoryAggregatesMultiCompletion(t *testing.T) {
+	f := newTestFixture(t)
+	f.complete(t, f.userId)
+	f.complete(t, f.userId)
+	f.complete(t, f.userId)
+
+	userBonus, err := getUserBonus(f.storage, f.userId)
+	if err != nil {
+		t.Fatalf("getUserBonus: %s", err)
+	}
+	if len(userBonus.CompletedQuests) != 1 {
+		t.Fatalf("CompletedQuests = %+v, want 1 quest", userBonus.CompletedQuests)
+	}
+	quest := userBonus.CompletedQuests[0]
+	if quest.QuestName == "" || quest.AllStepsCount != 1 || quest.CompletedStepsCount != 1 || quest.Bonus != 30 {
+		t.Fatalf("unexpected quest %+v", quest)
+	}
+	if len(quest.CompletedSteps) != 1 || quest.CompletedSteps[0].Count != 3 || quest.CompletedSteps[0].UserBonusStep != 30 {
+		t.Fatalf("unexpected steps %+v", quest.CompletedSteps)
+	}
+	if userBonus.TotalBonus != 30 {
+		t.Fatalf("TotalBonus = %d, want 30", userBonus.TotalBonus)
+	}
+}
+
+func TestGetHistoryCountsOnlyActiveSteps(t *testing.T) {
+	f := newTestFixture(t)
+	var questId int
+	if err := f.storage.DB.Select("questid").From("queststeps").Where(dbx.HashExp{"id": f.stepId}).Row(&questId); err != nil {
+		t.Fatalf("select quest: %s", err)
+	}
+	archivedId := testdb.Insert(t, f.storage, "queststeps", dbx.Params{"questid": questId, "stepname": "archived", "bonus": 5, "ismulti": true})
+	testdb.Delete(t, f.storage, "history", dbx.HashExp{"stepid": archivedId})
+	f.complete(t, f.userId)
+	f.record(t, archivedId, f.userId)
+	if _, err := f.storage.DB.NewQuery("UPDATE queststeps SET archived_at = now() WHERE id = {:id}").Bind(dbx.Params{"id": archivedId}).Execute(); err != nil {
+		t.Fatalf("archive step: %s", err)
+	}
+
+	userBonus, err := getUserBonus(f.storage, f.userId)
+	if err != nil {
+		t.Fatalf("getUserBonus: %s", err)
+	}
+	//выполнение архивного шага остается в истории, но в счетчиках шагов учитываются только действующие шаги
+	if len(userBonus.CompletedQuests) != 1 {
+		t.Fatalf("CompletedQuests = %+v, want 1 quest", userBonus.CompletedQuests)
+	}
+	quest := userBonus.CompletedQuests[0]
+	if len(quest.CompletedSteps) != 2 || quest.AllStepsCount != 1 || quest.CompletedStepsCount != 1 || quest.Bonus != 15 {
+		t.Fatalf("unexpected quest %+v", quest)
+	}
+}
+
+func TestGetHistoryWithoutHistoryReturnsEmptyPage(t *testing.T) {
+	f := newTestFixture(t)
+	teamId := testdb.Insert(t, f.storage, "teams", dbx.Params{"name": "team " + strconv.Itoa(f.userId)})
+
+	for _, header := range []struct{ name, value string }{
+		{"userid", strconv.Itoa(f.userId)},
+		{"teamid", strconv.Itoa(teamId)},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/GetHistory", nil)
+		r.Header.Set(header.name, header.value)
+		w := httptest.NewRecorder()
+		GetHistory(f.storage, f.logger)(w, r)
+
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, body %s", header.name, w.Code, w.Body.String())
+		}
+		if string(body["ComplitedQuests"]) != "[]" || string(body["next_cursor"]) != "null" || string(body["TotalBonus"]) != "0" {
+			t.Fatalf("%s: body %s, want empty page with null next_cursor", header.name, w.Body.String())
+		}
+	}
+}
+
+func TestCompleteStepsRejectsArchivedStep(t *testing.T) {
+	f := newTestFixture(t)
+	f.complete(t, f.userId)
+	_, err := f.storage.DB.NewQuery("UPDATE queststeps SET archived_at = now() WHERE id = {:id}").
+		Bind(dbx.Params{"id": f.stepId}).Execute()
+	if err != nil {
+		t.Fatalf("archive step: %s", err)
+	}
+
+	body := fmt.Sprintf(`{"CompleteSteps":[{"stepid":%d,"userid":%d}]}`, f.stepId, f.userId)
+	r := httptest.NewRequest(http.MethodPost, "/CompleteSteps", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	CompleteSteps(f.storage, f.logger)(w, r)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status %d, want %d, body %s", w.Code, http.StatusConflict, w.Body.String())
+	}
+	var result CompleteStepsResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode response: %s", err)
+	}
+	if result.Committed || result.Items[0].Status != StepStatusArchived {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if result.Error == nil || result.Error.Code != response.CodeStepsRejected {
+		t.Fatalf("unexpected error %+v", result.Error)
+	}
+
+	//история по архивному шагу продолжает учитываться
+	userBonus, err := getUserBonus(f.storage, f.userId)
+	if err != nil {
+		t.Fatalf("getUserBonus: %s", err)
+	}
+	if userBonus.TotalBonus != 10 {
+		t.Fatalf("TotalBonus = %d, want 10", userBonus.TotalBonus)
+	}
+	//архивный шаг не входит в число шагов задания
+	if len(userBonus.CompletedQuests) != 1 || userBonus.CompletedQuests[0].AllStepsCount != 0 {
+		t.Fatalf("unexpected completed quests %+v", userBonus.CompletedQuests)
+	}
+}
+
+func TestCompleteStepsReturnsLocalizedValidationErrors(t *testing.T) {
+	f := newTestFixture(t)
+
+	body := fmt.Sprintf(`{"CompleteSteps":[{"stepid":%d,"userid":%d},{"stepid":0,"userid":%d}]}`, f.stepId, f.userId, f.userId)
+	r := httptest.NewRequest(http.MethodPost, "/CompleteSteps", strings.NewReader(body))
+	r.Header.Set("Accept-Language", "en-US,en;q=0.9,ru;q=0.8")
+	w := httptest.NewRecorder()
+	CompleteSteps(f.storage, f.logger)(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want %d, body %s", w.Code, http.StatusUnprocessableEntity, w.Body.String())
+	}
+	var result CompleteStepsResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode response: %s", err)
+	}
+	if result.Committed || result.Error == nil || result.Error.Code != response.CodeValidationFailed {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if result.Error.Message != response.Text(response.LanguageEn, response.CodeValidationFailed) {
+		t.Fatalf("message %q is not in English", result.Error.Message)
+	}
+	if errs := result.Items[1].Errors; len(errs) != 1 || errs[0].Field != "stepid" || errs[0].Code != response.RulePositive ||
+		errs[0].Message != response.Text(response.LanguageEn, response.RulePositive) {
+		t.Fatalf("unexpected item errors %+v", errs)
+	}
+}
+
+func TestGetHistoryPagination(t *testing.T) {
+	f := newTestFixture(t)
+	questId := testdb.Insert(t, f.storage, "quests", dbx.Params{"questname": "second quest " + strconv.Itoa(f.userId)})
+	stepId := testdb.Insert(t, f.storage, "queststeps", dbx.Params{"questid": questId, "stepname": "step", "bonus": 5, "ismulti": false, "max_completions": 1})
+	testdb.Delete(t, f.storage, "history", dbx.HashExp{"stepid": stepId})
+	f.complete(t, f.userId)
+	f.record(t, stepId, f.userId)
+
+	query := url.Values{"limit": {"1"}}
+	var questIds []string
+	for {
+		filter, page, err := parseHistoryPage(query)
+		if err != nil {
+			t.Fatalf("parseHistoryPage: %s", err)
+		}
+		userBonus, err := getUserBonusPage(f.storage, f.userId, filter, page)
+		if err != nil {
+			t.Fatalf("getUserBonusPage: %s", err)
+		}
+		if userBonus.TotalBonus != 15 {
+			t.Fatalf("TotalBonus = %d, want 15", userBonus.TotalBonus)
+		}
+		if len(userBonus.CompletedQuests) > 1 {
+			t.Fatalf("page size %d, want 1", len(userBonus.CompletedQuests))
+		}
+		for _, quest := range userBonus.CompletedQuests {
+			questIds = append(questIds, quest.QuestId)
+		}
+		if userBonus.NextCursor == nil {
+			break
+		}
+		query.Set("cursor", *userBonus.NextCursor)
+	}
+	if len(questIds) != 2 || questIds[0] == questIds[1] {
+		t.Fatalf("quests across pages = %v, want 2 distinct", questIds)
+	}
+
+	query = url.Values{"sort": {"name"}, "cursor": {query.Get("cursor")}}
+	if _, _, err := parseHistoryPage(query); err == nil {
+		t.Fatal("cursor issued for another sort was accepted")
+	}
+}
+
+func TestCompleteStepsChecksQuestSchedule(t *testing.T) {
+	f := newTestFixture(t)
+	_, err := f.storage.DB.NewQuery("UPDATE queststeps SET ismulti = false, max_completions = 1 WHERE id = {:id}").
+		Bind(dbx.Params{"id": f.stepId}).Execute()
+	if err == nil {
+		_, err = f.storage.DB.NewQuery(`UPDATE quests SET starts_at = now() + interval '1 day'
+											WHERE id = (SELECT questid FROM queststeps WHERE id = {:id})`).
+			Bind(dbx.Params{"id": f.stepId}).Execute()
+	}
+	if err != nil {
+		t.Fatalf("schedule quest: %s", err)
+	}
+	steps := []storages.CompleteStep{{Stepid: f.stepId, Userid: f.userId}}
+
+	result, err := completeSteps(f.storage, steps, CompleteModePartial, 0)
+	if err != nil {
+		t.Fatalf("completeSteps: %s", err)
+	}
+	if result.Items[0].Status != StepStatusQuestNotStarted {
+		t.Fatalf("status %s, want %s", result.Items[0].Status, StepStatusQuestNotStarted)
+	}
+
+	//у ежедневного задания выполнение за прошлые сутки не мешает выполнить шаг сегодня
+	_, err = f.storage.DB.NewQuery(`UPDATE quests SET starts_at = NULL, recurrence = 'daily'
+										WHERE id = (SELECT questid FROM queststeps WHERE id = {:id})`).
+		Bind(dbx.Params{"id": f.stepId}).Execute()
+	if err != nil {
+		t.Fatalf("set recurrence: %s", err)
+	}
+	testdb.Insert(t, f.storage, "history", dbx.Params{"stepid": f.stepId, "userid": f.userId, "completed_at": time.Now().Add(-48 * time.Hour)})
+
+	for _, want := range []string{StepStatusRecorded, StepStatusAlreadyCompleted} {
+		result, err = completeSteps(f.storage, steps, CompleteModePartial, 0)
+		if err != nil {
+			t.Fatalf("completeSteps: %s", err)
+		}
+		if result.Items[0].Status != want {
+			t.Fatalf("status %s, want %s", result.Items[0].Status, want)
+		}
+	}
+}
+
+func TestCompleteStepsChecksStepLimits(t *testing.T) {
+	f := newTestFixture(t)
+	_, err := f.storage.DB.Update("queststeps", dbx.Params{"cooldown_seconds": 3600, "global_limit": 1}, dbx.HashExp{"id": f.stepId}).Execute()
+	if err != nil {
+		t.Fatalf("set step limits: %s", err)
+	}
+
+	for _, test := range []struct {
+		userId int
+		want   string
+	}{
+		{f.userId, StepStatusRecorded},
+		{f.userId, StepStatusCooldown},
+		{f.otherId, StepStatusLimitReached},
+	} {
+		result, err := completeSteps(f.storage, []storages.CompleteStep{{Stepid: f.stepId, Userid: test.userId}}, CompleteModePartial, 0)
+		if err != nil {
+			t.Fatalf("completeSteps: %s", err)
+		}
+		if result.Items[0].Status != test.want {
+			t.Fatalf("user %d: status %s, want %s", test.userId, result.Items[0].Status, test.want)
+		}
+	}
+}
+
+func TestCompleteStepsIdempotencyAndAudit(t *testing.T) {
+	f := newTestFixture(t)
+	steps := []storages.CompleteStep{{Stepid: f.stepId, Userid: f.userId, Source: "test", IdempotencyKey: "key-" + strconv.Itoa(f.userId)}}
+
+	for _, want := range []string{StepStatusRecorded, StepStatusDuplicate} {
+		result, err := completeSteps(f.storage, steps, CompleteModeAll, f.otherId)
+		if err != nil {
+			t.Fatalf("completeSteps: %s", err)
+		}
+		if !result.Committed || result.Items[0].Status != want {
+			t.Fatalf("unexpected result %+v, want status %s", result, want)
+		}
+	}
+
+	//ключ уникален в пределах пользователя: у другого пользователя тот же ключ записывает новое выполнение
+	otherSteps := []storages.CompleteStep{{Stepid: f.stepId, Userid: f.otherId, IdempotencyKey: steps[0].IdempotencyKey}}
+	result, err := completeSteps(f.storage, otherSteps, CompleteModeAll, f.otherId)
+	if err != nil {
+		t.Fatalf("completeSteps: %s", err)
+	}
+	if !result.Committed || result.Items[0].Status != StepStatusRecorded {
+		t.Fatalf("same key for another user: %+v", result)
+	}
+
+	var questId int
+	if err = f.storage.DB.Select("questid").From("queststeps").Where(dbx.HashExp{"id": f.stepId}).Row(&questId); err != nil {
+		t.Fatalf("get quest: %s", err)
+	}
+	stepId := testdb.Insert(t, f.storage, "queststeps", dbx.Params{"questid": questId, "stepname": "other", "bonus": 1, "ismulti": true})
+	reused := []storages.CompleteStep{{Stepid: stepId, Userid: f.userId, IdempotencyKey: steps[0].IdempotencyKey}}
+	result, err = completeSteps(f.storage, reused, CompleteModeAll, f.otherId)
+	if err != nil {
+		t.Fatalf("completeSteps: %s", err)
+	}
+	if result.Committed || result.Items[0].Status != StepStatusInvalid {
+		t.Fatalf("key reused for another step: %+v", result)
+	}
+
+	userBonus, err := getUserBonus(f.storage, f.userId)
+	if err != nil {
+		t.Fatalf("getUserBonus: %s", err)
+	}
+	completions := userBonus.CompletedQuests[0].CompletedSteps[0].Completions
+	if len(completions) != 1 || completions[0].RecordedBy == nil || *completions[0].RecordedBy != f.otherId ||
+		completions[0].Source == nil || *completions[0].Source != "test" || completions[0].CompletedAt.IsZero() {
+		t.Fatalf("unexpected completions %+v", completions)
+	}
+
+	tomorrow := time.Now().Add(24 * time.Hour)
+	userBonus, err = getUserBonusPage(f.storage, f.userId, historyFilter{From: &tomorrow}, storages.PageRequest{})
+	if err != nil {
+		t.Fatalf("getUserBonusPage: %s", err)
+	}
+	if len(userBonus.CompletedQuests) != 0 {
+		t.Fatalf("completions after %s = %+v, want none", tomorrow, userBonus.CompletedQuests)
+	}
+}
+
+func TestCompleteStepsChecksPrerequisites(t *testing.T) {
+	f := newTestFixture(t)
+	var questId int
+	err := f.storage.DB.Select("questid").From("queststeps").Where(dbx.HashExp{"id": f.stepId}).Row(&questId)
+	if err != nil {
+		t.Fatalf("get quest: %s", err)
+	}
+	stepId := testdb.Insert(t, f.storage, "queststeps", dbx.Params{"questid": questId, "stepname": "next", "bonus": 1, "ismulti": true})
+	_, err = f.storage.DB.Insert("step_prerequisites", dbx.Params{"step_id": stepId, "required_step_id": f.stepId}).Execute()
+	if err != nil {
+		t.Fatalf("insert prerequisite: %s", err)
+	}
+	testdb.Delete(t, f.storage, "history", dbx.HashExp{"stepid": stepId})
+
+	steps := []storages.CompleteStep{{Stepid: stepId, Userid: f.userId}}
+	result, err := completeSteps(f.storage, steps, CompleteModePartial, 0)
+	if err != nil {
+		t.Fatalf("completeSteps: %s", err)
+	}
+	if result.Items[0].Status != StepStatusPrerequisites || len(result.Items[0].Errors) != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	f.complete(t, f.userId)
+	result, err = completeSteps(f.storage, steps, CompleteModePartial, 0)
+	if err != nil {
+		t.Fatalf("completeSteps: %s", err)
+	}
+	if result.Items[0].Status != StepStatusRecorded {
+		t.Fatalf("status %s after prerequisite, want %s", result.Items[0].Status, StepStatusRecorded)
+	}
+}
+
+func TestCompleteStepsAwardsQuestBonusOnce(t *testing.T) {
+	f := newTestFixture(t)
+	_, err := f.storage.DB.NewQuery("UPDATE quests SET cost = 100 WHERE id = (SELECT questid FROM queststeps WHERE id = {:id})").
+		Bind(dbx.Params{"id": f.stepId}).Execute()
+	if err != nil {
+		t.Fatalf("set quest cost: %s", err)
+	}
+
+	steps := []storages.CompleteStep{{Stepid: f.stepId, Userid: f.userId}}
+	for _, want := range []bool{true, false} {
+		result, err := completeSteps(f.storage, steps, CompleteModeAll, 0)
+		if err != nil {
+			t.Fatalf("completeSteps: %s", err)
+		}
+		if !result.Committed || result.Items[0].QuestCompleted != want {
+			t.Fatalf("unexpected result %+v, want quest_completed %t", result, want)
+		}
+	}
+
+	userBonus, err := getUserBonus(f.storage, f.userId)
+	if err != nil {
+		t.Fatalf("getUserBonus: %s", err)
+	}
+	quest := userBonus.CompletedQuests[0]
+	if quest.Status != QuestStatusCompleted || quest.CompletedAt == nil || quest.CompletionBonus != 100 || quest.Bonus != 120 {
+		t.Fatalf("unexpected quest %+v", quest)
+	}
+	if userBonus.TotalBonus != 120 {
+		t.Fatalf("TotalBonus = %d, want 120", userBonus.TotalBonus)
+	}
+}
+
+func TestLedgerKeepsBonusInEffectAndReversals(t *testing.T) {
+	f := newTestFixture(t)
+	f.complete(t, f.userId)
+	_, err := f.storage.DB.Update("queststeps", dbx.Params{"bonus": 50}, dbx.HashExp{"id": f.stepId}).Execute()
+	if err != nil {
+		t.Fatalf("update step bonus: %s", err)
+	}
+	f.complete(t, f.userId)
+
+	//изменение бонуса шага не меняет уже начисленные бонусы
+	balance, err := storages.Balance(f.storage.DB, f.userId)
+	if err != nil {
+		t.Fatalf("Balance: %s", err)
+	}
+	if balance != 60 {
+		t.Fatalf("balance = %d, want 60", balance)
+	}
+
+	err = f.storage.DB.Transactional(func(tx *dbx.Tx) error {
+		return storages.Debit(tx, &storages.LedgerEntry{UserId: f.userId, Amount: -61, Kind: storages.LedgerKindAdjustment})
+	})
+	if err != storages.ErrInsufficientBonus {
+		t.Fatalf("debit over balance: %v, want ErrInsufficientBonus", err)
+	}
+
+	var entryId int
+	err = f.storage.DB.Select("max(id)").From("bonus_ledger").Where(dbx.HashExp{"user_id": f.userId}).Row(&entryId)
+	if err != nil {
+		t.Fatalf("select ledger entry: %s", err)
+	}
+	for _, want := range []error{nil, storages.ErrAlreadyReversed} {
+		err = f.storage.DB.Transactional(func(tx *dbx.Tx) error {
+			_, err := storages.ReverseLedgerEntry(tx, entryId, nil, "test")
+			return err
+		})
+		if err != want {
+			t.Fatalf("ReverseLedgerEntry: %v, want %v", err, want)
+		}
+	}
+
+	userBonus, err := getUserBonus(f.storage, f.userId)
+	if err != nil {
+		t.Fatalf("getUserBonus: %s", err)
+	}
+	if userBonus.TotalBonus != 10 || userBonus.CompletedQuests[0].CompletedSteps[0].UserBonusStep != 10 {
+		t.Fatalf("unexpected history after reversal %+v", userBonus)
+	}
+
+	_, err = f.storage.DB.Update("bonus_ledger", dbx.Params{"amount": 1000}, dbx.HashExp{"id": entryId}).Execute()
+	if err == nil {
+		t.Fatal("ledger entry was updated")
+	}
+}
+
+func TestMyHandlersActAsPrincipal(t *testing.T) {
+	f := newTestFixture(t)
+	withPrincipal := func(r *http.Request) *http.Request {
+		return r.WithContext(storages.WithPrincipal(r.Context(), storages.Principal{UserId: f.userId}))
+	}
+
+	//userid в теле игнорируется, шаг выполняет авторизованный пользователь
+	body := fmt.Sprintf(`{"CompleteSteps":[{"stepid":%d,"userid":%d}]}`, f.stepId, f.otherId)
+	w := httptest.NewRecorder()
+	CompleteMySteps(f.storage, f.logger)(w, withPrincipal(httptest.NewRequest(http.MethodPost, "/me/CompleteSteps", strings.NewReader(body))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("CompleteMySteps: status %d, body %s", w.Code, w.Body.String())
+	}
+	for userId, want := range map[int]int{f.userId: 1, f.otherId: 0} {
+		var count int
+		err := f.storage.DB.Select("count(*)").From("history").Where(dbx.HashExp{"stepid": f.stepId, "userid": userId}).Row(&count)
+		if err != nil || count != want {
+			t.Fatalf("history of user %d = %d, %v, want %d", userId, count, err, want)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	GetMyHistory(f.storage, f.logger)(w, withPrincipal(httptest.NewRequest(http.MethodGet, "/me/history", nil)))
+	var userBonus UserBonus
+	if err := json.Unmarshal(w.Body.Bytes(), &userBonus); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GetMyHistory: status %d, body %s", w.Code, w.Body.String())
+	}
+	if userBonus.TotalBonus != 10 || len(userBonus.CompletedQuests) != 1 {
+		t.Fatalf("unexpected history %+v", userBonus)
+	}
+
+	//без авторизованного пользователя в контексте запросы отклоняются
+	for name, handler := range map[string]http.HandlerFunc{
+		"CompleteMySteps": CompleteMySteps(f.storage, f.logger),
+		"GetMyHistory":    GetMyHistory(f.storage, f.logger),
+	} {
+		w = httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/me", strings.NewReader(body)))
+		var errorBody response.ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &errorBody); err != nil || w.Code != http.StatusUnauthorized ||
+			errorBody.Error.Code != response.CodeUnauthorized {
+			t.Fatalf("%s without principal: status %d, body %s", name, w.Code, w.Body.String())
+		}
+	}
+}
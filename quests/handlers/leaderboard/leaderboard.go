@@ -0,0 +1,343 @@
+package leaderboard
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Рейтинги строятся по таблицам leaderboard_scores и team_leaderboard_scores, которые триггер обновляет при каждой записи
+// в журнал бонусов. Место определяется очками по убыванию, при равенстве очков выше тот, у кого очки последний раз
+// увеличились раньше, затем - с меньшим идентификатором. Отмена начисления уменьшает очки, но не меняет время
+
+// Периоды рейтинга
+const (
+	PeriodAll   = "all"   //за все время
+	PeriodWeek  = "week"  //за календарную неделю по UTC
+	PeriodMonth = "month" //за календарный месяц по UTC
+)
+
+// Размер рейтинга по умолчанию
+const defaultLimit = 10
+
+// Leaderboard model info
+// @Description Leaderboard рейтинг пользователей за период
+type Leaderboard struct {
+	Period      string             `json:"period"`                 //Период: all, week или month
+	PeriodStart *time.Time         `json:"period_start,omitempty"` //Начало периода, отсутствует для all
+	QuestId     *int               `json:"quest_id,omitempty"`     //Задание, отсутствует для общего рейтинга
+	Entries     []LeaderboardEntry `json:"entries"`                //Первые места рейтинга
+	Me          *LeaderboardEntry  `json:"me,omitempty"`           //Место авторизованного пользователя, отсутствует, если он не набрал очков
+}
+
+// LeaderboardEntry место в рейтинге
+type LeaderboardEntry struct {
+	Rank       int       `json:"rank" db:"rank"`               //Место
+	UserId     int       `json:"user_id" db:"user_id"`         //Идентификатор пользователя
+	Username   string    `json:"username" db:"username"`       //Имя пользователя
+	Score      int       `json:"score" db:"score"`             //Очки: бонусы за шаги и завершение заданий за период
+	AchievedAt time.Time `json:"achieved_at" db:"achieved_at"` //Время последнего увеличения очков
+}
+
+// TeamLeaderboard model info
+// @Description TeamLeaderboard рейтинг команд за период
+type TeamLeaderboard struct {
+	Period      string                 `json:"period"`                 //Период: all, week или month
+	PeriodStart *time.Time             `json:"period_start,omitempty"` //Начало периода, отсутствует для all
+	QuestId     *int                   `json:"quest_id,omitempty"`     //Задание, отсутствует для общего рейтинга
+	Entries     []TeamLeaderboardEntry `json:"entries"`                //Первые места рейтинга
+	Me          *TeamLeaderboardEntry  `json:"me,omitempty"`           //Место команды авторизованного пользователя, отсутствует, если команда не набрала очков
+}
+
+// TeamLeaderboardEntry место команды в рейтинге
+type TeamLeaderboardEntry struct {
+	Rank       int       `json:"rank" db:"rank"`               //Место
+	TeamId     int       `json:"team_id" db:"team_id"`         //Идентификатор команды
+	Name       string    `json:"name" db:"name"`               //Название команды
+	Score      int       `json:"score" db:"score"`             //Очки: бонусы, заработанные участниками в составе команды за период
+	AchievedAt time.Time `json:"achieved_at" db:"achieved_at"` //Время последнего увеличения очков
+}
+
+// leaderboardRequest параметры рейтинга
+type leaderboardRequest struct {
+	Period  string
+	At      time.Time
+	Limit   int
+	QuestId int //0 - общий рейтинг
+}
+
+// parseLeaderboardRequest разбирает параметры period, at и limit
+func parseLeaderboardRequest(query url.Values) (leaderboardRequest, error) {
+	request := leaderboardRequest{Period: PeriodAll, At: time.Now(), Limit: defaultLimit}
+	if value := query.Get("period"); value != "" {
+		if value != PeriodAll && value != PeriodWeek && value != PeriodMonth {
+			return request, response.Field("period", response.RuleOneOf, "all, week, month")
+		}
+		request.Period = value
+	}
+	at, err := storages.ParseTimeParam(query, "at")
+	if err != nil {
+		return request, err
+	}
+	if at != nil {
+		request.At = *at
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > storages.MaxPageLimit {
+			return request, response.Field("limit", response.RuleRange, 1, storages.MaxPageLimit)
+		}
+		request.Limit = limit
+	}
+	return request, nil
+}
+
+// getLeaderboard возвращает первые места рейтинга и место пользователя userId
+func getLeaderboard(db dbx.Builder, request leaderboardRequest, userId int) (Leaderboard, error) {
+	leaderboard := Leaderboard{Period: request.Period, Entries: []LeaderboardEntry{}}
+	if request.QuestId > 0 {
+		leaderboard.QuestId = &request.QuestId
+	}
+	params := dbx.Params{"period": request.Period, "at": request.At, "questid": request.QuestId, "limit": request.Limit, "userid": userId}
+
+	var err error
+	leaderboard.PeriodStart, err = periodStart(db, request)
+	if err != nil {
+		return leaderboard, err
+	}
+
+	err = db.NewQuery(`SELECT row_number() OVER (ORDER BY ls.score DESC, ls.achieved_at, ls.user_id) AS rank,
+							ls.user_id, u.username, ls.score, ls.achieved_at
+						FROM leaderboard_scores AS ls
+						JOIN users AS u ON u.id = ls.user_id
+						WHERE ls.period = {:period} AND ls.period_start = leaderboard_period_start({:period}, {:at})
+							AND ls.quest_id = {:questid} AND ls.score > 0
+						ORDER BY ls.score DESC, ls.achieved_at, ls.user_id
+						LIMIT {:limit}`).Bind(params).All(&leaderboard.Entries)
+	if err != nil {
+		return leaderboard, err
+	}
+
+	if userId == 0 {
+		return leaderboard, nil
+	}
+	var me LeaderboardEntry
+	err = db.NewQuery(`SELECT ls.user_id, u.username, ls.score, ls.achieved_at,
+							(SELECT 1 + count(*) FROM leaderboard_scores AS o
+							WHERE o.period = ls.period AND o.period_start = ls.period_start AND o.quest_id = ls.quest_id AND o.score > 0
+								AND (o.score > ls.score OR (o.score = ls.score AND (o.achieved_at, o.user_id) < (ls.achieved_at, ls.user_id)))) AS rank
+						FROM leaderboard_scores AS ls
+						JOIN users AS u ON u.id = ls.user_id
+						WHERE ls.period = {:period} AND ls.period_start = leaderboard_period_start({:period}, {:at})
+							AND ls.quest_id = {:questid} AND ls.user_id = {:userid} AND ls.score > 0`).Bind(params).One(&me)
+	if errors.Is(err, sql.ErrNoRows) {
+		return leaderboard, nil
+	}
+	if err != nil {
+		return leaderboard, err
+	}
+	leaderboard.Me = &me
+	return leaderboard, nil
+}
+
+// periodStart возвращает начало периода рейтинга, для рейтинга за все время - nil
+func periodStart(db dbx.Builder, request leaderboardRequest) (*time.Time, error) {
+	if request.Period == PeriodAll {
+		return nil, nil
+	}
+	var start time.Time
+	err := db.NewQuery("SELECT leaderboard_period_start({:period}, {:at})").
+		Bind(dbx.Params{"period": request.Period, "at": request.At}).Row(&start)
+	if err != nil {
+		return nil, err
+	}
+	return &start, nil
+}
+
+// getTeamLeaderboard возвращает первые места рейтинга команд и место команды teamId
+func getTeamLeaderboard(db dbx.Builder, request leaderboardRequest, teamId *int) (TeamLeaderboard, error) {
+	leaderboard := TeamLeaderboard{Period: request.Period, Entries: []TeamLeaderboardEntry{}}
+	if request.QuestId > 0 {
+		leaderboard.QuestId = &request.QuestId
+	}
+	params := dbx.Params{"period": request.Period, "at": request.At, "questid": request.QuestId, "limit": request.Limit, "teamid": teamId}
+
+	var err error
+	leaderboard.PeriodStart, err = periodStart(db, request)
+	if err != nil {
+		return leaderboard, err
+	}
+
+	err = db.NewQuery(`SELECT row_number() OVER (ORDER BY ls.score DESC, ls.achieved_at, ls.team_id) AS rank,
+							ls.team_id, t.name, ls.score, ls.achieved_at
+						FROM team_leaderboard_scores AS ls
+						JOIN teams AS t ON t.id = ls.team_id
+						WHERE ls.period = {:period} AND ls.period_start = leaderboard_period_start({:period}, {:at})
+							AND ls.quest_id = {:questid} AND ls.score > 0
+						ORDER BY ls.score DESC, ls.achieved_at, ls.team_id
+						LIMIT {:limit}`).Bind(params).All(&leaderboard.Entries)
+	if err != nil {
+		return leaderboard, err
+	}
+
+	if teamId == nil {
+		return leaderboard, nil
+	}
+	var me TeamLeaderboardEntry
+	err = db.NewQuery(`SELECT ls.team_id, t.name, ls.score, ls.achieved_at,
+							(SELECT 1 + count(*) FROM team_leaderboard_scores AS o
+							WHERE o.period = ls.period AND o.period_start = ls.period_start AND o.quest_id = ls.quest_id AND o.score > 0
+								AND (o.score > ls.score OR (o.score = ls.score AND (o.achieved_at, o.team_id) < (ls.achieved_at, ls.team_id)))) AS rank
+						FROM team_leaderboard_scores AS ls
+						JOIN teams AS t ON t.id = ls.team_id
+						WHERE ls.period = {:period} AND ls.period_start = leaderboard_period_start({:period}, {:at})
+							AND ls.quest_id = {:questid} AND ls.team_id = {:teamid} AND ls.score > 0`).Bind(params).One(&me)
+	if errors.Is(err, sql.ErrNoRows) {
+		return leaderboard, nil
+	}
+	if err != nil {
+		return leaderboard, err
+	}
+	leaderboard.Me = &me
+	return leaderboard, nil
+}
+
+// writeLeaderboard отвечает рейтингом с местом авторизованного пользователя
+func writeLeaderboard(storage *storages.Storage, logger *slog.Logger, w http.ResponseWriter, r *http.Request, questId int) {
+	request, err := parseLeaderboardRequest(r.URL.Query())
+	if err != nil {
+		response.BadRequest(w, r, err)
+		return
+	}
+	request.QuestId = questId
+	userId := 0
+	if principal, ok := storages.PrincipalFromContext(r.Context()); ok {
+		userId = principal.UserId
+	}
+
+	leaderboard, err := getLeaderboard(storage.DB, request, userId)
+	if err != nil {
+		logger.Error("get leaderboard failed", "error", err.Error())
+		response.Internal(w, r)
+		return
+	}
+	response.JSON(w, http.StatusOK, leaderboard)
+}
+
+// @Summary Общий рейтинг
+// @Tags leaderboard
+// @Description Возвращает первые места рейтинга пользователей по бонусам за шаги и завершение заданий и место авторизованного пользователя.
+// @Description Рейтинг за неделю и месяц строится по периоду, содержащему момент at (по умолчанию - текущий). Списания бонусов не уменьшают очки
+// @id GetLeaderboard
+// @Accept json
+// @Procedure json
+// @router /leaderboard [get]
+// @param period query string false "период, по умолчанию all" Enums(all, week, month)
+// @param at query string false "момент внутри периода (2006-01-02 или RFC3339)"
+// @param limit query int false "кол-во мест, по умолчанию 10, не больше 200"
+// @Success 200 {object} Leaderboard
+// @Failure 400 {object} response.ErrorResponse "неверные параметры запроса"
+// @Security BasicAuth
+// @Security BearerAuth
+func GetLeaderboard(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		writeLeaderboard(storage, logger, w, r, 0)
+	}
+}
+
+// @Summary Рейтинг задания
+// @Tags leaderboard
+// @Description Возвращает рейтинг по бонусам, заработанным в задании. Параметры такие же, как в GetLeaderboard
+// @id GetQuestLeaderboard
+// @Accept json
+// @Procedure json
+// @router /quests/{id}/leaderboard [get]
+// @param id path int true "идентификатор задания"
+// @param period query string false "период, по умолчанию all" Enums(all, week, month)
+// @param at query string false "момент внутри периода (2006-01-02 или RFC3339)"
+// @param limit query int false "кол-во мест, по умолчанию 10, не больше 200"
+// @Success 200 {object} Leaderboard
+// @Failure 400 {object} response.ErrorResponse "неверные параметры запроса"
+// @Failure 404 {object} response.ErrorResponse "задание не найдено"
+// @Security BasicAuth
+// @Security BearerAuth
+func GetQuestLeaderboard(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		questId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil || questId <= 0 {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+		var exists bool
+		err = storage.DB.NewQuery("SELECT EXISTS (SELECT 1 FROM quests WHERE id = {:id})").Bind(dbx.Params{"id": questId}).Row(&exists)
+		if err != nil {
+			logger.Error("get leaderboard failed", "error", err.Error())
+			response.Internal(w, r)
+			return
+		}
+		if !exists {
+			response.Error(w, r, http.StatusNotFound, response.CodeQuestNotFound)
+			return
+		}
+		writeLeaderboard(storage, logger, w, r, questId)
+	}
+}
+
+// @Summary Рейтинг команд
+// @Tags leaderboard
+// @Description Возвращает первые места рейтинга команд по бонусам, заработанным участниками в составе команды, и место команды
+// @Description авторизованного пользователя. С quest_id - рейтинг по бонусам задания. Остальные параметры такие же, как в GetLeaderboard
+// @id GetTeamLeaderboard
+// @Accept json
+// @Procedure json
+// @router /leaderboard/teams [get]
+// @param period query string false "период, по умолчанию all" Enums(all, week, month)
+// @param at query string false "момент внутри периода (2006-01-02 или RFC3339)"
+// @param limit query int false "кол-во мест, по умолчанию 10, не больше 200"
+// @param quest_id query int false "идентификатор задания"
+// @Success 200 {object} TeamLeaderboard
+// @Failure 400 {object} response.ErrorResponse "неверные параметры запроса"
+// @Security BasicAuth
+// @Security BearerAuth
+func GetTeamLeaderboard(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		query := r.URL.Query()
+		request, err := parseLeaderboardRequest(query)
+		if err == nil && query.Get("quest_id") != "" {
+			request.QuestId, err = strconv.Atoi(query.Get("quest_id"))
+			if err != nil || request.QuestId <= 0 {
+				err = response.Field("quest_id", response.RulePositive)
+			}
+		}
+		if err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+
+		var teamId *int
+		if principal, ok := storages.PrincipalFromContext(r.Context()); ok {
+			teamId, err = storages.UserTeamId(storage.DB, principal.UserId)
+		}
+		var leaderboard TeamLeaderboard
+		if err == nil {
+			leaderboard, err = getTeamLeaderboard(storage.DB, request, teamId)
+		}
+		if err != nil {
+			logger.Error("get team leaderboard failed", "error", err.Error())
+			response.Internal(w, r)
+			return
+		}
+		response.JSON(w, http.StatusOK, leaderboard)
+	}
+}
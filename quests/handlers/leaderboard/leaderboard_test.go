@@ -0,0 +1,161 @@
+package leaderboard
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"techno-test_quests/quests/internal/testdb"
+	storages "techno-test_quests/quests/storage"
+)
+
+// Тесты выполняются против тестовой БД, строка подключения задается переменной окружения QUESTS_TEST_DB (см. testdb)
+
+type testFixture struct {
+	storage *storages.Storage
+	userId  int
+	otherId int
+	questId int
+	stepId  int
+}
+
+func newTestFixture(t testing.TB) *testFixture {
+	t.Helper()
+	storage := testdb.Open(t)
+	suffix := testdb.Suffix()
+	f := &testFixture{
+		storage: storage,
+		userId:  testdb.User(t, storage, "u"+suffix),
+		otherId: testdb.User(t, storage, "o"+suffix),
+	}
+	f.questId = testdb.Insert(t, storage, "quests", dbx.Params{"questname": "quest " + suffix})
+	f.stepId = testdb.Insert(t, storage, "queststeps", dbx.Params{"questid": f.questId, "stepname": "step", "bonus": 10, "ismulti": true})
+	return f
+}
+
+// record записывает выполнение шага фикстуры пользователем userId и начисляет бонус шага в журнал
+func (f *testFixture) record(t *testing.T, userId int) {
+	t.Helper()
+	historyId := testdb.Insert(t, f.storage, "history", dbx.Params{"stepid": f.stepId, "userid": userId})
+	if err := storages.CreditStepBonus(f.storage.DB, historyId); err != nil {
+		t.Fatalf("credit step bonus: %s", err)
+	}
+}
+
+// scores возвращает очки пользователя по ключу "период/общий рейтинг"
+func (f *testFixture) scores(t *testing.T, userId int) map[string]int {
+	t.Helper()
+	var rows []struct {
+		Period  string `db:"period"`
+		QuestId int    `db:"quest_id"`
+		Score   int    `db:"score"`
+	}
+	err := f.storage.DB.Select("period", "quest_id", "score").From("leaderboard_scores").
+		Where(dbx.HashExp{"user_id": userId}).All(&rows)
+	if err != nil {
+		t.Fatalf("select leaderboard_scores: %s", err)
+	}
+	result := make(map[string]int)
+	for _, row := range rows {
+		result[fmt.Sprintf("%s/%t", row.Period, row.QuestId == 0)] = row.Score
+	}
+	return result
+}
+
+func TestLedgerUpdatesLeaderboardScores(t *testing.T) {
+	f := newTestFixture(t)
+	f.record(t, f.userId)
+	f.record(t, f.userId)
+
+	for key, score := range f.scores(t, f.userId) {
+		if score != 20 {
+			t.Fatalf("score %s = %d, want 20", key, score)
+		}
+	}
+
+	var entryId int
+	err := f.storage.DB.Select("max(id)").From("bonus_ledger").Where(dbx.HashExp{"user_id": f.userId}).Row(&entryId)
+	if err != nil {
+		t.Fatalf("select ledger entry: %s", err)
+	}
+	err = f.storage.DB.Transactional(func(tx *dbx.Tx) error {
+		_, err := storages.ReverseLedgerEntry(tx, entryId, nil, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ReverseLedgerEntry: %s", err)
+	}
+	result := f.scores(t, f.userId)
+	if len(result) != 6 {
+		t.Fatalf("scores %v, want 3 periods for global and quest leaderboards", result)
+	}
+	for key, score := range result {
+		if score != 10 {
+			t.Fatalf("score %s after reversal = %d, want 10", key, score)
+		}
+	}
+}
+
+func TestGetLeaderboardRanksUsers(t *testing.T) {
+	f := newTestFixture(t)
+	f.record(t, f.otherId)
+	f.record(t, f.userId)
+	f.record(t, f.userId)
+
+	request := leaderboardRequest{Period: PeriodAll, At: time.Now(), Limit: defaultLimit, QuestId: f.questId}
+	leaderboard, err := getLeaderboard(f.storage.DB, request, f.otherId)
+	if err != nil {
+		t.Fatalf("getLeaderboard: %s", err)
+	}
+	if len(leaderboard.Entries) != 2 {
+		t.Fatalf("entries %+v, want 2", leaderboard.Entries)
+	}
+	for i, want := range []struct{ userId, score int }{{f.userId, 20}, {f.otherId, 10}} {
+		entry := leaderboard.Entries[i]
+		if entry.Rank != i+1 || entry.UserId != want.userId || entry.Score != want.score {
+			t.Fatalf("entry %d = %+v, want user %d with score %d", i, entry, want.userId, want.score)
+		}
+	}
+	if leaderboard.Me == nil || leaderboard.Me.Rank != 2 {
+		t.Fatalf("me = %+v, want rank 2", leaderboard.Me)
+	}
+}
+
+func TestLeaderboardReversalKeepsAchievedAt(t *testing.T) {
+	f := newTestFixture(t)
+	f.record(t, f.userId)
+	f.record(t, f.userId)
+	f.record(t, f.otherId)
+
+	request := leaderboardRequest{Period: PeriodAll, At: time.Now(), Limit: defaultLimit, QuestId: f.questId}
+	before, err := getLeaderboard(f.storage.DB, request, f.userId)
+	if err != nil {
+		t.Fatalf("getLeaderboard: %s", err)
+	}
+
+	var entryId int
+	err = f.storage.DB.Select("max(id)").From("bonus_ledger").Where(dbx.HashExp{"user_id": f.userId}).Row(&entryId)
+	if err != nil {
+		t.Fatalf("select ledger entry: %s", err)
+	}
+	err = f.storage.DB.Transactional(func(tx *dbx.Tx) error {
+		_, err := storages.ReverseLedgerEntry(tx, entryId, nil, "test")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ReverseLedgerEntry: %s", err)
+	}
+
+	after, err := getLeaderboard(f.storage.DB, request, f.userId)
+	if err != nil {
+		t.Fatalf("getLeaderboard: %s", err)
+	}
+	if after.Me == nil || after.Me.Score != 10 || !after.Me.AchievedAt.Equal(before.Me.AchievedAt) {
+		t.Fatalf("me after reversal = %+v, want score 10 achieved at %s", after.Me, before.Me.AchievedAt)
+	}
+	//при равенстве очков выше тот, кто набрал их раньше, несмотря на более позднюю отмену
+	if len(after.Entries) != 2 || after.Entries[0].UserId != f.userId || after.Entries[1].UserId != f.otherId {
+		t.Fatalf("entries after reversal %+v, want user %d before user %d", after.Entries, f.userId, f.otherId)
+	}
+}
@@ -0,0 +1,496 @@
+package quest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+	"time"
+	"unicode/utf8"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Маршруты изменения, архивирования и удаления регистрируются с методом (PATCH /quests/{id} и т.д.),
+// поэтому на неподдерживаемый метод отвечает сам ServeMux со статусом 405.
+// Архивное задание или шаг не показываются в списках и не могут быть выполнены, но история по ним сохраняется
+
+var errStepNotExists = errors.New("шаг не существует")
+
+// UpdateQuestRequest model info
+// @Description UpdateQuestRequest json для изменения задания. Незаполненные поля не меняются
+type UpdateQuestRequest struct {
+	Name        *string      `json:"Name"`                                             //Новое имя задания
+	Description *string      `json:"Description"`                                      //Новое описание задания
+	StartsAt    optionalTime `json:"StartsAt" swaggertype:"string" format:"date-time"` //Начало проведения, null снимает ограничение
+	EndsAt      optionalTime `json:"EndsAt" swaggertype:"string" format:"date-time"`   //Окончание проведения, null снимает ограничение
+	Recurrence  *string      `json:"Recurrence"`                                       //Периодичность: none, daily или weekly
+	Cost        *int         `json:"Cost"`                                             //Бонус за завершение задания, уже начисленные бонусы не меняются
+	TeamScoped  *bool        `json:"TeamScoped"`                                       //Командное задание, уже записанная история не меняется
+
+	RequiresQuests *[]int `json:"RequiresQuests"` //Задания, которые нужно завершить перед выполнением шагов, заменяют текущий список
+}
+
+// optionalTime время в запросе на изменение, позволяет отличить отсутствующее поле от явного null
+type optionalTime struct {
+	Set   bool
+	Value *time.Time
+}
+
+func (t *optionalTime) UnmarshalJSON(data []byte) error {
+	t.Set = true
+	return json.Unmarshal(data, &t.Value)
+}
+
+// UpdateStepRequest model info
+// @Description UpdateStepRequest json для изменения шага. Незаполненные поля не меняются
+type UpdateStepRequest struct {
+	StepName *string `json:"StepName"` //Новое имя шага
+	Bonus    *int    `json:"Bonus"`    //Бонус за выполнение шага
+	IsMulti  *bool   `json:"IsMulti"`  //Признак того, что шаг можно выполнять несколько раз
+	storages.StepLimits
+
+	Position      *int      `json:"Position"`      //Порядковый номер шага в задании
+	RequiresSteps *[]string `json:"RequiresSteps"` //Имена шагов задания, которые нужно выполнить перед этим шагом, заменяют текущий список
+}
+
+// pathId возвращает идентификатор из пути запроса
+func pathId(r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// validName проверяет длину имени задания или шага, в БД под него отведено 200 символов
+func validName(name string) bool {
+	length := utf8.RuneCountInString(name)
+	return length > 0 && length <= 200
+}
+
+// findQuest возвращает задание со всеми шагами, включая архивные
+func findQuest(db dbx.Builder, questId int) (Quests, error) {
+	var quest Quests
+	err := db.Select("q.*", questRequiresColumn("q")+" AS requires").From("quests AS q").
+		Where(dbx.HashExp{"q.id": questId}).One(&quest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return quest, errQuestNotExists
+	}
+	if err != nil {
+		return quest, err
+	}
+	quest.Steps, err = getSteps(db, quest.Id, true)
+	return quest, err
+}
+
+// findStep возвращает шаг, в том числе архивный
+func findStep(db dbx.Builder, stepId int) (Steps, error) {
+	var step Steps
+	err := db.Select("s.*", stepRequiresColumn("s")+" AS requires").From("queststeps AS s").
+		Where(dbx.HashExp{"s.id": stepId}).One(&step)
+	if errors.Is(err, sql.ErrNoRows) {
+		return step, errStepNotExists
+	}
+	return step, err
+}
+
+// @Summary Изменить задание
+// @Tags quests
+// @Description Меняет имя, описание, время проведения, периодичность и бонус за завершение задания. Архивное задание изменить нельзя.
+// @Description StartsAt и EndsAt со значением null снимают ограничение по времени.
+// @Description RequiresQuests заменяет список заданий, которые нужно завершить перед выполнением шагов; цикл не допускается
+// @id UpdateQuest
+// @Accept json
+// @Procedure json
+// @router /quests/{id} [patch]
+// @param id path int true "идентификатор задания"
+// @param input body UpdateQuestRequest true "изменяемые поля"
+// @Success 200 {object} Quests
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса или время проведения"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Failure 404 {object} response.ErrorResponse "задание не найдено"
+// @Failure 409 {object} response.ErrorResponse "имя занято, задание в архиве или ошибка в предварительных условиях"
+// @Security BasicAuth
+// @Security BearerAuth
+func UpdateQuest(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		questId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+		var request UpdateQuestRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&request)
+		if err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+		if request.Name != nil && !validName(*request.Name) {
+			response.Invalid(w, r, []response.FieldError{response.Field("Name", response.RuleLength, 1, 200)})
+			return
+		}
+		if request.Cost != nil && *request.Cost < 0 {
+			response.Invalid(w, r, []response.FieldError{response.Field("Cost", response.RuleNotNegative)})
+			return
+		}
+		if request.Recurrence != nil && !storages.ValidRecurrence(*request.Recurrence) {
+			response.Invalid(w, r, []response.FieldError{response.Field("Recurrence", response.RuleOneOf, "none, daily, weekly")})
+			return
+		}
+
+		var quest Quests
+		err = storage.DB.Transactional(func(tx *dbx.Tx) error {
+			var archived bool
+			err := tx.NewQuery("SELECT archived_at IS NOT NULL FROM quests WHERE id = {:id} FOR UPDATE").
+				Bind(dbx.Params{"id": questId}).Row(&archived)
+			if errors.Is(err, sql.ErrNoRows) {
+				return errQuestNotExists
+			}
+			if err != nil {
+				return err
+			}
+			if archived {
+				return errQuestArchived
+			}
+
+			params := dbx.Params{}
+			if request.Name != nil {
+				params["questname"] = *request.Name
+			}
+			if request.Description != nil {
+				params["description"] = *request.Description
+			}
+			if request.StartsAt.Set {
+				params["starts_at"] = request.StartsAt.Value
+			}
+			if request.EndsAt.Set {
+				params["ends_at"] = request.EndsAt.Value
+			}
+			if request.Recurrence != nil {
+				params["recurrence"] = *request.Recurrence
+			}
+			if request.Cost != nil {
+				params["cost"] = *request.Cost
+			}
+			if request.TeamScoped != nil {
+				params["team_scoped"] = *request.TeamScoped
+			}
+			if len(params) > 0 {
+				_, err = tx.Update(quest.TableName(), params, dbx.HashExp{"id": questId}).Execute()
+				if err != nil {
+					return err
+				}
+			}
+			if request.RequiresQuests != nil {
+				err = setQuestPrerequisites(tx, questId, *request.RequiresQuests)
+				if err != nil {
+					return err
+				}
+			}
+			quest, err = findQuest(tx, questId)
+			return err
+		})
+		switch {
+		case err == nil:
+			response.JSON(w, http.StatusOK, quest)
+		case errors.Is(err, errQuestNotExists):
+			response.Error(w, r, http.StatusNotFound, response.CodeQuestNotFound)
+		case errors.Is(err, errQuestArchived):
+			response.Error(w, r, http.StatusConflict, response.CodeQuestArchived)
+		case storages.ConstraintName(err) == "quests_questname_key":
+			response.Error(w, r, http.StatusConflict, response.CodeQuestExists)
+		case storages.IsCheckViolation(err):
+			response.Invalid(w, r, []response.FieldError{response.Field("EndsAt", response.RuleAfter, "StartsAt")})
+		case errors.Is(err, errPrerequisiteNotExists), errors.Is(err, errPrerequisiteCycle):
+			response.ErrorDetails(w, r, http.StatusConflict, response.CodeInvalidPrerequisite, response.DetailsOf(err))
+		default:
+			logger.Error("update quest failed", "error", err.Error())
+			response.Internal(w, r)
+		}
+	}
+}
+
+// @Summary Архивировать задание
+// @Tags quests
+// @Description Переводит задание в архив. Шаги архивного задания нельзя выполнить, история и начисленные бонусы сохраняются
+// @id ArchiveQuest
+// @Accept json
+// @Procedure json
+// @router /quests/{id}/archive [post]
+// @param id path int true "идентификатор задания"
+// @Success 200 {object} Quests
+// @Failure 404 {object} response.ErrorResponse "задание не найдено"
+// @Security BasicAuth
+// @Security BearerAuth
+func ArchiveQuest(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		questId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+
+		var quest Quests
+		err := storage.DB.Transactional(func(tx *dbx.Tx) error {
+			_, err := tx.NewQuery("UPDATE quests SET archived_at = coalesce(archived_at, now()) WHERE id = {:id}").
+				Bind(dbx.Params{"id": questId}).Execute()
+			if err != nil {
+				return err
+			}
+			quest, err = findQuest(tx, questId)
+			return err
+		})
+		switch {
+		case err == nil:
+			response.JSON(w, http.StatusOK, quest)
+		case errors.Is(err, errQuestNotExists):
+			response.Error(w, r, http.StatusNotFound, response.CodeQuestNotFound)
+		default:
+			logger.Error("archive quest failed", "error", err.Error())
+			response.Internal(w, r)
+		}
+	}
+}
+
+// @Summary Удалить задание
+// @Tags quests
+// @Description Удаляет задание вместе с шагами. Задание, по шагам которого есть история выполнения, удалить нельзя - его нужно архивировать
+// @id DeleteQuest
+// @Accept json
+// @Procedure json
+// @router /quests/{id} [delete]
+// @param id path int true "идентификатор задания"
+// @Success 204
+// @Failure 404 {object} response.ErrorResponse "задание не найдено"
+// @Failure 409 {object} response.ErrorResponse "по заданию есть история выполнения"
+// @Security BasicAuth
+// @Security BearerAuth
+func DeleteQuest(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		questId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+
+		result, err := storage.DB.Delete("quests", dbx.HashExp{"id": questId}).Execute()
+		if storages.IsForeignKeyViolation(err) {
+			response.Error(w, r, http.StatusConflict, response.CodeQuestHasHistory)
+			return
+		}
+		if err != nil {
+			logger.Error("delete quest failed", "error", err.Error())
+			response.Internal(w, r)
+			return
+		}
+		if deleted, _ := result.RowsAffected(); deleted == 0 {
+			response.Error(w, r, http.StatusNotFound, response.CodeQuestNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary Изменить шаг
+// @Tags quests
+// @Description Меняет имя, бонус и правила выполнения шага. Архивный шаг и шаг архивного задания изменить нельзя.
+// @Description Значение 0 в MaxCompletions, CooldownSeconds и GlobalLimit снимает ограничение.
+// @Description RequiresSteps заменяет список шагов, которые нужно выполнить перед этим шагом; цикл в предварительных условиях не допускается
+// @id UpdateStep
+// @Accept json
+// @Procedure json
+// @router /steps/{id} [patch]
+// @param id path int true "идентификатор шага"
+// @param input body UpdateStepRequest true "изменяемые поля"
+// @Success 200 {object} Steps
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Failure 404 {object} response.ErrorResponse "шаг не найден"
+// @Failure 409 {object} response.ErrorResponse "имя занято, шаг в архиве или ошибка в предварительных условиях"
+// @Security BasicAuth
+// @Security BearerAuth
+func UpdateStep(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		stepId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+		var request UpdateStepRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&request)
+		if err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+		limits, errlist := request.UpdateParams(request.IsMulti)
+		if request.StepName != nil && !validName(*request.StepName) {
+			errlist = append(errlist, response.Field("StepName", response.RuleLength, 1, 200))
+		}
+		if request.Bonus != nil && *request.Bonus < 0 {
+			errlist = append(errlist, response.Field("Bonus", response.RuleNotNegative))
+		}
+		if request.Position != nil && *request.Position <= 0 {
+			errlist = append(errlist, response.Field("Position", response.RulePositive))
+		}
+		if len(errlist) > 0 {
+			response.Invalid(w, r, errlist)
+			return
+		}
+
+		var step Steps
+		err = storage.DB.Transactional(func(tx *dbx.Tx) error {
+			var archived bool
+			var questId int
+			err := tx.NewQuery(`SELECT s.archived_at IS NOT NULL OR q.archived_at IS NOT NULL, s.questid
+								FROM queststeps AS s
+								JOIN quests AS q ON q.id = s.questid
+								WHERE s.id = {:id}
+								FOR UPDATE OF s`).Bind(dbx.Params{"id": stepId}).Row(&archived, &questId)
+			if errors.Is(err, sql.ErrNoRows) {
+				return errStepNotExists
+			}
+			if err != nil {
+				return err
+			}
+			if archived {
+				return errQuestArchived
+			}
+
+			params := limits
+			if request.StepName != nil {
+				params["stepname"] = *request.StepName
+			}
+			if request.Bonus != nil {
+				params["bonus"] = *request.Bonus
+			}
+			if request.Position != nil {
+				params["position"] = *request.Position
+			}
+			if len(params) > 0 {
+				_, err = tx.Update("queststeps", params, dbx.HashExp{"id": stepId}).Execute()
+				if err != nil {
+					return err
+				}
+			}
+			if request.RequiresSteps != nil {
+				err = setStepPrerequisites(tx, questId, stepId, *request.RequiresSteps)
+				if err != nil {
+					return err
+				}
+			}
+			step, err = findStep(tx, stepId)
+			return err
+		})
+		switch {
+		case err == nil:
+			response.JSON(w, http.StatusOK, step)
+		case errors.Is(err, errStepNotExists):
+			response.Error(w, r, http.StatusNotFound, response.CodeStepNotFound)
+		case errors.Is(err, errQuestArchived):
+			response.Error(w, r, http.StatusConflict, response.CodeStepArchived)
+		case storages.ConstraintName(err) == "queststeps_questid_stepname_key":
+			response.Error(w, r, http.StatusConflict, response.CodeStepExists)
+		case errors.Is(err, errPrerequisiteNotExists), errors.Is(err, errPrerequisiteCycle):
+			response.ErrorDetails(w, r, http.StatusConflict, response.CodeInvalidPrerequisite, response.DetailsOf(err))
+		default:
+			logger.Error("update step failed", "error", err.Error())
+			response.Internal(w, r)
+		}
+	}
+}
+
+// @Summary Архивировать шаг
+// @Tags quests
+// @Description Переводит шаг в архив. Архивный шаг нельзя выполнить, история и начисленные бонусы сохраняются
+// @id ArchiveStep
+// @Accept json
+// @Procedure json
+// @router /steps/{id}/archive [post]
+// @param id path int true "идентификатор шага"
+// @Success 200 {object} Steps
+// @Failure 404 {object} response.ErrorResponse "шаг не найден"
+// @Security BasicAuth
+// @Security BearerAuth
+func ArchiveStep(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		stepId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+
+		var step Steps
+		err := storage.DB.Transactional(func(tx *dbx.Tx) error {
+			_, err := tx.NewQuery("UPDATE queststeps SET archived_at = coalesce(archived_at, now()) WHERE id = {:id}").
+				Bind(dbx.Params{"id": stepId}).Execute()
+			if err != nil {
+				return err
+			}
+			step, err = findStep(tx, stepId)
+			return err
+		})
+		switch {
+		case err == nil:
+			response.JSON(w, http.StatusOK, step)
+		case errors.Is(err, errStepNotExists):
+			response.Error(w, r, http.StatusNotFound, response.CodeStepNotFound)
+		default:
+			logger.Error("archive step failed", "error", err.Error())
+			response.Internal(w, r)
+		}
+	}
+}
+
+// @Summary Удалить шаг
+// @Tags quests
+// @Description Удаляет шаг. Шаг, по которому есть история выполнения, удалить нельзя - его нужно архивировать
+// @id DeleteStep
+// @Accept json
+// @Procedure json
+// @router /steps/{id} [delete]
+// @param id path int true "идентификатор шага"
+// @Success 204
+// @Failure 404 {object} response.ErrorResponse "шаг не найден"
+// @Failure 409 {object} response.ErrorResponse "по шагу есть история выполнения"
+// @Security BasicAuth
+// @Security BearerAuth
+func DeleteStep(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		stepId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+
+		result, err := storage.DB.Delete("queststeps", dbx.HashExp{"id": stepId}).Execute()
+		if storages.IsForeignKeyViolation(err) {
+			response.Error(w, r, http.StatusConflict, response.CodeStepHasHistory)
+			return
+		}
+		if err != nil {
+			logger.Error("delete step failed", "error", err.Error())
+			response.Internal(w, r)
+			return
+		}
+		if deleted, _ := result.RowsAffected(); deleted == 0 {
+			response.Error(w, r, http.StatusNotFound, response.CodeStepNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
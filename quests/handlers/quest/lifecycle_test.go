@@ -0,0 +1,157 @@
+package quest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"techno-test_quests/quests/internal/testdb"
+	"techno-test_quests/quests/response"
+)
+
+// serve выполняет запрос через маршруты изменения, архивирования и удаления, зарегистрированные как в main, но без проверки авторизации
+func (f *testFixture) serve(method, target, body string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PATCH /quests/{id}", UpdateQuest(f.storage, f.logger))
+	mux.HandleFunc("DELETE /quests/{id}", DeleteQuest(f.storage, f.logger))
+	mux.HandleFunc("POST /quests/{id}/archive", ArchiveQuest(f.storage, f.logger))
+	mux.HandleFunc("PATCH /steps/{id}", UpdateStep(f.storage, f.logger))
+	mux.HandleFunc("DELETE /steps/{id}", DeleteStep(f.storage, f.logger))
+	mux.HandleFunc("POST /steps/{id}/archive", ArchiveStep(f.storage, f.logger))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+// step добавляет шаг к заданию фикстуры и возвращает его идентификатор
+func (f *testFixture) step(t *testing.T, name string) int {
+	t.Helper()
+	return testdb.Insert(t, f.storage, "queststeps", dbx.Params{"questid": f.questId, "stepname": name, "bonus": 10, "ismulti": true})
+}
+
+func TestDeleteWithHistoryIsRejected(t *testing.T) {
+	f := newTestFixture(t)
+	stepId := f.step(t, "step")
+	userId := testdb.User(t, f.storage, "u"+testdb.Suffix())
+	testdb.Insert(t, f.storage, "history", dbx.Params{"stepid": stepId, "userid": userId})
+
+	for _, test := range []struct {
+		target string
+		code   string
+	}{
+		{fmt.Sprintf("/steps/%d", stepId), response.CodeStepHasHistory},
+		{fmt.Sprintf("/quests/%d", f.questId), response.CodeQuestHasHistory},
+	} {
+		if w := f.serve(http.MethodDelete, test.target, ""); w.Code != http.StatusConflict || errorCode(t, w) != test.code {
+			t.Fatalf("DELETE %s: status %d, body %s, want 409 %s", test.target, w.Code, w.Body.String(), test.code)
+		}
+	}
+
+	//задание и шаг с историей остаются на месте
+	if quest := f.getQuest(t); len(quest.Steps) != 1 || quest.Steps[0].Id != stepId {
+		t.Fatalf("quest after rejected delete %+v, want step %d", quest, stepId)
+	}
+}
+
+func TestDeleteWithoutHistory(t *testing.T) {
+	f := newTestFixture(t)
+	stepId := f.step(t, "step")
+	f.step(t, "other")
+
+	stepTarget := fmt.Sprintf("/steps/%d", stepId)
+	questTarget := fmt.Sprintf("/quests/%d", f.questId)
+	for _, test := range []struct {
+		target string
+		status int
+		code   string
+	}{
+		{stepTarget, http.StatusNoContent, ""},
+		{stepTarget, http.StatusNotFound, response.CodeStepNotFound},
+		//шаги без истории удаляются вместе с заданием
+		{questTarget, http.StatusNoContent, ""},
+		{questTarget, http.StatusNotFound, response.CodeQuestNotFound},
+		{"/steps/0", http.StatusBadRequest, response.CodeInvalidParameter},
+	} {
+		w := f.serve(http.MethodDelete, test.target, "")
+		if w.Code != test.status || test.code != "" && errorCode(t, w) != test.code {
+			t.Fatalf("DELETE %s: status %d, body %s, want %d %s", test.target, w.Code, w.Body.String(), test.status, test.code)
+		}
+	}
+}
+
+func TestArchiveStep(t *testing.T) {
+	f := newTestFixture(t)
+	stepId := f.step(t, "step")
+	target := fmt.Sprintf("/steps/%d/archive", stepId)
+
+	archive := func() Steps {
+		t.Helper()
+		w := f.serve(http.MethodPost, target, "")
+		var step Steps
+		if err := json.Unmarshal(w.Body.Bytes(), &step); err != nil || w.Code != http.StatusOK || step.ArchivedAt == nil {
+			t.Fatalf("POST %s: status %d, body %s", target, w.Code, w.Body.String())
+		}
+		return step
+	}
+	first := archive()
+	//повторное архивирование не меняет время архивирования
+	if second := archive(); !second.ArchivedAt.Equal(*first.ArchivedAt) {
+		t.Fatalf("archived at %s after second archive, want %s", second.ArchivedAt, first.ArchivedAt)
+	}
+
+	if w := f.serve(http.MethodPatch, fmt.Sprintf("/steps/%d", stepId), `{"Bonus":20}`); w.Code != http.StatusConflict || errorCode(t, w) != response.CodeStepArchived {
+		t.Fatalf("PATCH archived step: status %d, body %s", w.Code, w.Body.String())
+	}
+	w := f.updateQuestSteps(fmt.Sprintf(`{"QuestSteps":[{"id":%d,"Bonus":20,"IsMulti":true}]}`, stepId))
+	if w.Code != http.StatusConflict || errorCode(t, w) != response.CodeStepArchived {
+		t.Fatalf("UpdateQuestSteps archived step: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w := f.serve(http.MethodPost, fmt.Sprintf("/steps/%d/archive", math.MaxInt32), ""); w.Code != http.StatusNotFound || errorCode(t, w) != response.CodeStepNotFound {
+		t.Fatalf("archive unknown step: status %d, body %s", w.Code, w.Body.String())
+	}
+}
+
+func TestArchiveQuest(t *testing.T) {
+	f := newTestFixture(t)
+	stepId := f.step(t, "step")
+	otherId := f.step(t, "other")
+
+	w := f.serve(http.MethodPost, fmt.Sprintf("/quests/%d/archive", f.questId), "")
+	var quest Quests
+	if err := json.Unmarshal(w.Body.Bytes(), &quest); err != nil || w.Code != http.StatusOK || quest.ArchivedAt == nil {
+		t.Fatalf("archive quest: status %d, body %s", w.Code, w.Body.String())
+	}
+	//шаги архивного задания остаются в нем, но изменить их нельзя
+	if len(quest.Steps) != 2 {
+		t.Fatalf("archived quest steps %+v, want 2", quest.Steps)
+	}
+
+	for _, test := range []struct {
+		name string
+		w    *httptest.ResponseRecorder
+		code string
+	}{
+		{"PATCH quest", f.serve(http.MethodPatch, fmt.Sprintf("/quests/%d", f.questId), `{"Cost":5}`), response.CodeQuestArchived},
+		{"PATCH step", f.serve(http.MethodPatch, fmt.Sprintf("/steps/%d", stepId), `{"Bonus":20}`), response.CodeStepArchived},
+		{"UpdateQuestSteps", f.updateQuestSteps(fmt.Sprintf(`{"QuestSteps":[{"id":%d,"Bonus":20,"IsMulti":true},{"id":%d,"IsMulti":true}]}`,
+			stepId, otherId)), response.CodeStepArchived},
+	} {
+		if test.w.Code != http.StatusConflict || errorCode(t, test.w) != test.code {
+			t.Fatalf("%s of archived quest: status %d, body %s, want 409 %s", test.name, test.w.Code, test.w.Body.String(), test.code)
+		}
+	}
+
+	var bonus int
+	if err := f.storage.DB.Select("bonus").From("queststeps").Where(dbx.HashExp{"id": stepId}).Row(&bonus); err != nil || bonus != 10 {
+		t.Fatalf("bonus of archived quest step = %d, %v, want unchanged 10", bonus, err)
+	}
+	if w := f.serve(http.MethodPost, fmt.Sprintf("/quests/%d/archive", math.MaxInt32), ""); w.Code != http.StatusNotFound || errorCode(t, w) != response.CodeQuestNotFound {
+		t.Fatalf("archive unknown quest: status %d, body %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,192 @@
+package quest
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	storages "techno-test_quests/quests/storage"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/lib/pq"
+)
+
+// questSortColumns ключи сортировки заданий
+var questSortColumns = map[string]string{
+	"id":      "id",
+	"name":    "questname",
+	"created": "created_at",
+}
+
+// questFilter фильтры списка заданий
+type questFilter struct {
+	Name            string     //имя задания содержит строку
+	Multi           *bool      //есть или нет действующих шагов с многократным выполнением
+	IncludeArchived bool       //включить архивные задания и шаги
+	Active          *bool      //задание проводится или не проводится сейчас
+	CreatedFrom     *time.Time //создано не раньше
+	CreatedTo       *time.Time //создано раньше
+}
+
+// parseQuestFilter разбирает фильтры и параметры постраничной выдачи списка заданий
+func parseQuestFilter(query url.Values) (questFilter, storages.PageRequest, error) {
+	filter := questFilter{Name: query.Get("name")}
+	page, err := storages.ParsePageRequest(query, questSortColumns, "id")
+	if err != nil {
+		return filter, page, err
+	}
+	if filter.Multi, err = storages.ParseBoolParam(query, "multi"); err != nil {
+		return filter, page, err
+	}
+	archived, err := storages.ParseBoolParam(query, "archived")
+	if err != nil {
+		return filter, page, err
+	}
+	filter.IncludeArchived = archived != nil && *archived
+	if filter.Active, err = storages.ParseBoolParam(query, "active"); err != nil {
+		return filter, page, err
+	}
+	if filter.CreatedFrom, err = storages.ParseTimeParam(query, "created_from"); err != nil {
+		return filter, page, err
+	}
+	if filter.CreatedTo, err = storages.ParseTimeParam(query, "created_to"); err != nil {
+		return filter, page, err
+	}
+	return filter, page, nil
+}
+
+// apply добавляет условия фильтра к запросу заданий с псевдонимом q
+func (filter questFilter) apply(q *dbx.SelectQuery) {
+	if !filter.IncludeArchived {
+		q.AndWhere(dbx.NewExp("q.archived_at IS NULL"))
+	}
+	if filter.Name != "" {
+		q.AndWhere(dbx.NewExp("q.questname ILIKE {:name}", dbx.Params{"name": storages.ContainsPattern(filter.Name)}))
+	}
+	if filter.Multi != nil {
+		exists := "EXISTS (SELECT 1 FROM queststeps AS m WHERE m.questid = q.id AND m.ismulti AND m.archived_at IS NULL)"
+		if !*filter.Multi {
+			exists = "NOT " + exists
+		}
+		q.AndWhere(dbx.NewExp(exists))
+	}
+	if filter.Active != nil {
+		active := "(q.starts_at IS NULL OR q.starts_at <= now()) AND (q.ends_at IS NULL OR q.ends_at > now())"
+		if !*filter.Active {
+			active = "NOT (" + active + ")"
+		}
+		q.AndWhere(dbx.NewExp(active))
+	}
+	if filter.CreatedFrom != nil {
+		q.AndWhere(dbx.NewExp("q.created_at >= {:created_from}", dbx.Params{"created_from": *filter.CreatedFrom}))
+	}
+	if filter.CreatedTo != nil {
+		q.AndWhere(dbx.NewExp("q.created_at < {:created_to}", dbx.Params{"created_to": *filter.CreatedTo}))
+	}
+}
+
+// questCursorKey возвращает значения для курсора страницы заданий
+func questCursorKey(page storages.PageRequest, id int, name string, createdAt time.Time) (string, int) {
+	switch page.Sort {
+	case "name":
+		return name, id
+	case "created":
+		return storages.CursorTime(createdAt), id
+	default:
+		return "", id
+	}
+}
+
+// questStepRow строка выборки задания с одним из его шагов
+type questStepRow struct {
+	Id             int           `db:"id"`
+	QuestName      string        `db:"questname"`
+	Description    string        `db:"description"`
+	StartsAt       *time.Time    `db:"starts_at"`
+	EndsAt         *time.Time    `db:"ends_at"`
+	Recurrence     string        `db:"recurrence"`
+	Cost           int           `db:"cost"`
+	TeamScoped     bool          `db:"team_scoped"`
+	ArchivedAt     *time.Time    `db:"archived_at"`
+	CreatedAt      time.Time     `db:"created_at"`
+	Requires       pq.Int64Array `db:"requires"`
+	StepId         *int          `db:"step_id"`
+	StepName       *string       `db:"stepname"`
+	Bonus          int           `db:"bonus"`
+	IsMulti        bool          `db:"ismulti"`
+	StepArchivedAt *time.Time    `db:"step_archived_at"`
+	MaxCompletions *int          `db:"max_completions"`
+	Cooldown       *int          `db:"cooldown_seconds"`
+	GlobalLimit    *int          `db:"global_limit"`
+	Position       int           `db:"position"`
+	StepRequires   pq.Int64Array `db:"step_requires"`
+}
+
+// fetchQuests возвращает страницу заданий с шагами одним запросом: страница заданий выбирается подзапросом
+// и соединяется с шагами
+func fetchQuests(db *dbx.DB, filter questFilter, page storages.PageRequest) (storages.Page[Quests], error) {
+	q := db.Select("q.id", "q.questname", "q.description", "q.starts_at", "q.ends_at", "q.recurrence", "q.cost", "q.team_scoped", "q.archived_at", "q.created_at",
+		questRequiresColumn("q")+" AS requires").
+		From("quests AS q")
+	filter.apply(q)
+	questPage := page.Apply(q, "q").Build()
+
+	stepCondition := ""
+	if !filter.IncludeArchived {
+		stepCondition = " AND s.archived_at IS NULL"
+	}
+	queryText := `SELECT p.id, p.questname, p.description, p.starts_at, p.ends_at, p.recurrence, p.cost, p.team_scoped, p.archived_at, p.created_at, p.requires,
+						s.id AS step_id, s.stepname, coalesce(s.bonus, 0) AS bonus, coalesce(s.ismulti, false) AS ismulti,
+						s.archived_at AS step_archived_at, s.max_completions, s.cooldown_seconds, s.global_limit,
+						coalesce(s.position, 0) AS position, ` + stepRequiresColumn("s") + ` AS step_requires
+					FROM (` + questPage.SQL() + `) AS p
+					LEFT JOIN queststeps AS s ON s.questid = p.id` + stepCondition + `
+					ORDER BY ` + strings.Join(page.OrderBy("p"), ", ") + `, s.position, s.id`
+	var rows []questStepRow
+	err := db.NewQuery(queryText).Bind(questPage.Params()).All(&rows)
+	if err != nil {
+		return storages.Page[Quests]{}, err
+	}
+
+	var quests []Quests
+	for _, row := range rows {
+		last := len(quests) - 1
+		if last < 0 || quests[last].Id != strconv.Itoa(row.Id) {
+			quests = append(quests, Quests{
+				Id:          strconv.Itoa(row.Id),
+				QuestName:   row.QuestName,
+				Description: row.Description,
+				StartsAt:    row.StartsAt,
+				EndsAt:      row.EndsAt,
+				Recurrence:  row.Recurrence,
+				Cost:        row.Cost,
+				TeamScoped:  row.TeamScoped,
+				ArchivedAt:  row.ArchivedAt,
+				CreatedAt:   row.CreatedAt,
+
+				RequiresQuests: row.Requires,
+			})
+			last++
+		}
+		if row.StepId != nil {
+			quests[last].Steps = append(quests[last].Steps, Steps{
+				StepName:   *row.StepName,
+				Id:         *row.StepId,
+				Bonus:      row.Bonus,
+				IsMulti:    row.IsMulti,
+				ArchivedAt: row.StepArchivedAt,
+
+				MaxCompletions:  row.MaxCompletions,
+				CooldownSeconds: row.Cooldown,
+				GlobalLimit:     row.GlobalLimit,
+
+				Position:      row.Position,
+				RequiresSteps: row.StepRequires,
+			})
+		}
+	}
+	return storages.NewPage(page, quests, func(quest Quests) (string, int) {
+		id, _ := strconv.Atoi(quest.Id)
+		return questCursorKey(page, id, quest.QuestName, quest.CreatedAt)
+	}), nil
+}
@@ -0,0 +1,141 @@
+package quest
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/lib/pq"
+)
+
+// Шаг может требовать выполнения других шагов того же задания, задание - завершения других заданий.
+// Графы предварительных условий не должны содержать циклов, иначе шаги из цикла никогда не станут доступны.
+// Изменения графа выполняются под advisory блокировкой, чтобы параллельные транзакции не создали цикл в обход проверки
+
+var (
+	errPrerequisiteNotExists = errors.New("предварительное условие ссылается на несуществующий шаг или задание")
+	errPrerequisiteCycle     = errors.New("предварительные условия образуют цикл")
+)
+
+// stepRequiresColumn возвращает выражение для выборки идентификаторов шагов, которые требует шаг с псевдонимом alias
+func stepRequiresColumn(alias string) string {
+	return "ARRAY(SELECT sp.required_step_id FROM step_prerequisites AS sp WHERE sp.step_id = " + alias + ".id ORDER BY 1)"
+}
+
+// questRequiresColumn возвращает выражение для выборки идентификаторов заданий, которые требует задание с псевдонимом alias
+func questRequiresColumn(alias string) string {
+	return "ARRAY(SELECT qp.required_quest_id FROM quest_prerequisites AS qp WHERE qp.quest_id = " + alias + ".id ORDER BY 1)"
+}
+
+// nextStepPosition возвращает порядковый номер для шага, добавляемого в конец задания
+func nextStepPosition(db dbx.Builder, questId int) (int, error) {
+	var position int
+	err := db.NewQuery("SELECT coalesce(max(position), 0) + 1 FROM queststeps WHERE questid = {:questid}").
+		Bind(dbx.Params{"questid": questId}).Row(&position)
+	return position, err
+}
+
+// setStepPrerequisites заменяет шаги, которые требует шаг stepId. names - имена действующих шагов того же задания
+func setStepPrerequisites(db dbx.Builder, questId, stepId int, names []string) error {
+	_, err := db.NewQuery("SELECT pg_advisory_xact_lock(hashtext('step_prerequisites'), {:questid})").
+		Bind(dbx.Params{"questid": questId}).Execute()
+	if err != nil {
+		return err
+	}
+
+	var requiredIds []int
+	var found []string
+	rows, err := db.NewQuery(`SELECT id, stepname FROM queststeps
+								WHERE questid = {:questid} AND archived_at IS NULL AND stepname = ANY({:names})`).
+		Bind(dbx.Params{"questid": questId, "names": pq.Array(names)}).Rows()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int
+		var name string
+		if err = rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		requiredIds = append(requiredIds, id)
+		found = append(found, name)
+	}
+	rows.Close()
+	for _, name := range names {
+		if !slices.Contains(found, name) {
+			return fmt.Errorf("%w: %w", response.Field("RequiresSteps", response.RuleRejected, name), errPrerequisiteNotExists)
+		}
+	}
+	if slices.Contains(requiredIds, stepId) {
+		return fmt.Errorf("%w: %w", response.Field("RequiresSteps", response.RuleCycle), errPrerequisiteCycle)
+	}
+
+	_, err = db.Delete("step_prerequisites", dbx.HashExp{"step_id": stepId}).Execute()
+	if err != nil {
+		return err
+	}
+	if len(requiredIds) == 0 {
+		return nil
+	}
+	_, err = db.NewQuery(`INSERT INTO step_prerequisites (step_id, required_step_id)
+							SELECT {:stepid}, unnest({:ids}::integer[])`).
+		Bind(dbx.Params{"stepid": stepId, "ids": pq.Array(requiredIds)}).Execute()
+	if err != nil {
+		return err
+	}
+	return checkPrerequisiteCycle(db, "step_prerequisites", "step_id", "required_step_id", stepId)
+}
+
+// setQuestPrerequisites заменяет задания, которые требует задание questId
+func setQuestPrerequisites(db dbx.Builder, questId int, requiredIds []int) error {
+	_, err := db.NewQuery("SELECT pg_advisory_xact_lock(hashtext('quest_prerequisites'), 0)").Execute()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(requiredIds, questId) {
+		return fmt.Errorf("%w: %w", response.Field("RequiresQuests", response.RuleCycle), errPrerequisiteCycle)
+	}
+
+	_, err = db.Delete("quest_prerequisites", dbx.HashExp{"quest_id": questId}).Execute()
+	if err != nil {
+		return err
+	}
+	if len(requiredIds) == 0 {
+		return nil
+	}
+	_, err = db.NewQuery(`INSERT INTO quest_prerequisites (quest_id, required_quest_id)
+							SELECT DISTINCT {:questid}, unnest({:ids}::integer[])`).
+		Bind(dbx.Params{"questid": questId, "ids": pq.Array(requiredIds)}).Execute()
+	if storages.IsForeignKeyViolation(err) {
+		return fmt.Errorf("%w: %w", response.Field("RequiresQuests", response.RuleRejected, requiredIds), errPrerequisiteNotExists)
+	}
+	if err != nil {
+		return err
+	}
+	return checkPrerequisiteCycle(db, "quest_prerequisites", "quest_id", "required_quest_id", questId)
+}
+
+// checkPrerequisiteCycle возвращает errPrerequisiteCycle, если из вершины id графа table можно вернуться в нее же
+func checkPrerequisiteCycle(db dbx.Builder, table, fromColumn, toColumn string, id int) error {
+	queryText := strings.NewReplacer("{table}", table, "{from}", fromColumn, "{to}", toColumn).Replace(`
+		WITH RECURSIVE reach(id) AS (
+			SELECT {to} FROM {table} WHERE {from} = {:id}
+			UNION
+			SELECT t.{to} FROM reach AS r JOIN {table} AS t ON t.{from} = r.id
+		)
+		SELECT EXISTS (SELECT 1 FROM reach WHERE id = {:id})`)
+	var cycle bool
+	err := db.NewQuery(queryText).Bind(dbx.Params{"id": id}).Row(&cycle)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return errPrerequisiteCycle
+	}
+	return nil
+}
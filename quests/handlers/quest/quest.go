@@ -1,22 +1,36 @@
 package quest
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/lib/pq"
 	"log/slog"
 	"net/http"
-	"strconv"
+	"strings"
+	"techno-test_quests/quests/response"
 	storages "techno-test_quests/quests/storage"
+	"time"
 )
 
 // Quests model info
 // @Description Quests json информация о заданиях и их шагов
 type Quests struct {
-	Id        string  `json:"Id" db:"id"`               //ИД задания
-	QuestName string  `json:"QuestName" db:"questname"` //Имя выполненного задания пользователем
-	Steps     []Steps `json:"Steps" db:"-`              //Шаги задания
+	Id          string     `json:"Id" db:"id"`                            //ИД задания
+	QuestName   string     `json:"QuestName" db:"questname"`              //Имя выполненного задания пользователем
+	Description string     `json:"Description" db:"description"`          //Описание задания
+	StartsAt    *time.Time `json:"StartsAt" db:"starts_at"`               //Начало проведения задания
+	EndsAt      *time.Time `json:"EndsAt" db:"ends_at"`                   //Окончание проведения задания
+	Recurrence  string     `json:"Recurrence" db:"recurrence"`            //Периодичность: none, daily или weekly
+	Cost        int        `json:"Cost" db:"cost"`                        //Бонус за завершение задания
+	TeamScoped  bool       `json:"TeamScoped" db:"team_scoped"`           //Командное задание: выполнение шага участником засчитывается всей команде
+	ArchivedAt  *time.Time `json:"ArchivedAt,omitempty" db:"archived_at"` //Время архивирования задания
+	CreatedAt   time.Time  `json:"CreatedAt" db:"created_at"`             //Время создания задания
+	Steps       []Steps    `json:"Steps" db:"-"`                          //Шаги задания
+
+	RequiresQuests pq.Int64Array `json:"RequiresQuests" db:"requires" swaggertype:"array,integer"` //Задания, которые нужно завершить перед выполнением шагов
 }
 
 func (quest *Quests) TableName() string {
@@ -24,59 +38,112 @@ func (quest *Quests) TableName() string {
 }
 
 type Steps struct {
-	StepName string `json:"StepName" db:"stepname"` //Имя шага
-	Id       int    `json:"Id" db:"id"`             //ИД шага
-	Bonus    int    `json:"Bonus" db:"bonus"`       //Бонус за выполнение шага
-	IsMulti  bool   `json:"isMulti" db:"ismulti"`   //Признак того, что шаг можно выполнять повторно
+	StepName   string     `json:"StepName" db:"stepname"`                //Имя шага
+	Id         int        `json:"Id" db:"id"`                            //ИД шага
+	Bonus      int        `json:"Bonus" db:"bonus"`                      //Бонус за выполнение шага
+	IsMulti    bool       `json:"isMulti" db:"ismulti"`                  //Признак того, что шаг можно выполнять повторно
+	ArchivedAt *time.Time `json:"ArchivedAt,omitempty" db:"archived_at"` //Время архивирования шага
+
+	MaxCompletions  *int `json:"MaxCompletions" db:"max_completions"`   //Сколько раз пользователь может выполнить шаг (за период), пусто - без ограничения
+	CooldownSeconds *int `json:"CooldownSeconds" db:"cooldown_seconds"` //Минимальный интервал между выполнениями шага пользователем в секундах
+	GlobalLimit     *int `json:"GlobalLimit" db:"global_limit"`         //Сколько раз шаг могут выполнить все пользователи вместе
+
+	Position      int           `json:"Position" db:"position"`                                  //Порядковый номер шага в задании
+	RequiresSteps pq.Int64Array `json:"RequiresSteps" db:"requires" swaggertype:"array,integer"` //Шаги задания, которые нужно выполнить перед этим шагом
 }
 
-// @Summary Обновить шаг к заданию
+// stepAvailableCondition возвращает условие доступности шага step задания quest пользователю {:userid} из команды {:teamid}
+// с учетом ограничений числа выполнений, интервала между выполнениями, общего ограничения и предварительных условий.
+// В командном задании учитываются выполнения всех участников команды
+func stepAvailableCondition(step, quest string) string {
+	return strings.NewReplacer("{step}", step, "{quest}", quest).Replace(`({step}.max_completions IS NULL OR {step}.max_completions > (
+				SELECT count(*) FROM history AS ah WHERE ah.stepid = {step}.id AND ` + historyOwnerCondition("ah", quest) + `
+					AND ah.completed_at >= quest_period_start({quest}.recurrence, {quest}.starts_at, now())))
+		AND ({step}.cooldown_seconds IS NULL OR NOT EXISTS (
+				SELECT 1 FROM history AS ah WHERE ah.stepid = {step}.id AND ` + historyOwnerCondition("ah", quest) + `
+					AND ah.completed_at > now() - {step}.cooldown_seconds * interval '1 second'))
+		AND ({step}.global_limit IS NULL OR {step}.global_limit > (SELECT count(*) FROM history AS ah WHERE ah.stepid = {step}.id))
+		AND step_prerequisites_met({step}.id, {:userid}, quest_period_start({quest}.recurrence, {quest}.starts_at, now()), {:teamid})`)
+}
+
+// historyOwnerCondition возвращает условие, что запись истории history сделана пользователем {:userid}
+// или, если задание quest командное, участником его команды {:teamid}
+func historyOwnerCondition(history, quest string) string {
+	return "(" + history + ".userid = {:userid} OR (" + quest + ".team_scoped AND " + history + ".team_id = {:teamid}))"
+}
+
+// @Summary Получить задания
 // @Tags quests
-// @Description Создает новое задание
+// @Description Возвращает страницу заданий с шагами. Архивные задания и шаги возвращаются только с параметром archived=true.
+// @Description active=true возвращает только задания, окно проведения которых включает текущий момент.
+// @Description Для получения следующей страницы передайте next_cursor из ответа в параметре cursor, остальные параметры не меняйте
 // @id GetQuests
 // @Accept json
 // @Procedure json
 // @router /GetQuests [GET]
-// @Success 200 {object} Quests
+// @param limit query int false "кол-во заданий на странице, по умолчанию 50, не больше 200"
+// @param cursor query string false "курсор следующей страницы"
+// @param sort query string false "сортировка, '-' в начале - по убыванию" Enums(id, -id, name, -name, created, -created)
+// @param name query string false "имя задания содержит строку"
+// @param multi query bool false "есть (true) или нет (false) шагов с многократным выполнением"
+// @param archived query bool false "включить архивные задания и шаги"
+// @param active query bool false "только задания, которые проводятся сейчас (true) или не проводятся (false)"
+// @param created_from query string false "создано не раньше (2006-01-02 или RFC3339)"
+// @param created_to query string false "создано раньше (2006-01-02 или RFC3339)"
+// @Success 200 {object} storages.Page[Quests]
+// @Failure 400 {object} response.ErrorResponse "неверные параметры запроса"
 // @Security BasicAuth
+// @Security BearerAuth
 func GetQuests(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		storages.RequestTolog(r, logger)
 		if r.Method == http.MethodGet {
-
-			var quests []Quests
-			err := storage.DB.Select().From("quests").All(&quests)
+			filter, page, err := parseQuestFilter(r.URL.Query())
 			if err != nil {
-				storages.HttpResponse(w, http.StatusInternalServerError, "Ошибка при получении данных о заданиях")
+				response.BadRequest(w, r, err)
 				return
 			}
-			for i, quest := range quests {
-				var steps []Steps
-				err = storage.DB.Select().From("queststeps").Where(dbx.HashExp{"questid": quest.Id}).All(&steps)
-				if err != nil {
-					storages.HttpResponse(w, http.StatusInternalServerError, "Ошибка при получении данных о заданиях")
-					return
-				}
-				quests[i].Steps = steps
+
+			quests, err := fetchQuests(storage.DB, filter, page)
+			if err != nil {
+				logger.Error("get quests failed", "error", err.Error())
+				response.Internal(w, r)
+				return
 			}
-			result, _ := json.MarshalIndent(quests, "", "\t")
-			storages.HttpResponseObject(w, http.StatusInternalServerError, result)
+			response.JSON(w, http.StatusOK, quests)
 		} else {
-			storages.HttpResponse(w, http.StatusMethodNotAllowed, "Метод не поддерживается, используйте метод POST")
+			response.MethodNotAllowed(w, r, http.MethodGet)
 		}
 	}
 }
 
+// getSteps возвращает шаги задания
+func getSteps(db dbx.Builder, questId string, includeArchived bool) ([]Steps, error) {
+	var steps []Steps
+	q := db.Select("s.*", stepRequiresColumn("s")+" AS requires").From("queststeps AS s").
+		Where(dbx.HashExp{"s.questid": questId}).OrderBy("s.position", "s.id")
+	if !includeArchived {
+		q.AndWhere(dbx.NewExp("s.archived_at IS NULL"))
+	}
+	err := q.All(&steps)
+	return steps, err
+}
+
 // @Summary Добавить задание
 // @Tags quests
-// @Description Создает новое задание
+// @Description Создает новое задание. Шаг может требовать выполнения других шагов задания (RequiresSteps - имена шагов),
+// @Description задание - завершения других заданий (RequiresQuests). Цикл в предварительных условиях не допускается
 // @id CreateQuest
 // @Accept json
 // @Procedure json
 // @router /CreateQuest [POST]
+// @Failure 409 {object} response.ErrorResponse "Задание или шаг с таким именем уже существует или ошибка в предварительных условиях"
 // @param input body storage.NewQuest true "информация о задании"
 // @Success 200 {object} storage.NewQuest
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
 // @Security BasicAuth
+// @Security BearerAuth
 func CreateQuest(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		storages.RequestTolog(r, logger)
@@ -86,89 +153,163 @@ func CreateQuest(storage *storages.Storage, logger *slog.Logger) http.HandlerFun
 			err := decoder.Decode(&quest)
 
 			if err != nil {
-				storages.HttpResponse(w, http.StatusBadRequest, "Неверный формат запроса "+err.Error())
+				response.BadRequest(w, r, err)
 				return
 			}
 
 			questDB, errlist := quest.ConvertToDB()
 			if len(errlist) > 0 {
-				result, _ := json.MarshalIndent(errlist, "", "\t")
-				storages.HttpResponseObject(w, http.StatusInternalServerError, result)
+				response.Invalid(w, r, errlist)
 				return
 			}
 
-			var oldquestDB = storages.NewQuestDB{}
-			err = storage.DB.Select().From(questDB.TableName()).Where(dbx.HashExp{"questname": questDB.Name}).One(&oldquestDB)
-			if err != nil {
-				err = storage.DB.Model(&questDB).Insert("Name", "Cost")
+			//Задание и его шаги добавляем в одной транзакции, уникальность проверяется ограничениями БД
+			err = storage.DB.Transactional(func(tx *dbx.Tx) error {
+				err := tx.Model(&questDB).Insert("Name", "Description", "StartsAt", "EndsAt", "Recurrence", "Cost", "TeamScoped")
 				if err != nil {
-					storages.HttpResponse(w, http.StatusInternalServerError, "Не удалось добавить задание")
-				} else {
-
-					//Если передавалась информация о шагах - добавляем и шаги
-					if quest.QuestSteps != nil {
-						for _, questStep := range quest.QuestSteps {
-							questStep.QuestId = questDB.Id
-							questStepDB, errlist := questStep.ConvertToDB()
-							if len(errlist) > 0 {
-								result, _ := json.MarshalIndent(errlist, "", "\t")
-								storages.HttpResponseObject(w, http.StatusInternalServerError, result)
-								return
-							}
-							err = createQuestStep(storage, questStepDB)
-							if err != nil {
-								storages.HttpResponse(w, http.StatusBadRequest, "Ошибка при добавлении шага: "+err.Error())
-								return
-							}
-						}
-					}
-					storages.HttpResponse(w, http.StatusOK, "Успешно")
+					return err
+				}
+
+				//Если передавалась информация о шагах - добавляем и шаги
+				for i := range quest.QuestSteps {
+					quest.QuestSteps[i].QuestId = questDB.Id
 				}
-			} else {
-				storages.HttpResponse(w, http.StatusInternalServerError, "Задание с таким именем существует, id :"+strconv.Itoa(oldquestDB.Id))
+				err = createQuestSteps(tx, quest.QuestSteps)
+				if err != nil {
+					return err
+				}
+				if len(quest.RequiresQuests) > 0 {
+					return setQuestPrerequisites(tx, questDB.Id, quest.RequiresQuests)
+				}
+				return nil
+			})
+
+			var stepErrors stepValidationError
+			switch {
+			case err == nil:
+				response.Message(w, r, http.StatusOK, response.MessageSuccess)
+			case errors.As(err, &stepErrors):
+				response.Invalid(w, r, stepErrors)
+			case storages.ConstraintName(err) == "quests_questname_key":
+				response.Error(w, r, http.StatusConflict, response.CodeQuestExists)
+			case errors.Is(err, errStepExists), errors.Is(err, errQuestNotExists), errors.Is(err, errQuestArchived):
+				response.ErrorDetails(w, r, http.StatusConflict, addStepErrorCode(err), response.DetailsOf(err))
+			case errors.Is(err, errPrerequisiteNotExists), errors.Is(err, errPrerequisiteCycle):
+				response.ErrorDetails(w, r, http.StatusConflict, response.CodeInvalidPrerequisite, response.DetailsOf(err))
+			default:
+				response.Internal(w, r)
 			}
 
 		} else {
-			storages.HttpResponse(w, http.StatusMethodNotAllowed, "Метод не поддерживается, используйте метод POST")
+			response.MethodNotAllowed(w, r, http.MethodPost)
 		}
 	}
 }
 
-// createQuestStep добавляет шаг к заданию.
-func createQuestStep(storage *storages.Storage, questStepDB storages.NewQuestStepDB) error {
-	//Проверяем, что существует questID.
+var (
+	errStepExists     = errors.New("шаг с таким именем уже существует")
+	errQuestNotExists = errors.New("задание не существует")
+	errQuestArchived  = errors.New("задание в архиве")
+)
+
+// stepValidationError ошибки валидации шагов, возвращаемые из транзакции
+type stepValidationError []response.FieldError
 
-	var quest storages.NewQuestDB
-	err := storage.DB.Select().From(quest.TableName()).Where(dbx.HashExp{"id": questStepDB.QuestId}).One(&quest)
-	if err == nil {
-		//Проверяем, что существует шаг.
-		var oldquestStepDB = storages.NewQuestStepDB{}
-		err = storage.DB.Select().From(questStepDB.TableName()).Where(dbx.HashExp{"stepname": questStepDB.StepName, "questid": questStepDB.QuestId}).One(&oldquestStepDB)
+func (e stepValidationError) Error() string {
+	return "step validation failed"
+}
+
+// addStepErrorCode возвращает код ошибки добавления шага
+func addStepErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errStepExists):
+		return response.CodeStepExists
+	case errors.Is(err, errQuestArchived):
+		return response.CodeQuestArchived
+	case errors.Is(err, errQuestNotExists):
+		return response.CodeQuestNotFound
+	default:
+		return response.CodeInvalidPrerequisite
+	}
+}
+
+// createQuestSteps добавляет шаги к заданиям. Предварительные условия задаются после добавления всех шагов,
+// поэтому шаг может требовать шаг, описанный в запросе после него
+func createQuestSteps(db dbx.Builder, questSteps []storages.NewQuestStep) error {
+	stepIds := make([]int, len(questSteps))
+	for i, questStep := range questSteps {
+		questStepDB, errlist := questStep.ConvertToDB()
+		if len(errlist) > 0 {
+			return stepValidationError(errlist)
+		}
+		var err error
+		stepIds[i], err = createQuestStep(db, questStepDB)
 		if err != nil {
-			err = storage.DB.Model(&questStepDB).Insert("QuestId", "StepName", "Bonus", "IsMulti")
-			if err != nil {
-				return errors.New("Не удалось добавить задание")
-			}
-		} else {
-			return errors.New(fmt.Sprint("Не удалось добавить шаг '", questStepDB.StepName, "' т.к. шаг с именем '", questStepDB.StepName, "' уже сщуествует"))
+			return err
+		}
+	}
+	for i, questStep := range questSteps {
+		if len(questStep.RequiresSteps) == 0 {
+			continue
+		}
+		err := setStepPrerequisites(db, questStep.QuestId, stepIds[i], questStep.RequiresSteps)
+		if err != nil {
+			return fmt.Errorf("не удалось задать предварительные условия шага '%s': %w", questStep.StepName, err)
 		}
-	} else {
-		//TODO по хорошему, нужно делать уведомление о том, что шаг не добавлен, но, как мне кажется, это должно обрабатываться на стороне фронтенда, поэтому тут просто будем игнорировать
-		return errors.New(fmt.Sprint("Не удалось добавить шаг '", questStepDB.StepName, "' т.к. задание с id '", questStepDB.QuestId, "' не сщуествует"))
 	}
 	return nil
 }
 
+// createQuestStep добавляет шаг к заданию и возвращает его идентификатор. Существование задания и уникальность имени шага
+// проверяются ограничениями БД, в архивное задание шаг добавить нельзя. Шаг без порядкового номера добавляется в конец задания
+func createQuestStep(db dbx.Builder, questStepDB storages.NewQuestStepDB) (int, error) {
+	var archived bool
+	err := db.NewQuery("SELECT archived_at IS NOT NULL FROM quests WHERE id = {:id} FOR SHARE").
+		Bind(dbx.Params{"id": questStepDB.QuestId}).Row(&archived)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: %w", response.Field("QuestId", response.RuleRejected, questStepDB.QuestId), errQuestNotExists)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if archived {
+		return 0, fmt.Errorf("%w: %w", response.Field("QuestId", response.RuleRejected, questStepDB.QuestId), errQuestArchived)
+	}
+	if questStepDB.Position == 0 {
+		questStepDB.Position, err = nextStepPosition(db, questStepDB.QuestId)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = db.Model(&questStepDB).Insert("QuestId", "StepName", "Bonus", "IsMulti", "MaxCompletions", "CooldownSeconds", "GlobalLimit", "Position")
+	switch {
+	case err == nil:
+		return questStepDB.Id, nil
+	case storages.IsUniqueViolation(err):
+		return 0, fmt.Errorf("%w: %w", response.Field("StepName", response.RuleRejected, questStepDB.StepName), errStepExists)
+	case storages.IsForeignKeyViolation(err):
+		return 0, fmt.Errorf("%w: %w", response.Field("QuestId", response.RuleRejected, questStepDB.QuestId), errQuestNotExists)
+	default:
+		return 0, err
+	}
+}
+
 // @Summary Добавить шаг к заданию
 // @Tags quests
-// @Description Добавляет новые шаги к заданию
+// @Description Добавляет новые шаги к заданию. RequiresSteps - имена шагов того же задания, в том числе добавляемых этим запросом,
+// @Description которые нужно выполнить перед шагом. Цикл в предварительных условиях не допускается
 // @id CreateQuestSteps
 // @Accept json
 // @Procedure json
 // @router /CreateQuestSteps [POST]
+// @Failure 409 {object} response.ErrorResponse "Шаг уже существует, задание не найдено или ошибка в предварительных условиях"
 // @param input body storage.NewQuestSteps true "информация о шагах задания"
 // @Success 200 {object} storage.NewQuestStep
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
 // @Security BasicAuth
+// @Security BearerAuth
 func CreateQuestSteps(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		storages.RequestTolog(r, logger)
@@ -178,40 +319,49 @@ func CreateQuestSteps(storage *storages.Storage, logger *slog.Logger) http.Handl
 			err := decoder.Decode(&questSteps)
 
 			if err != nil {
-				storages.HttpResponse(w, http.StatusBadRequest, "Неверный формат запроса "+err.Error())
+				response.BadRequest(w, r, err)
 				return
 			}
 
-			for _, questStep := range questSteps.QuestSteps {
-				questStepDB, errlist := questStep.ConvertToDB()
-				if len(errlist) > 0 {
-					result, _ := json.MarshalIndent(errlist, "", "\t")
-					storages.HttpResponseObject(w, http.StatusInternalServerError, result)
-					return
-				}
-				err = createQuestStep(storage, questStepDB)
-				if err != nil {
-					storages.HttpResponse(w, http.StatusBadRequest, "Ошибка при добавлении шага: "+err.Error())
-					return
-				}
+			err = storage.DB.Transactional(func(tx *dbx.Tx) error {
+				return createQuestSteps(tx, questSteps.QuestSteps)
+			})
+
+			var stepErrors stepValidationError
+			switch {
+			case err == nil:
+				response.Message(w, r, http.StatusOK, response.MessageSuccess)
+			case errors.As(err, &stepErrors):
+				response.Invalid(w, r, stepErrors)
+			case errors.Is(err, errStepExists), errors.Is(err, errQuestNotExists), errors.Is(err, errQuestArchived),
+				errors.Is(err, errPrerequisiteNotExists), errors.Is(err, errPrerequisiteCycle):
+				response.ErrorDetails(w, r, http.StatusConflict, addStepErrorCode(err), response.DetailsOf(err))
+			default:
+				response.Internal(w, r)
 			}
-			storages.HttpResponse(w, http.StatusOK, "Успешно")
 		} else {
-			storages.HttpResponse(w, http.StatusMethodNotAllowed, "Метод не поддерживается, используйте метод POST")
+			response.MethodNotAllowed(w, r, http.MethodPost)
 		}
 	}
 }
 
 // @Summary Обновить шаг к заданию
 // @Tags quests
-// @Description Создает новое задание
+// @Description Обновляет бонус и правила выполнения шагов. Значение 0 в MaxCompletions, CooldownSeconds и GlobalLimit снимает ограничение.
+// @Description Архивный шаг и шаг архивного задания изменить нельзя.
+// @Description Шаги обновляются в одной транзакции: если хотя бы один шаг не прошел проверку, не найден или в архиве, изменения не записываются
 // @id UpdateQuestSteps
 // @Accept json
 // @Procedure json
 // @router /UpdateQuestSteps [POST]
-// @param input body storage.NewQuestSteps true "обновленная информация о шагах задания"
+// @param input body storage.UpdateQuestSteps true "обновленная информация о шагах задания"
 // @Success 200 {object} storage.NewQuestStep
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Failure 404 {object} response.ErrorResponse "шаг не найден, изменения не записаны"
+// @Failure 409 {object} response.ErrorResponse "шаг или его задание в архиве, изменения не записаны"
 // @Security BasicAuth
+// @Security BearerAuth
 func UpdateQuestSteps(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		storages.RequestTolog(r, logger)
@@ -221,29 +371,203 @@ func UpdateQuestSteps(storage *storages.Storage, logger *slog.Logger) http.Handl
 			err := decoder.Decode(&updateQuestSteps)
 
 			if err != nil {
-				storages.HttpResponse(w, http.StatusBadRequest, "Неверный формат запроса "+err.Error())
+				response.BadRequest(w, r, err)
 				return
 			}
 
+			//проверяем все шаги до изменений, чтобы не записать часть запроса
+			questStepsDB := make([]storages.NewQuestStepDB, 0, len(updateQuestSteps.QuestSteps))
 			for _, questStep := range updateQuestSteps.QuestSteps {
 				questStepDB, errlist := questStep.ConvertToDB()
 				if len(errlist) > 0 {
-					result, _ := json.MarshalIndent(errlist, "", "\t")
-					storages.HttpResponseObject(w, http.StatusInternalServerError, result)
+					response.Invalid(w, r, errlist)
 					return
 				}
-				params := questStepDB.GetUpdatesData()
-				if len(params) > 0 {
-					_, err = storage.DB.Update(questStepDB.TableName(), params, dbx.HashExp{"id": questStepDB.Id}).Execute()
-					if err != nil {
-						storages.HttpResponse(w, http.StatusBadRequest, "не удалось обновить задание"+err.Error())
-						return
-					}
+				questStepsDB = append(questStepsDB, questStepDB)
+			}
+
+			err = storage.DB.Transactional(func(tx *dbx.Tx) error {
+				return updateSteps(tx, questStepsDB)
+			})
+			switch {
+			case err == nil:
+				response.Message(w, r, http.StatusOK, response.MessageSuccess)
+			case errors.Is(err, errStepNotExists):
+				response.ErrorDetails(w, r, http.StatusNotFound, response.CodeStepNotFound, response.DetailsOf(err))
+			case errors.Is(err, errQuestArchived):
+				response.ErrorDetails(w, r, http.StatusConflict, response.CodeStepArchived, response.DetailsOf(err))
+			default:
+				logger.Error("update quest steps failed", "error", err.Error())
+				response.Internal(w, r)
+			}
+		} else {
+			response.MethodNotAllowed(w, r, http.MethodPost)
+		}
+	}
+}
+
+// updateSteps обновляет бонус и правила выполнения шагов. Архивный шаг и шаг архивного задания не меняются,
+// как в UpdateStep. Вызывается внутри транзакции
+func updateSteps(db dbx.Builder, questSteps []storages.NewQuestStepDB) error {
+	for _, questStepDB := range questSteps {
+		field := response.Field("id", response.RuleRejected, questStepDB.Id)
+		var archived bool
+		err := db.NewQuery(`SELECT s.archived_at IS NOT NULL OR q.archived_at IS NOT NULL
+							FROM queststeps AS s
+							JOIN quests AS q ON q.id = s.questid
+							WHERE s.id = {:id}
+							FOR UPDATE OF s`).Bind(dbx.Params{"id": questStepDB.Id}).Row(&archived)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %w", field, errStepNotExists)
+		}
+		if err != nil {
+			return err
+		}
+		if archived {
+			return fmt.Errorf("%w: %w", field, errQuestArchived)
+		}
+
+		params := questStepDB.GetUpdatesData()
+		if len(params) == 0 {
+			continue
+		}
+		_, err = db.Update(questStepDB.TableName(), params, dbx.HashExp{"id": questStepDB.Id}).Execute()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AvailableQuest model info
+// @Description AvailableQuest задание с шагами и отметкой о доступности шагов пользователю
+type AvailableQuest struct {
+	Id        int             `json:"Id"`        //ИД задания
+	QuestName string          `json:"QuestName"` //Имя задания
+	CreatedAt time.Time       `json:"CreatedAt"` //Время создания задания
+	Status    string          `json:"Status"`    //Состояние задания у пользователя: not_started или in_progress
+	Steps     []AvailableStep `json:"Steps"`     //Шаги задания
+}
+
+type AvailableStep struct {
+	Steps
+	CompletedCount int  `json:"CompletedCount"` //Сколько раз пользователь выполнил шаг за все время
+	Available      bool `json:"Available"`      //Признак того, что шаг можно выполнить сейчас
+}
+
+// availableStepRow строка запроса шагов с количеством выполнений пользователем
+type availableStepRow struct {
+	QuestId         int           `db:"questid"`
+	QuestName       string        `db:"questname"`
+	CreatedAt       time.Time     `db:"created_at"`
+	Id              int           `db:"id"`
+	StepName        string        `db:"stepname"`
+	Bonus           int           `db:"bonus"`
+	IsMulti         bool          `db:"ismulti"`
+	MaxCompletions  *int          `db:"max_completions"`
+	CooldownSeconds *int          `db:"cooldown_seconds"`
+	GlobalLimit     *int          `db:"global_limit"`
+	Position        int           `db:"position"`
+	Requires        pq.Int64Array `db:"requires"`
+	CompletedCount  int           `db:"completed"`
+	Available       bool          `db:"available"`
+	QuestStatus     string        `db:"quest_status"`
+}
+
+// @Summary Доступные задания
+// @Tags me
+// @Description Возвращает страницу заданий, которые проводятся сейчас и в которых у авторизованного пользователя есть шаги, доступные для выполнения.
+// @Description Шаг доступен, если не исчерпаны ограничения числа выполнений (у периодического задания - за текущий период)
+// @Description и общее ограничение, после предыдущего выполнения прошел CooldownSeconds и выполнены предварительные условия
+// @id GetMyQuests
+// @Accept json
+// @Procedure json
+// @router /me/quests [GET]
+// @param limit query int false "кол-во заданий на странице, по умолчанию 50, не больше 200"
+// @param cursor query string false "курсор следующей страницы"
+// @param sort query string false "сортировка, '-' в начале - по убыванию" Enums(id, -id, name, -name, created, -created)
+// @param name query string false "имя задания содержит строку"
+// @param multi query bool false "есть (true) или нет (false) шагов с многократным выполнением"
+// @param created_from query string false "создано не раньше (2006-01-02 или RFC3339)"
+// @param created_to query string false "создано раньше (2006-01-02 или RFC3339)"
+// @Success 200 {object} storages.Page[AvailableQuest]
+// @Failure 400 {object} response.ErrorResponse "неверные параметры запроса"
+// @Security BasicAuth
+// @Security BearerAuth
+func GetMyQuests(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		if r.Method == http.MethodGet {
+			principal, ok := storages.PrincipalFromContext(r.Context())
+			if !ok {
+				response.Error(w, r, http.StatusUnauthorized, response.CodeUnauthorized)
+				return
+			}
+			filter, page, err := parseQuestFilter(r.URL.Query())
+			if err != nil {
+				response.BadRequest(w, r, err)
+				return
+			}
+			filter.IncludeArchived = false
+			active := true
+			filter.Active = &active
+
+			teamId, err := storages.UserTeamId(storage.DB, principal.UserId)
+			if err != nil {
+				logger.Error("get available quests failed", "error", err.Error())
+				response.Internal(w, r)
+				return
+			}
+
+			//страница заданий, в которых есть хотя бы один доступный пользователю шаг
+			q := storage.DB.Select("q.id", "q.questname", "q.created_at", "q.recurrence", "q.starts_at", "q.team_scoped").From("quests AS q")
+			filter.apply(q)
+			q.AndWhere(dbx.NewExp(`EXISTS (SELECT 1 FROM queststeps AS a
+								WHERE a.questid = q.id AND a.archived_at IS NULL AND `+stepAvailableCondition("a", "q")+`)`,
+				dbx.Params{"userid": principal.UserId, "teamid": teamId}))
+			questPage := page.Apply(q, "q").Build()
+
+			queryText := `SELECT p.id AS questid, p.questname, p.created_at, s.id, s.stepname, coalesce(s.bonus, 0) AS bonus, s.ismulti,
+								s.max_completions, s.cooldown_seconds, s.global_limit, s.position, ` + stepRequiresColumn("s") + ` AS requires,
+								count(h.id) AS completed, ` + stepAvailableCondition("s", "p") + ` AS available,
+								coalesce(CASE WHEN p.team_scoped AND {:teamid}::integer IS NOT NULL
+									THEN (SELECT tq.status FROM team_quests AS tq WHERE tq.quest_id = p.id AND tq.team_id = {:teamid})
+									ELSE (SELECT uq.status FROM user_quests AS uq WHERE uq.quest_id = p.id AND uq.user_id = {:userid}) END,
+									'not_started') AS quest_status
+							FROM (` + questPage.SQL() + `) AS p
+							JOIN queststeps AS s ON s.questid = p.id AND s.archived_at IS NULL
+							LEFT JOIN history AS h ON h.stepid = s.id AND ` + historyOwnerCondition("h", "p") + `
+							GROUP BY p.id, p.questname, p.created_at, p.recurrence, p.starts_at, p.team_scoped, s.id, s.stepname, s.bonus, s.ismulti
+							ORDER BY ` + strings.Join(page.OrderBy("p"), ", ") + `, s.position, s.id`
+			var rows []availableStepRow
+			err = storage.DB.NewQuery(queryText).Bind(questPage.Params()).All(&rows)
+			if err != nil {
+				logger.Error("get available quests failed", "error", err.Error())
+				response.Internal(w, r)
+				return
+			}
+
+			var quests []AvailableQuest
+			for _, row := range rows {
+				last := len(quests) - 1
+				if last < 0 || quests[last].Id != row.QuestId {
+					quests = append(quests, AvailableQuest{Id: row.QuestId, QuestName: row.QuestName, CreatedAt: row.CreatedAt, Status: row.QuestStatus})
+					last++
 				}
+				quests[last].Steps = append(quests[last].Steps, AvailableStep{
+					Steps: Steps{StepName: row.StepName, Id: row.Id, Bonus: row.Bonus, IsMulti: row.IsMulti,
+						MaxCompletions: row.MaxCompletions, CooldownSeconds: row.CooldownSeconds, GlobalLimit: row.GlobalLimit,
+						Position: row.Position, RequiresSteps: row.Requires},
+					CompletedCount: row.CompletedCount,
+					Available:      row.Available,
+				})
 			}
-			storages.HttpResponse(w, http.StatusOK, "Успешно")
+
+			response.JSON(w, http.StatusOK, storages.NewPage(page, quests, func(quest AvailableQuest) (string, int) {
+				return questCursorKey(page, quest.Id, quest.QuestName, quest.CreatedAt)
+			}))
 		} else {
-			storages.HttpResponse(w, http.StatusMethodNotAllowed, "Метод не поддерживается, используйте метод POST")
+			response.MethodNotAllowed(w, r, http.MethodGet)
 		}
 	}
 }
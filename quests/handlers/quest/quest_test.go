@@ -0,0 +1,187 @@
+package quest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"techno-test_quests/quests/internal/testdb"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+)
+
+// Тесты выполняются против тестовой БД, строка подключения задается переменной окружения QUESTS_TEST_DB (см. testdb)
+
+type testFixture struct {
+	storage   *storages.Storage
+	logger    *slog.Logger
+	questName string
+	questId   int
+}
+
+func newTestFixture(t testing.TB) *testFixture {
+	t.Helper()
+	storage := testdb.Open(t)
+	f := &testFixture{
+		storage:   storage,
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		questName: "quest " + testdb.Suffix(),
+	}
+	f.questId = testdb.Insert(t, storage, "quests", dbx.Params{"questname": f.questName})
+	return f
+}
+
+// getQuest возвращает задание фикстуры из ответа GetQuests
+func (f *testFixture) getQuest(t *testing.T) Quests {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/GetQuests?name="+url.QueryEscape(f.questName), nil)
+	w := httptest.NewRecorder()
+	GetQuests(f.storage, f.logger)(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+
+	var page storages.Page[Quests]
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("decode response: %s", err)
+	}
+	if len(page.Items) != 1 {
+		t.Fatalf("got %d quests, want 1", len(page.Items))
+	}
+	return page.Items[0]
+}
+
+func TestGetQuestsReturnsStepLimits(t *testing.T) {
+	f := newTestFixture(t)
+	testdb.Insert(t, f.storage, "queststeps", dbx.Params{"questid": f.questId, "stepname": "limited", "bonus": 10, "ismulti": true,
+		"max_completions": 3, "cooldown_seconds": 60, "global_limit": 100})
+
+	quest := f.getQuest(t)
+	if len(quest.Steps) != 1 {
+		t.Fatalf("got %d steps, want 1", len(quest.Steps))
+	}
+	step := quest.Steps[0]
+	if step.MaxCompletions == nil || *step.MaxCompletions != 3 {
+		t.Fatalf("MaxCompletions %v, want 3", step.MaxCompletions)
+	}
+	if step.CooldownSeconds == nil || *step.CooldownSeconds != 60 {
+		t.Fatalf("CooldownSeconds %v, want 60", step.CooldownSeconds)
+	}
+	if step.GlobalLimit == nil || *step.GlobalLimit != 100 {
+		t.Fatalf("GlobalLimit %v, want 100", step.GlobalLimit)
+	}
+}
+
+func TestGetQuestsReturnsStepOrderAndPrerequisites(t *testing.T) {
+	f := newTestFixture(t)
+	second := testdb.Insert(t, f.storage, "queststeps", dbx.Params{"questid": f.questId, "stepname": "second", "position": 2})
+	first := testdb.Insert(t, f.storage, "queststeps", dbx.Params{"questid": f.questId, "stepname": "first", "position": 1})
+	_, err := f.storage.DB.Insert("step_prerequisites", dbx.Params{"step_id": second, "required_step_id": first}).Execute()
+	if err != nil {
+		t.Fatalf("insert step prerequisite: %s", err)
+	}
+
+	quest := f.getQuest(t)
+	if len(quest.Steps) != 2 {
+		t.Fatalf("got %d steps, want 2", len(quest.Steps))
+	}
+	if quest.Steps[0].Id != first || quest.Steps[0].Position != 1 || quest.Steps[1].Id != second || quest.Steps[1].Position != 2 {
+		t.Fatalf("steps out of order: %+v", quest.Steps)
+	}
+	if requires := quest.Steps[1].RequiresSteps; len(requires) != 1 || requires[0] != int64(first) {
+		t.Fatalf("RequiresSteps %v, want [%d]", requires, first)
+	}
+	if requires := quest.Steps[0].RequiresSteps; len(requires) != 0 {
+		t.Fatalf("RequiresSteps of the first step %v, want empty", requires)
+	}
+}
+
+func TestGetMyQuestsListsAvailableSteps(t *testing.T) {
+	f := newTestFixture(t)
+	userId := testdb.User(t, f.storage, "u"+testdb.Suffix())
+	stepId := testdb.Insert(t, f.storage, "queststeps", dbx.Params{"questid": f.questId, "stepname": "step", "bonus": 10, "ismulti": true})
+
+	r := httptest.NewRequest(http.MethodGet, "/me/quests?name="+url.QueryEscape(f.questName), nil)
+	w := httptest.NewRecorder()
+	GetMyQuests(f.storage, f.logger)(w, r.WithContext(storages.WithPrincipal(r.Context(), storages.Principal{UserId: userId})))
+	var page storages.Page[AvailableQuest]
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GetMyQuests: status %d, body %s", w.Code, w.Body.String())
+	}
+	if len(page.Items) != 1 || page.Items[0].Status != "not_started" || len(page.Items[0].Steps) != 1 {
+		t.Fatalf("unexpected quests %+v", page.Items)
+	}
+	if step := page.Items[0].Steps[0]; step.Id != stepId || !step.Available || step.CompletedCount != 0 {
+		t.Fatalf("unexpected step %+v", step)
+	}
+
+	w = httptest.NewRecorder()
+	GetMyQuests(f.storage, f.logger)(w, httptest.NewRequest(http.MethodGet, "/me/quests", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("GetMyQuests without principal: status %d, body %s", w.Code, w.Body.String())
+	}
+}
+
+// updateQuestSteps выполняет UpdateQuestSteps с телом body и возвращает ответ
+func (f *testFixture) updateQuestSteps(body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	UpdateQuestSteps(f.storage, f.logger)(w, httptest.NewRequest(http.MethodPost, "/UpdateQuestSteps", strings.NewReader(body)))
+	return w
+}
+
+func TestUpdateQuestStepsIsAtomic(t *testing.T) {
+	f := newTestFixture(t)
+	stepId := testdb.Insert(t, f.storage, "queststeps", dbx.Params{"questid": f.questId, "stepname": "step", "bonus": 10, "ismulti": true})
+	bonus := func() int {
+		t.Helper()
+		var bonus int
+		if err := f.storage.DB.Select("bonus").From("queststeps").Where(dbx.HashExp{"id": stepId}).Row(&bonus); err != nil {
+			t.Fatalf("select bonus: %s", err)
+		}
+		return bonus
+	}
+
+	//ошибка во втором шаге не дает записать изменения первого
+	for _, test := range []struct {
+		second string
+		status int
+		code   string
+	}{
+		{`{"id":0,"Bonus":5,"IsMulti":true}`, http.StatusUnprocessableEntity, response.CodeValidationFailed},
+		{fmt.Sprintf(`{"id":%d,"Bonus":5,"IsMulti":true}`, math.MaxInt32), http.StatusNotFound, response.CodeStepNotFound},
+		{fmt.Sprintf(`{"id":%d,"IsMulti":true}`, math.MaxInt32), http.StatusNotFound, response.CodeStepNotFound},
+	} {
+		w := f.updateQuestSteps(fmt.Sprintf(`{"QuestSteps":[{"id":%d,"Bonus":20,"IsMulti":true},%s]}`, stepId, test.second))
+		if w.Code != test.status || errorCode(t, w) != test.code {
+			t.Fatalf("second step %s: status %d, body %s, want %d %s", test.second, w.Code, w.Body.String(), test.status, test.code)
+		}
+		if got := bonus(); got != 10 {
+			t.Fatalf("second step %s: bonus %d, want unchanged 10", test.second, got)
+		}
+	}
+
+	if w := f.updateQuestSteps(fmt.Sprintf(`{"QuestSteps":[{"id":%d,"Bonus":20,"IsMulti":true}]}`, stepId)); w.Code != http.StatusOK {
+		t.Fatalf("valid update: status %d, body %s", w.Code, w.Body.String())
+	}
+	if got := bonus(); got != 20 {
+		t.Fatalf("bonus %d, want 20", got)
+	}
+}
+
+// errorCode возвращает код ошибки из ответа
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body response.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error response %s: %s", w.Body.String(), err)
+	}
+	return body.Error.Code
+}
@@ -0,0 +1,404 @@
+package reward
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+	"time"
+	"unicode/utf8"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Заявка на получение награды создается в статусе pending: стоимость сразу списывается с бонусного счета, остаток награды
+// уменьшается. Заявка переходит в fulfilled, когда награда выдана, или в cancelled с возвратом бонусов и остатка.
+// Порядок блокировок во всех транзакциях одинаковый: заявка, награда, пользователь - это исключает взаимные блокировки
+
+// Статусы заявки на получение награды
+const (
+	RedemptionPending   = "pending"   //бонусы списаны, награда ожидает выдачи
+	RedemptionFulfilled = "fulfilled" //награда выдана
+	RedemptionCancelled = "cancelled" //заявка отменена, бонусы и остаток возвращены
+)
+
+var (
+	errRedemptionNotExists = errors.New("заявка не существует")
+	errRedemptionResolved  = errors.New("заявка уже выполнена или отменена")
+)
+
+// Redemption model info
+// @Description Redemption заявка пользователя на получение награды
+type Redemption struct {
+	Id         int        `json:"id" db:"id"`                   //Идентификатор заявки
+	UserId     int        `json:"user_id" db:"user_id"`         //Пользователь, получающий награду
+	RewardId   int        `json:"reward_id" db:"reward_id"`     //Награда
+	RewardName string     `json:"reward_name" db:"reward_name"` //Название награды
+	Cost       int        `json:"cost" db:"cost"`               //Списанная стоимость
+	Status     string     `json:"status" db:"status"`           //Статус: pending, fulfilled или cancelled
+	DebitId    int        `json:"debit_id" db:"debit_id"`       //Запись журнала бонусов со списанием
+	RefundId   *int       `json:"refund_id" db:"refund_id"`     //Запись журнала бонусов с возвратом при отмене
+	Comment    string     `json:"comment" db:"comment"`         //Комментарий при выполнении или отмене
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`   //Время создания
+	ResolvedAt *time.Time `json:"resolved_at" db:"resolved_at"` //Время выполнения или отмены
+	ResolvedBy *int       `json:"resolved_by" db:"resolved_by"` //Пользователь, выполнивший или отменивший заявку
+}
+
+// ResolveRequest model info
+// @Description ResolveRequest json для выполнения или отмены заявки
+type ResolveRequest struct {
+	Comment string `json:"comment"` //Комментарий, не длиннее 200 символов
+}
+
+// redemptionColumns колонки выборки заявки с псевдонимом d и наградой с псевдонимом rw
+var redemptionColumns = []string{"d.id", "d.user_id", "d.reward_id", "rw.name AS reward_name", "d.cost", "d.status", "d.debit_id",
+	"d.refund_id", "d.comment", "d.created_at", "d.resolved_at", "d.resolved_by"}
+
+// redemptionSortColumns ключи сортировки заявок
+var redemptionSortColumns = map[string]string{
+	"id": "id",
+}
+
+// selectRedemptions возвращает запрос заявок с названиями наград
+func selectRedemptions(db dbx.Builder) *dbx.SelectQuery {
+	return db.Select(redemptionColumns...).From("redemptions AS d").InnerJoin("rewards AS rw", dbx.NewExp("rw.id = d.reward_id"))
+}
+
+// findRedemption возвращает заявку
+func findRedemption(db dbx.Builder, redemptionId int) (Redemption, error) {
+	var redemption Redemption
+	err := selectRedemptions(db).Where(dbx.HashExp{"d.id": redemptionId}).One(&redemption)
+	if errors.Is(err, sql.ErrNoRows) {
+		return redemption, errRedemptionNotExists
+	}
+	return redemption, err
+}
+
+// redeem создает заявку на награду: уменьшает остаток и списывает стоимость. Вызывается внутри транзакции
+func redeem(tx *dbx.Tx, rewardId, userId int) (Redemption, error) {
+	var reward Reward
+	err := tx.NewQuery(`UPDATE rewards SET stock = stock - 1
+						WHERE id = {:id} AND archived_at IS NULL AND (stock IS NULL OR stock > 0)
+						RETURNING *`).Bind(dbx.Params{"id": rewardId}).One(&reward)
+	if errors.Is(err, sql.ErrNoRows) {
+		//определяем причину отказа
+		reward, err = findReward(tx, rewardId)
+		if err != nil {
+			return Redemption{}, err
+		}
+		if reward.ArchivedAt != nil {
+			return Redemption{}, errRewardArchived
+		}
+		return Redemption{}, errRewardOutOfStock
+	}
+	if err != nil {
+		return Redemption{}, err
+	}
+
+	debit := storages.LedgerEntry{
+		UserId:    userId,
+		Amount:    -reward.Cost,
+		Kind:      storages.LedgerKindRedemption,
+		Comment:   reward.Name,
+		CreatedBy: &userId,
+	}
+	if err = storages.Debit(tx, &debit); err != nil {
+		return Redemption{}, err
+	}
+
+	var redemptionId int
+	err = tx.NewQuery(`INSERT INTO redemptions (user_id, reward_id, cost, debit_id)
+						VALUES ({:userid}, {:rewardid}, {:cost}, {:debitid})
+						RETURNING id`).
+		Bind(dbx.Params{"userid": userId, "rewardid": rewardId, "cost": reward.Cost, "debitid": debit.Id}).Row(&redemptionId)
+	if err != nil {
+		return Redemption{}, err
+	}
+	return findRedemption(tx, redemptionId)
+}
+
+// resolve переводит заявку в статус fulfilled или cancelled. При отмене возвращает бонусы записью, отменяющей списание,
+// и увеличивает остаток награды. canResolve проверяет, может ли пользователь изменить заявку
+func resolve(tx *dbx.Tx, redemptionId int, status string, resolvedBy int, comment string, canResolve func(Redemption) bool) (Redemption, error) {
+	_, err := tx.NewQuery("SELECT id FROM redemptions WHERE id = {:id} FOR UPDATE").
+		Bind(dbx.Params{"id": redemptionId}).Execute()
+	if err != nil {
+		return Redemption{}, err
+	}
+	redemption, err := findRedemption(tx, redemptionId)
+	if err != nil {
+		return redemption, err
+	}
+	if !canResolve(redemption) {
+		return redemption, errRedemptionNotExists
+	}
+	if redemption.Status != RedemptionPending {
+		return redemption, errRedemptionResolved
+	}
+
+	params := dbx.Params{"status": status, "comment": comment, "resolved_at": dbx.NewExp("now()"), "resolved_by": resolvedBy}
+	if status == RedemptionCancelled {
+		_, err = tx.NewQuery("UPDATE rewards SET stock = stock + 1 WHERE id = {:id} AND stock IS NOT NULL").
+			Bind(dbx.Params{"id": redemption.RewardId}).Execute()
+		if err != nil {
+			return redemption, err
+		}
+		refund, err := storages.RefundRedemption(tx, redemption.DebitId, &resolvedBy, comment)
+		if err != nil {
+			return redemption, err
+		}
+		params["refund_id"] = refund.Id
+	}
+	_, err = tx.Update("redemptions", params, dbx.HashExp{"id": redemptionId}).Execute()
+	if err != nil {
+		return redemption, err
+	}
+	return findRedemption(tx, redemptionId)
+}
+
+// decodeResolveRequest разбирает необязательное тело запроса на выполнение или отмену заявки
+func decodeResolveRequest(w http.ResponseWriter, r *http.Request) (ResolveRequest, bool) {
+	var request ResolveRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		response.BadRequest(w, r, err)
+		return request, false
+	}
+	if utf8.RuneCountInString(request.Comment) > 200 {
+		response.Invalid(w, r, []response.FieldError{response.Field("comment", response.RuleMaxLength, 200)})
+		return request, false
+	}
+	return request, true
+}
+
+// writeRedemptionResult отвечает заявкой или ошибкой ее создания или изменения
+func writeRedemptionResult(w http.ResponseWriter, r *http.Request, logger *slog.Logger, status int, redemption Redemption, err error, message string) {
+	switch {
+	case err == nil:
+		response.JSON(w, status, redemption)
+	case errors.Is(err, errRewardNotExists):
+		response.Error(w, r, http.StatusNotFound, response.CodeRewardNotFound)
+	case errors.Is(err, errRedemptionNotExists):
+		response.Error(w, r, http.StatusNotFound, response.CodeRedemptionNotFound)
+	case errors.Is(err, errRewardArchived):
+		response.Error(w, r, http.StatusConflict, response.CodeRewardArchived)
+	case errors.Is(err, errRewardOutOfStock):
+		response.Error(w, r, http.StatusConflict, response.CodeRewardOutOfStock)
+	case errors.Is(err, storages.ErrInsufficientBonus):
+		response.Error(w, r, http.StatusConflict, response.CodeInsufficientBonus)
+	case errors.Is(err, errRedemptionResolved):
+		response.Error(w, r, http.StatusConflict, response.CodeRedemptionResolved)
+	case errors.Is(err, storages.ErrAlreadyReversed):
+		response.Error(w, r, http.StatusConflict, response.CodeAlreadyReversed)
+	default:
+		logger.Error(message, "error", err.Error())
+		response.Internal(w, r)
+	}
+}
+
+// @Summary Получить награду
+// @Tags rewards
+// @Description Создает заявку на награду от имени авторизованного пользователя в статусе pending.
+// @Description Стоимость награды списывается с бонусного счета, остаток награды уменьшается на 1
+// @id RedeemReward
+// @Accept json
+// @Procedure json
+// @router /rewards/{id}/redeem [post]
+// @param id path int true "идентификатор награды"
+// @Success 201 {object} Redemption
+// @Failure 404 {object} response.ErrorResponse "награда не найдена"
+// @Failure 409 {object} response.ErrorResponse "награда в архиве, закончилась или недостаточно бонусов"
+// @Security BasicAuth
+// @Security BearerAuth
+func RedeemReward(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		rewardId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+		principal, ok := storages.PrincipalFromContext(r.Context())
+		if !ok {
+			response.Error(w, r, http.StatusUnauthorized, response.CodeUnauthorized)
+			return
+		}
+
+		var redemption Redemption
+		err := storage.DB.Transactional(func(tx *dbx.Tx) error {
+			var err error
+			redemption, err = redeem(tx, rewardId, principal.UserId)
+			return err
+		})
+		writeRedemptionResult(w, r, logger, http.StatusCreated, redemption, err, "redeem reward failed")
+	}
+}
+
+// @Summary Заявки на награды
+// @Tags rewards
+// @Description Возвращает страницу заявок всех пользователей
+// @id ListRedemptions
+// @Accept json
+// @Procedure json
+// @router /redemptions [get]
+// @param limit query int false "кол-во заявок на странице, по умолчанию 50, не больше 200"
+// @param cursor query string false "курсор следующей страницы"
+// @param sort query string false "сортировка, '-' в начале - по убыванию" Enums(id, -id)
+// @param status query string false "статус заявки" Enums(pending, fulfilled, cancelled)
+// @param user_id query int false "идентификатор пользователя"
+// @Success 200 {object} storages.Page[Redemption]
+// @Failure 400 {object} response.ErrorResponse "неверные параметры запроса"
+// @Security BasicAuth
+// @Security BearerAuth
+func ListRedemptions(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		writeRedemptions(storage, logger, w, r, 0)
+	}
+}
+
+// @Summary Мои заявки на награды
+// @Tags me
+// @Description Возвращает страницу заявок авторизованного пользователя. Параметры такие же, как в ListRedemptions, кроме user_id
+// @id GetMyRedemptions
+// @Accept json
+// @Procedure json
+// @router /me/redemptions [get]
+// @param limit query int false "кол-во заявок на странице, по умолчанию 50, не больше 200"
+// @param cursor query string false "курсор следующей страницы"
+// @param sort query string false "сортировка, '-' в начале - по убыванию" Enums(id, -id)
+// @param status query string false "статус заявки" Enums(pending, fulfilled, cancelled)
+// @Success 200 {object} storages.Page[Redemption]
+// @Failure 400 {object} response.ErrorResponse "неверные параметры запроса"
+// @Security BasicAuth
+// @Security BearerAuth
+func GetMyRedemptions(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		principal, ok := storages.PrincipalFromContext(r.Context())
+		if !ok {
+			response.Error(w, r, http.StatusUnauthorized, response.CodeUnauthorized)
+			return
+		}
+		writeRedemptions(storage, logger, w, r, principal.UserId)
+	}
+}
+
+// writeRedemptions отвечает страницей заявок. Если userId больше 0, выбираются только заявки этого пользователя
+func writeRedemptions(storage *storages.Storage, logger *slog.Logger, w http.ResponseWriter, r *http.Request, userId int) {
+	query := r.URL.Query()
+	page, err := storages.ParsePageRequest(query, redemptionSortColumns, "id")
+	if err != nil {
+		response.BadRequest(w, r, err)
+		return
+	}
+	if userId == 0 && query.Get("user_id") != "" {
+		if userId, err = strconv.Atoi(query.Get("user_id")); err != nil || userId <= 0 {
+			response.InvalidParam(w, r, "user_id", response.RulePositive)
+			return
+		}
+	}
+
+	status := query.Get("status")
+	switch status {
+	case "", RedemptionPending, RedemptionFulfilled, RedemptionCancelled:
+	default:
+		response.InvalidParam(w, r, "status", response.RuleOneOf, "pending, fulfilled, cancelled")
+		return
+	}
+
+	q := selectRedemptions(storage.DB)
+	if userId > 0 {
+		q.AndWhere(dbx.HashExp{"d.user_id": userId})
+	}
+	if status != "" {
+		q.AndWhere(dbx.HashExp{"d.status": status})
+	}
+	var redemptions []Redemption
+	err = page.Apply(q, "d").All(&redemptions)
+	if err != nil {
+		logger.Error("list redemptions failed", "error", err.Error())
+		response.Internal(w, r)
+		return
+	}
+	response.JSON(w, http.StatusOK, storages.NewPage(page, redemptions, func(redemption Redemption) (string, int) {
+		return "", redemption.Id
+	}))
+}
+
+// @Summary Выполнить заявку
+// @Tags rewards
+// @Description Отмечает, что награда выдана. Выполнить можно только заявку в статусе pending
+// @id FulfillRedemption
+// @Accept json
+// @Procedure json
+// @router /redemptions/{id}/fulfill [post]
+// @param id path int true "идентификатор заявки"
+// @param input body ResolveRequest false "комментарий"
+// @Success 200 {object} Redemption
+// @Failure 404 {object} response.ErrorResponse "заявка не найдена"
+// @Failure 409 {object} response.ErrorResponse "заявка уже выполнена или отменена"
+// @Security BasicAuth
+// @Security BearerAuth
+func FulfillRedemption(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		resolveRedemption(storage, logger, w, r, RedemptionFulfilled)
+	}
+}
+
+// @Summary Отменить заявку
+// @Tags rewards
+// @Description Отменяет заявку в статусе pending: бонусы возвращаются записью журнала, отменяющей списание, остаток награды увеличивается.
+// @Description Пользователь может отменить свою заявку, заявки других пользователей отменяет пользователь с разрешением rewards.manage
+// @id CancelRedemption
+// @Accept json
+// @Procedure json
+// @router /redemptions/{id}/cancel [post]
+// @param id path int true "идентификатор заявки"
+// @param input body ResolveRequest false "причина отмены"
+// @Success 200 {object} Redemption
+// @Failure 404 {object} response.ErrorResponse "заявка не найдена"
+// @Failure 409 {object} response.ErrorResponse "заявка уже выполнена или отменена"
+// @Security BasicAuth
+// @Security BearerAuth
+func CancelRedemption(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		resolveRedemption(storage, logger, w, r, RedemptionCancelled)
+	}
+}
+
+func resolveRedemption(storage *storages.Storage, logger *slog.Logger, w http.ResponseWriter, r *http.Request, status string) {
+	redemptionId, ok := pathId(r)
+	if !ok {
+		response.InvalidParam(w, r, "id", response.RulePositive)
+		return
+	}
+	principal, ok := storages.PrincipalFromContext(r.Context())
+	if !ok {
+		response.Error(w, r, http.StatusUnauthorized, response.CodeUnauthorized)
+		return
+	}
+	request, ok := decodeResolveRequest(w, r)
+	if !ok {
+		return
+	}
+
+	//чужие заявки для пользователя без rewards.manage не существуют
+	canManage := principal.HasPermission(storages.PermRewardsManage)
+	canResolve := func(redemption Redemption) bool {
+		return canManage || redemption.UserId == principal.UserId
+	}
+	var redemption Redemption
+	err := storage.DB.Transactional(func(tx *dbx.Tx) error {
+		var err error
+		redemption, err = resolve(tx, redemptionId, status, principal.UserId, request.Comment, canResolve)
+		return err
+	})
+	writeRedemptionResult(w, r, logger, http.StatusOK, redemption, err, "resolve redemption failed")
+}
@@ -0,0 +1,372 @@
+package reward
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+	"time"
+	"unicode/utf8"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Каталог наград, за которые пользователи тратят бонусы. Награды не удаляются, а архивируются:
+// по ним остаются заявки пользователей. Остаток (stock) уменьшается атомарным UPDATE при создании заявки,
+// поэтому параллельные заявки не могут выдать больше наград, чем есть
+
+var (
+	errRewardNotExists  = errors.New("награда не существует")
+	errRewardArchived   = errors.New("награда в архиве")
+	errRewardOutOfStock = errors.New("награды нет в наличии")
+)
+
+// Reward model info
+// @Description Reward награда из каталога
+type Reward struct {
+	Id          int        `json:"id" db:"id"`                   //Идентификатор награды
+	Name        string     `json:"name" db:"name"`               //Название
+	Description string     `json:"description" db:"description"` //Описание
+	Cost        int        `json:"cost" db:"cost"`               //Стоимость в бонусах
+	Stock       *int       `json:"stock" db:"stock"`             //Остаток, пусто - без ограничения
+	ArchivedAt  *time.Time `json:"archived_at" db:"archived_at"` //Время архивирования, пусто для действующей награды
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`   //Время создания
+}
+
+func (reward *Reward) TableName() string {
+	return "rewards"
+}
+
+// NewReward model info
+// @Description NewReward json для создания награды
+type NewReward struct {
+	Name        string `json:"name"`        //Название, уникальное в каталоге
+	Description string `json:"description"` //Описание
+	Cost        int    `json:"cost"`        //Стоимость в бонусах, больше 0
+	Stock       *int   `json:"stock"`       //Остаток, не указан - без ограничения
+}
+
+// UpdateRewardRequest model info
+// @Description UpdateRewardRequest json для изменения награды. Незаполненные поля не меняются
+type UpdateRewardRequest struct {
+	Name        *string     `json:"name"`                        //Новое название
+	Description *string     `json:"description"`                 //Новое описание
+	Cost        *int        `json:"cost"`                        //Новая стоимость, уже созданные заявки не меняются
+	Stock       optionalInt `json:"stock" swaggertype:"integer"` //Новый остаток, null снимает ограничение
+}
+
+// optionalInt число в запросе на изменение, позволяет отличить отсутствующее поле от явного null
+type optionalInt struct {
+	Set   bool
+	Value *int
+}
+
+func (i *optionalInt) UnmarshalJSON(data []byte) error {
+	i.Set = true
+	return json.Unmarshal(data, &i.Value)
+}
+
+// rewardSortColumns ключи сортировки каталога наград
+var rewardSortColumns = map[string]string{
+	"id":   "id",
+	"name": "name",
+	"cost": "cost",
+}
+
+// pathId возвращает идентификатор из пути запроса
+func pathId(r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// validName проверяет длину названия награды, в БД под него отведено 200 символов
+func validName(name string) bool {
+	length := utf8.RuneCountInString(name)
+	return length > 0 && length <= 200
+}
+
+// validate возвращает ошибки входных данных новой награды
+func (reward NewReward) validate() []response.FieldError {
+	var errlist []response.FieldError
+	if !validName(reward.Name) {
+		errlist = append(errlist, response.Field("name", response.RuleLength, 1, 200))
+	}
+	if reward.Cost <= 0 {
+		errlist = append(errlist, response.Field("cost", response.RulePositive))
+	}
+	if reward.Stock != nil && *reward.Stock < 0 {
+		errlist = append(errlist, response.Field("stock", response.RuleNotNegative))
+	}
+	return errlist
+}
+
+// updateParams возвращает изменяемые колонки награды и ошибки входных данных
+func (request UpdateRewardRequest) updateParams() (dbx.Params, []response.FieldError) {
+	params := dbx.Params{}
+	var errlist []response.FieldError
+	if request.Name != nil {
+		if !validName(*request.Name) {
+			errlist = append(errlist, response.Field("name", response.RuleLength, 1, 200))
+		}
+		params["name"] = *request.Name
+	}
+	if request.Description != nil {
+		params["description"] = *request.Description
+	}
+	if request.Cost != nil {
+		if *request.Cost <= 0 {
+			errlist = append(errlist, response.Field("cost", response.RulePositive))
+		}
+		params["cost"] = *request.Cost
+	}
+	if request.Stock.Set {
+		if request.Stock.Value != nil && *request.Stock.Value < 0 {
+			errlist = append(errlist, response.Field("stock", response.RuleNotNegative))
+		}
+		params["stock"] = request.Stock.Value
+	}
+	return params, errlist
+}
+
+// findReward возвращает награду, включая архивную
+func findReward(db dbx.Builder, rewardId int) (Reward, error) {
+	var reward Reward
+	err := db.Select().From(reward.TableName()).Where(dbx.HashExp{"id": rewardId}).One(&reward)
+	if errors.Is(err, sql.ErrNoRows) {
+		return reward, errRewardNotExists
+	}
+	return reward, err
+}
+
+// writeRewardError отвечает на ошибку изменения награды
+func writeRewardError(w http.ResponseWriter, r *http.Request, logger *slog.Logger, err error, message string) {
+	switch {
+	case errors.Is(err, errRewardNotExists):
+		response.Error(w, r, http.StatusNotFound, response.CodeRewardNotFound)
+	case errors.Is(err, errRewardArchived):
+		response.Error(w, r, http.StatusConflict, response.CodeRewardArchived)
+	case storages.ConstraintName(err) == "rewards_name_key":
+		response.Error(w, r, http.StatusConflict, response.CodeRewardExists)
+	default:
+		logger.Error(message, "error", err.Error())
+		response.Internal(w, r)
+	}
+}
+
+// @Summary Каталог наград
+// @Tags rewards
+// @Description Возвращает страницу каталога наград. Архивные награды показываются только с archived=true
+// @id ListRewards
+// @Accept json
+// @Procedure json
+// @router /rewards [get]
+// @param limit query int false "кол-во наград на странице, по умолчанию 50, не больше 200"
+// @param cursor query string false "курсор следующей страницы"
+// @param sort query string false "сортировка, '-' в начале - по убыванию" Enums(id, -id, name, -name, cost, -cost)
+// @param name query string false "название содержит строку"
+// @param available query bool false "только награды в наличии (true) или только закончившиеся (false)"
+// @param archived query bool false "включить архивные награды"
+// @Success 200 {object} storages.Page[Reward]
+// @Failure 400 {object} response.ErrorResponse "неверные параметры запроса"
+// @Security BasicAuth
+// @Security BearerAuth
+func ListRewards(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		query := r.URL.Query()
+		page, err := storages.ParsePageRequest(query, rewardSortColumns, "id")
+		var available, archived *bool
+		if err == nil {
+			available, err = storages.ParseBoolParam(query, "available")
+		}
+		if err == nil {
+			archived, err = storages.ParseBoolParam(query, "archived")
+		}
+		if err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+
+		q := storage.DB.Select().From("rewards")
+		if archived == nil || !*archived {
+			q.AndWhere(dbx.NewExp("archived_at IS NULL"))
+		}
+		if name := query.Get("name"); name != "" {
+			q.AndWhere(dbx.NewExp("name ILIKE {:name}", dbx.Params{"name": storages.ContainsPattern(name)}))
+		}
+		if available != nil {
+			condition := "(stock IS NULL OR stock > 0)"
+			if !*available {
+				condition = "NOT " + condition
+			}
+			q.AndWhere(dbx.NewExp(condition))
+		}
+
+		var rewards []Reward
+		err = page.Apply(q, "").All(&rewards)
+		if err != nil {
+			logger.Error("list rewards failed", "error", err.Error())
+			response.Internal(w, r)
+			return
+		}
+		response.JSON(w, http.StatusOK, storages.NewPage(page, rewards, func(reward Reward) (string, int) {
+			switch page.Sort {
+			case "name":
+				return reward.Name, reward.Id
+			case "cost":
+				return strconv.Itoa(reward.Cost), reward.Id
+			default:
+				return "", reward.Id
+			}
+		}))
+	}
+}
+
+// @Summary Создать награду
+// @Tags rewards
+// @Description Добавляет награду в каталог
+// @id CreateReward
+// @Accept json
+// @Procedure json
+// @router /rewards [post]
+// @param input body NewReward true "награда"
+// @Success 201 {object} Reward
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Failure 409 {object} response.ErrorResponse "награда с таким названием существует"
+// @Security BasicAuth
+// @Security BearerAuth
+func CreateReward(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		var request NewReward
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+		if errlist := request.validate(); len(errlist) > 0 {
+			response.Invalid(w, r, errlist)
+			return
+		}
+
+		var reward Reward
+		err := storage.DB.NewQuery(`INSERT INTO rewards (name, description, cost, stock)
+									VALUES ({:name}, {:description}, {:cost}, {:stock})
+									RETURNING *`).
+			Bind(dbx.Params{"name": request.Name, "description": request.Description, "cost": request.Cost, "stock": request.Stock}).
+			One(&reward)
+		if err != nil {
+			writeRewardError(w, r, logger, err, "create reward failed")
+			return
+		}
+		response.JSON(w, http.StatusCreated, reward)
+	}
+}
+
+// @Summary Изменить награду
+// @Tags rewards
+// @Description Изменяет название, описание, стоимость или остаток награды. Архивную награду изменить нельзя
+// @id UpdateReward
+// @Accept json
+// @Procedure json
+// @router /rewards/{id} [patch]
+// @param id path int true "идентификатор награды"
+// @param input body UpdateRewardRequest true "изменяемые поля"
+// @Success 200 {object} Reward
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Failure 404 {object} response.ErrorResponse "награда не найдена"
+// @Failure 409 {object} response.ErrorResponse "награда в архиве или с таким названием существует"
+// @Security BasicAuth
+// @Security BearerAuth
+func UpdateReward(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		rewardId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+		var request UpdateRewardRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+		params, errlist := request.updateParams()
+		if len(errlist) > 0 {
+			response.Invalid(w, r, errlist)
+			return
+		}
+
+		var reward Reward
+		err := storage.DB.Transactional(func(tx *dbx.Tx) error {
+			var err error
+			reward, err = findReward(tx, rewardId)
+			if err != nil {
+				return err
+			}
+			if reward.ArchivedAt != nil {
+				return errRewardArchived
+			}
+			if len(params) > 0 {
+				_, err = tx.Update(reward.TableName(), params, dbx.HashExp{"id": rewardId}).Execute()
+				if err != nil {
+					return err
+				}
+			}
+			reward, err = findReward(tx, rewardId)
+			return err
+		})
+		if err != nil {
+			writeRewardError(w, r, logger, err, "update reward failed")
+			return
+		}
+		response.JSON(w, http.StatusOK, reward)
+	}
+}
+
+// @Summary Архивировать награду
+// @Tags rewards
+// @Description Убирает награду из каталога. Созданные заявки на нее можно выполнить или отменить
+// @id ArchiveReward
+// @Accept json
+// @Procedure json
+// @router /rewards/{id}/archive [post]
+// @param id path int true "идентификатор награды"
+// @Success 200 {object} Reward
+// @Failure 404 {object} response.ErrorResponse "награда не найдена"
+// @Security BasicAuth
+// @Security BearerAuth
+func ArchiveReward(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		rewardId, ok := pathId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+
+		var reward Reward
+		err := storage.DB.Transactional(func(tx *dbx.Tx) error {
+			_, err := tx.NewQuery("UPDATE rewards SET archived_at = coalesce(archived_at, now()) WHERE id = {:id}").
+				Bind(dbx.Params{"id": rewardId}).Execute()
+			if err != nil {
+				return err
+			}
+			reward, err = findReward(tx, rewardId)
+			return err
+		})
+		if err != nil {
+			writeRewardError(w, r, logger, err, "archive reward failed")
+			return
+		}
+		response.JSON(w, http.StatusOK, reward)
+	}
+}
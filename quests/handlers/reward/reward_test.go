@@ -0,0 +1,219 @@
+package reward
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"techno-test_quests/quests/internal/testdb"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+)
+
+// Тесты выполняются против тестовой БД, строка подключения задается переменной окружения QUESTS_TEST_DB (см. testdb)
+
+type testFixture struct {
+	storage *storages.Storage
+	logger  *slog.Logger
+	userId  int
+	adminId int
+	suffix  string
+}
+
+func newTestFixture(t testing.TB) *testFixture {
+	t.Helper()
+	storage := testdb.Open(t)
+	suffix := testdb.Suffix()
+	return &testFixture{
+		storage: storage,
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		userId:  testdb.User(t, storage, "u"+suffix),
+		adminId: testdb.User(t, storage, "a"+suffix),
+		suffix:  suffix,
+	}
+}
+
+// reward добавляет награду стоимостью cost с остатком stock, nil - без ограничения.
+// Заявки на награду удаляются по завершении теста раньше нее
+func (f *testFixture) reward(t *testing.T, cost int, stock *int) int {
+	t.Helper()
+	rewardId := testdb.Insert(t, f.storage, "rewards", dbx.Params{"name": "reward " + strconv.Itoa(cost) + " " + f.suffix, "cost": cost, "stock": stock})
+	testdb.Delete(t, f.storage, "redemptions", dbx.HashExp{"reward_id": rewardId})
+	return rewardId
+}
+
+// credit начисляет пользователю amount бонусов ручной корректировкой
+func (f *testFixture) credit(t *testing.T, amount int) {
+	t.Helper()
+	entry := storages.LedgerEntry{UserId: f.userId, Amount: amount, Kind: storages.LedgerKindAdjustment, CreatedBy: &f.adminId}
+	if err := storages.AddLedgerEntry(f.storage.DB, &entry); err != nil {
+		t.Fatalf("credit bonus: %s", err)
+	}
+}
+
+func (f *testFixture) balance(t *testing.T) int {
+	t.Helper()
+	balance, err := storages.Balance(f.storage.DB, f.userId)
+	if err != nil {
+		t.Fatalf("balance: %s", err)
+	}
+	return balance
+}
+
+func (f *testFixture) stock(t *testing.T, rewardId int) *int {
+	t.Helper()
+	reward, err := findReward(f.storage.DB, rewardId)
+	if err != nil {
+		t.Fatalf("findReward: %s", err)
+	}
+	return reward.Stock
+}
+
+func (f *testFixture) redeem(rewardId int) (Redemption, error) {
+	var redemption Redemption
+	err := f.storage.DB.Transactional(func(tx *dbx.Tx) error {
+		var err error
+		redemption, err = redeem(tx, rewardId, f.userId)
+		return err
+	})
+	return redemption, err
+}
+
+func (f *testFixture) resolve(redemptionId int, status string) (Redemption, error) {
+	var redemption Redemption
+	err := f.storage.DB.Transactional(func(tx *dbx.Tx) error {
+		var err error
+		redemption, err = resolve(tx, redemptionId, status, f.adminId, "test", func(Redemption) bool { return true })
+		return err
+	})
+	return redemption, err
+}
+
+func TestRedeemExhaustsStock(t *testing.T) {
+	f := newTestFixture(t)
+	f.credit(t, 30)
+	stock := 1
+	rewardId := f.reward(t, 10, &stock)
+
+	redemption, err := f.redeem(rewardId)
+	if err != nil {
+		t.Fatalf("redeem: %s", err)
+	}
+	if redemption.Status != RedemptionPending || redemption.Cost != 10 {
+		t.Fatalf("unexpected redemption %+v", redemption)
+	}
+	if left := f.stock(t, rewardId); left == nil || *left != 0 {
+		t.Fatalf("stock %v, want 0", left)
+	}
+
+	if _, err = f.redeem(rewardId); !errors.Is(err, errRewardOutOfStock) {
+		t.Fatalf("redeem out of stock: %v, want %v", err, errRewardOutOfStock)
+	}
+	if balance := f.balance(t); balance != 20 {
+		t.Fatalf("balance %d, want 20", balance)
+	}
+}
+
+func TestRedeemRejectsInsufficientBalance(t *testing.T) {
+	f := newTestFixture(t)
+	f.credit(t, 30)
+	stock := 5
+	rewardId := f.reward(t, 50, &stock)
+
+	if _, err := f.redeem(rewardId); !errors.Is(err, storages.ErrInsufficientBonus) {
+		t.Fatalf("redeem: %v, want %v", err, storages.ErrInsufficientBonus)
+	}
+	//транзакция откатывается вместе с уменьшением остатка
+	if left := f.stock(t, rewardId); left == nil || *left != 5 {
+		t.Fatalf("stock %v, want 5", left)
+	}
+	if balance := f.balance(t); balance != 30 {
+		t.Fatalf("balance %d, want 30", balance)
+	}
+}
+
+func TestCancelRedemptionRefunds(t *testing.T) {
+	f := newTestFixture(t)
+	f.credit(t, 30)
+	stock := 1
+	rewardId := f.reward(t, 10, &stock)
+	redemption, err := f.redeem(rewardId)
+	if err != nil {
+		t.Fatalf("redeem: %s", err)
+	}
+
+	cancelled, err := f.resolve(redemption.Id, RedemptionCancelled)
+	if err != nil {
+		t.Fatalf("cancel: %s", err)
+	}
+	if cancelled.Status != RedemptionCancelled || cancelled.RefundId == nil || cancelled.ResolvedBy == nil || *cancelled.ResolvedBy != f.adminId {
+		t.Fatalf("unexpected redemption %+v", cancelled)
+	}
+	if balance := f.balance(t); balance != 30 {
+		t.Fatalf("balance %d after refund, want 30", balance)
+	}
+	if left := f.stock(t, rewardId); left == nil || *left != 1 {
+		t.Fatalf("stock %v after cancel, want 1", left)
+	}
+
+	if _, err = f.resolve(redemption.Id, RedemptionCancelled); !errors.Is(err, errRedemptionResolved) {
+		t.Fatalf("cancel twice: %v, want %v", err, errRedemptionResolved)
+	}
+	if balance := f.balance(t); balance != 30 {
+		t.Fatalf("balance %d after second cancel, want 30", balance)
+	}
+}
+
+func TestFulfillRedemptionKeepsDebit(t *testing.T) {
+	f := newTestFixture(t)
+	f.credit(t, 30)
+	rewardId := f.reward(t, 10, nil)
+	redemption, err := f.redeem(rewardId)
+	if err != nil {
+		t.Fatalf("redeem: %s", err)
+	}
+
+	fulfilled, err := f.resolve(redemption.Id, RedemptionFulfilled)
+	if err != nil {
+		t.Fatalf("fulfill: %s", err)
+	}
+	if fulfilled.Status != RedemptionFulfilled || fulfilled.RefundId != nil || fulfilled.ResolvedAt == nil {
+		t.Fatalf("unexpected redemption %+v", fulfilled)
+	}
+	if _, err = f.resolve(redemption.Id, RedemptionCancelled); !errors.Is(err, errRedemptionResolved) {
+		t.Fatalf("cancel fulfilled: %v, want %v", err, errRedemptionResolved)
+	}
+	if balance := f.balance(t); balance != 20 {
+		t.Fatalf("balance %d, want 20", balance)
+	}
+}
+
+func TestListRedemptionsValidatesStatus(t *testing.T) {
+	f := newTestFixture(t)
+
+	for status, want := range map[string]int{"pending": http.StatusOK, "cancelled": http.StatusOK, "unknown": http.StatusBadRequest} {
+		r := httptest.NewRequest(http.MethodGet, "/redemptions?status="+status, nil)
+		w := httptest.NewRecorder()
+		ListRedemptions(f.storage, f.logger)(w, r)
+
+		if w.Code != want {
+			t.Fatalf("status %q: code %d, want %d, body %s", status, w.Code, want, w.Body.String())
+		}
+		if want != http.StatusBadRequest {
+			continue
+		}
+		var body response.ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode response: %s", err)
+		}
+		if body.Error.Code != response.CodeInvalidParameter || len(body.Error.Details) != 1 || body.Error.Details[0].Field != "status" {
+			t.Fatalf("unexpected error %+v", body.Error)
+		}
+	}
+}
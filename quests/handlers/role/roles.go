@@ -0,0 +1,110 @@
+package role
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// SetUserRolesRequest model info
+// @Description SetUserRolesRequest json для назначения ролей пользователю
+type SetUserRolesRequest struct {
+	UserId int      `json:"userid"` //Идентификатор пользователя
+	Roles  []string `json:"roles"`  //Полный список ролей пользователя, текущие роли будут заменены
+}
+
+// @Summary Получить роли
+// @Tags roles
+// @Description Возвращает все роли и их разрешения
+// @id GetRoles
+// @Accept json
+// @Procedure json
+// @router /GetRoles [get]
+// @Success 200 {array} storage.Role
+// @Security BasicAuth
+// @Security BearerAuth
+func GetRoles(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		if r.Method == http.MethodGet {
+			roles, err := storages.GetRoles(storage.DB)
+			if err != nil {
+				logger.Error("get roles failed", "error", err.Error())
+				response.Internal(w, r)
+				return
+			}
+			response.JSON(w, http.StatusOK, roles)
+		} else {
+			response.MethodNotAllowed(w, r, http.MethodGet)
+		}
+	}
+}
+
+// @Summary Назначить роли пользователю
+// @Tags roles
+// @Description Заменяет роли пользователя переданным списком. Роль admin также устанавливает признак userIsAdmin.
+// @Description Роль admin нельзя забрать у последнего администратора.
+// @Description Выданные пользователю access токены отзываются, новые роли действуют после входа или обновления токена
+// @id SetUserRoles
+// @Accept json
+// @Procedure json
+// @router /SetUserRoles [post]
+// @param input body SetUserRolesRequest true "пользователь и его роли"
+// @Success 200 {string} string "ok"
+// @Failure 404 {object} response.ErrorResponse "пользователь не найден"
+// @Failure 409 {object} response.ErrorResponse "у пользователя забирается роль последнего администратора"
+// @Failure 422 {object} response.ErrorResponse "роль не существует"
+// @Security BasicAuth
+// @Security BearerAuth
+func SetUserRoles(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		if r.Method == http.MethodPost {
+			var request SetUserRolesRequest
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			err := decoder.Decode(&request)
+			if err != nil || request.UserId <= 0 {
+				response.Error(w, r, http.StatusBadRequest, response.CodeMalformedRequest)
+				return
+			}
+
+			var unknown []string
+			err = storage.DB.Transactional(func(tx *dbx.Tx) error {
+				var count int
+				err := tx.Select("count(*)").From("users").Where(dbx.HashExp{"id": request.UserId}).Row(&count)
+				if err != nil {
+					return err
+				}
+				if count == 0 {
+					return storages.ErrUserNotFound
+				}
+				unknown, err = storages.SetUserRoles(tx, request.UserId, request.Roles)
+				return err
+			})
+
+			switch {
+			case errors.Is(err, storages.ErrUserNotFound):
+				response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound)
+			case errors.Is(err, storages.ErrLastAdmin):
+				response.Error(w, r, http.StatusConflict, response.CodeLastAdmin)
+			case err != nil:
+				logger.Error("set user roles failed", "error", err.Error())
+				response.Internal(w, r)
+			case len(unknown) > 0:
+				response.ErrorDetails(w, r, http.StatusUnprocessableEntity, response.CodeUnknownRoles,
+					[]response.FieldError{response.Field("roles", response.RuleUnknown, strings.Join(unknown, ", "))})
+			default:
+				response.Message(w, r, http.StatusOK, response.MessageRolesAssigned)
+			}
+		} else {
+			response.MethodNotAllowed(w, r, http.MethodPost)
+		}
+	}
+}
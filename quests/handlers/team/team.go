@@ -0,0 +1,366 @@
+package team
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+	"time"
+	"unicode/utf8"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Команды пользователей. Пользователь состоит не больше чем в одной команде. В командном задании (team_scoped)
+// выполнение шага любым участником засчитывается всей команде, а бонусы, заработанные в составе команды,
+// помечаются в журнале бонусов командой и составляют ее счет. Команду с историей выполнений удалить нельзя
+
+var (
+	errTeamNotExists   = errors.New("команда не существует")
+	errMemberNotExists = errors.New("пользователь не состоит в команде")
+)
+
+// Team model info
+// @Description Team команда пользователей
+type Team struct {
+	Id          int       `json:"id" db:"id"`                     //Идентификатор команды
+	Name        string    `json:"name" db:"name"`                 //Название
+	MemberCount int       `json:"member_count" db:"member_count"` //Кол-во участников
+	TotalBonus  int       `json:"total_bonus" db:"total_bonus"`   //Бонусы, заработанные участниками в составе команды
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`     //Время создания
+}
+
+// TeamDetails model info
+// @Description TeamDetails команда с участниками
+type TeamDetails struct {
+	Team
+	Members []TeamMember `json:"members"` //Участники команды
+}
+
+// TeamMember участник команды
+type TeamMember struct {
+	UserId   int       `json:"user_id" db:"user_id"`     //Идентификатор пользователя
+	Username string    `json:"username" db:"username"`   //Имя пользователя
+	JoinedAt time.Time `json:"joined_at" db:"joined_at"` //Время вступления в команду
+}
+
+// NewTeam model info
+// @Description NewTeam json для создания команды
+type NewTeam struct {
+	Name string `json:"name"` //Название, уникальное среди команд
+}
+
+// AddMemberRequest model info
+// @Description AddMemberRequest json для добавления участника в команду
+type AddMemberRequest struct {
+	UserId int `json:"user_id"` //Идентификатор пользователя, не состоящего в другой команде
+}
+
+// teamSortColumns ключи сортировки списка команд
+var teamSortColumns = map[string]string{
+	"id":   "id",
+	"name": "name",
+}
+
+// teamColumns колонки команды с кол-вом участников и суммой бонусов
+func teamColumns(db dbx.Builder) *dbx.SelectQuery {
+	return db.Select("t.id", "t.name", "t.created_at",
+		"(SELECT count(*) FROM team_members AS m WHERE m.team_id = t.id) AS member_count",
+		"(SELECT coalesce(sum(l.amount), 0) FROM bonus_ledger AS l WHERE l.team_id = t.id) AS total_bonus").
+		From("teams AS t")
+}
+
+// pathId возвращает идентификатор из пути запроса
+func pathId(r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// findTeam возвращает команду с участниками
+func findTeam(db dbx.Builder, teamId int) (TeamDetails, error) {
+	team := TeamDetails{Members: []TeamMember{}}
+	err := teamColumns(db).Where(dbx.NewExp("t.id = {:id}", dbx.Params{"id": teamId})).One(&team.Team)
+	if errors.Is(err, sql.ErrNoRows) {
+		return team, errTeamNotExists
+	}
+	if err != nil {
+		return team, err
+	}
+	err = db.NewQuery(`SELECT m.user_id, u.username, m.joined_at
+						FROM team_members AS m
+						JOIN users AS u ON u.id = m.user_id
+						WHERE m.team_id = {:id}
+						ORDER BY m.joined_at, m.user_id`).Bind(dbx.Params{"id": teamId}).All(&team.Members)
+	return team, err
+}
+
+// writeTeamError отвечает на ошибку изменения команды
+func writeTeamError(w http.ResponseWriter, r *http.Request, logger *slog.Logger, err error, message string) {
+	switch {
+	case errors.Is(err, errTeamNotExists):
+		response.Error(w, r, http.StatusNotFound, response.CodeTeamNotFound)
+	case errors.Is(err, errMemberNotExists):
+		response.Error(w, r, http.StatusNotFound, response.CodeTeamMemberNotFound)
+	case storages.ConstraintName(err) == "teams_name_key":
+		response.Error(w, r, http.StatusConflict, response.CodeTeamExists)
+	case storages.ConstraintName(err) == "team_members_user_id_key", storages.ConstraintName(err) == "team_members_pkey":
+		response.Error(w, r, http.StatusConflict, response.CodeAlreadyInTeam)
+	case storages.ConstraintName(err) == "team_members_user_id_fkey":
+		response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound)
+	case storages.IsForeignKeyViolation(err):
+		response.Error(w, r, http.StatusConflict, response.CodeTeamHasHistory)
+	default:
+		logger.Error(message, "error", err.Error())
+		response.Internal(w, r)
+	}
+}
+
+// writeTeam отвечает командой с участниками
+func writeTeam(storage *storages.Storage, logger *slog.Logger, w http.ResponseWriter, r *http.Request, teamId int, status int) {
+	team, err := findTeam(storage.DB, teamId)
+	if err != nil {
+		writeTeamError(w, r, logger, err, "get team failed")
+		return
+	}
+	response.JSON(w, status, team)
+}
+
+// @Summary Список команд
+// @Tags teams
+// @Description Возвращает страницу команд с кол-вом участников и суммой бонусов, заработанных в составе команды
+// @id ListTeams
+// @Accept json
+// @Procedure json
+// @router /teams [get]
+// @param limit query int false "кол-во команд на странице, по умолчанию 50, не больше 200"
+// @param cursor query string false "курсор следующей страницы"
+// @param sort query string false "сортировка, '-' в начале - по убыванию" Enums(id, -id, name, -name)
+// @param name query string false "название содержит строку"
+// @Success 200 {object} storages.Page[Team]
+// @Failure 400 {object} response.ErrorResponse "неверные параметры запроса"
+// @Security BasicAuth
+// @Security BearerAuth
+func ListTeams(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		query := r.URL.Query()
+		page, err := storages.ParsePageRequest(query, teamSortColumns, "id")
+		if err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+
+		q := teamColumns(storage.DB)
+		if name := query.Get("name"); name != "" {
+			q.AndWhere(dbx.NewExp("t.name ILIKE {:name}", dbx.Params{"name": storages.ContainsPattern(name)}))
+		}
+
+		var teams []Team
+		err = page.Apply(q, "t").All(&teams)
+		if err != nil {
+			logger.Error("list teams failed", "error", err.Error())
+			response.Internal(w, r)
+			return
+		}
+		response.JSON(w, http.StatusOK, storages.NewPage(page, teams, func(team Team) (string, int) {
+			if page.Sort == "name" {
+				return team.Name, team.Id
+			}
+			return "", team.Id
+		}))
+	}
+}
+
+// @Summary Получить команду
+// @Tags teams
+// @Description Возвращает команду с участниками и суммой бонусов, заработанных в ее составе
+// @id GetTeam
+// @Accept json
+// @Procedure json
+// @router /teams/{id} [get]
+// @param id path int true "идентификатор команды"
+// @Success 200 {object} TeamDetails
+// @Failure 404 {object} response.ErrorResponse "команда не найдена"
+// @Security BasicAuth
+// @Security BearerAuth
+func GetTeam(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		teamId, ok := pathId(r, "id")
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+		writeTeam(storage, logger, w, r, teamId, http.StatusOK)
+	}
+}
+
+// @Summary Создать команду
+// @Tags teams
+// @Description Создает команду без участников
+// @id CreateTeam
+// @Accept json
+// @Procedure json
+// @router /teams [post]
+// @param input body NewTeam true "команда"
+// @Success 201 {object} TeamDetails
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Failure 409 {object} response.ErrorResponse "команда с таким названием существует"
+// @Security BasicAuth
+// @Security BearerAuth
+func CreateTeam(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		var request NewTeam
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+		if length := utf8.RuneCountInString(request.Name); length == 0 || length > 200 {
+			response.Invalid(w, r, []response.FieldError{response.Field("name", response.RuleLength, 1, 200)})
+			return
+		}
+
+		var teamId int
+		err := storage.DB.NewQuery("INSERT INTO teams (name) VALUES ({:name}) RETURNING id").
+			Bind(dbx.Params{"name": request.Name}).Row(&teamId)
+		if err != nil {
+			writeTeamError(w, r, logger, err, "create team failed")
+			return
+		}
+		writeTeam(storage, logger, w, r, teamId, http.StatusCreated)
+	}
+}
+
+// @Summary Удалить команду
+// @Tags teams
+// @Description Удаляет команду и ее состав. Команду, участники которой выполняли шаги в ее составе, удалить нельзя
+// @id DeleteTeam
+// @Accept json
+// @Procedure json
+// @router /teams/{id} [delete]
+// @param id path int true "идентификатор команды"
+// @Success 204
+// @Failure 404 {object} response.ErrorResponse "команда не найдена"
+// @Failure 409 {object} response.ErrorResponse "у команды есть история выполнения заданий"
+// @Security BasicAuth
+// @Security BearerAuth
+func DeleteTeam(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		teamId, ok := pathId(r, "id")
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+
+		result, err := storage.DB.Delete("teams", dbx.HashExp{"id": teamId}).Execute()
+		if err == nil {
+			if count, _ := result.RowsAffected(); count == 0 {
+				err = errTeamNotExists
+			}
+		}
+		if err != nil {
+			writeTeamError(w, r, logger, err, "delete team failed")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary Добавить участника
+// @Tags teams
+// @Description Добавляет пользователя в команду. Пользователь может состоять только в одной команде.
+// @Description Выполнения до вступления в команду ей не засчитываются
+// @id AddTeamMember
+// @Accept json
+// @Procedure json
+// @router /teams/{id}/members [post]
+// @param id path int true "идентификатор команды"
+// @param input body AddMemberRequest true "участник"
+// @Success 200 {object} TeamDetails
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Failure 404 {object} response.ErrorResponse "команда или пользователь не найдены"
+// @Failure 409 {object} response.ErrorResponse "пользователь уже состоит в команде"
+// @Security BasicAuth
+// @Security BearerAuth
+func AddTeamMember(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		teamId, ok := pathId(r, "id")
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+		var request AddMemberRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+		if request.UserId <= 0 {
+			response.Invalid(w, r, []response.FieldError{response.Field("user_id", response.RulePositive)})
+			return
+		}
+
+		_, err := storage.DB.NewQuery("INSERT INTO team_members (team_id, user_id) VALUES ({:teamid}, {:userid})").
+			Bind(dbx.Params{"teamid": teamId, "userid": request.UserId}).Execute()
+		if storages.ConstraintName(err) == "team_members_team_id_fkey" {
+			err = errTeamNotExists
+		}
+		if err != nil {
+			writeTeamError(w, r, logger, err, "add team member failed")
+			return
+		}
+		writeTeam(storage, logger, w, r, teamId, http.StatusOK)
+	}
+}
+
+// @Summary Исключить участника
+// @Tags teams
+// @Description Исключает пользователя из команды. Выполнения и бонусы, полученные в составе команды, остаются за ней
+// @id RemoveTeamMember
+// @Accept json
+// @Procedure json
+// @router /teams/{id}/members/{user_id} [delete]
+// @param id path int true "идентификатор команды"
+// @param user_id path int true "идентификатор пользователя"
+// @Success 200 {object} TeamDetails
+// @Failure 404 {object} response.ErrorResponse "команда не найдена или пользователь не состоит в ней"
+// @Security BasicAuth
+// @Security BearerAuth
+func RemoveTeamMember(storage *storages.Storage, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		storages.RequestTolog(r, logger)
+		teamId, ok := pathId(r, "id")
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+		userId, ok := pathId(r, "user_id")
+		if !ok {
+			response.InvalidParam(w, r, "user_id", response.RulePositive)
+			return
+		}
+
+		result, err := storage.DB.Delete("team_members", dbx.HashExp{"team_id": teamId, "user_id": userId}).Execute()
+		if err == nil {
+			if count, _ := result.RowsAffected(); count == 0 {
+				err = errMemberNotExists
+			}
+		}
+		if err != nil {
+			writeTeamError(w, r, logger, err, "remove team member failed")
+			return
+		}
+		writeTeam(storage, logger, w, r, teamId, http.StatusOK)
+	}
+}
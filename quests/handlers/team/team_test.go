@@ -0,0 +1,191 @@
+package team
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"techno-test_quests/quests/handlers/history"
+	"techno-test_quests/quests/internal/testdb"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+)
+
+// Тесты выполняются против тестовой БД, строка подключения задается переменной окружения QUESTS_TEST_DB (см. testdb)
+
+type testFixture struct {
+	storage *storages.Storage
+	mux     *http.ServeMux
+	userId  int
+	otherId int
+	stepId  int
+	teamId  int
+}
+
+func newTestFixture(t testing.TB) *testFixture {
+	t.Helper()
+	storage := testdb.Open(t)
+
+	//маршруты регистрируются так же, как в main, но без проверки авторизации
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /teams/{id}", GetTeam(storage, logger))
+	mux.HandleFunc("DELETE /teams/{id}", DeleteTeam(storage, logger))
+	mux.HandleFunc("POST /teams/{id}/members", AddTeamMember(storage, logger))
+	mux.HandleFunc("DELETE /teams/{id}/members/{user_id}", RemoveTeamMember(storage, logger))
+	mux.HandleFunc("POST /CompleteSteps", history.CompleteSteps(storage, logger))
+
+	suffix := testdb.Suffix()
+	f := &testFixture{
+		storage: storage,
+		mux:     mux,
+		userId:  testdb.User(t, storage, "u"+suffix),
+		otherId: testdb.User(t, storage, "o"+suffix),
+	}
+	//команда добавляется раньше задания: история и записи журнала со ссылкой на команду удаляются вместе с шагом и заданием
+	f.teamId = testdb.Insert(t, storage, "teams", dbx.Params{"name": "team " + suffix})
+	questId := testdb.Insert(t, storage, "quests", dbx.Params{"questname": "quest " + suffix, "cost": 100, "team_scoped": true})
+	f.stepId = testdb.Insert(t, storage, "queststeps", dbx.Params{"questid": questId, "stepname": "step", "bonus": 10, "ismulti": false, "max_completions": 1})
+	testdb.Delete(t, storage, "history", dbx.HashExp{"stepid": f.stepId})
+	return f
+}
+
+func (f *testFixture) serve(method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	f.mux.ServeHTTP(w, r)
+	return w
+}
+
+// addMember добавляет пользователя userId в команду teamId и возвращает ответ
+func (f *testFixture) addMember(teamId, userId int) *httptest.ResponseRecorder {
+	return f.serve(http.MethodPost, fmt.Sprintf("/teams/%d/members", teamId), fmt.Sprintf(`{"user_id":%d}`, userId))
+}
+
+// complete выполняет шаг фикстуры пользователем userId и возвращает статус шага
+func (f *testFixture) complete(t *testing.T, userId int) string {
+	t.Helper()
+	w := f.serve(http.MethodPost, "/CompleteSteps?mode=partial", fmt.Sprintf(`{"CompleteSteps":[{"stepid":%d,"userid":%d}]}`, f.stepId, userId))
+	var result history.CompleteStepsResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || len(result.Items) != 1 {
+		t.Fatalf("CompleteSteps: status %d, body %s", w.Code, w.Body.String())
+	}
+	return result.Items[0].Status
+}
+
+// errorCode возвращает код ошибки из ответа
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body response.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error response %s: %s", w.Body.String(), err)
+	}
+	return body.Error.Code
+}
+
+func TestTeamScopedQuestCountsForWholeTeam(t *testing.T) {
+	f := newTestFixture(t)
+	for _, userId := range []int{f.userId, f.otherId} {
+		if w := f.addMember(f.teamId, userId); w.Code != http.StatusOK {
+			t.Fatalf("add member %d: status %d, body %s", userId, w.Code, w.Body.String())
+		}
+	}
+
+	//шаг с одним выполнением, выполненный одним участником, засчитан всей команде
+	for _, test := range []struct {
+		userId int
+		want   string
+	}{
+		{f.userId, history.StepStatusRecorded},
+		{f.otherId, history.StepStatusAlreadyCompleted},
+	} {
+		if status := f.complete(t, test.userId); status != test.want {
+			t.Fatalf("user %d: status %s, want %s", test.userId, status, test.want)
+		}
+	}
+
+	team, err := findTeam(f.storage.DB, f.teamId)
+	if err != nil {
+		t.Fatalf("findTeam: %s", err)
+	}
+	if team.TotalBonus != 110 || team.MemberCount != 2 {
+		t.Fatalf("unexpected team %+v, want total bonus 110 of step and quest completion", team)
+	}
+
+	var score int
+	err = f.storage.DB.Select("score").From("team_leaderboard_scores").
+		Where(dbx.HashExp{"team_id": f.teamId, "period": "all", "quest_id": 0}).Row(&score)
+	if err != nil || score != 110 {
+		t.Fatalf("team score = %d, %v, want 110", score, err)
+	}
+}
+
+func TestTeamMembershipAndHistory(t *testing.T) {
+	f := newTestFixture(t)
+	if w := f.addMember(f.teamId, f.userId); w.Code != http.StatusOK {
+		t.Fatalf("add member: status %d, body %s", w.Code, w.Body.String())
+	}
+
+	//пользователь состоит не больше чем в одной команде
+	otherTeamId := testdb.Insert(t, f.storage, "teams", dbx.Params{"name": "other team " + strconv.Itoa(f.teamId)})
+	for _, teamId := range []int{f.teamId, otherTeamId} {
+		if w := f.addMember(teamId, f.userId); w.Code != http.StatusConflict || errorCode(t, w) != response.CodeAlreadyInTeam {
+			t.Fatalf("add member to team %d again: status %d, body %s", teamId, w.Code, w.Body.String())
+		}
+	}
+
+	if status := f.complete(t, f.userId); status != history.StepStatusRecorded {
+		t.Fatalf("complete step: status %s", status)
+	}
+
+	//выполнения остаются за командой после исключения участника, и команду с историей удалить нельзя
+	target := fmt.Sprintf("/teams/%d", f.teamId)
+	if w := f.serve(http.MethodDelete, fmt.Sprintf("%s/members/%d", target, f.userId), ""); w.Code != http.StatusOK {
+		t.Fatalf("remove member: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w := f.serve(http.MethodDelete, target, ""); w.Code != http.StatusConflict || errorCode(t, w) != response.CodeTeamHasHistory {
+		t.Fatalf("delete team with history: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w := f.serve(http.MethodGet, target, ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"total_bonus":110`) {
+		t.Fatalf("GET %s: status %d, body %s", target, w.Code, w.Body.String())
+	}
+}
+
+func TestPersonalQuestDoesNotCountForTeam(t *testing.T) {
+	f := newTestFixture(t)
+	if w := f.addMember(f.teamId, f.userId); w.Code != http.StatusOK {
+		t.Fatalf("add member: status %d, body %s", w.Code, w.Body.String())
+	}
+	questId := testdb.Insert(t, f.storage, "quests", dbx.Params{"questname": "personal quest " + strconv.Itoa(f.teamId), "cost": 100})
+	stepId := testdb.Insert(t, f.storage, "queststeps", dbx.Params{"questid": questId, "stepname": "step", "bonus": 10, "ismulti": false})
+	testdb.Delete(t, f.storage, "history", dbx.HashExp{"stepid": stepId})
+
+	w := f.serve(http.MethodPost, "/CompleteSteps", fmt.Sprintf(`{"CompleteSteps":[{"stepid":%d,"userid":%d}]}`, stepId, f.userId))
+	var result history.CompleteStepsResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || !result.Committed || !result.Items[0].QuestCompleted {
+		t.Fatalf("CompleteSteps: status %d, body %s", w.Code, w.Body.String())
+	}
+
+	//выполнение личного задания участником не записывается за командой ни в истории, ни в журнале
+	var teamRows int
+	err := f.storage.DB.NewQuery(`SELECT (SELECT count(*) FROM history WHERE stepid = {:stepid} AND team_id IS NOT NULL)
+								+ (SELECT count(*) FROM bonus_ledger WHERE quest_id = {:questid} AND team_id IS NOT NULL)`).
+		Bind(dbx.Params{"stepid": stepId, "questid": questId}).Row(&teamRows)
+	if err != nil || teamRows != 0 {
+		t.Fatalf("rows with team = %d, %v, want 0", teamRows, err)
+	}
+	team, err := findTeam(f.storage.DB, f.teamId)
+	if err != nil {
+		t.Fatalf("findTeam: %s", err)
+	}
+	if team.TotalBonus != 0 {
+		t.Fatalf("team total bonus %d, want 0 for a personal quest", team.TotalBonus)
+	}
+}
@@ -0,0 +1,446 @@
+package users
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+	"time"
+	"unicode/utf8"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Маршруты ресурса пользователей регистрируются с методом (GET /users, PATCH /users/{id} и т.д.),
+// поэтому на неподдерживаемый метод отвечает сам ServeMux со статусом 405
+
+// UpdateUserRequest model info
+// @Description UpdateUserRequest json для изменения пользователя. Незаполненные поля не меняются
+type UpdateUserRequest struct {
+	Username *string `json:"username"`    // новое имя пользователя
+	Isadmin  *bool   `json:"userIsAdmin"` // выдать или забрать роль admin
+}
+
+// ChangePasswordRequest model info
+// @Description ChangePasswordRequest json для смены пароля
+type ChangePasswordRequest struct {
+	Password        string `json:"password"`         // новый пароль
+	CurrentPassword string `json:"current_password"` // текущий пароль, обязателен при смене своего пароля без разрешения users.write
+}
+
+var errWrongPassword = errors.New("wrong current password")
+
+// pathUserId возвращает идентификатор пользователя из пути запроса
+func pathUserId(r *http.Request) (int, bool) {
+	userId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || userId <= 0 {
+		return 0, false
+	}
+	return userId, true
+}
+
+// validUsername проверяет длину имени пользователя, в БД под него отведено 20 символов
+func validUsername(username string) bool {
+	length := utf8.RuneCountInString(username)
+	return length > 0 && length <= 20
+}
+
+// findUser возвращает пользователя без пароля или storages.ErrUserNotFound
+func findUser(db dbx.Builder, userId int) (User, error) {
+	var user User
+	err := db.Select("id", "username", "isadmin", "created_at").From(user.TableName()).Where(dbx.HashExp{"id": userId}).One(&user)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, storages.ErrUserNotFound
+	}
+	return user, err
+}
+
+// setAdmin выдает или забирает роль admin, сохраняя остальные роли пользователя
+func setAdmin(db dbx.Builder, userId int, isAdmin bool) error {
+	access, err := storages.GetUserAccess(db, userId)
+	if err != nil {
+		return err
+	}
+	roles := slices.DeleteFunc(access.Roles, func(role string) bool { return role == storages.RoleAdmin })
+	if isAdmin {
+		roles = append(roles, storages.RoleAdmin)
+	}
+	_, err = storages.SetUserRoles(db, userId, roles)
+	return err
+}
+
+// userSortColumns ключи сортировки пользователей
+var userSortColumns = map[string]string{
+	"id":      "id",
+	"name":    "username",
+	"created": "created_at",
+}
+
+// @Summary Список пользователей
+// @Tags users
+// @Description Возвращает страницу пользователей приложения.
+// @Description Для получения следующей страницы передайте next_cursor из ответа в параметре cursor, остальные параметры не меняйте
+// @id ListUsers
+// @Accept json
+// @Procedure json
+// @router /users [get]
+// @param limit query int false "кол-во пользователей на странице, по умолчанию 50, не больше 200"
+// @param cursor query string false "курсор следующей страницы"
+// @param sort query string false "сортировка, '-' в начале - по убыванию" Enums(id, -id, name, -name, created, -created)
+// @param name query string false "имя пользователя содержит строку"
+// @param admin query bool false "только администраторы (true) или только не администраторы (false)"
+// @param created_from query string false "создан не раньше (2006-01-02 или RFC3339)"
+// @param created_to query string false "создан раньше (2006-01-02 или RFC3339)"
+// @Success 200 {object} storages.Page[User]
+// @Failure 400 {object} response.ErrorResponse "неверные параметры запроса"
+// @Security BasicAuth
+// @Security BearerAuth
+func ListUsers(storage *storages.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		page, err := storages.ParsePageRequest(query, userSortColumns, "id")
+		var isAdmin *bool
+		var createdFrom, createdTo *time.Time
+		if err == nil {
+			isAdmin, err = storages.ParseBoolParam(query, "admin")
+		}
+		if err == nil {
+			createdFrom, err = storages.ParseTimeParam(query, "created_from")
+		}
+		if err == nil {
+			createdTo, err = storages.ParseTimeParam(query, "created_to")
+		}
+		if err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+
+		q := storage.DB.Select("id", "username", "isadmin", "created_at").From("users")
+		if name := query.Get("name"); name != "" {
+			q.AndWhere(dbx.NewExp("username ILIKE {:name}", dbx.Params{"name": storages.ContainsPattern(name)}))
+		}
+		if isAdmin != nil {
+			q.AndWhere(dbx.HashExp{"isadmin": *isAdmin})
+		}
+		if createdFrom != nil {
+			q.AndWhere(dbx.NewExp("created_at >= {:created_from}", dbx.Params{"created_from": *createdFrom}))
+		}
+		if createdTo != nil {
+			q.AndWhere(dbx.NewExp("created_at < {:created_to}", dbx.Params{"created_to": *createdTo}))
+		}
+
+		var users []User
+		err = page.Apply(q, "").All(&users)
+		if err != nil {
+			response.Internal(w, r)
+			return
+		}
+		response.JSON(w, http.StatusOK, storages.NewPage(page, users, func(user User) (string, int) {
+			switch page.Sort {
+			case "name":
+				return user.Username, user.Id
+			case "created":
+				return storages.CursorTime(*user.CreatedAt), user.Id
+			default:
+				return "", user.Id
+			}
+		}))
+	}
+}
+
+// @Summary Получить пользователя
+// @Tags users
+// @Description Возвращает пользователя по идентификатору
+// @id GetUserById
+// @Accept json
+// @Procedure json
+// @router /users/{id} [get]
+// @param id path int true "идентификатор пользователя"
+// @Success 200 {object} User
+// @Failure 404 {object} response.ErrorResponse "пользователь не найден"
+// @Security BasicAuth
+// @Security BearerAuth
+func GetUserById(storage *storages.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := pathUserId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+
+		user, err := findUser(storage.DB, userId)
+		if errors.Is(err, storages.ErrUserNotFound) {
+			response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound)
+			return
+		}
+		if err != nil {
+			response.Internal(w, r)
+			return
+		}
+		response.JSON(w, http.StatusOK, user)
+	}
+}
+
+// @Summary Создать пользователя
+// @Tags users
+// @Description Создает пользователя. userIsAdmin=true назначает роль admin, остальные получают роль player
+// @id PostUser
+// @Accept json
+// @Procedure json
+// @router /users [post]
+// @param input body User true "Информация о пользователе"
+// @Success 201 {object} User
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Failure 409 {object} response.ErrorResponse "пользователь уже существует"
+// @Security BasicAuth
+// @Security BearerAuth
+func PostUser(storage *storages.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user User
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&user)
+		if err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+		var errlist []response.FieldError
+		if !validUsername(user.Username) {
+			errlist = append(errlist, response.Field("username", response.RuleLength, 1, 20))
+		}
+		if user.Password == "" {
+			errlist = append(errlist, response.Field("password", response.RuleRequired))
+		}
+		if len(errlist) > 0 {
+			response.Invalid(w, r, errlist)
+			return
+		}
+
+		user.Id = 0
+		user.Password, err = storages.HashPassword(user.Password)
+		if err != nil {
+			response.Internal(w, r)
+			return
+		}
+		err = storage.DB.Transactional(func(tx *dbx.Tx) error {
+			err := tx.Model(&user).Insert("Username", "Password", "Isadmin")
+			if err != nil {
+				return err
+			}
+			_, err = storages.SetUserRoles(tx, user.Id, storages.DefaultRoles(user.Isadmin))
+			return err
+		})
+		if storages.IsUniqueViolation(err) {
+			response.Error(w, r, http.StatusConflict, response.CodeUserExists)
+			return
+		}
+		if err != nil {
+			response.Internal(w, r)
+			return
+		}
+
+		user.Password = ""
+		w.Header().Set("Location", "/users/"+strconv.Itoa(user.Id))
+		response.JSON(w, http.StatusCreated, user)
+	}
+}
+
+// @Summary Изменить пользователя
+// @Tags users
+// @Description Меняет имя пользователя и/или выдает и забирает роль admin. Роль admin нельзя забрать у последнего администратора
+// @id PatchUser
+// @Accept json
+// @Procedure json
+// @router /users/{id} [patch]
+// @param id path int true "идентификатор пользователя"
+// @param input body UpdateUserRequest true "изменяемые поля"
+// @Success 200 {object} User
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Failure 404 {object} response.ErrorResponse "пользователь не найден"
+// @Failure 409 {object} response.ErrorResponse "имя занято или пользователь последний администратор"
+// @Security BasicAuth
+// @Security BearerAuth
+func PatchUser(storage *storages.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := pathUserId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+		var request UpdateUserRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&request)
+		if err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+		if request.Username != nil && !validUsername(*request.Username) {
+			response.Invalid(w, r, []response.FieldError{response.Field("username", response.RuleLength, 1, 20)})
+			return
+		}
+
+		var user User
+		err = storage.DB.Transactional(func(tx *dbx.Tx) error {
+			if _, err := findUser(tx, userId); err != nil {
+				return err
+			}
+			if request.Username != nil {
+				_, err := tx.Update("users", dbx.Params{"username": *request.Username}, dbx.HashExp{"id": userId}).Execute()
+				if err != nil {
+					return err
+				}
+				//имя пользователя записано в выданные токены
+				if err = storages.RevokeAccessTokens(tx, userId); err != nil {
+					return err
+				}
+			}
+			if request.Isadmin != nil {
+				if err := setAdmin(tx, userId, *request.Isadmin); err != nil {
+					return err
+				}
+			}
+			user, err = findUser(tx, userId)
+			return err
+		})
+		switch {
+		case errors.Is(err, storages.ErrUserNotFound):
+			response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound)
+		case storages.IsUniqueViolation(err):
+			response.Error(w, r, http.StatusConflict, response.CodeUserExists)
+		case errors.Is(err, storages.ErrLastAdmin):
+			response.Error(w, r, http.StatusConflict, response.CodeLastAdmin)
+		case err != nil:
+			response.Internal(w, r)
+		default:
+			response.JSON(w, http.StatusOK, user)
+		}
+	}
+}
+
+// @Summary Удалить пользователя
+// @Tags users
+// @Description Удаляет пользователя. Последнего администратора удалить нельзя
+// @id RemoveUser
+// @Accept json
+// @Procedure json
+// @router /users/{id} [delete]
+// @param id path int true "идентификатор пользователя"
+// @Success 204
+// @Failure 404 {object} response.ErrorResponse "пользователь не найден"
+// @Failure 409 {object} response.ErrorResponse "нельзя удалить последнего администратора"
+// @Security BasicAuth
+// @Security BearerAuth
+func RemoveUser(storage *storages.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := pathUserId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+
+		err := storage.DeleteUser(userId)
+		switch {
+		case errors.Is(err, storages.ErrLastAdmin):
+			response.Error(w, r, http.StatusConflict, response.CodeLastAdmin)
+		case errors.Is(err, storages.ErrUserNotFound):
+			response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound)
+		case err != nil:
+			response.Internal(w, r)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// @Summary Сменить пароль
+// @Tags users
+// @Description Задает пользователю новый пароль и отзывает его refresh токены.
+// @Description Пользователь без разрешения users.write может сменить только свой пароль, указав текущий
+// @id ChangePassword
+// @Accept json
+// @Procedure json
+// @router /users/{id}/password [post]
+// @param id path int true "идентификатор пользователя"
+// @param input body ChangePasswordRequest true "новый пароль"
+// @Success 204
+// @Failure 400 {object} response.ErrorResponse "неверный формат запроса"
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Failure 403 {object} response.ErrorResponse "недостаточно прав или неверный текущий пароль"
+// @Failure 404 {object} response.ErrorResponse "пользователь не найден"
+// @Security BasicAuth
+// @Security BearerAuth
+func ChangePassword(storage *storages.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := pathUserId(r)
+		if !ok {
+			response.InvalidParam(w, r, "id", response.RulePositive)
+			return
+		}
+		principal, ok := storages.PrincipalFromContext(r.Context())
+		if !ok {
+			response.Error(w, r, http.StatusUnauthorized, response.CodeUnauthorized)
+			return
+		}
+		canWrite := principal.HasPermission(storages.PermUsersWrite)
+		if principal.UserId != userId && !canWrite {
+			response.Error(w, r, http.StatusForbidden, response.CodeForbidden)
+			return
+		}
+
+		var request ChangePasswordRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&request)
+		if err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+		if request.Password == "" {
+			response.Invalid(w, r, []response.FieldError{response.Field("password", response.RuleRequired)})
+			return
+		}
+		hashPass, err := storages.HashPassword(request.Password)
+		if err != nil {
+			response.Internal(w, r)
+			return
+		}
+
+		err = storage.DB.Transactional(func(tx *dbx.Tx) error {
+			var user User
+			err := tx.Select().From(user.TableName()).Where(dbx.HashExp{"id": userId}).One(&user)
+			if errors.Is(err, sql.ErrNoRows) {
+				return storages.ErrUserNotFound
+			}
+			if err != nil {
+				return err
+			}
+			if !canWrite {
+				if ok, _ := storages.CheckPassword(user.Password, request.CurrentPassword); !ok {
+					return errWrongPassword
+				}
+			}
+			_, err = tx.Update(user.TableName(), dbx.Params{"password": hashPass}, dbx.HashExp{"id": userId}).Execute()
+			if err != nil {
+				return err
+			}
+			return storages.RevokeRefreshTokens(tx, userId)
+		})
+		switch {
+		case errors.Is(err, storages.ErrUserNotFound):
+			response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound)
+		case errors.Is(err, errWrongPassword):
+			response.Error(w, r, http.StatusForbidden, response.CodeWrongPassword)
+		case err != nil:
+			response.Internal(w, r)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+)
+
+// newUsersMux регистрирует маршруты ресурса пользователей и устаревший /CreateUser так же, как main, но без проверки авторизации
+func newUsersMux(storage *storages.Storage) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users", ListUsers(storage))
+	mux.HandleFunc("POST /users", PostUser(storage))
+	mux.HandleFunc("GET /users/{id}", GetUserById(storage))
+	mux.HandleFunc("PATCH /users/{id}", PatchUser(storage))
+	mux.HandleFunc("DELETE /users/{id}", RemoveUser(storage))
+	mux.HandleFunc("/CreateUser", CreateUser(storage))
+	return mux
+}
+
+func serve(mux *http.ServeMux, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+// errorCode возвращает код ошибки из ответа
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body response.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error response %s: %s", w.Body.String(), err)
+	}
+	return body.Error.Code
+}
+
+func TestUsersResourceRoutes(t *testing.T) {
+	f := newTestFixture(t)
+	mux := newUsersMux(f.storage)
+	taken := f.user(t, "t", "-")
+
+	w := serve(mux, http.MethodPost, "/users", fmt.Sprintf(`{"username":"n%s","password":"s3cret"}`, f.suffix))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /users: status %d, body %s", w.Code, w.Body.String())
+	}
+	var created User
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode user: %s", err)
+	}
+	f.userIds = append(f.userIds, created.Id)
+	location := "/users/" + strconv.Itoa(created.Id)
+	if w.Header().Get("Location") != location || created.Password != "" {
+		t.Fatalf("unexpected created user %+v, location %q", created, w.Header().Get("Location"))
+	}
+	access, err := storages.GetUserAccess(f.storage.DB, created.Id)
+	if err != nil || len(access.Roles) != 1 || access.Roles[0] != storages.RolePlayer {
+		t.Fatalf("roles of created user %v, %v, want [%s]", access.Roles, err, storages.RolePlayer)
+	}
+
+	w = serve(mux, http.MethodGet, location, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"n`+f.suffix+`"`) {
+		t.Fatalf("GET %s: status %d, body %s", location, w.Code, w.Body.String())
+	}
+
+	w = serve(mux, http.MethodPatch, location, fmt.Sprintf(`{"username":"m%s"}`, f.suffix))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"m`+f.suffix+`"`) {
+		t.Fatalf("PATCH %s: status %d, body %s", location, w.Code, w.Body.String())
+	}
+	w = serve(mux, http.MethodPatch, location, fmt.Sprintf(`{"username":"t%s"}`, f.suffix))
+	if w.Code != http.StatusConflict || errorCode(t, w) != response.CodeUserExists {
+		t.Fatalf("PATCH %s to taken name of user %d: status %d, body %s", location, taken, w.Code, w.Body.String())
+	}
+
+	//метод, который не зарегистрирован для ресурса, отклоняет ServeMux
+	if w = serve(mux, http.MethodPut, location, "{}"); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("PUT %s: status %d, want %d", location, w.Code, http.StatusMethodNotAllowed)
+	}
+
+	w = serve(mux, http.MethodDelete, location, "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE %s: status %d, body %s", location, w.Code, w.Body.String())
+	}
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		w = serve(mux, method, location, "")
+		if w.Code != http.StatusNotFound || errorCode(t, w) != response.CodeUserNotFound {
+			t.Fatalf("%s deleted user: status %d, body %s", method, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestUsersResourceValidatesInput(t *testing.T) {
+	f := newTestFixture(t)
+	mux := newUsersMux(f.storage)
+
+	for _, test := range []struct {
+		method, target, body string
+		status               int
+		code                 string
+	}{
+		{http.MethodGet, "/users/abc", "", http.StatusBadRequest, response.CodeInvalidParameter},
+		{http.MethodGet, "/users/0", "", http.StatusBadRequest, response.CodeInvalidParameter},
+		{http.MethodPost, "/users", `{"username":""}`, http.StatusUnprocessableEntity, response.CodeValidationFailed},
+		{http.MethodPost, "/users", `{"username":"x","unknown":1}`, http.StatusBadRequest, response.CodeMalformedRequest},
+		{http.MethodPatch, "/users/-1", `{}`, http.StatusBadRequest, response.CodeInvalidParameter},
+		{http.MethodPatch, "/users/1", `{"username":"` + strings.Repeat("x", 21) + `"}`, http.StatusUnprocessableEntity, response.CodeValidationFailed},
+	} {
+		w := serve(mux, test.method, test.target, test.body)
+		if w.Code != test.status || errorCode(t, w) != test.code {
+			t.Errorf("%s %s %s: status %d, body %s, want %d %s", test.method, test.target, test.body, w.Code, w.Body.String(), test.status, test.code)
+		}
+	}
+}
+
+func TestCreateUserValidatesUsername(t *testing.T) {
+	f := newTestFixture(t)
+
+	//пустое имя и имя длиннее колонки users.username отклоняются до обращения к БД
+	for _, username := range []string{"", strings.Repeat("x", 21)} {
+		w := serve(newUsersMux(f.storage), http.MethodPost, "/CreateUser", fmt.Sprintf(`{"username":%q,"password":"s3cret"}`, username))
+		if w.Code != http.StatusUnprocessableEntity || errorCode(t, w) != response.CodeValidationFailed {
+			t.Errorf("username %q: status %d, body %s", username, w.Code, w.Body.String())
+		}
+	}
+}
@@ -1,20 +1,25 @@
 package users
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	dbx "github.com/go-ozzo/ozzo-dbx"
 	"net/http"
+	"techno-test_quests/quests/response"
 	storages "techno-test_quests/quests/storage"
+	"time"
 )
 
 // User model info
 // @Description User информация о пользователе
 type User struct {
-	Id       int    `json:"id"`          // идентификатор пользователя
-	Username string `json:"username"`    // имя пользователя
-	Password string `json:"password"`    // пароль пользователя
-	Isadmin  bool   `json:"userIsAdmin"` // признак того, что пользователь является администратором
+	Id        int        `json:"id"`                  // идентификатор пользователя
+	Username  string     `json:"username"`            // имя пользователя
+	Password  string     `json:"password,omitempty"`  // пароль пользователя
+	Isadmin   bool       `json:"userIsAdmin"`         // признак того, что пользователь является администратором
+	CreatedAt *time.Time `json:"createdAt,omitempty"` // время создания пользователя
 }
 
 func (u *User) TableName() string {
@@ -25,66 +30,77 @@ type DeleteUserStruct struct {
 	Id int `json:"id"`
 }
 
-func HttpResponse(w http.ResponseWriter, status int, text string) {
-	w.WriteHeader(status)
-	result, _ := json.MarshalIndent(text, "", "\t")
-	w.Write([]byte(result))
-}
-
-func HttpResponseObject(w http.ResponseWriter, status int, text []byte) {
-	w.WriteHeader(status)
-	w.Write(text)
-}
-
-// GetUser Функция возвращает пользователя по логину и паролю
+// GetUser Функция возвращает пользователя по логину и паролю.
+// Если пароль хранится в устаревшем формате, после успешной проверки он перехэшируется.
 func GetUser(userName, password string, storage *storages.Storage) (User, error) {
 	var user User
-	hashPass := storages.EncodePassword(password)
-	err := storage.DB.Select().From("users").Where(dbx.HashExp{"username": userName, "password": hashPass}).One(&user)
+	err := storage.DB.Select().From("users").Where(dbx.HashExp{"username": userName}).One(&user)
 	if err != nil {
-		return user, fmt.Errorf("select script 'getUsers' complete with error: %s", err.Error())
+		storages.CheckDummyPassword(password)
+		return User{}, fmt.Errorf("select script 'getUsers' complete with error: %s", err.Error())
+	}
+
+	ok, needsRehash := storages.CheckPassword(user.Password, password)
+	if !ok {
+		return User{}, errors.New("invalid username or password")
+	}
+
+	if needsRehash {
+		hashPass, err := storages.HashPassword(password)
+		if err == nil {
+			_, err = storage.DB.Update(user.TableName(), dbx.Params{"password": hashPass}, dbx.HashExp{"id": user.Id}).Execute()
+		}
+		if err != nil {
+			return User{}, fmt.Errorf("rehash password complete with error: %s", err.Error())
+		}
+		user.Password = hashPass
 	}
 	return user, nil
 }
 
 // @Summary получить пользователей
 // @Tags user
-// @Description Возвращает всех пользователей приложения
+// @Description Возвращает всех пользователей приложения. Устарел, используйте GET /users
 // @id GetAllUsers
+// @Deprecated
 // @Accept json
 // @Procedure json
 // @router /GetAllUsers [get]
 // @Success 200 {string} string "ok"
 // @Security BasicAuth
+// @Security BearerAuth
 func GetAllUsers(storage *storages.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
 			var users []User
 			//TODO переделать
-			q := storage.DB.Select("id", "username", "isadmin").From("users")
+			q := storage.DB.Select("id", "username", "isadmin", "created_at").From("users")
 			q.All(&users)
 
-			result, _ := json.MarshalIndent(users, "", "\t")
 			if users != nil {
-				HttpResponseObject(w, http.StatusOK, result)
+				response.JSON(w, http.StatusOK, users)
 			} else {
-				HttpResponse(w, http.StatusOK, "Нет пользователей")
+				response.Message(w, r, http.StatusOK, response.MessageNoUsers)
 			}
 		} else {
-			HttpResponse(w, http.StatusMethodNotAllowed, "Метод не поддерживается, используйте метод GET")
+			response.MethodNotAllowed(w, r, http.MethodGet)
 		}
 	}
 }
 
 // @Summary Создать пользователя
 // @Tags user
-// @Description Создает нового пользователя приложения
+// @Description Создает нового пользователя приложения. Устарел, используйте POST /users
 // @id CreateUser
+// @Deprecated
 // @Accept json
 // @Procedure json
 // @param input body User true "Информация о пользователе"
 // @router /CreateUser [post]
+// @Failure 422 {object} response.ErrorResponse "входные данные не прошли проверку"
+// @Failure 409 {object} response.ErrorResponse "Пользователь уже существует"
 // @Security BasicAuth
+// @Security BearerAuth
 func CreateUser(storage *storages.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPost {
@@ -94,62 +110,117 @@ func CreateUser(storage *storages.Storage) http.HandlerFunc {
 			decoder.DisallowUnknownFields()
 			err := decoder.Decode(&user)
 			if err != nil {
-				HttpResponse(w, http.StatusBadRequest, "Неверный формат запроса")
+				response.Error(w, r, http.StatusBadRequest, response.CodeMalformedRequest)
+				return
+			}
+			if !validUsername(user.Username) {
+				response.Invalid(w, r, []response.FieldError{response.Field("username", response.RuleLength, 1, 20)})
 				return
 			}
 
 			//хешируем пароль
-			user.Password = storages.EncodePassword(user.Password)
-
-			//Проверяем существует ли такой пользователь по имени
-			var oldUser User
-			err = storage.DB.Select().From(user.TableName()).Where(dbx.HashExp{"username": user.Username}).One(&oldUser)
-			if err != nil { //ошибка в случае dbx возвращается если значения не нашлись
-				err1 := storage.DB.Model(&user).Insert()
-				if err1 != nil {
-					HttpResponse(w, http.StatusInternalServerError, "Не удалось добавить пользователя")
-				} else {
-					HttpResponse(w, http.StatusOK, "Пользователь успешно добавлен")
+			user.Password, err = storages.HashPassword(user.Password)
+			if err != nil {
+				response.Internal(w, r)
+				return
+			}
+
+			//Уникальность имени обеспечивает ограничение в БД
+			err = storage.DB.Transactional(func(tx *dbx.Tx) error {
+				err := tx.Model(&user).Insert("Username", "Password", "Isadmin")
+				if err != nil {
+					return err
 				}
+				_, err = storages.SetUserRoles(tx, user.Id, storages.DefaultRoles(user.Isadmin))
+				return err
+			})
+			if storages.IsUniqueViolation(err) {
+				response.Error(w, r, http.StatusConflict, response.CodeUserExists)
+			} else if err != nil {
+				response.Internal(w, r)
 			} else {
-				HttpResponse(w, http.StatusInternalServerError, "Пользователь уже существует")
+				response.Message(w, r, http.StatusOK, response.MessageUserCreated)
 			}
 		} else {
-			HttpResponse(w, http.StatusMethodNotAllowed, "Метод не поддерживается, используйте метод POST")
+			response.MethodNotAllowed(w, r, http.MethodPost)
 		}
 	}
 }
 
 // @Summary Удалить пользователя
 // @Tags user
-// @Description Удаляет пользователя приложения
+// @Description Удаляет пользователя приложения. Последнего администратора удалить нельзя. Устарел, используйте DELETE /users/{id}
 // @id DeleteUser
+// @Deprecated
 // @Accept json
 // @Procedure json
 // @param input body DeleteUserStruct true "Идентификатор пользователя"
 // @router /DeleteUser [Delete]
+// @Failure 404 {object} response.ErrorResponse "Пользователь не найден"
+// @Failure 409 {object} response.ErrorResponse "Нельзя удалить последнего администратора"
 // @Security BasicAuth
+// @Security BearerAuth
 func DeleteUser(storage *storages.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		//TODO сделать проверку что хотя бы один администратор должен оставаться.
 		if r.Method == http.MethodDelete {
 			var user DeleteUserStruct
 			decoder := json.NewDecoder(r.Body)
 			decoder.DisallowUnknownFields()
 			err := decoder.Decode(&user)
-			if err != nil {
-				HttpResponse(w, http.StatusBadRequest, "Неверный формат запроса")
+			if err != nil || user.Id <= 0 {
+				response.Error(w, r, http.StatusBadRequest, response.CodeMalformedRequest)
+				return
+			}
+
+			err = storage.DeleteUser(user.Id)
+			switch {
+			case errors.Is(err, storages.ErrLastAdmin):
+				response.Error(w, r, http.StatusConflict, response.CodeLastAdmin)
+			case errors.Is(err, storages.ErrUserNotFound):
+				response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound)
+			case err != nil:
+				response.Internal(w, r)
+			default:
+				response.Message(w, r, http.StatusOK, response.MessageUserDeleted)
+			}
+		} else {
+			response.MethodNotAllowed(w, r, http.MethodDelete)
+		}
+	}
+}
+
+// @Summary Мой профиль
+// @Tags me
+// @Description Возвращает информацию об авторизованном пользователе
+// @id GetMe
+// @Accept json
+// @Procedure json
+// @router /me [get]
+// @Success 200 {object} User
+// @Security BasicAuth
+// @Security BearerAuth
+func GetMe(storage *storages.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			principal, ok := storages.PrincipalFromContext(r.Context())
+			if !ok {
+				response.Error(w, r, http.StatusUnauthorized, response.CodeUnauthorized)
 				return
 			}
 
-			_, err = storage.DB.Delete("users", dbx.HashExp{"id": user.Id}).Execute()
+			var user User
+			err := storage.DB.Select("id", "username", "isadmin", "created_at").From(user.TableName()).Where(dbx.HashExp{"id": principal.UserId}).One(&user)
+			if errors.Is(err, sql.ErrNoRows) {
+				response.Error(w, r, http.StatusNotFound, response.CodeUserNotFound)
+				return
+			}
 			if err != nil {
-				HttpResponse(w, http.StatusInternalServerError, "Ошибка удаления пользователя")
-			} else {
-				HttpResponse(w, http.StatusOK, "Пользователь успешно удален")
+				response.Internal(w, r)
+				return
 			}
+			response.JSON(w, http.StatusOK, user)
 		} else {
-			HttpResponse(w, http.StatusMethodNotAllowed, "Метод не поддерживается, используйте метод DELETE")
+			response.MethodNotAllowed(w, r, http.MethodGet)
 		}
 	}
 }
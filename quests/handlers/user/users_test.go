@@ -0,0 +1,158 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	_ "github.com/lib/pq"
+	"techno-test_quests/quests/response"
+	storages "techno-test_quests/quests/storage"
+)
+
+// Тесты выполняются против тестовой БД, строка подключения задается переменной окружения QUESTS_TEST_DB.
+// Пример: QUESTS_TEST_DB="host=localhost port=5432 user=adminPG password=1Qwerty2$ dbname=quests_test sslmode=disable"
+
+type testFixture struct {
+	storage *storages.Storage
+	suffix  string
+	userIds []int
+}
+
+func newTestFixture(t testing.TB) *testFixture {
+	t.Helper()
+	dsn := os.Getenv("QUESTS_TEST_DB")
+	if dsn == "" {
+		t.Skip("QUESTS_TEST_DB is not set")
+	}
+
+	storage, err := storages.New(dsn)
+	if err != nil {
+		t.Fatalf("connect to test database: %s", err)
+	}
+	if err = storage.Init(); err != nil {
+		t.Fatalf("init test database: %s", err)
+	}
+
+	f := &testFixture{storage: storage, suffix: strconv.FormatInt(time.Now().UnixNano()%1e12, 36)}
+	t.Cleanup(func() {
+		if len(f.userIds) > 0 {
+			ids := make([]interface{}, len(f.userIds))
+			for i, id := range f.userIds {
+				ids[i] = id
+			}
+			storage.DB.Delete("users", dbx.HashExp{"id": ids}).Execute()
+		}
+		storage.DB.Close()
+	})
+	return f
+}
+
+// user добавляет пользователя с ролями roles и сохраненным паролем storedPassword. Имя пользователя - prefix с суффиксом теста
+func (f *testFixture) user(t *testing.T, prefix, storedPassword string, roles ...string) int {
+	t.Helper()
+	var userId int
+	err := f.storage.DB.NewQuery("INSERT INTO users (username, password, isadmin) VALUES ({:username}, {:password}, false) RETURNING id").
+		Bind(dbx.Params{"username": prefix + f.suffix, "password": storedPassword}).Row(&userId)
+	if err != nil {
+		t.Fatalf("insert user: %s", err)
+	}
+	f.userIds = append(f.userIds, userId)
+	if len(roles) > 0 {
+		if _, err = storages.SetUserRoles(f.storage.DB, userId, roles); err != nil {
+			t.Fatalf("set user roles: %s", err)
+		}
+	}
+	return userId
+}
+
+func (f *testFixture) storedPassword(t *testing.T, userId int) string {
+	t.Helper()
+	var password string
+	if err := f.storage.DB.Select("password").From("users").Where(dbx.HashExp{"id": userId}).Row(&password); err != nil {
+		t.Fatalf("select password: %s", err)
+	}
+	return password
+}
+
+// legacyPassword пароль в формате, который хранился до перехода на bcrypt
+func legacyPassword(password string) string {
+	bs := []byte(password + "@1")
+	for i := range bs {
+		bs[i]++
+	}
+	return string(bs)
+}
+
+func TestGetUserRehashesLegacyPassword(t *testing.T) {
+	f := newTestFixture(t)
+	userId := f.user(t, "l", legacyPassword("s3cret"))
+
+	if _, err := GetUser("l"+f.suffix, "wrong", f.storage); err == nil {
+		t.Fatal("wrong password accepted")
+	}
+	if stored := f.storedPassword(t, userId); stored != legacyPassword("s3cret") {
+		t.Fatal("password rehashed after failed login")
+	}
+
+	user, err := GetUser("l"+f.suffix, "s3cret", f.storage)
+	if err != nil {
+		t.Fatalf("GetUser: %s", err)
+	}
+	if user.Id != userId {
+		t.Fatalf("user id %d, want %d", user.Id, userId)
+	}
+	stored := f.storedPassword(t, userId)
+	if !strings.HasPrefix(stored, "bcrypt-v1$") {
+		t.Fatalf("password is not rehashed: %q", stored)
+	}
+	if ok, needsRehash := storages.CheckPassword(stored, "s3cret"); !ok || needsRehash {
+		t.Fatalf("rehashed password: ok=%v needsRehash=%v", ok, needsRehash)
+	}
+
+	//после перехэширования вход по тому же паролю продолжает работать
+	if _, err = GetUser("l"+f.suffix, "s3cret", f.storage); err != nil {
+		t.Fatalf("GetUser after rehash: %s", err)
+	}
+}
+
+func TestGetMe(t *testing.T) {
+	f := newTestFixture(t)
+	userId := f.user(t, "me", "-")
+	getMe := func(principal *storages.Principal) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/me", nil)
+		if principal != nil {
+			r = r.WithContext(storages.WithPrincipal(r.Context(), *principal))
+		}
+		w := httptest.NewRecorder()
+		GetMe(f.storage)(w, r)
+		return w
+	}
+
+	w := getMe(&storages.Principal{UserId: userId})
+	var user User
+	if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /me: status %d, body %s", w.Code, w.Body.String())
+	}
+	if user.Id != userId || user.Username != "me"+f.suffix || user.Password != "" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	if w = getMe(nil); w.Code != http.StatusUnauthorized || errorCode(t, w) != response.CodeUnauthorized {
+		t.Fatalf("GET /me without principal: status %d, body %s", w.Code, w.Body.String())
+	}
+
+	//токен пользователя, удаленного после входа
+	if _, err := f.storage.DB.Delete("users", dbx.HashExp{"id": userId}).Execute(); err != nil {
+		t.Fatalf("delete user: %s", err)
+	}
+	if w = getMe(&storages.Principal{UserId: userId}); w.Code != http.StatusNotFound || errorCode(t, w) != response.CodeUserNotFound {
+		t.Fatalf("GET /me of deleted user: status %d, body %s", w.Code, w.Body.String())
+	}
+}
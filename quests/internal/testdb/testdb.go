@@ -0,0 +1,85 @@
+// Package testdb общие помощники тестов, которые выполняются против тестовой БД.
+// Строка подключения задается переменной окружения QUESTS_TEST_DB, без нее тесты пропускаются.
+// Пример: QUESTS_TEST_DB="host=localhost port=5432 user=adminPG password=1Qwerty2$ dbname=quests_test sslmode=disable"
+package testdb
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	_ "github.com/lib/pq"
+	storages "techno-test_quests/quests/storage"
+)
+
+// DSN возвращает строку подключения к тестовой БД, без нее тест пропускается
+func DSN(t testing.TB) string {
+	t.Helper()
+	dsn := os.Getenv("QUESTS_TEST_DB")
+	if dsn == "" {
+		t.Skip("QUESTS_TEST_DB is not set")
+	}
+	return dsn
+}
+
+// Open подключается к тестовой БД и применяет миграции. Подключение закрывается после остальных функций очистки теста
+func Open(t testing.TB) *storages.Storage {
+	t.Helper()
+	storage, err := storages.New(DSN(t))
+	if err != nil {
+		t.Fatalf("connect to test database: %s", err)
+	}
+	t.Cleanup(func() {
+		if err := storage.DB.Close(); err != nil {
+			t.Errorf("close test database: %s", err)
+		}
+	})
+	if err = storage.Init(); err != nil {
+		t.Fatalf("init test database: %s", err)
+	}
+	return storage
+}
+
+// Suffix возвращает суффикс для уникальных имен строк теста
+func Suffix() string {
+	return strconv.FormatInt(time.Now().UnixNano()%1e12, 36)
+}
+
+// Insert добавляет строку в таблицу table и возвращает ее идентификатор. Строка удаляется по завершении теста.
+// Функции очистки выполняются в обратном порядке, поэтому строки удаляются раньше строк, на которые ссылаются
+func Insert(t testing.TB, storage *storages.Storage, table string, params dbx.Params) int {
+	t.Helper()
+	var id int
+	columns := make([]string, 0, len(params))
+	values := make([]string, 0, len(params))
+	for column := range params {
+		columns = append(columns, column)
+		values = append(values, "{:"+column+"}")
+	}
+	queryText := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id", table, strings.Join(columns, ", "), strings.Join(values, ", "))
+	if err := storage.DB.NewQuery(queryText).Bind(params).Row(&id); err != nil {
+		t.Fatalf("insert into %s: %s", table, err)
+	}
+	Delete(t, storage, table, dbx.HashExp{"id": id})
+	return id
+}
+
+// User добавляет пользователя без прав с именем username и возвращает его идентификатор
+func User(t testing.TB, storage *storages.Storage, username string) int {
+	t.Helper()
+	return Insert(t, storage, "users", dbx.Params{"username": username, "password": "-", "isadmin": false})
+}
+
+// Delete удаляет по завершении теста строки таблицы table, подходящие под условие where. Используется для строк,
+// которые создает проверяемый код: вызов нужно делать после добавления строк, на которые они ссылаются
+func Delete(t testing.TB, storage *storages.Storage, table string, where dbx.Expression) {
+	t.Cleanup(func() {
+		if _, err := storage.DB.Delete(table, where).Execute(); err != nil {
+			t.Errorf("cleanup %s: %s", table, err)
+		}
+	})
+}
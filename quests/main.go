@@ -3,25 +3,42 @@ package main
 import (
 	_ "github.com/lib/pq"
 	"github.com/swaggo/http-swagger"
+	"log/slog"
 	"net/http"
+	"os"
 	"techno-test_quests/quests/config"
+	"techno-test_quests/quests/handlers/badge"
+	"techno-test_quests/quests/handlers/bonus"
 	"techno-test_quests/quests/handlers/history"
+	"techno-test_quests/quests/handlers/leaderboard"
 	"techno-test_quests/quests/handlers/quest"
+	"techno-test_quests/quests/handlers/reward"
+	"techno-test_quests/quests/handlers/role"
+	"techno-test_quests/quests/handlers/team"
 
 	_ "techno-test_quests/quests/docs"
 	"techno-test_quests/quests/handlers/auth"
 	users "techno-test_quests/quests/handlers/user"
 	slogpretty "techno-test_quests/quests/lib"
+	"techno-test_quests/quests/response"
 	storage2 "techno-test_quests/quests/storage"
+	"time"
 )
 
 // @title Задания пользователей API
 // @version 1.0
 // @description Фильмотека
+// @description Ошибки возвращаются в формате response.ErrorResponse: code - машиночитаемый код ошибки (список - в описании модели),
+// @description message - описание ошибки, details - ошибки в полях запроса со своими кодами.
+// @description Язык сообщений (ru или en) выбирается по заголовку Accept-Language, без него используется язык по умолчанию из конфига (language)
 // @host localhost:8080
 // @securitydefinitions.basic BasicAuth
 // @in header
 // @name Authorization
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description Access токен из /auth/login в формате "Bearer <token>"
 func main() {
 	//загружаем конфиг
 	cfg := config.MustLoad()
@@ -30,37 +47,127 @@ func main() {
 	logger := slogpretty.SetupLogger()
 	logger.Info("Logger is start")
 
+	//язык сообщений API по умолчанию
+	if err := response.SetDefaultLanguage(cfg.Language); err != nil {
+		logger.Error("Language is not supported", "error", err.Error())
+		os.Exit(1)
+	}
+
 	db, err := storage2.New(cfg.DbStorage)
 	if err != nil {
-		logger.Error("Database service is not start: ", err.Error())
+		logger.Error("Database service is not start", "error", err.Error())
+		os.Exit(1)
+	}
+	db.Logger = logger
+
+	//подкоманды бинарника
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			if err = runMigrate(db, os.Args[2:]); err != nil {
+				logger.Error("Migrate complete with error", "error", err.Error())
+				os.Exit(1)
+			}
+			logger.Info("Migrate complete")
+		case "reset-admin":
+			if err = runResetAdmin(db, os.Args[2:]); err != nil {
+				logger.Error("Reset admin complete with error", "error", err.Error())
+				os.Exit(1)
+			}
+			logger.Info("Reset admin complete")
+		default:
+			logger.Error("Unknown command", "command", os.Args[1])
+			os.Exit(2)
+		}
 		return
 	}
 
-	//создаем все необходимые таблицы
+	//применяем миграции и создаем администратора
 	err = db.Init()
 	if err != nil {
-		logger.Error("Initialization database complete with error: ", err.Error())
+		logger.Error("Initialization database complete with error", "error", err.Error())
+		os.Exit(1)
 	}
 	logger.Info("Initialization database complete")
 
+	//авторизация
+	authService, err := auth.New(cfg.Auth, db)
+	if err != nil {
+		logger.Error("Auth service is not start", "error", err.Error())
+		os.Exit(1)
+	}
+	go syncRevokedTokens(authService.Tokens(), cfg.Auth.RevokedSyncEvery, logger)
+
 	//роут
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", auth.NonPage)
 	mux.HandleFunc("/swagger/*", httpSwagger.Handler(httpSwagger.URL("http://localhost:8080/swagger/doc.json")))
-	mux.HandleFunc("/GetAllUsers", auth.AdminAuth(users.GetAllUsers(db), db))
-	mux.HandleFunc("/CreateUser", auth.AdminAuth(users.CreateUser(db), db))
-	mux.HandleFunc("/DeleteUser", auth.AdminAuth(users.DeleteUser(db), db))
-	mux.HandleFunc("/CreateQuest", auth.AdminAuth(quest.CreateQuest(db, logger), db))
-	mux.HandleFunc("/CreateQuestSteps", auth.AdminAuth(quest.CreateQuestSteps(db, logger), db))
-	mux.HandleFunc("/CompleteSteps", auth.AdminAuth(history.CompleteSteps(db, logger), db))
-	mux.HandleFunc("/UpdateQuestSteps", auth.AdminAuth(quest.UpdateQuestSteps(db, logger), db))
-	mux.HandleFunc("/GetHistory", auth.AdminAuth(history.GetHistory(db, logger), db))
-	mux.HandleFunc("/GetQuests", auth.AdminAuth(quest.GetQuests(db, logger), db))
+	mux.HandleFunc("/auth/login", authService.Login(logger))
+	mux.HandleFunc("/auth/refresh", authService.Refresh(logger))
+	mux.HandleFunc("/auth/logout", authService.Logout(logger))
+	mux.HandleFunc("GET /users", authService.Require(storage2.PermUsersRead, users.ListUsers(db)))
+	mux.HandleFunc("POST /users", authService.Require(storage2.PermUsersWrite, users.PostUser(db)))
+	mux.HandleFunc("GET /users/{id}", authService.Require(storage2.PermUsersRead, users.GetUserById(db)))
+	mux.HandleFunc("PATCH /users/{id}", authService.Require(storage2.PermUsersWrite, users.PatchUser(db)))
+	mux.HandleFunc("DELETE /users/{id}", authService.Require(storage2.PermUsersWrite, users.RemoveUser(db)))
+	mux.HandleFunc("POST /users/{id}/password", authService.Require(storage2.PermSelf, users.ChangePassword(db)))
+	//устаревшие маршруты, оставлены для совместимости
+	mux.HandleFunc("/GetAllUsers", storage2.DeprecatedRoute("/users", authService.Require(storage2.PermUsersRead, users.GetAllUsers(db))))
+	mux.HandleFunc("/CreateUser", storage2.DeprecatedRoute("/users", authService.Require(storage2.PermUsersWrite, users.CreateUser(db))))
+	mux.HandleFunc("/DeleteUser", storage2.DeprecatedRoute("/users/{id}", authService.Require(storage2.PermUsersWrite, users.DeleteUser(db))))
+	mux.HandleFunc("/CreateQuest", authService.Require(storage2.PermQuestsWrite, quest.CreateQuest(db, logger)))
+	mux.HandleFunc("/CreateQuestSteps", authService.Require(storage2.PermQuestsWrite, quest.CreateQuestSteps(db, logger)))
+	mux.HandleFunc("/CompleteSteps", authService.Require(storage2.PermHistoryWrite, history.CompleteSteps(db, logger)))
+	mux.HandleFunc("/UpdateQuestSteps", authService.Require(storage2.PermQuestsWrite, quest.UpdateQuestSteps(db, logger)))
+	mux.HandleFunc("/GetHistory", authService.Require(storage2.PermHistoryRead, history.GetHistory(db, logger)))
+	mux.HandleFunc("/GetQuests", authService.Require(storage2.PermQuestsRead, quest.GetQuests(db, logger)))
+	mux.HandleFunc("PATCH /quests/{id}", authService.Require(storage2.PermQuestsWrite, quest.UpdateQuest(db, logger)))
+	mux.HandleFunc("DELETE /quests/{id}", authService.Require(storage2.PermQuestsWrite, quest.DeleteQuest(db, logger)))
+	mux.HandleFunc("POST /quests/{id}/archive", authService.Require(storage2.PermQuestsWrite, quest.ArchiveQuest(db, logger)))
+	mux.HandleFunc("PATCH /steps/{id}", authService.Require(storage2.PermQuestsWrite, quest.UpdateStep(db, logger)))
+	mux.HandleFunc("DELETE /steps/{id}", authService.Require(storage2.PermQuestsWrite, quest.DeleteStep(db, logger)))
+	mux.HandleFunc("POST /steps/{id}/archive", authService.Require(storage2.PermQuestsWrite, quest.ArchiveStep(db, logger)))
+	mux.HandleFunc("/GetRoles", authService.Require(storage2.PermRolesManage, role.GetRoles(db, logger)))
+	mux.HandleFunc("/SetUserRoles", authService.Require(storage2.PermRolesManage, role.SetUserRoles(db, logger)))
+	mux.HandleFunc("/me", authService.Require(storage2.PermSelf, users.GetMe(db)))
+	mux.HandleFunc("/me/history", authService.Require(storage2.PermSelf, history.GetMyHistory(db, logger)))
+	mux.HandleFunc("/me/CompleteSteps", authService.Require(storage2.PermSelf, history.CompleteMySteps(db, logger)))
+	mux.HandleFunc("/me/quests", authService.Require(storage2.PermSelf, quest.GetMyQuests(db, logger)))
+	mux.HandleFunc("GET /me/balance", authService.Require(storage2.PermSelf, bonus.GetMyBalance(db, logger)))
+	mux.HandleFunc("GET /me/ledger", authService.Require(storage2.PermSelf, bonus.GetMyLedger(db, logger)))
+	mux.HandleFunc("GET /users/{id}/balance", authService.Require(storage2.PermHistoryRead, bonus.GetUserBalance(db, logger)))
+	mux.HandleFunc("GET /users/{id}/ledger", authService.Require(storage2.PermHistoryRead, bonus.GetUserLedger(db, logger)))
+	mux.HandleFunc("POST /users/{id}/ledger", authService.Require(storage2.PermBonusWrite, bonus.AdjustBalance(db, logger)))
+	mux.HandleFunc("POST /ledger/{id}/reverse", authService.Require(storage2.PermBonusWrite, bonus.ReverseLedgerEntry(db, logger)))
+	mux.HandleFunc("GET /rewards", authService.Require(storage2.PermSelf, reward.ListRewards(db, logger)))
+	mux.HandleFunc("POST /rewards", authService.Require(storage2.PermRewardsManage, reward.CreateReward(db, logger)))
+	mux.HandleFunc("PATCH /rewards/{id}", authService.Require(storage2.PermRewardsManage, reward.UpdateReward(db, logger)))
+	mux.HandleFunc("POST /rewards/{id}/archive", authService.Require(storage2.PermRewardsManage, reward.ArchiveReward(db, logger)))
+	mux.HandleFunc("POST /rewards/{id}/redeem", authService.Require(storage2.PermSelf, reward.RedeemReward(db, logger)))
+	mux.HandleFunc("GET /redemptions", authService.Require(storage2.PermRewardsManage, reward.ListRedemptions(db, logger)))
+	mux.HandleFunc("GET /me/redemptions", authService.Require(storage2.PermSelf, reward.GetMyRedemptions(db, logger)))
+	mux.HandleFunc("POST /redemptions/{id}/fulfill", authService.Require(storage2.PermRewardsManage, reward.FulfillRedemption(db, logger)))
+	mux.HandleFunc("POST /redemptions/{id}/cancel", authService.Require(storage2.PermSelf, reward.CancelRedemption(db, logger)))
+	mux.HandleFunc("GET /leaderboard", authService.Require(storage2.PermSelf, leaderboard.GetLeaderboard(db, logger)))
+	mux.HandleFunc("GET /quests/{id}/leaderboard", authService.Require(storage2.PermSelf, leaderboard.GetQuestLeaderboard(db, logger)))
+	mux.HandleFunc("GET /leaderboard/teams", authService.Require(storage2.PermSelf, leaderboard.GetTeamLeaderboard(db, logger)))
+	mux.HandleFunc("GET /teams", authService.Require(storage2.PermSelf, team.ListTeams(db, logger)))
+	mux.HandleFunc("POST /teams", authService.Require(storage2.PermTeamsManage, team.CreateTeam(db, logger)))
+	mux.HandleFunc("GET /teams/{id}", authService.Require(storage2.PermSelf, team.GetTeam(db, logger)))
+	mux.HandleFunc("DELETE /teams/{id}", authService.Require(storage2.PermTeamsManage, team.DeleteTeam(db, logger)))
+	mux.HandleFunc("POST /teams/{id}/members", authService.Require(storage2.PermTeamsManage, team.AddTeamMember(db, logger)))
+	mux.HandleFunc("DELETE /teams/{id}/members/{user_id}", authService.Require(storage2.PermTeamsManage, team.RemoveTeamMember(db, logger)))
+	mux.HandleFunc("GET /badges", authService.Require(storage2.PermSelf, badge.ListBadges(db, logger)))
+	mux.HandleFunc("POST /badges", authService.Require(storage2.PermBadgesManage, badge.CreateBadge(db, logger)))
+	mux.HandleFunc("POST /badges/{id}/archive", authService.Require(storage2.PermBadgesManage, badge.ArchiveBadge(db, logger)))
+	mux.HandleFunc("POST /badges/dry-run", authService.Require(storage2.PermBadgesManage, badge.DryRunBadge(db, logger)))
+	mux.HandleFunc("GET /users/{id}/badges", authService.Require(storage2.PermHistoryRead, badge.GetUserBadges(db, logger)))
+	mux.HandleFunc("GET /me/badges", authService.Require(storage2.PermSelf, badge.GetMyBadges(db, logger)))
 
 	//запуск сервера
 	server := &http.Server{
 		Addr:         cfg.HttpServer.Address,
-		Handler:      mux,
+		Handler:      response.WithRequestId(mux),
 		ReadTimeout:  cfg.HttpServer.TimeoutRequest,
 		WriteTimeout: cfg.HttpServer.IdleTimeout,
 		IdleTimeout:  cfg.HttpServer.IdleTimeout,
@@ -71,3 +178,15 @@ func main() {
 	}
 
 }
+
+// syncRevokedTokens периодически подтягивает из БД токены, отозванные другими экземплярами сервиса
+func syncRevokedTokens(tokens *auth.Tokens, interval time.Duration, logger *slog.Logger) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	for range time.Tick(interval) {
+		if err := tokens.SyncRevoked(); err != nil {
+			logger.Error("Sync revoked tokens complete with error", "error", err.Error())
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	storage2 "techno-test_quests/quests/storage"
+)
+
+// runMigrate обрабатывает подкоманду migrate up|down|status|to N
+func runMigrate(db *storage2.Storage, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down|status|to N")
+	}
+
+	switch args[0] {
+	case "up":
+		return db.MigrateUp()
+	case "down":
+		return db.MigrateDown()
+	case "to":
+		if len(args) != 2 {
+			return errors.New("usage: migrate to N")
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid migration version %q", args[1])
+		}
+		return db.MigrateTo(version)
+	case "status":
+		statuses, err := db.MigrationsStatus()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, status := range statuses {
+			state, appliedAt := "pending", ""
+			if status.Applied {
+				state, appliedAt = "applied", status.AppliedAt.Format("02-01-2006 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q, usage: migrate up|down|status|to N", args[0])
+	}
+}
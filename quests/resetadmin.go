@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+
+	storage2 "techno-test_quests/quests/storage"
+)
+
+// runResetAdmin обрабатывает подкоманду reset-admin [-username name].
+// Создает администратора или восстанавливает права и пароль существующего пользователя напрямую в БД.
+// Пароль берется из переменной окружения QUESTS_ADMIN_PASSWORD, если она не задана - генерируется и выводится в stdout
+func runResetAdmin(db *storage2.Storage, args []string) error {
+	flags := flag.NewFlagSet("reset-admin", flag.ContinueOnError)
+	username := flags.String("username", "admin", "имя пользователя администратора")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return fmt.Errorf("usage: reset-admin [-username name]")
+	}
+
+	password, generated := os.Getenv("QUESTS_ADMIN_PASSWORD"), false
+	if password == "" {
+		buf := make([]byte, 12)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		password, generated = base64.RawURLEncoding.EncodeToString(buf), true
+	}
+
+	created, err := db.ResetAdmin(*username, password)
+	if err != nil {
+		return err
+	}
+
+	action := "updated"
+	if created {
+		action = "created"
+	}
+	fmt.Printf("administrator %q %s\n", *username, action)
+	if generated {
+		fmt.Printf("password: %s\n", password)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package response
+
+// Коды ошибок API. Код не меняется при изменении текста сообщения, клиенты должны опираться на него.
+// Тексты сообщений на каждом языке - в messages.go
+const (
+	//region общие
+	CodeMalformedRequest = "malformed_request"  //тело или заголовки запроса не удалось разобрать
+	CodeInvalidParameter = "invalid_parameter"  //неверный параметр запроса или пути
+	CodeValidationFailed = "validation_failed"  //входные данные не прошли проверки, подробности в details
+	CodeUnauthorized     = "unauthorized"       //запрос без авторизации или с недействительными учетными данными
+	CodeForbidden        = "forbidden"          //у пользователя нет разрешения на метод
+	CodeNotFound         = "not_found"          //маршрут не существует
+	CodeMethodNotAllowed = "method_not_allowed" //метод не поддерживается маршрутом
+	CodeInternal         = "internal_error"     //внутренняя ошибка сервиса
+	//endregion
+
+	//region авторизация
+	CodeInvalidCredentials  = "invalid_credentials"   //неверный логин или пароль
+	CodeInvalidRefreshToken = "invalid_refresh_token" //refresh токен недействителен
+	CodeWrongPassword       = "wrong_password"        //неверный текущий пароль при смене пароля
+	//endregion
+
+	//region пользователи и роли
+	CodeUserNotFound = "user_not_found" //пользователь не найден
+	CodeUserExists   = "user_exists"    //пользователь с таким именем существует
+	CodeLastAdmin    = "last_admin"     //операция оставит систему без администратора
+	CodeUnknownRoles = "unknown_roles"  //назначаемые роли не существуют
+	//endregion
+
+	//region задания и шаги
+	CodeQuestNotFound       = "quest_not_found"      //задание не найдено
+	CodeQuestExists         = "quest_exists"         //задание с таким именем существует
+	CodeQuestArchived       = "quest_archived"       //задание в архиве
+	CodeQuestHasHistory     = "quest_has_history"    //по заданию есть история выполнения
+	CodeStepNotFound        = "step_not_found"       //шаг не найден
+	CodeStepExists          = "step_exists"          //шаг с таким именем в задании существует
+	CodeStepArchived        = "step_archived"        //шаг или его задание в архиве
+	CodeStepHasHistory      = "step_has_history"     //по шагу есть история выполнения
+	CodeInvalidPrerequisite = "invalid_prerequisite" //предварительное условие не существует или образует цикл
+	CodeStepsRejected       = "steps_rejected"       //шаги не прошли проверки выполнения, подробности в items
+	//endregion
+
+	//region бонусы и награды
+	CodeLedgerEntryNotFound = "ledger_entry_not_found" //запись журнала бонусов не найдена
+	CodeAlreadyReversed     = "already_reversed"       //запись журнала уже отменена или сама является отменой
+	CodeRedemptionEntry     = "redemption_entry"       //списание за награду возвращается отменой заявки
+	CodeInsufficientBonus   = "insufficient_bonus"     //недостаточно бонусов для списания
+	CodeRewardNotFound      = "reward_not_found"       //награда не найдена
+	CodeRewardExists        = "reward_exists"          //награда с таким названием существует
+	CodeRewardArchived      = "reward_archived"        //награда в архиве
+	CodeRewardOutOfStock    = "reward_out_of_stock"    //награды нет в наличии
+	CodeRedemptionNotFound  = "redemption_not_found"   //заявка не найдена
+	CodeRedemptionResolved  = "redemption_resolved"    //заявка уже выполнена или отменена
+	//endregion
+
+	//region команды и значки
+	CodeTeamNotFound       = "team_not_found"        //команда не найдена
+	CodeTeamExists         = "team_exists"           //команда с таким названием существует
+	CodeTeamHasHistory     = "team_has_history"      //у команды есть история выполнения
+	CodeTeamMemberNotFound = "team_member_not_found" //пользователь не состоит в команде
+	CodeAlreadyInTeam      = "already_in_team"       //пользователь уже состоит в команде
+	CodeBadgeNotFound      = "badge_not_found"       //значок не найден
+	CodeBadgeExists        = "badge_exists"          //значок с таким названием существует
+	//endregion
+)
+
+// Коды ошибок в полях и параметрах запроса (details[].code). Аргументы подставляются в текст сообщения
+const (
+	RuleRequired        = "required"         //значение не указано
+	RuleRequiredOneOf   = "required_one_of"  //не указано ни одно из полей, аргументы: имена полей
+	RuleLength          = "length"           //длина строки вне диапазона, аргументы: минимум, максимум
+	RuleMaxLength       = "max_length"       //строка длиннее допустимого, аргумент: максимум
+	RulePositive        = "positive"         //значение должно быть целым числом больше 0
+	RuleNotNegative     = "not_negative"     //значение не может быть меньше 0
+	RuleNotZero         = "not_zero"         //значение не может быть равно 0
+	RuleRange           = "range"            //число вне диапазона, аргументы: минимум, максимум
+	RuleOneOf           = "one_of"           //значение не из списка допустимых, аргумент: допустимые значения через запятую
+	RuleAfter           = "after"            //время должно быть позже другого поля, аргумент: имя поля
+	RuleConflicts       = "conflicts"        //значение противоречит другому полю, аргумент: имя поля
+	RuleOnlyForRule     = "only_for_rule"    //поле указывается только для другого типа правила значка, аргумент: тип правила
+	RuleRequiredFor     = "required_for"     //поле обязательно для типа правила значка, аргумент: тип правила
+	RuleDateTime        = "date_time"        //значение не является датой или временем
+	RuleCursor          = "cursor"           //курсор недействителен или выдан для другой сортировки
+	RuleType            = "type"             //значение json не подходит по типу, аргумент: тип значения
+	RuleSyntax          = "syntax"           //тело запроса не удалось разобрать, аргумент: текст ошибки разбора
+	RuleUnknown         = "unknown"          //значения не существуют, аргумент: значения через запятую
+	RuleRejected        = "rejected"         //значение отклонено, причина - в коде ошибки, аргумент: значение
+	RuleCycle           = "cycle"            //предварительные условия образуют цикл
+	RuleKeyReused       = "key_reused"       //ключ идемпотентности уже использован для другого шага или пользователя
+	RuleQuestUnfinished = "quest_unfinished" //не завершено требуемое задание, аргумент: имя задания
+	RuleStepIncomplete  = "step_incomplete"  //не выполнен требуемый шаг, аргумент: имя шага
+)
+
+// Ключи сообщений об успешном выполнении
+const (
+	MessageSuccess       = "success"        //запрос выполнен
+	MessageNoUsers       = "no_users"       //в системе нет пользователей
+	MessageUserCreated   = "user_created"   //пользователь добавлен
+	MessageUserDeleted   = "user_deleted"   //пользователь удален
+	MessageRolesAssigned = "roles_assigned" //роли назначены
+)
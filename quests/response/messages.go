@@ -0,0 +1,257 @@
+package response
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Языки сообщений API. Язык ответа выбирается по заголовку Accept-Language, без него - язык по умолчанию из конфига
+const (
+	LanguageRu = "ru"
+	LanguageEn = "en"
+)
+
+// defaultLanguage язык сообщений для запросов без подходящего Accept-Language
+var defaultLanguage = LanguageRu
+
+// catalogs тексты сообщений по кодам ошибок, кодам ошибок в полях и ключам сообщений об успешном выполнении
+var catalogs = map[string]map[string]string{
+	LanguageRu: {
+		//region общие
+		CodeMalformedRequest: "Неверный формат запроса",
+		CodeInvalidParameter: "Неверный параметр запроса",
+		CodeValidationFailed: "Входные данные не прошли проверку",
+		CodeUnauthorized:     "Пользователь не авторизован",
+		CodeForbidden:        "Недостаточно прав",
+		CodeNotFound:         "Страница не существует",
+		CodeMethodNotAllowed: "Метод не поддерживается, используйте метод %s",
+		CodeInternal:         "Внутренняя ошибка сервиса",
+		//endregion
+
+		//region авторизация
+		CodeInvalidCredentials:  "Введен неверный логин/пароль",
+		CodeInvalidRefreshToken: "Refresh токен недействителен",
+		CodeWrongPassword:       "Неверный текущий пароль",
+		//endregion
+
+		//region пользователи и роли
+		CodeUserNotFound: "Пользователь не найден",
+		CodeUserExists:   "Пользователь с таким именем уже существует",
+		CodeLastAdmin:    "Нельзя удалить или лишить прав последнего администратора",
+		CodeUnknownRoles: "Роли не существуют",
+		//endregion
+
+		//region задания и шаги
+		CodeQuestNotFound:       "Задание не найдено",
+		CodeQuestExists:         "Задание с таким именем существует",
+		CodeQuestArchived:       "Задание в архиве, изменить его нельзя",
+		CodeQuestHasHistory:     "По заданию есть история выполнения, используйте архивирование",
+		CodeStepNotFound:        "Шаг не найден",
+		CodeStepExists:          "Шаг с таким именем в задании существует",
+		CodeStepArchived:        "Шаг или его задание в архиве, изменить шаг нельзя",
+		CodeStepHasHistory:      "По шагу есть история выполнения, используйте архивирование",
+		CodeInvalidPrerequisite: "Ошибка в предварительных условиях",
+		CodeStepsRejected:       "Шаги не прошли проверки выполнения",
+		//endregion
+
+		//region бонусы и награды
+		CodeLedgerEntryNotFound: "Запись журнала не найдена",
+		CodeAlreadyReversed:     "Запись уже отменена или сама является отменой",
+		CodeRedemptionEntry:     "Списание за награду нельзя отменить, отмените заявку",
+		CodeInsufficientBonus:   "Недостаточно бонусов для списания",
+		CodeRewardNotFound:      "Награда не найдена",
+		CodeRewardExists:        "Награда с таким названием существует",
+		CodeRewardArchived:      "Награда в архиве",
+		CodeRewardOutOfStock:    "Награды нет в наличии",
+		CodeRedemptionNotFound:  "Заявка не найдена",
+		CodeRedemptionResolved:  "Заявка уже выполнена или отменена",
+		//endregion
+
+		//region команды и значки
+		CodeTeamNotFound:       "Команда не найдена",
+		CodeTeamExists:         "Команда с таким названием существует",
+		CodeTeamHasHistory:     "У команды есть история выполнения заданий, удалить ее нельзя",
+		CodeTeamMemberNotFound: "Пользователь не состоит в команде",
+		CodeAlreadyInTeam:      "Пользователь уже состоит в команде",
+		CodeBadgeNotFound:      "Значок не найден",
+		CodeBadgeExists:        "Значок с таким названием существует",
+		//endregion
+
+		//region ошибки в полях
+		RuleRequired:        "Значение не указано",
+		RuleRequiredOneOf:   "Укажите %s или %s",
+		RuleLength:          "Длина должна быть от %d до %d символов",
+		RuleMaxLength:       "Длина не может быть больше %d символов",
+		RulePositive:        "Значение должно быть целым числом больше 0",
+		RuleNotNegative:     "Значение не может быть меньше 0",
+		RuleNotZero:         "Значение не может быть равно 0",
+		RuleRange:           "Значение должно быть целым числом от %d до %d",
+		RuleOneOf:           "Значение может быть одним из: %s",
+		RuleAfter:           "Значение должно быть позже %s",
+		RuleConflicts:       "Значение противоречит %s",
+		RuleOnlyForRule:     "Указывается только для правила %s",
+		RuleRequiredFor:     "Обязательно для правила %s",
+		RuleDateTime:        "Значение должно быть датой (2006-01-02) или временем в формате RFC3339",
+		RuleCursor:          "Курсор недействителен или выдан для другой сортировки",
+		RuleType:            "Значение типа %s не подходит для поля",
+		RuleSyntax:          "Тело запроса не удалось разобрать: %s",
+		RuleUnknown:         "Не существуют: %s",
+		RuleRejected:        "Отклонено значение '%v'",
+		RuleCycle:           "Предварительные условия образуют цикл",
+		RuleKeyReused:       "Ключ идемпотентности уже использован пользователем для другого шага",
+		RuleQuestUnfinished: "Не завершено задание '%s'",
+		RuleStepIncomplete:  "Не выполнен шаг '%s'",
+		//endregion
+
+		//region успешное выполнение
+		MessageSuccess:       "Успешно",
+		MessageNoUsers:       "Нет пользователей",
+		MessageUserCreated:   "Пользователь успешно добавлен",
+		MessageUserDeleted:   "Пользователь успешно удален",
+		MessageRolesAssigned: "Роли успешно назначены",
+		//endregion
+	},
+	LanguageEn: {
+		//region общие
+		CodeMalformedRequest: "Malformed request",
+		CodeInvalidParameter: "Invalid request parameter",
+		CodeValidationFailed: "Input validation failed",
+		CodeUnauthorized:     "User is not authorized",
+		CodeForbidden:        "Insufficient permissions",
+		CodeNotFound:         "Page does not exist",
+		CodeMethodNotAllowed: "Method is not supported, use %s",
+		CodeInternal:         "Internal server error",
+		//endregion
+
+		//region авторизация
+		CodeInvalidCredentials:  "Invalid username or password",
+		CodeInvalidRefreshToken: "Refresh token is invalid",
+		CodeWrongPassword:       "Current password is incorrect",
+		//endregion
+
+		//region пользователи и роли
+		CodeUserNotFound: "User not found",
+		CodeUserExists:   "A user with this name already exists",
+		CodeLastAdmin:    "The last administrator cannot be deleted or lose their permissions",
+		CodeUnknownRoles: "Roles do not exist",
+		//endregion
+
+		//region задания и шаги
+		CodeQuestNotFound:       "Quest not found",
+		CodeQuestExists:         "A quest with this name already exists",
+		CodeQuestArchived:       "The quest is archived and cannot be changed",
+		CodeQuestHasHistory:     "The quest has completion history, archive it instead",
+		CodeStepNotFound:        "Step not found",
+		CodeStepExists:          "A step with this name already exists in the quest",
+		CodeStepArchived:        "The step or its quest is archived, the step cannot be changed",
+		CodeStepHasHistory:      "The step has completion history, archive it instead",
+		CodeInvalidPrerequisite: "Invalid prerequisites",
+		CodeStepsRejected:       "Steps did not pass completion checks",
+		//endregion
+
+		//region бонусы и награды
+		CodeLedgerEntryNotFound: "Ledger entry not found",
+		CodeAlreadyReversed:     "The entry is already reversed or is a reversal itself",
+		CodeRedemptionEntry:     "A redemption debit cannot be reversed, cancel the redemption instead",
+		CodeInsufficientBonus:   "Insufficient bonus balance",
+		CodeRewardNotFound:      "Reward not found",
+		CodeRewardExists:        "A reward with this name already exists",
+		CodeRewardArchived:      "The reward is archived",
+		CodeRewardOutOfStock:    "The reward is out of stock",
+		CodeRedemptionNotFound:  "Redemption not found",
+		CodeRedemptionResolved:  "The redemption is already fulfilled or cancelled",
+		//endregion
+
+		//region команды и значки
+		CodeTeamNotFound:       "Team not found",
+		CodeTeamExists:         "A team with this name already exists",
+		CodeTeamHasHistory:     "The team has quest completion history and cannot be deleted",
+		CodeTeamMemberNotFound: "The user is not a member of the team",
+		CodeAlreadyInTeam:      "The user is already a member of a team",
+		CodeBadgeNotFound:      "Badge not found",
+		CodeBadgeExists:        "A badge with this name already exists",
+		//endregion
+
+		//region ошибки в полях
+		RuleRequired:        "Value is required",
+		RuleRequiredOneOf:   "Specify %s or %s",
+		RuleLength:          "Length must be between %d and %d characters",
+		RuleMaxLength:       "Length must not exceed %d characters",
+		RulePositive:        "Value must be an integer greater than 0",
+		RuleNotNegative:     "Value must not be negative",
+		RuleNotZero:         "Value must not be zero",
+		RuleRange:           "Value must be an integer between %d and %d",
+		RuleOneOf:           "Value must be one of: %s",
+		RuleAfter:           "Value must be later than %s",
+		RuleConflicts:       "Value conflicts with %s",
+		RuleOnlyForRule:     "Allowed only for rule %s",
+		RuleRequiredFor:     "Required for rule %s",
+		RuleDateTime:        "Value must be a date (2006-01-02) or an RFC3339 time",
+		RuleCursor:          "Cursor is invalid or was issued for a different sort order",
+		RuleType:            "A value of type %s is not valid for this field",
+		RuleSyntax:          "Request body could not be parsed: %s",
+		RuleUnknown:         "Do not exist: %s",
+		RuleRejected:        "Value '%v' is rejected",
+		RuleCycle:           "Prerequisites form a cycle",
+		RuleKeyReused:       "The idempotency key is already used by the user for another step",
+		RuleQuestUnfinished: "Quest '%s' is not finished",
+		RuleStepIncomplete:  "Step '%s' is not completed",
+		//endregion
+
+		//region успешное выполнение
+		MessageSuccess:       "Success",
+		MessageNoUsers:       "No users",
+		MessageUserCreated:   "User created",
+		MessageUserDeleted:   "User deleted",
+		MessageRolesAssigned: "Roles assigned",
+		//endregion
+	},
+}
+
+// SetDefaultLanguage задает язык сообщений по умолчанию
+func SetDefaultLanguage(language string) error {
+	if _, ok := catalogs[language]; !ok {
+		return fmt.Errorf("unsupported language %q, use %s or %s", language, LanguageRu, LanguageEn)
+	}
+	defaultLanguage = language
+	return nil
+}
+
+// Language возвращает язык ответа на запрос: поддерживаемый язык с наибольшим весом из Accept-Language
+// (en-US соответствует en), иначе язык по умолчанию
+func Language(r *http.Request) string {
+	language, weight := defaultLanguage, 0.0
+	for _, item := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(item, ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := catalogs[primary]; ok && q > weight {
+			language, weight = primary, q
+		}
+	}
+	return language
+}
+
+// Text возвращает текст сообщения key на языке language. Сообщение, которого нет в каталоге языка,
+// берется из каталога языка по умолчанию, при его отсутствии возвращается сам ключ
+func Text(language, key string, args ...any) string {
+	text, ok := catalogs[language][key]
+	if !ok {
+		text, ok = catalogs[defaultLanguage][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
@@ -0,0 +1,92 @@
+package response
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withDefaultLanguage задает язык по умолчанию на время теста
+func withDefaultLanguage(t *testing.T, language string) {
+	t.Helper()
+	previous := defaultLanguage
+	if err := SetDefaultLanguage(language); err != nil {
+		t.Fatalf("SetDefaultLanguage: %s", err)
+	}
+	t.Cleanup(func() {
+		defaultLanguage = previous
+	})
+}
+
+func TestLanguage(t *testing.T) {
+	for _, test := range []struct {
+		defaultLanguage string
+		header          string
+		want            string
+	}{
+		{LanguageRu, "", LanguageRu},
+		{LanguageEn, "", LanguageEn},
+		{LanguageRu, "en", LanguageEn},
+		{LanguageRu, "en-US", LanguageEn},
+		{LanguageRu, "EN-gb, ru;q=0.9", LanguageEn},
+		{LanguageRu, "de, fr;q=0.9", LanguageRu},
+		{LanguageRu, "de, en;q=0.1", LanguageEn},
+		//побеждает язык с наибольшим весом, порядок в заголовке не важен
+		{LanguageRu, "ru;q=0.5, en;q=0.8", LanguageEn},
+		{LanguageEn, "en;q=0.3, ru;q=0.7", LanguageRu},
+		{LanguageRu, "en;q=0.8, ru", LanguageRu},
+		//элемент с неразбираемым весом пропускается
+		{LanguageRu, "en;q=abc", LanguageRu},
+		{LanguageEn, "ru;q=, en;q=0.2", LanguageEn},
+		//q=0 означает, что язык не подходит
+		{LanguageRu, "en;q=0", LanguageRu},
+		{LanguageEn, "en;q=0, ru;q=0", LanguageEn},
+	} {
+		withDefaultLanguage(t, test.defaultLanguage)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.header != "" {
+			r.Header.Set("Accept-Language", test.header)
+		}
+		if got := Language(r); got != test.want {
+			t.Errorf("default %s, Accept-Language %q: language %s, want %s", test.defaultLanguage, test.header, got, test.want)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	withDefaultLanguage(t, LanguageRu)
+	//сообщение есть только в каталоге языка по умолчанию
+	const onlyDefault = "test_only_default"
+	catalogs[LanguageRu][onlyDefault] = "Только по умолчанию %s"
+	t.Cleanup(func() {
+		delete(catalogs[LanguageRu], onlyDefault)
+	})
+
+	for _, test := range []struct {
+		language string
+		key      string
+		args     []any
+		want     string
+	}{
+		{LanguageEn, CodeNotFound, nil, catalogs[LanguageEn][CodeNotFound]},
+		{LanguageRu, CodeNotFound, nil, catalogs[LanguageRu][CodeNotFound]},
+		{LanguageEn, RuleLength, []any{1, 20}, "Length must be between 1 and 20 characters"},
+		{LanguageRu, RuleMaxLength, []any{200}, "Длина не может быть больше 200 символов"},
+		{"de", RuleMaxLength, []any{200}, "Длина не может быть больше 200 символов"},
+		{LanguageEn, onlyDefault, []any{"en"}, "Только по умолчанию en"},
+		{LanguageEn, "unknown_key", []any{1}, "unknown_key"},
+	} {
+		if got := Text(test.language, test.key, test.args...); got != test.want {
+			t.Errorf("Text(%s, %s, %v) = %q, want %q", test.language, test.key, test.args, got, test.want)
+		}
+	}
+}
+
+func TestSetDefaultLanguageRejectsUnsupported(t *testing.T) {
+	if err := SetDefaultLanguage("de"); err == nil {
+		t.Fatal("unsupported language accepted")
+	}
+	if defaultLanguage == "de" {
+		t.Fatal("unsupported language became default")
+	}
+}
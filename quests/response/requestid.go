@@ -0,0 +1,56 @@
+package response
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIdHeader заголовок с идентификатором запроса. Клиент может передать свой идентификатор, иначе он генерируется
+const RequestIdHeader = "X-Request-Id"
+
+// maxRequestIdLength ограничение длины идентификатора запроса от клиента
+const maxRequestIdLength = 64
+
+type requestIdKey struct{}
+
+// WithRequestId присваивает запросу идентификатор, сохраняет его в контексте и возвращает в заголовке ответа
+func WithRequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get(RequestIdHeader)
+		if !validRequestId(requestId) {
+			requestId = newRequestId()
+		}
+		w.Header().Set(RequestIdHeader, requestId)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIdKey{}, requestId)))
+	})
+}
+
+// RequestId возвращает идентификатор запроса, пустую строку, если запрос прошел не через WithRequestId
+func RequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
+
+// validRequestId проверяет идентификатор от клиента: он попадает в логи и заголовки, поэтому допускаются только
+// латинские буквы, цифры и символы - _ . :
+func validRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLength {
+		return false
+	}
+	for _, c := range requestId {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,157 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Все ошибки API возвращаются в одном формате:
+//
+//	{"error": {"code": "quest_not_found", "message": "Задание не найдено", "details": [...], "request_id": "..."}}
+//
+// code - машиночитаемый код ошибки (см. codes.go), message - описание для человека на языке из Accept-Language,
+// details - ошибки по отдельным полям и параметрам запроса, request_id - идентификатор запроса из заголовка X-Request-Id
+
+// ErrorResponse model info
+// @Description ErrorResponse ошибка API
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"` //Описание ошибки
+}
+
+// ErrorBody model info
+// @Description ErrorBody описание ошибки
+type ErrorBody struct {
+	Code string `json:"code" enums:"malformed_request,invalid_parameter,validation_failed,unauthorized,forbidden,not_found,method_not_allowed,internal_error,invalid_credentials,invalid_refresh_token,wrong_password,user_not_found,user_exists,last_admin,unknown_roles,quest_not_found,quest_exists,quest_archived,quest_has_history,step_not_found,step_exists,step_archived,step_has_history,invalid_prerequisite,steps_rejected,ledger_entry_not_found,already_reversed,insufficient_bonus,reward_not_found,reward_exists,reward_archived,reward_out_of_stock,redemption_not_found,redemption_resolved,team_not_found,team_exists,team_has_history,team_member_not_found,already_in_team,badge_not_found,badge_exists"` //Машиночитаемый код ошибки
+
+	Message   string       `json:"message"`              //Описание ошибки на языке из Accept-Language
+	Details   []FieldError `json:"details,omitempty"`    //Ошибки по полям и параметрам запроса
+	RequestId string       `json:"request_id,omitempty"` //Идентификатор запроса
+}
+
+// FieldError model info
+// @Description FieldError ошибка в поле или параметре запроса
+type FieldError struct {
+	Field string `json:"field,omitempty"` //Поле тела или параметр запроса, отсутствует для ошибок, не относящихся к одному полю
+
+	Code string `json:"code" enums:"required,required_one_of,length,max_length,positive,not_negative,not_zero,range,one_of,after,conflicts,only_for_rule,required_for,date_time,cursor,type,syntax,unknown,rejected,cycle,key_reused,quest_unfinished,step_incomplete"` //Машиночитаемый код ошибки в поле
+
+	Message string `json:"message"` //Описание ошибки на языке из Accept-Language
+
+	args []any
+}
+
+// Field возвращает ошибку в поле field с кодом code. Текст сообщения подставляется при записи ответа на языке запроса
+func Field(field, code string, args ...any) FieldError {
+	return FieldError{Field: field, Code: code, args: args}
+}
+
+// Error возвращает текст ошибки на языке по умолчанию, FieldError можно вернуть как ошибку параметра запроса
+func (e FieldError) Error() string {
+	text := e.Message
+	if text == "" {
+		text = Text(defaultLanguage, e.Code, e.args...)
+	}
+	if e.Field == "" {
+		return text
+	}
+	return e.Field + ": " + text
+}
+
+// Localize возвращает копию ошибок в полях с текстами сообщений на языке запроса. Нужна для ответов,
+// в которые ошибки в полях встраиваются вместе с другими данными
+func Localize(r *http.Request, details []FieldError) []FieldError {
+	if details == nil {
+		return nil
+	}
+	language := Language(r)
+	localized := make([]FieldError, len(details))
+	for i, detail := range details {
+		localized[i] = detail
+		localized[i].Message = Text(language, detail.Code, detail.args...)
+	}
+	return localized
+}
+
+// DetailsOf возвращает ошибку в поле, которую оборачивает err
+func DetailsOf(err error) []FieldError {
+	var detail FieldError
+	if errors.As(err, &detail) {
+		return []FieldError{detail}
+	}
+	return nil
+}
+
+// JSON записывает value в формате json с отступами
+func JSON(w http.ResponseWriter, status int, value any) {
+	result, _ := json.MarshalIndent(value, "", "\t")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(result)
+}
+
+// Message записывает сообщение об успешном выполнении с ключом key в виде json строки на языке запроса
+func Message(w http.ResponseWriter, r *http.Request, status int, key string) {
+	language := Language(r)
+	w.Header().Set("Content-Language", language)
+	JSON(w, status, Text(language, key))
+}
+
+// Error записывает ошибку с кодом code
+func Error(w http.ResponseWriter, r *http.Request, status int, code string, args ...any) {
+	ErrorDetails(w, r, status, code, nil, args...)
+}
+
+// ErrorDetails записывает ошибку с кодом code и ошибками по полям запроса
+func ErrorDetails(w http.ResponseWriter, r *http.Request, status int, code string, details []FieldError, args ...any) {
+	w.Header().Set("Content-Language", Language(r))
+	JSON(w, status, NewError(r, code, details, args...))
+}
+
+// NewError возвращает ошибку для ответов, в которые она встраивается вместе с другими данными.
+// Сообщение берется из каталога по коду, args подставляются в него
+func NewError(r *http.Request, code string, details []FieldError, args ...any) ErrorResponse {
+	return ErrorResponse{Error: ErrorBody{
+		Code:      code,
+		Message:   Text(Language(r), code, args...),
+		Details:   Localize(r, details),
+		RequestId: RequestId(r.Context()),
+	}}
+}
+
+// Invalid отвечает 422 на входные данные, которые разобраны, но не прошли проверки
+func Invalid(w http.ResponseWriter, r *http.Request, details []FieldError) {
+	ErrorDetails(w, r, http.StatusUnprocessableEntity, CodeValidationFailed, details)
+}
+
+// InvalidParam отвечает 400 на неверный параметр запроса или пути
+func InvalidParam(w http.ResponseWriter, r *http.Request, field, code string, args ...any) {
+	ErrorDetails(w, r, http.StatusBadRequest, CodeInvalidParameter, []FieldError{Field(field, code, args...)})
+}
+
+// BadRequest отвечает 400 на тело или параметры запроса, которые не удалось разобрать.
+// Ошибки параметров возвращаются в details с именем параметра
+func BadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	var param FieldError
+	if errors.As(err, &param) {
+		ErrorDetails(w, r, http.StatusBadRequest, CodeInvalidParameter, []FieldError{param})
+		return
+	}
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		ErrorDetails(w, r, http.StatusBadRequest, CodeMalformedRequest, []FieldError{Field(typeError.Field, RuleType, typeError.Value)})
+		return
+	}
+	ErrorDetails(w, r, http.StatusBadRequest, CodeMalformedRequest, []FieldError{Field("", RuleSyntax, err.Error())})
+}
+
+// MethodNotAllowed отвечает 405 для устаревших маршрутов, которые сами проверяют метод запроса
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed string) {
+	w.Header().Set("Allow", allowed)
+	Error(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, allowed)
+}
+
+// Internal отвечает 500. Причина ошибки клиенту не возвращается, ее нужно записать в лог до вызова
+func Internal(w http.ResponseWriter, r *http.Request) {
+	Error(w, r, http.StatusInternalServerError, CodeInternal)
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+var (
+	// ErrLastAdmin операция удалила бы последнего администратора
+	ErrLastAdmin = errors.New("at least one administrator must remain")
+	// ErrUserNotFound пользователь не существует
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// lockAdmins блокирует назначения роли admin до конца транзакции и возвращает идентификаторы администраторов.
+// Блокировка не дает двум параллельным запросам одновременно удалить или разжаловать двух последних администраторов
+func lockAdmins(db dbx.Builder) ([]int, error) {
+	var adminIds []int
+	err := db.NewQuery(`SELECT ur.user_id
+						FROM user_roles AS ur
+						JOIN roles AS r ON r.id = ur.role_id
+						WHERE r.name = {:role}
+						ORDER BY ur.user_id
+						FOR UPDATE OF ur`).Bind(dbx.Params{"role": RoleAdmin}).Column(&adminIds)
+	if err != nil {
+		return nil, fmt.Errorf("lock script 'user_roles' complete with error: %s", err)
+	}
+	return adminIds, nil
+}
+
+// ensureAdminRemains возвращает ErrLastAdmin, если после лишения userId прав администратора не останется ни одного администратора.
+// Вызывается внутри транзакции
+func ensureAdminRemains(db dbx.Builder, userId int) error {
+	adminIds, err := lockAdmins(db)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(adminIds, userId) && len(adminIds) == 1 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// DeleteUser удаляет пользователя в транзакции. Последнего администратора удалить нельзя
+func (storage *Storage) DeleteUser(userId int) error {
+	return storage.DB.Transactional(func(tx *dbx.Tx) error {
+		err := ensureAdminRemains(tx, userId)
+		if err != nil {
+			return err
+		}
+		//refresh токены удаляются вместе с пользователем, поэтому access токены отзываем до удаления
+		if err = RevokeAccessTokens(tx, userId); err != nil {
+			return err
+		}
+		result, err := tx.Delete("users", dbx.HashExp{"id": userId}).Execute()
+		if err != nil {
+			return fmt.Errorf("delete script 'users' complete with error: %s", err)
+		}
+		if deleted, _ := result.RowsAffected(); deleted == 0 {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+}
+
+// ResetAdmin создает пользователя с ролью admin или, если пользователь существует, задает ему новый пароль и добавляет роль admin.
+// Все refresh токены пользователя отзываются. Возвращает true, если пользователь был создан
+func (storage *Storage) ResetAdmin(username, password string) (bool, error) {
+	hashPass, err := HashPassword(password)
+	if err != nil {
+		return false, err
+	}
+
+	created := false
+	err = storage.DB.Transactional(func(tx *dbx.Tx) error {
+		var userId int
+		err := tx.NewQuery(`INSERT INTO users (username, password, isadmin) VALUES ({:username}, {:password}, true)
+							ON CONFLICT (username) DO UPDATE SET password = excluded.password
+							RETURNING id, (xmax = 0) AS created`).
+			Bind(dbx.Params{"username": username, "password": hashPass}).Row(&userId, &created)
+		if err != nil {
+			return fmt.Errorf("upsert script 'users' complete with error: %s", err)
+		}
+
+		access, err := GetUserAccess(tx, userId)
+		if err != nil {
+			return err
+		}
+		roles := access.Roles
+		for _, role := range DefaultRoles(true) {
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+		if _, err = SetUserRoles(tx, userId, roles); err != nil {
+			return err
+		}
+
+		return RevokeRefreshTokens(tx, userId)
+	})
+	return created, err
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	_ "github.com/lib/pq"
+)
+
+// Тесты с БД выполняются против тестовой БД, строка подключения задается переменной окружения QUESTS_TEST_DB.
+// Пример: QUESTS_TEST_DB="host=localhost port=5432 user=adminPG password=1Qwerty2$ dbname=quests_test sslmode=disable"
+
+// errRollback возвращается из транзакции теста, чтобы откатить ее изменения
+var errRollback = errors.New("rollback")
+
+type testFixture struct {
+	storage *Storage
+	adminId int
+	userId  int
+}
+
+func newTestFixture(t testing.TB) *testFixture {
+	t.Helper()
+	dsn := os.Getenv("QUESTS_TEST_DB")
+	if dsn == "" {
+		t.Skip("QUESTS_TEST_DB is not set")
+	}
+
+	storage, err := New(dsn)
+	if err != nil {
+		t.Fatalf("connect to test database: %s", err)
+	}
+	if err = storage.Init(); err != nil {
+		t.Fatalf("init test database: %s", err)
+	}
+
+	suffix := strconv.FormatInt(time.Now().UnixNano()%1e12, 36)
+	f := &testFixture{storage: storage}
+	t.Cleanup(func() {
+		storage.DB.Delete("users", dbx.HashExp{"id": []interface{}{f.adminId, f.userId}}).Execute()
+		storage.DB.Close()
+	})
+	f.adminId = f.addUser(t, "a"+suffix, RoleAdmin)
+	f.userId = f.addUser(t, "u"+suffix, RolePlayer)
+	return f
+}
+
+func (f *testFixture) addUser(t testing.TB, username string, roles ...string) int {
+	t.Helper()
+	var userId int
+	err := f.storage.DB.NewQuery("INSERT INTO users (username, password, isadmin) VALUES ({:username}, '-', false) RETURNING id").
+		Bind(dbx.Params{"username": username}).Row(&userId)
+	if err != nil {
+		t.Fatalf("insert user: %s", err)
+	}
+	if _, err = SetUserRoles(f.storage.DB, userId, roles); err != nil {
+		t.Fatalf("set user roles: %s", err)
+	}
+	return userId
+}
+
+// withOnlyAdmin выполняет check в транзакции, в которой роль admin есть только у администратора фикстуры.
+// Транзакция откатывается
+func (f *testFixture) withOnlyAdmin(t *testing.T, check func(tx *dbx.Tx)) {
+	t.Helper()
+	err := f.storage.DB.Transactional(func(tx *dbx.Tx) error {
+		_, err := tx.NewQuery(`DELETE FROM user_roles
+								WHERE user_id <> {:userid} AND role_id = (SELECT id FROM roles WHERE name = {:role})`).
+			Bind(dbx.Params{"userid": f.adminId, "role": RoleAdmin}).Execute()
+		if err != nil {
+			return err
+		}
+		check(tx)
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("transaction: %s", err)
+	}
+}
+
+func TestEnsureAdminRemainsGuardsLastAdmin(t *testing.T) {
+	f := newTestFixture(t)
+
+	f.withOnlyAdmin(t, func(tx *dbx.Tx) {
+		if err := ensureAdminRemains(tx, f.adminId); !errors.Is(err, ErrLastAdmin) {
+			t.Errorf("last admin: error %v, want %v", err, ErrLastAdmin)
+		}
+		if err := ensureAdminRemains(tx, f.userId); err != nil {
+			t.Errorf("not an admin: %s", err)
+		}
+		if _, err := SetUserRoles(tx, f.adminId, []string{RolePlayer}); !errors.Is(err, ErrLastAdmin) {
+			t.Errorf("demote last admin: error %v, want %v", err, ErrLastAdmin)
+		}
+	})
+
+	f.withOnlyAdmin(t, func(tx *dbx.Tx) {
+		//со вторым администратором первого можно лишить прав
+		if _, err := SetUserRoles(tx, f.userId, []string{RoleAdmin}); err != nil {
+			t.Errorf("promote user: %s", err)
+		}
+		if err := ensureAdminRemains(tx, f.adminId); err != nil {
+			t.Errorf("second admin exists: %s", err)
+		}
+	})
+}
+
+func TestResetAdmin(t *testing.T) {
+	f := newTestFixture(t)
+	username := "r" + strconv.FormatInt(time.Now().UnixNano()%1e12, 36)
+	t.Cleanup(func() {
+		f.storage.DB.Delete("users", dbx.HashExp{"username": username}).Execute()
+	})
+
+	for i, password := range []string{"first-password", "second-password"} {
+		created, err := f.storage.ResetAdmin(username, password)
+		if err != nil {
+			t.Fatalf("ResetAdmin: %s", err)
+		}
+		if created != (i == 0) {
+			t.Fatalf("call %d: created %v", i, created)
+		}
+
+		var user struct {
+			Id       int    `db:"id"`
+			Password string `db:"password"`
+		}
+		if err = f.storage.DB.Select("id", "password").From("users").Where(dbx.HashExp{"username": username}).One(&user); err != nil {
+			t.Fatalf("select user: %s", err)
+		}
+		if ok, _ := CheckPassword(user.Password, password); !ok {
+			t.Fatalf("call %d: password is not set", i)
+		}
+		access, err := GetUserAccess(f.storage.DB, user.Id)
+		if err != nil {
+			t.Fatalf("GetUserAccess: %s", err)
+		}
+		if !slices.Contains(access.Roles, RoleAdmin) {
+			t.Fatalf("call %d: roles %v without %s", i, access.Roles, RoleAdmin)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Типы правил значков, правило проверяется функцией badge_rule_met по истории выполнения
+const (
+	BadgeRuleQuestsCompleted = "quests_completed" //завершено не меньше threshold заданий
+	BadgeRuleStepCompletions = "step_completions" //не меньше threshold выполнений шага step_id или любых шагов
+	BadgeRuleFirstToFinish   = "first_to_finish"  //пользователь первым завершил задание quest_id, командное - выполнив его последний шаг
+)
+
+// UserBadge model info
+// @Description UserBadge значок, выданный пользователю
+type UserBadge struct {
+	BadgeId     int       `json:"badge_id" db:"badge_id"`       //Идентификатор значка
+	Name        string    `json:"name" db:"name"`               //Название значка
+	Description string    `json:"description" db:"description"` //Описание значка
+	AwardedAt   time.Time `json:"awarded_at" db:"awarded_at"`   //Время выдачи
+}
+
+// AwardBadges выдает пользователю действующие значки, правила которых выполнены после записи истории historyId,
+// и возвращает выданные этим вызовом. Значок выдается один раз, значок first_to_finish - только одному пользователю.
+// Вызывается внутри транзакции выполнения шагов
+func AwardBadges(db dbx.Builder, userId, historyId int) ([]UserBadge, error) {
+	params := dbx.Params{"userid": userId, "historyid": historyId}
+
+	//блокируем значки «первым завершил» задания шага, чтобы параллельные завершения не выдали значок двоим
+	_, err := db.NewQuery(`SELECT b.id FROM badges AS b
+							WHERE b.rule_type = 'first_to_finish' AND b.archived_at IS NULL
+								AND b.quest_id = (SELECT s.questid FROM history AS h JOIN queststeps AS s ON s.id = h.stepid WHERE h.id = {:historyid})
+							ORDER BY b.id
+							FOR UPDATE`).Bind(params).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("lock script 'badges' complete with error: %w", err)
+	}
+
+	badges := []UserBadge{}
+	err = db.NewQuery(`WITH awarded AS (
+							INSERT INTO user_badges (user_id, badge_id, history_id)
+							SELECT {:userid}, b.id, {:historyid}
+							FROM badges AS b
+							WHERE b.archived_at IS NULL
+								AND NOT EXISTS (SELECT 1 FROM user_badges AS ub WHERE ub.badge_id = b.id
+									AND (ub.user_id = {:userid} OR b.rule_type = 'first_to_finish'))
+								AND badge_rule_met(b.rule_type, b.threshold, b.quest_id, b.step_id, {:userid})
+							ON CONFLICT (user_id, badge_id) DO NOTHING
+							RETURNING badge_id, awarded_at
+						)
+						SELECT a.badge_id, b.name, b.description, a.awarded_at
+						FROM awarded AS a
+						JOIN badges AS b ON b.id = a.badge_id
+						ORDER BY a.badge_id`).Bind(params).All(&badges)
+	if err != nil {
+		return nil, fmt.Errorf("insert script 'user_badges' complete with error: %w", err)
+	}
+	return badges, nil
+}
+
+// UserBadges возвращает значки пользователя в порядке выдачи, включая архивные
+func UserBadges(db dbx.Builder, userId int) ([]UserBadge, error) {
+	badges := []UserBadge{}
+	err := db.NewQuery(`SELECT ub.badge_id, b.name, b.description, ub.awarded_at
+						FROM user_badges AS ub
+						JOIN badges AS b ON b.id = ub.badge_id
+						WHERE ub.user_id = {:userid}
+						ORDER BY ub.awarded_at, ub.badge_id`).Bind(dbx.Params{"userid": userId}).All(&badges)
+	if err != nil {
+		return nil, fmt.Errorf("select script 'user_badges' complete with error: %w", err)
+	}
+	return badges, nil
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Бонусный счет пользователя ведется в журнале bonus_ledger. Записи журнала не изменяются и не удаляются:
+// ошибочное начисление или списание компенсируется записью reversal с противоположной суммой.
+// Баланс пользователя - сумма всех его записей
+
+// Виды записей журнала бонусов
+const (
+	LedgerKindStep       = "step"       //начисление за выполнение шага
+	LedgerKindQuest      = "quest"      //начисление за завершение задания
+	LedgerKindRedemption = "redemption" //списание за получение награды
+	LedgerKindAdjustment = "adjustment" //ручная корректировка
+	LedgerKindReversal   = "reversal"   //отмена другой записи
+)
+
+var (
+	// ErrInsufficientBonus на счете пользователя недостаточно бонусов для списания
+	ErrInsufficientBonus = errors.New("insufficient bonus balance")
+	// ErrLedgerEntryNotFound запись журнала бонусов не существует
+	ErrLedgerEntryNotFound = errors.New("ledger entry not found")
+	// ErrAlreadyReversed запись журнала уже отменена или сама является отменой
+	ErrAlreadyReversed = errors.New("ledger entry already reversed")
+	// ErrRedemptionEntry списание за награду возвращается только отменой заявки
+	ErrRedemptionEntry = errors.New("redemption ledger entry is refunded by cancelling the redemption")
+)
+
+// LedgerEntry model info
+// @Description LedgerEntry запись журнала бонусов: положительная сумма - начисление, отрицательная - списание
+type LedgerEntry struct {
+	Id         int       `json:"id" db:"id"`                             //Идентификатор записи
+	UserId     int       `json:"user_id" db:"user_id"`                   //Пользователь, счет которого изменяется
+	Amount     int       `json:"amount" db:"amount"`                     //Сумма: больше 0 - начисление, меньше 0 - списание
+	Kind       string    `json:"kind" db:"kind"`                         //Вид записи: step, quest, redemption, adjustment или reversal
+	HistoryId  *int      `json:"history_id,omitempty" db:"history_id"`   //Запись истории выполнения шага
+	QuestId    *int      `json:"quest_id,omitempty" db:"quest_id"`       //Завершенное задание
+	TeamId     *int      `json:"team_id,omitempty" db:"team_id"`         //Команда пользователя на момент начисления
+	ReversesId *int      `json:"reverses_id,omitempty" db:"reverses_id"` //Запись, которую отменяет эта запись
+	ReversedBy *int      `json:"reversed_by,omitempty" db:"reversed_by"` //Запись, которой отменена эта запись
+	Comment    string    `json:"comment" db:"comment"`                   //Комментарий
+	CreatedBy  *int      `json:"created_by" db:"created_by"`             //Пользователь, создавший запись, пусто для автоматических записей
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`             //Время создания записи
+}
+
+func (entry *LedgerEntry) TableName() string {
+	return "bonus_ledger"
+}
+
+// LedgerColumns колонки выборки записей журнала с псевдонимом alias, включая идентификатор отменяющей записи
+func LedgerColumns(alias string) []string {
+	prefix := columnPrefix(alias)
+	columns := []string{"id", "user_id", "amount", "kind", "history_id", "quest_id", "team_id", "reverses_id", "comment", "created_by", "created_at"}
+	for i, column := range columns {
+		columns[i] = prefix + column
+	}
+	return append(columns, "(SELECT r.id FROM bonus_ledger AS r WHERE r.reverses_id = "+prefix+"id) AS reversed_by")
+}
+
+// AddLedgerEntry добавляет запись в журнал бонусов и заполняет ее идентификатор и время создания.
+// Баланс не проверяется, для списаний используется Debit
+func AddLedgerEntry(db dbx.Builder, entry *LedgerEntry) error {
+	err := db.NewQuery(`INSERT INTO bonus_ledger (user_id, amount, kind, history_id, quest_id, team_id, reverses_id, comment, created_by)
+						VALUES ({:user_id}, {:amount}, {:kind}, {:history_id}, {:quest_id}, {:team_id}, {:reverses_id}, {:comment}, {:created_by})
+						RETURNING id, created_at`).
+		Bind(dbx.Params{
+			"user_id":     entry.UserId,
+			"amount":      entry.Amount,
+			"kind":        entry.Kind,
+			"history_id":  entry.HistoryId,
+			"quest_id":    entry.QuestId,
+			"team_id":     entry.TeamId,
+			"reverses_id": entry.ReversesId,
+			"comment":     entry.Comment,
+			"created_by":  entry.CreatedBy,
+		}).Row(&entry.Id, &entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert script 'bonus_ledger' complete with error: %w", err)
+	}
+	return nil
+}
+
+// CreditStepBonus начисляет бонус шага за запись истории historyId по бонусу шага на момент вызова.
+// Шаги без бонуса не создают записей в журнале. Вызывается в транзакции, записавшей выполнение шага
+func CreditStepBonus(db dbx.Builder, historyId int) error {
+	_, err := db.NewQuery(`INSERT INTO bonus_ledger (user_id, amount, kind, history_id, team_id, created_by)
+							SELECT h.userid, s.bonus, {:kind}, h.id, h.team_id, h.recorded_by
+							FROM history AS h
+							JOIN queststeps AS s ON s.id = h.stepid
+							WHERE h.id = {:id} AND coalesce(s.bonus, 0) <> 0`).
+		Bind(dbx.Params{"id": historyId, "kind": LedgerKindStep}).Execute()
+	if err != nil {
+		return fmt.Errorf("insert script 'bonus_ledger' complete with error: %w", err)
+	}
+	return nil
+}
+
+// Balance возвращает бонусный баланс пользователя
+func Balance(db dbx.Builder, userId int) (int, error) {
+	var balance int
+	err := db.NewQuery("SELECT coalesce(sum(amount), 0) FROM bonus_ledger WHERE user_id = {:userid}").
+		Bind(dbx.Params{"userid": userId}).Row(&balance)
+	if err != nil {
+		return 0, fmt.Errorf("select script 'bonus_ledger' complete with error: %w", err)
+	}
+	return balance, nil
+}
+
+// lockBalance блокирует строку пользователя до конца транзакции и возвращает его баланс.
+// Блокировка не дает параллельным списаниям вместе превысить баланс
+func lockBalance(db dbx.Builder, userId int) (int, error) {
+	var id int
+	err := db.NewQuery("SELECT id FROM users WHERE id = {:userid} FOR UPDATE").
+		Bind(dbx.Params{"userid": userId}).Row(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrUserNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("lock script 'users' complete with error: %w", err)
+	}
+	return Balance(db, userId)
+}
+
+// Debit списывает -entry.Amount бонусов со счета пользователя. Если баланса не хватает, возвращает ErrInsufficientBonus.
+// Вызывается внутри транзакции
+func Debit(db dbx.Builder, entry *LedgerEntry) error {
+	if entry.Amount >= 0 {
+		return fmt.Errorf("debit amount must be negative, got %d", entry.Amount)
+	}
+	balance, err := lockBalance(db, entry.UserId)
+	if err != nil {
+		return err
+	}
+	if balance+entry.Amount < 0 {
+		return ErrInsufficientBonus
+	}
+	return AddLedgerEntry(db, entry)
+}
+
+// ReverseLedgerEntry добавляет запись, отменяющую запись id, и возвращает ее. Отмена начисления может сделать баланс
+// отрицательным, если бонусы уже потрачены. Запись отменяется не больше одного раза, отмену отменить нельзя.
+// Списание за награду не отменяется: бонусы возвращает отмена заявки (RefundRedemption), иначе заявка останется в
+// статусе pending с возвращенными бонусами. Вызывается внутри транзакции
+func ReverseLedgerEntry(db dbx.Builder, id int, createdBy *int, comment string) (LedgerEntry, error) {
+	original, err := findLedgerEntry(db, id)
+	if err != nil {
+		return original, err
+	}
+	if original.Kind == LedgerKindRedemption {
+		return original, ErrRedemptionEntry
+	}
+	return reverse(db, original, createdBy, comment)
+}
+
+// RefundRedemption возвращает бонусы, списанные за награду записью debitId, и возвращает отменяющую запись.
+// Вызывается внутри транзакции отмены заявки
+func RefundRedemption(db dbx.Builder, debitId int, createdBy *int, comment string) (LedgerEntry, error) {
+	original, err := findLedgerEntry(db, debitId)
+	if err != nil {
+		return original, err
+	}
+	if original.Kind != LedgerKindRedemption {
+		return original, fmt.Errorf("ledger entry %d is %s, not a redemption", debitId, original.Kind)
+	}
+	return reverse(db, original, createdBy, comment)
+}
+
+// findLedgerEntry возвращает запись журнала id
+func findLedgerEntry(db dbx.Builder, id int) (LedgerEntry, error) {
+	var entry LedgerEntry
+	err := db.Select(LedgerColumns("")...).From(entry.TableName()).
+		Where(dbx.HashExp{"id": id}).One(&entry)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entry, ErrLedgerEntryNotFound
+	}
+	if err != nil {
+		return entry, fmt.Errorf("select script 'bonus_ledger' complete with error: %w", err)
+	}
+	return entry, nil
+}
+
+// reverse добавляет запись, отменяющую запись original
+func reverse(db dbx.Builder, original LedgerEntry, createdBy *int, comment string) (LedgerEntry, error) {
+	if original.Kind == LedgerKindReversal || original.ReversedBy != nil {
+		return original, ErrAlreadyReversed
+	}
+	if _, err := lockBalance(db, original.UserId); err != nil {
+		return original, err
+	}
+
+	reversal := LedgerEntry{
+		UserId:     original.UserId,
+		Amount:     -original.Amount,
+		Kind:       LedgerKindReversal,
+		HistoryId:  original.HistoryId,
+		QuestId:    original.QuestId,
+		TeamId:     original.TeamId,
+		ReversesId: &original.Id,
+		Comment:    comment,
+		CreatedBy:  createdBy,
+	}
+	err := AddLedgerEntry(db, &reversal)
+	if IsUniqueViolation(err) {
+		return reversal, ErrAlreadyReversed
+	}
+	return reversal, err
+}
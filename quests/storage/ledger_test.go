@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// ledgerEntryExists проверяет, что запись журнала бонусов id есть в БД
+func (f *testFixture) ledgerEntryExists(t *testing.T, id int) bool {
+	t.Helper()
+	var exists bool
+	err := f.storage.DB.NewQuery("SELECT EXISTS (SELECT 1 FROM bonus_ledger WHERE id = {:id})").Bind(dbx.Params{"id": id}).Row(&exists)
+	if err != nil {
+		t.Fatalf("select ledger entry: %s", err)
+	}
+	return exists
+}
+
+func TestLedgerEntryIsDeletedOnlyByCascade(t *testing.T) {
+	f := newTestFixture(t)
+	userId := f.addUser(t, "l"+strconv.FormatInt(time.Now().UnixNano()%1e12, 36), RolePlayer)
+	t.Cleanup(func() {
+		f.storage.DB.Delete("users", dbx.HashExp{"id": userId}).Execute()
+	})
+	entry := LedgerEntry{UserId: userId, Amount: 10, Kind: LedgerKindAdjustment}
+	if err := AddLedgerEntry(f.storage.DB, &entry); err != nil {
+		t.Fatalf("AddLedgerEntry: %s", err)
+	}
+
+	if _, err := f.storage.DB.Delete("bonus_ledger", dbx.HashExp{"id": entry.Id}).Execute(); err == nil {
+		t.Fatal("ledger entry was deleted directly")
+	}
+	if !f.ledgerEntryExists(t, entry.Id) {
+		t.Fatal("ledger entry disappeared after rejected delete")
+	}
+
+	//записи пользователя удаляются вместе с ним
+	if _, err := f.storage.DB.Delete("users", dbx.HashExp{"id": userId}).Execute(); err != nil {
+		t.Fatalf("delete user: %s", err)
+	}
+	if f.ledgerEntryExists(t, entry.Id) {
+		t.Fatal("ledger entry was not deleted with its user")
+	}
+}
+
+func TestReverseLedgerEntryRejectsRedemption(t *testing.T) {
+	f := newTestFixture(t)
+	add := func(amount int, kind string) LedgerEntry {
+		t.Helper()
+		entry := LedgerEntry{UserId: f.userId, Amount: amount, Kind: kind}
+		if err := AddLedgerEntry(f.storage.DB, &entry); err != nil {
+			t.Fatalf("AddLedgerEntry: %s", err)
+		}
+		return entry
+	}
+	adjustment := add(10, LedgerKindAdjustment)
+	debit := add(-5, LedgerKindRedemption)
+
+	//списание за награду возвращается только отменой заявки
+	if _, err := ReverseLedgerEntry(f.storage.DB, debit.Id, nil, "test"); !errors.Is(err, ErrRedemptionEntry) {
+		t.Fatalf("reverse redemption: error %v, want %v", err, ErrRedemptionEntry)
+	}
+	if _, err := RefundRedemption(f.storage.DB, adjustment.Id, nil, "test"); err == nil {
+		t.Fatal("RefundRedemption reversed an adjustment")
+	}
+	if refund, err := RefundRedemption(f.storage.DB, debit.Id, nil, "test"); err != nil || refund.Amount != 5 {
+		t.Fatalf("RefundRedemption = %+v, %v, want amount 5", refund, err)
+	}
+	if reversal, err := ReverseLedgerEntry(f.storage.DB, adjustment.Id, nil, "test"); err != nil || reversal.Amount != -10 {
+		t.Fatalf("reverse adjustment = %+v, %v, want amount -10", reversal, err)
+	}
+}
@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationLockKey ключ advisory lock, под которым выполняются миграции, чтобы несколько
+// одновременно запущенных экземпляров не применяли их параллельно
+const migrationLockKey = 727100542
+
+// Migration описывает одну версию схемы БД
+type Migration struct {
+	Version int    // номер миграции, берется из префикса имени файла
+	Name    string // имя миграции без номера и суффикса
+	Up      string // SQL применения
+	Down    string // SQL отката
+}
+
+// MigrationStatus состояние миграции в БД
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// LoadMigrations читает встроенные в бинарник миграции. Имена файлов: NNNN_name.up.sql и NNNN_name.down.sql
+func LoadMigrations() ([]Migration, error) {
+	files, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		base := path.Base(file)
+		name, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: file name must end with .up.sql or .down.sql", base)
+		}
+		number, title, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: file name must start with version number", base)
+		}
+
+		body, err := migrationsFS.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, inMap := byVersion[version]
+		if !inMap {
+			migration = &Migration{Version: version, Name: title}
+			byVersion[version] = migration
+		}
+		if direction == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s: up script is missing", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrateUp применяет все неприменённые миграции
+func (storage *Storage) MigrateUp() error {
+	return storage.migrate(func(migrations []Migration, current int) int {
+		if len(migrations) == 0 {
+			return current
+		}
+		return migrations[len(migrations)-1].Version
+	})
+}
+
+// MigrateDown откатывает последнюю примененную миграцию
+func (storage *Storage) MigrateDown() error {
+	return storage.migrate(func(migrations []Migration, current int) int {
+		target := 0
+		for _, migration := range migrations {
+			if migration.Version < current {
+				target = migration.Version
+			}
+		}
+		return target
+	})
+}
+
+// MigrateTo применяет или откатывает миграции до версии version включительно. 0 - откат всех миграций
+func (storage *Storage) MigrateTo(version int) error {
+	return storage.migrate(func(migrations []Migration, current int) int {
+		return version
+	})
+}
+
+// MigrationsStatus возвращает список миграций с признаком применения
+func (storage *Storage) MigrationsStatus() ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	conn, err := storage.DB.DB().Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err = ensureMigrationsTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		appliedAt, ok := applied[migration.Version]
+		result = append(result, MigrationStatus{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+	}
+	return result, nil
+}
+
+// migrate под advisory lock приводит схему к версии, которую вернет target
+func (storage *Storage) migrate(target func(migrations []Migration, current int) int) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+
+	//блокировка держится на соединении, поэтому все запросы выполняем через одно соединение
+	ctx := context.Background()
+	conn, err := storage.DB.DB().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("acquire migration lock complete with error: %s", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+
+	//сообщения RAISE NOTICE из скриптов (например, число строк, удаленных при очистке данных) пишем в журнал.
+	//Соединение после миграций возвращается в пул, поэтому обработчик снимаем
+	logger := storage.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	setNoticeHandler(conn, func(notice *pq.Error) {
+		logger.Warn("Migration notice", "message", notice.Message)
+	})
+	defer setNoticeHandler(conn, nil)
+
+	if err = ensureMigrationsTable(ctx, conn); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	current := 0
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+
+	version := target(migrations, current)
+	if version < 0 || (version > 0 && !hasMigration(migrations, version)) {
+		return fmt.Errorf("migration %d does not exist", version)
+	}
+
+	//применяем недостающие миграции по возрастанию
+	for _, migration := range migrations {
+		if migration.Version > version {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err = runMigration(ctx, conn, migration.Up,
+			"INSERT INTO schema_migrations(version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s up complete with error: %s", migration.Version, migration.Name, err)
+		}
+	}
+
+	//откатываем лишние миграции по убыванию
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if migration.Version <= version {
+			break
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == "" {
+			return fmt.Errorf("migration %04d_%s has no down script", migration.Version, migration.Name)
+		}
+		err = runMigration(ctx, conn, migration.Down,
+			"DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s down complete with error: %s", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// runMigration выполняет скрипт миграции и запись в schema_migrations в одной транзакции
+func runMigration(ctx context.Context, conn *sql.Conn, script, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// setNoticeHandler устанавливает обработчик сообщений сервера на соединение, nil - снимает его
+func setNoticeHandler(conn *sql.Conn, handler func(*pq.Error)) {
+	conn.Raw(func(driverConn interface{}) error {
+		pq.SetNoticeHandler(driverConn.(driver.Conn), handler)
+		return nil
+	})
+}
+
+func ensureMigrationsTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+								version integer PRIMARY KEY,
+								name varchar(200) NOT NULL,
+								applied_at timestamptz NOT NULL DEFAULT now()
+								)`)
+	if err != nil {
+		return fmt.Errorf("create table 'schema_migrations' complete with error: %s", err)
+	}
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("select script 'schema_migrations' complete with error: %s", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func hasMigration(migrations []Migration, version int) bool {
+	for _, migration := range migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
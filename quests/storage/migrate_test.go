@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatalf("LoadMigrations: %s", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations")
+	}
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Fatalf("migration %d has version %d, versions must go in a row from 1", i, migration.Version)
+		}
+		if migration.Name == "" || strings.TrimSpace(migration.Up) == "" || strings.TrimSpace(migration.Down) == "" {
+			t.Fatalf("migration %04d_%s must have name, up and down scripts", migration.Version, migration.Name)
+		}
+	}
+}
+
+// newMigrateFixture возвращает хранилище, все подключения которого работают в отдельной пустой схеме.
+// Схема удаляется по завершении теста
+func newMigrateFixture(t *testing.T) *Storage {
+	t.Helper()
+	dsn := os.Getenv("QUESTS_TEST_DB")
+	if dsn == "" {
+		t.Skip("QUESTS_TEST_DB is not set")
+	}
+
+	base, err := New(dsn)
+	if err != nil {
+		t.Fatalf("connect to test database: %s", err)
+	}
+	schema := "migrate_test_" + strconv.FormatInt(time.Now().UnixNano()%1e12, 36)
+	if _, err = base.DB.NewQuery("CREATE SCHEMA " + schema).Execute(); err != nil {
+		t.Fatalf("create schema: %s", err)
+	}
+	t.Cleanup(func() {
+		if _, err := base.DB.NewQuery("DROP SCHEMA " + schema + " CASCADE").Execute(); err != nil {
+			t.Errorf("drop schema %s: %s", schema, err)
+		}
+		base.DB.Close()
+	})
+
+	//неизвестные драйверу параметры строки подключения передаются серверу как параметры сеанса
+	separator := " "
+	if strings.Contains(dsn, "://") {
+		separator = "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+	}
+	storage, err := New(dsn + separator + "search_path=" + schema)
+	if err != nil {
+		t.Fatalf("connect to test schema: %s", err)
+	}
+	t.Cleanup(func() {
+		storage.DB.Close()
+	})
+	return storage
+}
+
+// appliedVersions возвращает номера примененных миграций через запятую
+func appliedVersions(t *testing.T, storage *Storage) string {
+	t.Helper()
+	statuses, err := storage.MigrationsStatus()
+	if err != nil {
+		t.Fatalf("MigrationsStatus: %s", err)
+	}
+	var versions []string
+	for _, status := range statuses {
+		if status.Applied {
+			versions = append(versions, strconv.Itoa(status.Version))
+		}
+	}
+	return strings.Join(versions, ",")
+}
+
+// versionsUpTo возвращает номера миграций с 1 по version через запятую
+func versionsUpTo(version int) string {
+	versions := make([]string, 0, version)
+	for i := 1; i <= version; i++ {
+		versions = append(versions, strconv.Itoa(i))
+	}
+	return strings.Join(versions, ",")
+}
+
+func TestMigrateRoundTrip(t *testing.T) {
+	storage := newMigrateFixture(t)
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatalf("LoadMigrations: %s", err)
+	}
+	last := migrations[len(migrations)-1].Version
+
+	//применение, откат всех миграций и повторное применение должны проходить на пустой схеме
+	for i, test := range []struct {
+		name    string
+		migrate func() error
+		want    string
+	}{
+		{"up", storage.MigrateUp, versionsUpTo(last)},
+		{"down", storage.MigrateDown, versionsUpTo(last - 1)},
+		{"to 2", func() error { return storage.MigrateTo(2) }, versionsUpTo(2)},
+		{"to 0", func() error { return storage.MigrateTo(0) }, ""},
+		{"up again", storage.MigrateUp, versionsUpTo(last)},
+		{"up twice", storage.MigrateUp, versionsUpTo(last)},
+	} {
+		if err := test.migrate(); err != nil {
+			t.Fatalf("step %d %s: %s", i, test.name, err)
+		}
+		if got := appliedVersions(t, storage); got != test.want {
+			t.Fatalf("step %d %s: applied %q, want %q", i, test.name, got, test.want)
+		}
+	}
+
+	if err = storage.MigrateTo(last + 1); err == nil {
+		t.Fatalf("MigrateTo(%d) did not fail on missing migration", last+1)
+	}
+	if err = storage.Init(); err != nil {
+		t.Fatalf("Init after round trip: %s", err)
+	}
+}
+
+func TestMigrateWaitsForLock(t *testing.T) {
+	storage := newMigrateFixture(t)
+	ctx := context.Background()
+	conn, err := storage.DB.DB().Conn(ctx)
+	if err != nil {
+		t.Fatalf("get connection: %s", err)
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		t.Fatalf("acquire migration lock: %s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- storage.MigrateUp()
+	}()
+	select {
+	case err = <-done:
+		t.Fatalf("MigrateUp completed while lock is held, error: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey); err != nil {
+		t.Fatalf("release migration lock: %s", err)
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("MigrateUp: %s", err)
+		}
+	case <-time.After(time.Minute):
+		t.Fatal("MigrateUp did not complete after lock release")
+	}
+}
+
+func TestRunMigrationRollsBackOnError(t *testing.T) {
+	storage := newMigrateFixture(t)
+	ctx := context.Background()
+	conn, err := storage.DB.DB().Conn(ctx)
+	if err != nil {
+		t.Fatalf("get connection: %s", err)
+	}
+	defer conn.Close()
+	if err = ensureMigrationsTable(ctx, conn); err != nil {
+		t.Fatalf("ensureMigrationsTable: %s", err)
+	}
+
+	//скрипт падает после создания таблицы: ни таблица, ни запись о миграции не должны остаться
+	script := "CREATE TABLE broken_migration (id integer); SELECT 1 / 0;"
+	err = runMigration(ctx, conn, script, "INSERT INTO schema_migrations(version, name) VALUES ($1, $2)", 9999, "broken")
+	if err == nil {
+		t.Fatal("runMigration did not fail")
+	}
+
+	var exists bool
+	if err = conn.QueryRowContext(ctx, "SELECT to_regclass('broken_migration') IS NOT NULL").Scan(&exists); err != nil {
+		t.Fatalf("check table: %s", err)
+	}
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		t.Fatalf("appliedMigrations: %s", err)
+	}
+	if _, ok := applied[9999]; ok || exists {
+		t.Fatalf("failed migration left table %t or record %t", exists, ok)
+	}
+}
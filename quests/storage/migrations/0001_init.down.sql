@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS history;
+DROP TABLE IF EXISTS questSteps;
+DROP TABLE IF EXISTS quests;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS users (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    userName varchar(20) NOT NULL,
+    password varchar(100) NOT NULL,
+    isAdmin boolean NOT NULL
+);
+
+-- в старых базах поле пароля слишком короткое для хэша
+ALTER TABLE users ALTER COLUMN password TYPE varchar(100);
+
+CREATE TABLE IF NOT EXISTS quests (
+    id integer GENERATED BY DEFAULT AS IDENTITY,
+    questName varchar(200) NOT NULL,
+    PRIMARY KEY (questName)
+);
+
+CREATE TABLE IF NOT EXISTS questSteps (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    questID integer NOT NULL,
+    stepName varchar(200) NOT NULL,
+    bonus integer,
+    isMulti bool NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS history (
+    stepId integer NOT NULL,
+    userId integer NOT NULL
+);
@@ -0,0 +1,16 @@
+ALTER TABLE users DROP CONSTRAINT users_username_key;
+
+DROP INDEX history_stepid_idx;
+DROP INDEX history_userid_idx;
+ALTER TABLE history DROP CONSTRAINT history_userid_fkey;
+ALTER TABLE history DROP CONSTRAINT history_stepid_fkey;
+ALTER TABLE history DROP CONSTRAINT history_pkey;
+ALTER TABLE history DROP COLUMN id;
+
+ALTER TABLE questSteps DROP CONSTRAINT queststeps_questid_stepname_key;
+ALTER TABLE questSteps DROP CONSTRAINT queststeps_questid_fkey;
+
+ALTER TABLE quests DROP COLUMN cost;
+ALTER TABLE quests DROP CONSTRAINT quests_questname_key;
+ALTER TABLE quests DROP CONSTRAINT quests_pkey;
+ALTER TABLE quests ADD CONSTRAINT quests_pkey PRIMARY KEY (questName);
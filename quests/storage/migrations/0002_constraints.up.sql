@@ -0,0 +1,81 @@
+-- region Очистка данных, которые не пройдут проверку ограничений.
+-- Число измененных строк выводится через RAISE NOTICE, мигратор пишет эти сообщения в журнал
+DO $$
+DECLARE
+    affected integer;
+BEGIN
+    -- шаги без задания и история по несуществующим шагам и пользователям
+    DELETE FROM questSteps WHERE questID NOT IN (SELECT id FROM quests);
+    GET DIAGNOSTICS affected = ROW_COUNT;
+    IF affected > 0 THEN
+        RAISE NOTICE 'deleted % quest steps without quest', affected;
+    END IF;
+
+    DELETE FROM history WHERE stepId NOT IN (SELECT id FROM questSteps);
+    GET DIAGNOSTICS affected = ROW_COUNT;
+    IF affected > 0 THEN
+        RAISE NOTICE 'deleted % history rows of missing steps', affected;
+    END IF;
+
+    DELETE FROM history WHERE userId NOT IN (SELECT id FROM users);
+    GET DIAGNOSTICS affected = ROW_COUNT;
+    IF affected > 0 THEN
+        RAISE NOTICE 'deleted % history rows of missing users', affected;
+    END IF;
+
+    -- шаги-дубликаты внутри задания: историю переносим на первый шаг, остальные удаляем
+    UPDATE history AS h SET stepId = d.keep_id
+    FROM (SELECT id, min(id) OVER (PARTITION BY questID, stepName) AS keep_id FROM questSteps) AS d
+    WHERE h.stepId = d.id AND d.id <> d.keep_id;
+    GET DIAGNOSTICS affected = ROW_COUNT;
+    IF affected > 0 THEN
+        RAISE NOTICE 'moved % history rows from duplicate quest steps', affected;
+    END IF;
+
+    DELETE FROM questSteps AS s
+    USING (SELECT id, min(id) OVER (PARTITION BY questID, stepName) AS keep_id FROM questSteps) AS d
+    WHERE s.id = d.id AND d.id <> d.keep_id;
+    GET DIAGNOSTICS affected = ROW_COUNT;
+    IF affected > 0 THEN
+        RAISE NOTICE 'deleted % duplicate quest steps', affected;
+    END IF;
+
+    -- пользователи с одинаковыми именами: всем, кроме первого, добавляем к имени идентификатор
+    UPDATE users AS u SET userName = left(u.userName, 19 - length(u.id::text)) || '_' || u.id
+    FROM (SELECT id, min(id) OVER (PARTITION BY userName) AS keep_id FROM users) AS d
+    WHERE u.id = d.id AND d.id <> d.keep_id;
+    GET DIAGNOSTICS affected = ROW_COUNT;
+    IF affected > 0 THEN
+        RAISE NOTICE 'renamed % users with duplicate names', affected;
+    END IF;
+END $$;
+
+-- endregion
+
+-- region quests: первичный ключ по id, имя уникально
+ALTER TABLE quests DROP CONSTRAINT quests_pkey;
+ALTER TABLE quests ADD CONSTRAINT quests_pkey PRIMARY KEY (id);
+ALTER TABLE quests ADD CONSTRAINT quests_questname_key UNIQUE (questName);
+ALTER TABLE quests ADD COLUMN IF NOT EXISTS cost integer NOT NULL DEFAULT 0;
+-- endregion
+
+-- region questSteps: шаги удаляются вместе с заданием, имя шага уникально внутри задания
+ALTER TABLE questSteps ADD CONSTRAINT queststeps_questid_fkey
+    FOREIGN KEY (questID) REFERENCES quests (id) ON DELETE CASCADE;
+ALTER TABLE questSteps ADD CONSTRAINT queststeps_questid_stepname_key UNIQUE (questID, stepName);
+-- endregion
+
+-- region history: шаг с историей удалить нельзя, история удаляется вместе с пользователем
+ALTER TABLE history ADD COLUMN id integer GENERATED BY DEFAULT AS IDENTITY;
+ALTER TABLE history ADD CONSTRAINT history_pkey PRIMARY KEY (id);
+ALTER TABLE history ADD CONSTRAINT history_stepid_fkey
+    FOREIGN KEY (stepId) REFERENCES questSteps (id) ON DELETE RESTRICT;
+ALTER TABLE history ADD CONSTRAINT history_userid_fkey
+    FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE;
+CREATE INDEX history_userid_idx ON history (userId);
+CREATE INDEX history_stepid_idx ON history (stepId);
+-- endregion
+
+-- region users: имя пользователя уникально
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (userName);
+-- endregion
@@ -0,0 +1,16 @@
+-- refresh токены хранятся только в виде sha256 хэша
+CREATE TABLE refresh_tokens (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash varchar(64) NOT NULL UNIQUE,
+    expires_at timestamptz NOT NULL,
+    revoked_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+-- отозванные access токены, хранятся до истечения срока действия токена
+CREATE TABLE revoked_tokens (
+    jti varchar(64) PRIMARY KEY,
+    expires_at timestamptz NOT NULL
+);
@@ -0,0 +1,55 @@
+CREATE TABLE roles (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    name varchar(50) NOT NULL UNIQUE,
+    description varchar(200) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE permissions (
+    name varchar(50) PRIMARY KEY,
+    description varchar(200) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE role_permissions (
+    role_id integer NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    permission varchar(50) NOT NULL REFERENCES permissions (name) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission)
+);
+
+CREATE TABLE user_roles (
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role_id integer NOT NULL REFERENCES roles (id) ON DELETE RESTRICT,
+    PRIMARY KEY (user_id, role_id)
+);
+CREATE INDEX user_roles_role_id_idx ON user_roles (role_id);
+
+INSERT INTO permissions (name, description) VALUES
+    ('self', 'Доступ к своему профилю, истории и выполнению шагов от своего имени'),
+    ('users.read', 'Просмотр пользователей'),
+    ('users.write', 'Создание, изменение и удаление пользователей'),
+    ('roles.manage', 'Назначение ролей пользователям'),
+    ('quests.read', 'Просмотр заданий'),
+    ('quests.write', 'Создание и изменение заданий и шагов'),
+    ('history.read', 'Просмотр истории выполнения любого пользователя'),
+    ('history.write', 'Отметка выполнения шагов за любого пользователя');
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Полный доступ'),
+    ('quest-editor', 'Автор заданий'),
+    ('operator', 'Отметка выполнения шагов, например на стойке регистрации'),
+    ('player', 'Участник');
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.name
+FROM roles AS r
+JOIN permissions AS p ON
+    r.name = 'admin'
+    OR (r.name = 'quest-editor' AND p.name IN ('self', 'quests.read', 'quests.write'))
+    OR (r.name = 'operator' AND p.name IN ('self', 'users.read', 'quests.read', 'history.read', 'history.write'))
+    OR (r.name = 'player' AND p.name IN ('self'));
+
+-- все пользователи становятся участниками, администраторы получают роль admin
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.id, r.id FROM users AS u JOIN roles AS r ON r.name = 'player';
+
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.id, r.id FROM users AS u JOIN roles AS r ON r.name = 'admin' WHERE u.isAdmin;
@@ -0,0 +1,10 @@
+-- откат не пройдет, если имя архивного задания или шага повторно использовано
+DROP INDEX queststeps_questid_stepname_key;
+ALTER TABLE questSteps ADD CONSTRAINT queststeps_questid_stepname_key UNIQUE (questID, stepName);
+
+DROP INDEX quests_questname_key;
+ALTER TABLE quests ADD CONSTRAINT quests_questname_key UNIQUE (questName);
+
+ALTER TABLE questSteps DROP COLUMN archived_at;
+ALTER TABLE quests DROP COLUMN archived_at;
+ALTER TABLE quests DROP COLUMN description;
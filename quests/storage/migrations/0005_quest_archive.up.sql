@@ -0,0 +1,17 @@
+-- region quests: описание и архивирование
+ALTER TABLE quests ADD COLUMN description text NOT NULL DEFAULT '';
+ALTER TABLE quests ADD COLUMN archived_at timestamptz;
+-- endregion
+
+-- region questSteps: архивирование
+ALTER TABLE questSteps ADD COLUMN archived_at timestamptz;
+-- endregion
+
+-- region имена должны быть уникальны только среди действующих заданий и шагов,
+-- чтобы имя архивного задания можно было использовать повторно
+ALTER TABLE quests DROP CONSTRAINT quests_questname_key;
+CREATE UNIQUE INDEX quests_questname_key ON quests (questName) WHERE archived_at IS NULL;
+
+ALTER TABLE questSteps DROP CONSTRAINT queststeps_questid_stepname_key;
+CREATE UNIQUE INDEX queststeps_questid_stepname_key ON questSteps (questID, stepName) WHERE archived_at IS NULL;
+-- endregion
@@ -0,0 +1,5 @@
+DROP INDEX users_created_at_idx;
+DROP INDEX quests_created_at_idx;
+
+ALTER TABLE users DROP COLUMN created_at;
+ALTER TABLE quests DROP COLUMN created_at;
@@ -0,0 +1,7 @@
+-- время создания заданий и пользователей, для существующих записей - время применения миграции
+ALTER TABLE quests ADD COLUMN created_at timestamptz NOT NULL DEFAULT now();
+ALTER TABLE users ADD COLUMN created_at timestamptz NOT NULL DEFAULT now();
+
+-- индексы для постраничной выдачи с сортировкой по времени создания
+CREATE INDEX quests_created_at_idx ON quests (created_at, id);
+CREATE INDEX users_created_at_idx ON users (created_at, id);
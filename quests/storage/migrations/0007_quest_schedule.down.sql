@@ -0,0 +1,10 @@
+DROP FUNCTION quest_period_start(varchar, timestamptz, timestamptz);
+
+DROP INDEX history_userid_stepid_completed_at_idx;
+ALTER TABLE history DROP COLUMN completed_at;
+
+ALTER TABLE quests DROP CONSTRAINT quests_window_check;
+ALTER TABLE quests DROP CONSTRAINT quests_recurrence_check;
+ALTER TABLE quests DROP COLUMN recurrence;
+ALTER TABLE quests DROP COLUMN ends_at;
+ALTER TABLE quests DROP COLUMN starts_at;
@@ -0,0 +1,26 @@
+-- region quests: окно проведения и периодичность
+ALTER TABLE quests ADD COLUMN starts_at timestamptz;
+ALTER TABLE quests ADD COLUMN ends_at timestamptz;
+ALTER TABLE quests ADD COLUMN recurrence varchar(10) NOT NULL DEFAULT 'none';
+ALTER TABLE quests ADD CONSTRAINT quests_recurrence_check CHECK (recurrence IN ('none', 'daily', 'weekly'));
+ALTER TABLE quests ADD CONSTRAINT quests_window_check CHECK (starts_at IS NULL OR ends_at IS NULL OR ends_at > starts_at);
+-- endregion
+
+-- region history: время выполнения шага, для существующих записей - время применения миграции
+ALTER TABLE history ADD COLUMN completed_at timestamptz NOT NULL DEFAULT now();
+CREATE INDEX history_userid_stepid_completed_at_idx ON history (userId, stepId, completed_at);
+-- endregion
+
+-- quest_period_start возвращает начало текущего периода периодического задания на момент $3.
+-- Периоды отсчитываются от starts_at, если он задан, иначе от начала суток (недели) по UTC.
+-- Для непериодического задания возвращает -infinity: учитываются все выполнения
+CREATE FUNCTION quest_period_start(recurrence varchar, starts_at timestamptz, at timestamptz) RETURNS timestamptz
+LANGUAGE sql STABLE AS $$
+    SELECT CASE
+        WHEN $1 = 'daily' AND $2 IS NULL THEN date_trunc('day', $3, 'UTC')
+        WHEN $1 = 'weekly' AND $2 IS NULL THEN date_trunc('week', $3, 'UTC')
+        WHEN $1 = 'daily' THEN $2 + floor(extract(epoch FROM $3 - $2) / 86400) * interval '24 hours'
+        WHEN $1 = 'weekly' THEN $2 + floor(extract(epoch FROM $3 - $2) / 604800) * interval '168 hours'
+        ELSE '-infinity'::timestamptz
+    END
+$$;
@@ -0,0 +1,5 @@
+ALTER TABLE questSteps DROP CONSTRAINT queststeps_ismulti_check;
+ALTER TABLE questSteps DROP CONSTRAINT queststeps_limits_check;
+ALTER TABLE questSteps DROP COLUMN global_limit;
+ALTER TABLE questSteps DROP COLUMN cooldown_seconds;
+ALTER TABLE questSteps DROP COLUMN max_completions;
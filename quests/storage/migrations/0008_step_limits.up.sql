@@ -0,0 +1,13 @@
+-- region questSteps: правила выполнения шага.
+-- max_completions - сколько раз пользователь может выполнить шаг (за период периодического задания), NULL - без ограничения;
+-- cooldown_seconds - минимальный интервал между выполнениями шага одним пользователем;
+-- global_limit - сколько раз шаг могут выполнить все пользователи вместе.
+-- ismulti сохраняется для совместимости и всегда равен max_completions IS DISTINCT FROM 1
+ALTER TABLE questSteps ADD COLUMN max_completions integer;
+ALTER TABLE questSteps ADD COLUMN cooldown_seconds integer;
+ALTER TABLE questSteps ADD COLUMN global_limit integer;
+UPDATE questSteps SET max_completions = 1 WHERE NOT ismulti;
+ALTER TABLE questSteps ADD CONSTRAINT queststeps_limits_check
+    CHECK (max_completions > 0 AND cooldown_seconds > 0 AND global_limit > 0);
+ALTER TABLE questSteps ADD CONSTRAINT queststeps_ismulti_check CHECK (ismulti = (max_completions IS DISTINCT FROM 1));
+-- endregion
@@ -0,0 +1,5 @@
+DROP INDEX history_idempotency_key_key;
+ALTER TABLE history DROP COLUMN idempotency_key;
+ALTER TABLE history DROP COLUMN source;
+ALTER TABLE history DROP CONSTRAINT history_recorded_by_fkey;
+ALTER TABLE history DROP COLUMN recorded_by;
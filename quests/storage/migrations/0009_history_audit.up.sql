@@ -0,0 +1,10 @@
+-- region history: кто записал выполнение шага и откуда пришел запрос.
+-- recorded_by - авторизованный пользователь, отметивший выполнение; для записей до миграции не известен.
+-- idempotency_key - ключ клиента, повторный запрос с тем же ключом не создает новую запись
+ALTER TABLE history ADD COLUMN recorded_by integer;
+ALTER TABLE history ADD CONSTRAINT history_recorded_by_fkey
+    FOREIGN KEY (recorded_by) REFERENCES users (id) ON DELETE SET NULL;
+ALTER TABLE history ADD COLUMN source varchar(50);
+ALTER TABLE history ADD COLUMN idempotency_key varchar(100);
+CREATE UNIQUE INDEX history_idempotency_key_key ON history (idempotency_key) WHERE idempotency_key IS NOT NULL;
+-- endregion
@@ -0,0 +1,8 @@
+DROP FUNCTION step_prerequisites_met(integer, integer, timestamptz);
+DROP FUNCTION quest_finished(integer, integer);
+
+DROP TABLE quest_prerequisites;
+DROP TABLE step_prerequisites;
+
+DROP INDEX queststeps_questid_position_idx;
+ALTER TABLE questSteps DROP COLUMN position;
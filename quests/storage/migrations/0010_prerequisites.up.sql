@@ -0,0 +1,52 @@
+-- region questSteps: порядок шагов в задании, существующие шаги нумеруются в порядке создания
+ALTER TABLE questSteps ADD COLUMN position integer NOT NULL DEFAULT 0;
+UPDATE questSteps AS s SET position = n.position
+FROM (SELECT id, row_number() OVER (PARTITION BY questID ORDER BY id) AS position FROM questSteps) AS n
+WHERE n.id = s.id;
+CREATE INDEX queststeps_questid_position_idx ON questSteps (questID, position, id);
+-- endregion
+
+-- region предварительные условия: шаг требует выполнения другого шага того же задания,
+-- задание требует завершения другого задания. Графы не содержат циклов, это проверяется при изменении
+CREATE TABLE step_prerequisites (
+    step_id integer NOT NULL REFERENCES questSteps (id) ON DELETE CASCADE,
+    required_step_id integer NOT NULL REFERENCES questSteps (id) ON DELETE CASCADE,
+    PRIMARY KEY (step_id, required_step_id),
+    CONSTRAINT step_prerequisites_self_check CHECK (step_id <> required_step_id)
+);
+CREATE INDEX step_prerequisites_required_step_id_idx ON step_prerequisites (required_step_id);
+
+CREATE TABLE quest_prerequisites (
+    quest_id integer NOT NULL REFERENCES quests (id) ON DELETE CASCADE,
+    required_quest_id integer NOT NULL REFERENCES quests (id) ON DELETE CASCADE,
+    PRIMARY KEY (quest_id, required_quest_id),
+    CONSTRAINT quest_prerequisites_self_check CHECK (quest_id <> required_quest_id)
+);
+CREATE INDEX quest_prerequisites_required_quest_id_idx ON quest_prerequisites (required_quest_id);
+-- endregion
+
+-- quest_finished возвращает true, если пользователь хотя бы раз выполнил каждый действующий шаг задания
+CREATE FUNCTION quest_finished(quest_id integer, user_id integer) RETURNS boolean
+LANGUAGE sql STABLE AS $$
+    SELECT NOT EXISTS (
+        SELECT 1 FROM questSteps AS s
+        WHERE s.questID = $1 AND s.archived_at IS NULL
+            AND NOT EXISTS (SELECT 1 FROM history AS h WHERE h.stepId = s.id AND h.userId = $2)
+    )
+$$;
+
+-- step_prerequisites_met возвращает true, если пользователь выполнил все действующие шаги, которые требует шаг,
+-- не раньше $3 (начала периода периодического задания), и завершил все задания, которые требует задание шага
+CREATE FUNCTION step_prerequisites_met(step_id integer, user_id integer, since timestamptz) RETURNS boolean
+LANGUAGE sql STABLE AS $$
+    SELECT NOT EXISTS (
+        SELECT 1 FROM step_prerequisites AS sp
+        JOIN questSteps AS r ON r.id = sp.required_step_id AND r.archived_at IS NULL
+        WHERE sp.step_id = $1
+            AND NOT EXISTS (SELECT 1 FROM history AS h WHERE h.stepId = r.id AND h.userId = $2 AND h.completed_at >= $3)
+    ) AND NOT EXISTS (
+        SELECT 1 FROM questSteps AS s
+        JOIN quest_prerequisites AS qp ON qp.quest_id = s.questID
+        WHERE s.id = $1 AND NOT quest_finished(qp.required_quest_id, $2)
+    )
+$$;
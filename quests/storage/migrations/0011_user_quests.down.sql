@@ -0,0 +1,2 @@
+ALTER TABLE quests DROP CONSTRAINT quests_cost_check;
+DROP TABLE user_quests;
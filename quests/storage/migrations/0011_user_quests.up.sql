@@ -0,0 +1,35 @@
+-- region user_quests: состояние задания у пользователя. Отсутствие строки означает, что пользователь не начинал задание.
+-- bonus - бонус за завершение задания (quests.cost на момент завершения), начисляется один раз
+CREATE TABLE user_quests (
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    quest_id integer NOT NULL REFERENCES quests (id) ON DELETE CASCADE,
+    status varchar(20) NOT NULL DEFAULT 'in_progress',
+    started_at timestamptz NOT NULL DEFAULT now(),
+    completed_at timestamptz,
+    bonus integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, quest_id),
+    CONSTRAINT user_quests_status_check CHECK (status IN ('in_progress', 'completed')),
+    CONSTRAINT user_quests_completed_check CHECK ((status = 'completed') = (completed_at IS NOT NULL))
+);
+CREATE INDEX user_quests_quest_id_idx ON user_quests (quest_id);
+-- endregion
+
+-- region quests: стоимость задания - бонус за его завершение, не может быть отрицательной
+UPDATE quests SET cost = 0 WHERE cost < 0;
+ALTER TABLE quests ADD CONSTRAINT quests_cost_check CHECK (cost >= 0);
+-- endregion
+
+-- region заполнение по существующей истории: завершенные задания получают бонус за завершение
+INSERT INTO user_quests (user_id, quest_id, started_at)
+SELECT h.userId, s.questID, min(h.completed_at)
+FROM history AS h
+JOIN questSteps AS s ON s.id = h.stepId
+GROUP BY h.userId, s.questID;
+
+UPDATE user_quests AS uq
+SET status = 'completed', bonus = q.cost,
+    completed_at = (SELECT max(h.completed_at) FROM history AS h JOIN questSteps AS s ON s.id = h.stepId
+                    WHERE h.userId = uq.user_id AND s.questID = uq.quest_id)
+FROM quests AS q
+WHERE q.id = uq.quest_id AND quest_finished(uq.quest_id, uq.user_id);
+-- endregion
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE name = 'bonus.write';
+
+DROP TABLE bonus_ledger;
+DROP FUNCTION bonus_ledger_immutable();
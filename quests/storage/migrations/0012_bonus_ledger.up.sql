@@ -0,0 +1,61 @@
+-- region bonus_ledger: журнал начислений и списаний бонусов. Записи не меняются, ошибочная запись компенсируется
+-- записью reversal с противоположной суммой. Баланс пользователя - сумма amount его записей.
+-- history_id заполняется у начислений за шаг, quest_id - у начислений за завершение задания;
+-- компенсирующая запись копирует их из исходной
+CREATE TABLE bonus_ledger (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    amount integer NOT NULL,
+    kind varchar(20) NOT NULL,
+    history_id integer REFERENCES history (id) ON DELETE CASCADE,
+    quest_id integer REFERENCES quests (id) ON DELETE CASCADE,
+    reverses_id integer UNIQUE REFERENCES bonus_ledger (id) ON DELETE CASCADE,
+    comment varchar(200) NOT NULL DEFAULT '',
+    created_by integer REFERENCES users (id) ON DELETE SET NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT bonus_ledger_amount_check CHECK (amount <> 0),
+    CONSTRAINT bonus_ledger_kind_check CHECK (kind IN ('step', 'quest', 'redemption', 'adjustment', 'reversal')),
+    CONSTRAINT bonus_ledger_reversal_check CHECK ((kind = 'reversal') = (reverses_id IS NOT NULL))
+);
+CREATE INDEX bonus_ledger_user_id_idx ON bonus_ledger (user_id, id);
+CREATE INDEX bonus_ledger_history_id_idx ON bonus_ledger (history_id);
+CREATE INDEX bonus_ledger_quest_id_idx ON bonus_ledger (quest_id);
+
+-- допускается только обнуление created_by при удалении автора записи (ON DELETE SET NULL)
+CREATE FUNCTION bonus_ledger_immutable() RETURNS trigger
+LANGUAGE plpgsql AS $$
+DECLARE
+    expected bonus_ledger;
+BEGIN
+    expected := OLD;
+    expected.created_by := NULL;
+    IF NEW IS NOT DISTINCT FROM expected THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'bonus_ledger is append-only, add a reversal entry instead';
+END
+$$;
+
+CREATE TRIGGER bonus_ledger_no_update BEFORE UPDATE ON bonus_ledger
+FOR EACH ROW EXECUTE FUNCTION bonus_ledger_immutable();
+-- endregion
+
+-- region заполнение по существующей истории: бонус за шаг до миграции известен только текущий
+INSERT INTO bonus_ledger (user_id, amount, kind, history_id, created_by, created_at)
+SELECT h.userId, s.bonus, 'step', h.id, h.recorded_by, h.completed_at
+FROM history AS h
+JOIN questSteps AS s ON s.id = h.stepId
+WHERE coalesce(s.bonus, 0) <> 0
+ORDER BY h.id;
+
+INSERT INTO bonus_ledger (user_id, amount, kind, quest_id, created_at)
+SELECT user_id, bonus, 'quest', quest_id, completed_at
+FROM user_quests
+WHERE status = 'completed' AND bonus <> 0
+ORDER BY completed_at;
+-- endregion
+
+-- region разрешение на списание и корректировку бонусов
+INSERT INTO permissions (name, description) VALUES ('bonus.write', 'Списание, корректировка и отмена начислений бонусов');
+INSERT INTO role_permissions (role_id, permission) SELECT id, 'bonus.write' FROM roles WHERE name = 'admin';
+-- endregion
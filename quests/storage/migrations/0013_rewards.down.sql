@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE name = 'rewards.manage';
+
+DROP TABLE redemptions;
+DROP TABLE rewards;
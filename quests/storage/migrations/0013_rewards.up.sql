@@ -0,0 +1,42 @@
+-- region rewards: каталог наград за бонусы. stock - остаток, NULL - без ограничения.
+-- Архивная награда не показывается в каталоге и не может быть получена, заявки по ней сохраняются
+CREATE TABLE rewards (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    name varchar(200) NOT NULL,
+    description text NOT NULL DEFAULT '',
+    cost integer NOT NULL,
+    stock integer,
+    archived_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT rewards_name_key UNIQUE (name),
+    CONSTRAINT rewards_cost_check CHECK (cost > 0),
+    CONSTRAINT rewards_stock_check CHECK (stock >= 0)
+);
+-- endregion
+
+-- region redemptions: заявки на получение наград. При создании заявки стоимость списывается записью debit_id,
+-- при отмене возвращается записью refund_id, отменяющей списание
+CREATE TABLE redemptions (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    reward_id integer NOT NULL REFERENCES rewards (id),
+    cost integer NOT NULL,
+    status varchar(20) NOT NULL DEFAULT 'pending',
+    debit_id integer NOT NULL REFERENCES bonus_ledger (id) ON DELETE CASCADE,
+    refund_id integer REFERENCES bonus_ledger (id) ON DELETE SET NULL,
+    comment varchar(200) NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    resolved_at timestamptz,
+    resolved_by integer REFERENCES users (id) ON DELETE SET NULL,
+    CONSTRAINT redemptions_status_check CHECK (status IN ('pending', 'fulfilled', 'cancelled')),
+    CONSTRAINT redemptions_resolved_check CHECK ((status = 'pending') = (resolved_at IS NULL))
+);
+CREATE INDEX redemptions_user_id_idx ON redemptions (user_id, id);
+CREATE INDEX redemptions_status_idx ON redemptions (status, id);
+CREATE INDEX redemptions_reward_id_idx ON redemptions (reward_id);
+-- endregion
+
+-- region разрешение на управление каталогом наград и заявками
+INSERT INTO permissions (name, description) VALUES ('rewards.manage', 'Управление каталогом наград и заявками на их получение');
+INSERT INTO role_permissions (role_id, permission) SELECT id, 'rewards.manage' FROM roles WHERE name = 'admin';
+-- endregion
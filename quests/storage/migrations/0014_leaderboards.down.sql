@@ -0,0 +1,5 @@
+DROP TRIGGER bonus_ledger_leaderboard ON bonus_ledger;
+DROP FUNCTION leaderboard_apply_ledger();
+DROP FUNCTION leaderboard_add(integer, integer, integer, timestamptz, timestamptz);
+DROP TABLE leaderboard_scores;
+DROP FUNCTION leaderboard_period_start(varchar, timestamptz);
@@ -0,0 +1,75 @@
+-- leaderboard_period_start возвращает начало периода рейтинга ('week', 'month' по UTC), содержащего момент $2.
+-- Для рейтинга за все время ('all') возвращает -infinity
+CREATE FUNCTION leaderboard_period_start(period varchar, at timestamptz) RETURNS timestamptz
+LANGUAGE sql STABLE AS $$
+    SELECT CASE
+        WHEN $1 = 'week' THEN date_trunc('week', $2, 'UTC')
+        WHEN $1 = 'month' THEN date_trunc('month', $2, 'UTC')
+        ELSE '-infinity'::timestamptz
+    END
+$$;
+
+-- region leaderboard_scores: очки пользователей по периодам, общие (quest_id = 0) и по заданиям.
+-- Очки - бонусы журнала, заработанные выполнением шагов и завершением заданий, с учетом отмен; списания и ручные
+-- корректировки не учитываются. achieved_at - время, когда пользователь набрал текущее кол-во очков: при равенстве
+-- очков выше тот, кто набрал их раньше. Таблица обновляется триггером на bonus_ledger, поэтому запрос рейтинга не
+-- пересчитывает историю
+CREATE TABLE leaderboard_scores (
+    period varchar(10) NOT NULL,
+    period_start timestamptz NOT NULL,
+    quest_id integer NOT NULL,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    score integer NOT NULL DEFAULT 0,
+    achieved_at timestamptz NOT NULL,
+    PRIMARY KEY (period, period_start, quest_id, user_id),
+    CONSTRAINT leaderboard_scores_period_check CHECK (period IN ('all', 'week', 'month'))
+);
+CREATE INDEX leaderboard_scores_rank_idx ON leaderboard_scores (period, period_start, quest_id, score DESC, achieved_at, user_id);
+-- endregion
+
+-- leaderboard_add добавляет очки пользователю во всех рейтингах, к которым относится момент earned_at:
+-- за все время, неделю и месяц, общих и задания quest_id
+CREATE FUNCTION leaderboard_add(user_id integer, quest_id integer, amount integer, earned_at timestamptz, achieved_at timestamptz) RETURNS void
+LANGUAGE sql AS $$
+    INSERT INTO leaderboard_scores AS ls (period, period_start, quest_id, user_id, score, achieved_at)
+    SELECT p.period, leaderboard_period_start(p.period, $4), q.quest_id, $1, $3, $5
+    FROM (VALUES ('all'), ('week'), ('month')) AS p(period)
+    CROSS JOIN (VALUES (0), ($2)) AS q(quest_id)
+    ON CONFLICT (period, period_start, quest_id, user_id) DO UPDATE
+    SET score = ls.score + EXCLUDED.score, achieved_at = EXCLUDED.achieved_at
+$$;
+
+-- region начисление очков при добавлении записи в журнал бонусов. Отмена уменьшает очки в периоде исходной записи
+CREATE FUNCTION leaderboard_apply_ledger() RETURNS trigger
+LANGUAGE plpgsql AS $$
+DECLARE
+    earned_at timestamptz := NEW.created_at;
+    quest integer := NEW.quest_id;
+BEGIN
+    IF NEW.history_id IS NULL AND NEW.quest_id IS NULL THEN
+        RETURN NULL;
+    END IF;
+    IF NEW.reverses_id IS NOT NULL THEN
+        SELECT created_at INTO earned_at FROM bonus_ledger WHERE id = NEW.reverses_id;
+    END IF;
+    IF quest IS NULL THEN
+        SELECT s.questId INTO quest FROM history AS h JOIN questSteps AS s ON s.id = h.stepId WHERE h.id = NEW.history_id;
+    END IF;
+    PERFORM leaderboard_add(NEW.user_id, quest, NEW.amount, earned_at, NEW.created_at);
+    RETURN NULL;
+END
+$$;
+
+CREATE TRIGGER bonus_ledger_leaderboard AFTER INSERT ON bonus_ledger
+FOR EACH ROW EXECUTE FUNCTION leaderboard_apply_ledger();
+-- endregion
+
+-- region заполнение по существующему журналу
+SELECT leaderboard_add(l.user_id, coalesce(l.quest_id, s.questId), l.amount, coalesce(o.created_at, l.created_at), l.created_at)
+FROM bonus_ledger AS l
+LEFT JOIN bonus_ledger AS o ON o.id = l.reverses_id
+LEFT JOIN history AS h ON h.id = l.history_id
+LEFT JOIN questSteps AS s ON s.id = h.stepId
+WHERE l.history_id IS NOT NULL OR l.quest_id IS NOT NULL
+ORDER BY l.id;
+-- endregion
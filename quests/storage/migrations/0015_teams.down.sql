@@ -0,0 +1,59 @@
+DELETE FROM permissions WHERE name = 'teams.manage';
+
+CREATE OR REPLACE FUNCTION leaderboard_apply_ledger() RETURNS trigger
+LANGUAGE plpgsql AS $$
+DECLARE
+    earned_at timestamptz := NEW.created_at;
+    quest integer := NEW.quest_id;
+BEGIN
+    IF NEW.history_id IS NULL AND NEW.quest_id IS NULL THEN
+        RETURN NULL;
+    END IF;
+    IF NEW.reverses_id IS NOT NULL THEN
+        SELECT created_at INTO earned_at FROM bonus_ledger WHERE id = NEW.reverses_id;
+    END IF;
+    IF quest IS NULL THEN
+        SELECT s.questId INTO quest FROM history AS h JOIN questSteps AS s ON s.id = h.stepId WHERE h.id = NEW.history_id;
+    END IF;
+    PERFORM leaderboard_add(NEW.user_id, quest, NEW.amount, earned_at, NEW.created_at);
+    RETURN NULL;
+END
+$$;
+
+DROP FUNCTION team_leaderboard_add(integer, integer, integer, timestamptz, timestamptz);
+DROP TABLE team_leaderboard_scores;
+
+DROP FUNCTION step_prerequisites_met(integer, integer, timestamptz, integer);
+DROP FUNCTION quest_finished(integer, integer, integer);
+
+CREATE FUNCTION quest_finished(quest_id integer, user_id integer) RETURNS boolean
+LANGUAGE sql STABLE AS $$
+    SELECT NOT EXISTS (
+        SELECT 1 FROM questSteps AS s
+        WHERE s.questID = $1 AND s.archived_at IS NULL
+            AND NOT EXISTS (SELECT 1 FROM history AS h WHERE h.stepId = s.id AND h.userId = $2)
+    )
+$$;
+
+-- step_prerequisites_met возвращает true, если пользователь выполнил все действующие шаги, которые требует шаг,
+-- не раньше $3 (начала периода периодического задания), и завершил все задания, которые требует задание шага
+CREATE FUNCTION step_prerequisites_met(step_id integer, user_id integer, since timestamptz) RETURNS boolean
+LANGUAGE sql STABLE AS $$
+    SELECT NOT EXISTS (
+        SELECT 1 FROM step_prerequisites AS sp
+        JOIN questSteps AS r ON r.id = sp.required_step_id AND r.archived_at IS NULL
+        WHERE sp.step_id = $1
+            AND NOT EXISTS (SELECT 1 FROM history AS h WHERE h.stepId = r.id AND h.userId = $2 AND h.completed_at >= $3)
+    ) AND NOT EXISTS (
+        SELECT 1 FROM questSteps AS s
+        JOIN quest_prerequisites AS qp ON qp.quest_id = s.questID
+        WHERE s.id = $1 AND NOT quest_finished(qp.required_quest_id, $2)
+    )
+$$;
+
+DROP TABLE team_quests;
+ALTER TABLE bonus_ledger DROP COLUMN team_id;
+ALTER TABLE history DROP COLUMN team_id;
+ALTER TABLE quests DROP COLUMN team_scoped;
+DROP TABLE team_members;
+DROP TABLE teams;
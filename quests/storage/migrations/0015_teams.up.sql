@@ -0,0 +1,123 @@
+-- region teams: команды пользователей. Пользователь состоит не больше чем в одной команде
+CREATE TABLE teams (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    name varchar(200) NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT teams_name_key UNIQUE (name)
+);
+
+CREATE TABLE team_members (
+    team_id integer NOT NULL REFERENCES teams (id) ON DELETE CASCADE,
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    joined_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (team_id, user_id),
+    CONSTRAINT team_members_user_id_key UNIQUE (user_id)
+);
+-- endregion
+
+-- region командные задания: выполнение шага любым участником команды засчитывается всей команде.
+-- history.team_id и bonus_ledger.team_id - команда пользователя на момент выполнения, по ним считаются бонусы команды.
+-- Команду, по которой есть история, удалить нельзя
+ALTER TABLE quests ADD COLUMN team_scoped boolean NOT NULL DEFAULT false;
+ALTER TABLE history ADD COLUMN team_id integer REFERENCES teams (id);
+CREATE INDEX history_team_id_stepid_idx ON history (team_id, stepId) WHERE team_id IS NOT NULL;
+ALTER TABLE bonus_ledger ADD COLUMN team_id integer REFERENCES teams (id);
+CREATE INDEX bonus_ledger_team_id_idx ON bonus_ledger (team_id) WHERE team_id IS NOT NULL;
+
+-- team_quests: состояние командного задания у команды, аналог user_quests
+CREATE TABLE team_quests (
+    team_id integer NOT NULL REFERENCES teams (id) ON DELETE CASCADE,
+    quest_id integer NOT NULL REFERENCES quests (id) ON DELETE CASCADE,
+    status varchar(20) NOT NULL DEFAULT 'in_progress',
+    started_at timestamptz NOT NULL DEFAULT now(),
+    completed_at timestamptz,
+    bonus integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (team_id, quest_id),
+    CONSTRAINT team_quests_status_check CHECK (status IN ('in_progress', 'completed')),
+    CONSTRAINT team_quests_completed_check CHECK ((status = 'completed') = (completed_at IS NOT NULL))
+);
+CREATE INDEX team_quests_quest_id_idx ON team_quests (quest_id);
+-- endregion
+
+-- region предварительные условия с учетом команды: в командном задании учитываются выполнения всех участников команды $3
+DROP FUNCTION step_prerequisites_met(integer, integer, timestamptz);
+DROP FUNCTION quest_finished(integer, integer);
+
+CREATE FUNCTION quest_finished(quest_id integer, user_id integer, team_id integer DEFAULT NULL) RETURNS boolean
+LANGUAGE sql STABLE AS $$
+    SELECT NOT EXISTS (
+        SELECT 1 FROM questSteps AS s
+        JOIN quests AS q ON q.id = s.questID
+        WHERE s.questID = $1 AND s.archived_at IS NULL
+            AND NOT EXISTS (SELECT 1 FROM history AS h WHERE h.stepId = s.id AND (h.userId = $2 OR (q.team_scoped AND h.team_id = $3)))
+    )
+$$;
+
+CREATE FUNCTION step_prerequisites_met(step_id integer, user_id integer, since timestamptz, team_id integer DEFAULT NULL) RETURNS boolean
+LANGUAGE sql STABLE AS $$
+    SELECT NOT EXISTS (
+        SELECT 1 FROM step_prerequisites AS sp
+        JOIN questSteps AS r ON r.id = sp.required_step_id AND r.archived_at IS NULL
+        JOIN quests AS q ON q.id = r.questID
+        WHERE sp.step_id = $1
+            AND NOT EXISTS (SELECT 1 FROM history AS h WHERE h.stepId = r.id AND (h.userId = $2 OR (q.team_scoped AND h.team_id = $4))
+                AND h.completed_at >= $3)
+    ) AND NOT EXISTS (
+        SELECT 1 FROM questSteps AS s
+        JOIN quest_prerequisites AS qp ON qp.quest_id = s.questID
+        WHERE s.id = $1 AND NOT quest_finished(qp.required_quest_id, $2, $4)
+    )
+$$;
+-- endregion
+
+-- region рейтинг команд: очки, заработанные участниками в составе команды
+CREATE TABLE team_leaderboard_scores (
+    period varchar(10) NOT NULL,
+    period_start timestamptz NOT NULL,
+    quest_id integer NOT NULL,
+    team_id integer NOT NULL REFERENCES teams (id) ON DELETE CASCADE,
+    score integer NOT NULL DEFAULT 0,
+    achieved_at timestamptz NOT NULL,
+    PRIMARY KEY (period, period_start, quest_id, team_id),
+    CONSTRAINT team_leaderboard_scores_period_check CHECK (period IN ('all', 'week', 'month'))
+);
+CREATE INDEX team_leaderboard_scores_rank_idx ON team_leaderboard_scores (period, period_start, quest_id, score DESC, achieved_at, team_id);
+
+CREATE FUNCTION team_leaderboard_add(team_id integer, quest_id integer, amount integer, earned_at timestamptz, achieved_at timestamptz) RETURNS void
+LANGUAGE sql AS $$
+    INSERT INTO team_leaderboard_scores AS ls (period, period_start, quest_id, team_id, score, achieved_at)
+    SELECT p.period, leaderboard_period_start(p.period, $4), q.quest_id, $1, $3, $5
+    FROM (VALUES ('all'), ('week'), ('month')) AS p(period)
+    CROSS JOIN (VALUES (0), ($2)) AS q(quest_id)
+    ON CONFLICT (period, period_start, quest_id, team_id) DO UPDATE
+    SET score = ls.score + EXCLUDED.score, achieved_at = EXCLUDED.achieved_at
+$$;
+
+CREATE OR REPLACE FUNCTION leaderboard_apply_ledger() RETURNS trigger
+LANGUAGE plpgsql AS $$
+DECLARE
+    earned_at timestamptz := NEW.created_at;
+    quest integer := NEW.quest_id;
+BEGIN
+    IF NEW.history_id IS NULL AND NEW.quest_id IS NULL THEN
+        RETURN NULL;
+    END IF;
+    IF NEW.reverses_id IS NOT NULL THEN
+        SELECT created_at INTO earned_at FROM bonus_ledger WHERE id = NEW.reverses_id;
+    END IF;
+    IF quest IS NULL THEN
+        SELECT s.questId INTO quest FROM history AS h JOIN questSteps AS s ON s.id = h.stepId WHERE h.id = NEW.history_id;
+    END IF;
+    PERFORM leaderboard_add(NEW.user_id, quest, NEW.amount, earned_at, NEW.created_at);
+    IF NEW.team_id IS NOT NULL THEN
+        PERFORM team_leaderboard_add(NEW.team_id, quest, NEW.amount, earned_at, NEW.created_at);
+    END IF;
+    RETURN NULL;
+END
+$$;
+-- endregion
+
+-- region разрешение на управление командами
+INSERT INTO permissions (name, description) VALUES ('teams.manage', 'Создание и удаление команд, управление составом');
+INSERT INTO role_permissions (role_id, permission) SELECT id, 'teams.manage' FROM roles WHERE name IN ('admin', 'operator');
+-- endregion
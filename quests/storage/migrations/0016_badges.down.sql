@@ -0,0 +1,6 @@
+DELETE FROM permissions WHERE name = 'badges.manage';
+
+DROP FUNCTION badge_rule_met(varchar, integer, integer, integer, integer);
+DROP VIEW user_finished_quests;
+DROP TABLE user_badges;
+DROP TABLE badges;
@@ -0,0 +1,66 @@
+-- region badges: значки, которые выдаются пользователям по правилам над историей выполнения.
+-- Правило задается типом rule_type и параметрами:
+--   quests_completed - завершено не меньше threshold заданий (командное задание засчитывается участникам команды);
+--   step_completions - не меньше threshold выполнений шага step_id, без step_id - любых шагов;
+--   first_to_finish  - пользователь первым завершил задание quest_id
+CREATE TABLE badges (
+    id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+    name varchar(200) NOT NULL,
+    description text NOT NULL DEFAULT '',
+    rule_type varchar(30) NOT NULL,
+    threshold integer NOT NULL DEFAULT 1,
+    quest_id integer REFERENCES quests (id) ON DELETE CASCADE,
+    step_id integer REFERENCES questSteps (id) ON DELETE CASCADE,
+    archived_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT badges_name_key UNIQUE (name),
+    CONSTRAINT badges_rule_type_check CHECK (rule_type IN ('quests_completed', 'step_completions', 'first_to_finish')),
+    CONSTRAINT badges_threshold_check CHECK (threshold > 0),
+    CONSTRAINT badges_rule_check CHECK ((rule_type = 'first_to_finish') = (quest_id IS NOT NULL)
+        AND (rule_type = 'step_completions' OR step_id IS NULL))
+);
+CREATE INDEX badges_quest_id_idx ON badges (quest_id) WHERE quest_id IS NOT NULL;
+
+-- user_badges: выданные значки. Значок выдается пользователю один раз, history_id - выполнение, после которого он выдан
+CREATE TABLE user_badges (
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    badge_id integer NOT NULL REFERENCES badges (id) ON DELETE CASCADE,
+    history_id integer REFERENCES history (id) ON DELETE SET NULL,
+    awarded_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, badge_id)
+);
+CREATE INDEX user_badges_badge_id_idx ON user_badges (badge_id);
+-- endregion
+
+-- region правила значков
+-- user_finished_quests: завершенные пользователями задания, командное задание считается завершенным всеми участниками команды
+CREATE VIEW user_finished_quests AS
+    SELECT user_id, quest_id, completed_at FROM user_quests WHERE status = 'completed'
+    UNION ALL
+    SELECT tm.user_id, tq.quest_id, tq.completed_at
+    FROM team_quests AS tq
+    JOIN team_members AS tm ON tm.team_id = tq.team_id
+    WHERE tq.status = 'completed';
+
+-- badge_rule_met проверяет правило значка для пользователя $5 по текущей истории
+CREATE FUNCTION badge_rule_met(rule_type varchar, threshold integer, quest_id integer, step_id integer, user_id integer) RETURNS boolean
+LANGUAGE sql STABLE AS $$
+    SELECT CASE $1
+        WHEN 'quests_completed' THEN
+            (SELECT count(DISTINCT f.quest_id) FROM user_finished_quests AS f WHERE f.user_id = $5) >= $2
+        WHEN 'step_completions' THEN
+            (SELECT count(*) FROM history AS h WHERE h.userId = $5 AND ($4 IS NULL OR h.stepId = $4)) >= $2
+        WHEN 'first_to_finish' THEN EXISTS (
+            SELECT 1 FROM user_finished_quests AS f
+            WHERE f.user_id = $5 AND f.quest_id = $3
+                AND NOT EXISTS (SELECT 1 FROM user_finished_quests AS o
+                                WHERE o.quest_id = $3 AND (o.completed_at, o.user_id) < (f.completed_at, f.user_id)))
+        ELSE false
+    END
+$$;
+-- endregion
+
+-- region разрешение на управление значками
+INSERT INTO permissions (name, description) VALUES ('badges.manage', 'Создание и архивирование значков, проверка правил');
+INSERT INTO role_permissions (role_id, permission) SELECT id, 'badges.manage' FROM roles WHERE name = 'admin';
+-- endregion
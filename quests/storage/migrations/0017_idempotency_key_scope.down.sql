@@ -0,0 +1,6 @@
+-- ключи, повторяющиеся у разных пользователей, остаются только у первой записи
+UPDATE history AS h SET idempotency_key = NULL
+WHERE h.idempotency_key IS NOT NULL
+    AND EXISTS (SELECT 1 FROM history AS e WHERE e.idempotency_key = h.idempotency_key AND e.id < h.id);
+DROP INDEX history_userid_idempotency_key_key;
+CREATE UNIQUE INDEX history_idempotency_key_key ON history (idempotency_key) WHERE idempotency_key IS NOT NULL;
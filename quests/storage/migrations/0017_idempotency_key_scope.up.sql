@@ -0,0 +1,5 @@
+-- region history: ключ идемпотентности уникален в пределах пользователя, выполнившего шаг,
+-- одинаковые ключи разных клиентов не мешают друг другу
+DROP INDEX history_idempotency_key_key;
+CREATE UNIQUE INDEX history_userid_idempotency_key_key ON history (userId, idempotency_key) WHERE idempotency_key IS NOT NULL;
+-- endregion
@@ -0,0 +1,19 @@
+CREATE OR REPLACE FUNCTION team_leaderboard_add(team_id integer, quest_id integer, amount integer, earned_at timestamptz, achieved_at timestamptz) RETURNS void
+LANGUAGE sql AS $$
+    INSERT INTO team_leaderboard_scores AS ls (period, period_start, quest_id, team_id, score, achieved_at)
+    SELECT p.period, leaderboard_period_start(p.period, $4), q.quest_id, $1, $3, $5
+    FROM (VALUES ('all'), ('week'), ('month')) AS p(period)
+    CROSS JOIN (VALUES (0), ($2)) AS q(quest_id)
+    ON CONFLICT (period, period_start, quest_id, team_id) DO UPDATE
+    SET score = ls.score + EXCLUDED.score, achieved_at = EXCLUDED.achieved_at
+$$;
+
+CREATE OR REPLACE FUNCTION leaderboard_add(user_id integer, quest_id integer, amount integer, earned_at timestamptz, achieved_at timestamptz) RETURNS void
+LANGUAGE sql AS $$
+    INSERT INTO leaderboard_scores AS ls (period, period_start, quest_id, user_id, score, achieved_at)
+    SELECT p.period, leaderboard_period_start(p.period, $4), q.quest_id, $1, $3, $5
+    FROM (VALUES ('all'), ('week'), ('month')) AS p(period)
+    CROSS JOIN (VALUES (0), ($2)) AS q(quest_id)
+    ON CONFLICT (period, period_start, quest_id, user_id) DO UPDATE
+    SET score = ls.score + EXCLUDED.score, achieved_at = EXCLUDED.achieved_at
+$$;
@@ -0,0 +1,45 @@
+-- region рейтинги: achieved_at - время последнего увеличения очков. Отмена начисления уменьшает очки, но не меняет
+-- achieved_at, поэтому не поднимает участника над теми, кто набрал столько же очков позже. При равенстве очков
+-- выше тот, у кого achieved_at раньше, затем - с меньшим идентификатором
+CREATE OR REPLACE FUNCTION leaderboard_add(user_id integer, quest_id integer, amount integer, earned_at timestamptz, achieved_at timestamptz) RETURNS void
+LANGUAGE sql AS $$
+    INSERT INTO leaderboard_scores AS ls (period, period_start, quest_id, user_id, score, achieved_at)
+    SELECT p.period, leaderboard_period_start(p.period, $4), q.quest_id, $1, $3, $5
+    FROM (VALUES ('all'), ('week'), ('month')) AS p(period)
+    CROSS JOIN (VALUES (0), ($2)) AS q(quest_id)
+    ON CONFLICT (period, period_start, quest_id, user_id) DO UPDATE
+    SET score = ls.score + EXCLUDED.score,
+        achieved_at = CASE WHEN EXCLUDED.score > 0 THEN EXCLUDED.achieved_at ELSE ls.achieved_at END
+$$;
+
+CREATE OR REPLACE FUNCTION team_leaderboard_add(team_id integer, quest_id integer, amount integer, earned_at timestamptz, achieved_at timestamptz) RETURNS void
+LANGUAGE sql AS $$
+    INSERT INTO team_leaderboard_scores AS ls (period, period_start, quest_id, team_id, score, achieved_at)
+    SELECT p.period, leaderboard_period_start(p.period, $4), q.quest_id, $1, $3, $5
+    FROM (VALUES ('all'), ('week'), ('month')) AS p(period)
+    CROSS JOIN (VALUES (0), ($2)) AS q(quest_id)
+    ON CONFLICT (period, period_start, quest_id, team_id) DO UPDATE
+    SET score = ls.score + EXCLUDED.score,
+        achieved_at = CASE WHEN EXCLUDED.score > 0 THEN EXCLUDED.achieved_at ELSE ls.achieved_at END
+$$;
+-- endregion
+
+-- region пересчет рейтингов по журналу с новым правилом achieved_at
+TRUNCATE leaderboard_scores, team_leaderboard_scores;
+
+SELECT leaderboard_add(l.user_id, coalesce(l.quest_id, s.questId), l.amount, coalesce(o.created_at, l.created_at), l.created_at)
+FROM bonus_ledger AS l
+LEFT JOIN bonus_ledger AS o ON o.id = l.reverses_id
+LEFT JOIN history AS h ON h.id = l.history_id
+LEFT JOIN questSteps AS s ON s.id = h.stepId
+WHERE l.history_id IS NOT NULL OR l.quest_id IS NOT NULL
+ORDER BY l.id;
+
+SELECT team_leaderboard_add(l.team_id, coalesce(l.quest_id, s.questId), l.amount, coalesce(o.created_at, l.created_at), l.created_at)
+FROM bonus_ledger AS l
+LEFT JOIN bonus_ledger AS o ON o.id = l.reverses_id
+LEFT JOIN history AS h ON h.id = l.history_id
+LEFT JOIN questSteps AS s ON s.id = h.stepId
+WHERE l.team_id IS NOT NULL AND (l.history_id IS NOT NULL OR l.quest_id IS NOT NULL)
+ORDER BY l.id;
+-- endregion
@@ -0,0 +1,18 @@
+CREATE OR REPLACE FUNCTION badge_rule_met(rule_type varchar, threshold integer, quest_id integer, step_id integer, user_id integer) RETURNS boolean
+LANGUAGE sql STABLE AS $$
+    SELECT CASE $1
+        WHEN 'quests_completed' THEN
+            (SELECT count(DISTINCT f.quest_id) FROM user_finished_quests AS f WHERE f.user_id = $5) >= $2
+        WHEN 'step_completions' THEN
+            (SELECT count(*) FROM history AS h WHERE h.userId = $5 AND ($4 IS NULL OR h.stepId = $4)) >= $2
+        WHEN 'first_to_finish' THEN EXISTS (
+            SELECT 1 FROM user_finished_quests AS f
+            WHERE f.user_id = $5 AND f.quest_id = $3
+                AND NOT EXISTS (SELECT 1 FROM user_finished_quests AS o
+                                WHERE o.quest_id = $3 AND (o.completed_at, o.user_id) < (f.completed_at, f.user_id)))
+        ELSE false
+    END
+$$;
+
+DROP VIEW quest_finishers;
+ALTER TABLE team_quests DROP COLUMN completed_by;
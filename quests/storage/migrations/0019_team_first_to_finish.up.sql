@@ -0,0 +1,37 @@
+-- region team_quests.completed_by: участник команды, выполнением шага которого команда завершила задание.
+-- Для старых записей - участник с последним выполнением шага задания в составе команды до момента завершения
+ALTER TABLE team_quests ADD COLUMN completed_by integer REFERENCES users (id) ON DELETE SET NULL;
+
+UPDATE team_quests AS tq
+SET completed_by = (SELECT h.userId FROM history AS h
+                    JOIN questSteps AS s ON s.id = h.stepId
+                    WHERE s.questID = tq.quest_id AND h.team_id = tq.team_id AND h.completed_at <= tq.completed_at
+                    ORDER BY h.completed_at DESC, h.id DESC
+                    LIMIT 1)
+WHERE tq.status = 'completed';
+-- endregion
+
+-- region first_to_finish: командное задание завершает не вся команда, а участник, выполнивший последний шаг.
+-- quest_finishers - кто и когда завершил задание. Завершение, автор которого удален (completed_by пуст),
+-- никому не засчитывается, но по-прежнему опережает более поздние
+CREATE VIEW quest_finishers AS
+    SELECT user_id, quest_id, completed_at FROM user_quests WHERE status = 'completed'
+    UNION ALL
+    SELECT completed_by, quest_id, completed_at FROM team_quests WHERE status = 'completed';
+
+CREATE OR REPLACE FUNCTION badge_rule_met(rule_type varchar, threshold integer, quest_id integer, step_id integer, user_id integer) RETURNS boolean
+LANGUAGE sql STABLE AS $$
+    SELECT CASE $1
+        WHEN 'quests_completed' THEN
+            (SELECT count(DISTINCT f.quest_id) FROM user_finished_quests AS f WHERE f.user_id = $5) >= $2
+        WHEN 'step_completions' THEN
+            (SELECT count(*) FROM history AS h WHERE h.userId = $5 AND ($4 IS NULL OR h.stepId = $4)) >= $2
+        WHEN 'first_to_finish' THEN EXISTS (
+            SELECT 1 FROM quest_finishers AS f
+            WHERE f.user_id = $5 AND f.quest_id = $3
+                AND NOT EXISTS (SELECT 1 FROM quest_finishers AS o
+                                WHERE o.quest_id = $3 AND (o.completed_at, o.user_id) < (f.completed_at, f.user_id)))
+        ELSE false
+    END
+$$;
+-- endregion
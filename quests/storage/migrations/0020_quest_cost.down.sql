@@ -0,0 +1 @@
+-- Колонку создает 0002_constraints, прежние значение по умолчанию и допустимость NULL неизвестны, откатывать нечего
@@ -0,0 +1,6 @@
+-- region quests.cost: в старых базах колонка могла существовать до 0002_constraints, тогда ADD COLUMN IF NOT EXISTS
+-- пропускался и колонка оставалась без значения по умолчанию и NOT NULL. Приводим ее к виду, который ожидает код
+UPDATE quests SET cost = 0 WHERE cost IS NULL;
+ALTER TABLE quests ALTER COLUMN cost SET DEFAULT 0;
+ALTER TABLE quests ALTER COLUMN cost SET NOT NULL;
+-- endregion
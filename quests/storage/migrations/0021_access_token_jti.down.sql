@@ -0,0 +1,2 @@
+ALTER TABLE refresh_tokens DROP COLUMN access_expires_at;
+ALTER TABLE refresh_tokens DROP COLUMN access_jti;
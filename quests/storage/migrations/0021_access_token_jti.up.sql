@@ -0,0 +1,5 @@
+-- region refresh_tokens: идентификатор и срок действия access токена, выпущенного вместе с refresh токеном.
+-- По ним access токены пользователя добавляются в revoked_tokens при изменении его ролей и удалении
+ALTER TABLE refresh_tokens ADD COLUMN access_jti varchar(64);
+ALTER TABLE refresh_tokens ADD COLUMN access_expires_at timestamptz;
+-- endregion
@@ -0,0 +1,2 @@
+DROP TRIGGER bonus_ledger_no_delete ON bonus_ledger;
+DROP FUNCTION bonus_ledger_no_delete();
@@ -0,0 +1,16 @@
+-- region bonus_ledger: записи журнала нельзя удалить напрямую, ошибочная запись компенсируется записью reversal.
+-- Удаление допускается только каскадом вместе с пользователем, историей или заданием (ON DELETE CASCADE):
+-- каскадное удаление выполняет триггер внешнего ключа, поэтому глубина вложенности триггеров больше 1
+CREATE FUNCTION bonus_ledger_no_delete() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+    IF pg_trigger_depth() > 1 THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION 'bonus_ledger is append-only, add a reversal entry instead';
+END
+$$;
+
+CREATE TRIGGER bonus_ledger_no_delete BEFORE DELETE ON bonus_ledger
+FOR EACH ROW EXECUTE FUNCTION bonus_ledger_no_delete();
+-- endregion
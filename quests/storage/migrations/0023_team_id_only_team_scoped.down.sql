@@ -0,0 +1 @@
+-- Исправление данных: прежние ошибочные ссылки на команду не восстанавливаются, откатывать нечего
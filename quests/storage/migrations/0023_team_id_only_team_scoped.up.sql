@@ -0,0 +1,32 @@
+-- region history, bonus_ledger: команда сохраняется только у выполнений командных заданий. Раньше ее записывали
+-- для любого задания участника команды, и бонусы за личные задания попадали в счет и рейтинг команды.
+-- Командность определяется по текущему значению quests.team_scoped
+UPDATE history AS h
+SET team_id = NULL
+FROM questSteps AS s
+JOIN quests AS q ON q.id = s.questId
+WHERE s.id = h.stepId AND h.team_id IS NOT NULL AND NOT q.team_scoped;
+
+-- журнал не меняется (bonus_ledger_no_update), на время исправления триггер отключается
+ALTER TABLE bonus_ledger DISABLE TRIGGER bonus_ledger_no_update;
+
+UPDATE bonus_ledger AS l
+SET team_id = NULL
+FROM quests AS q
+WHERE l.team_id IS NOT NULL AND NOT q.team_scoped
+    AND q.id = coalesce(l.quest_id, (SELECT s.questId FROM history AS h JOIN questSteps AS s ON s.id = h.stepId WHERE h.id = l.history_id));
+
+ALTER TABLE bonus_ledger ENABLE TRIGGER bonus_ledger_no_update;
+-- endregion
+
+-- region пересчет рейтинга команд по исправленному журналу
+TRUNCATE team_leaderboard_scores;
+
+SELECT team_leaderboard_add(l.team_id, coalesce(l.quest_id, s.questId), l.amount, coalesce(o.created_at, l.created_at), l.created_at)
+FROM bonus_ledger AS l
+LEFT JOIN bonus_ledger AS o ON o.id = l.reverses_id
+LEFT JOIN history AS h ON h.id = l.history_id
+LEFT JOIN questSteps AS s ON s.id = h.stepId
+WHERE l.team_id IS NOT NULL AND (l.history_id IS NOT NULL OR l.quest_id IS NOT NULL)
+ORDER BY l.id;
+-- endregion
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"techno-test_quests/quests/response"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+//region постраничная выдача
+
+// Ограничения размера страницы
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 200
+)
+
+// Page model info
+// @Description Page страница списка. Для получения следующей страницы передайте next_cursor в параметре cursor
+type Page[T any] struct {
+	Items      []T     `json:"items"`       //Элементы страницы, пустой массив, если элементов нет
+	NextCursor *string `json:"next_cursor"` //Курсор следующей страницы, null на последней странице
+}
+
+// PageRequest параметры постраничной выдачи: размер страницы, сортировка и позиция, с которой продолжается выдача.
+// Используется keyset пагинация: следующая страница начинается после последней записи предыдущей по (колонка сортировки, id)
+type PageRequest struct {
+	Limit  int    //Кол-во элементов на странице, 0 - без ограничения
+	Sort   string //Ключ сортировки
+	Desc   bool   //Сортировка по убыванию
+	column string
+	after  *pageCursor
+}
+
+// pageCursor содержимое курсора: сортировка, для которой он выдан, и ключ последней записи страницы
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Id    int    `json:"id"`
+}
+
+// ParsePageRequest разбирает параметры limit, sort и cursor. columns сопоставляет ключи сортировки с колонками таблицы,
+// ключ с префиксом "-" означает сортировку по убыванию
+func ParsePageRequest(query url.Values, columns map[string]string, defaultSort string) (PageRequest, error) {
+	page := PageRequest{Limit: DefaultPageLimit, Sort: defaultSort}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > MaxPageLimit {
+			return page, response.Field("limit", response.RuleRange, 1, MaxPageLimit)
+		}
+		page.Limit = limit
+	}
+
+	if value := query.Get("sort"); value != "" {
+		page.Sort, page.Desc = strings.CutPrefix(value, "-")
+	}
+	column, ok := columns[page.Sort]
+	if !ok {
+		keys := make([]string, 0, len(columns))
+		for key := range columns {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		return page, response.Field("sort", response.RuleOneOf, strings.Join(keys, ", ")+", -"+strings.Join(keys, ", -"))
+	}
+	page.column = column
+
+	if value := query.Get("cursor"); value != "" {
+		var cursor pageCursor
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err == nil {
+			err = json.Unmarshal(data, &cursor)
+		}
+		if err != nil || cursor.Sort != page.sortKey() {
+			return page, response.Field("cursor", response.RuleCursor)
+		}
+		page.after = &cursor
+	}
+	return page, nil
+}
+
+func (page PageRequest) sortKey() string {
+	if page.Desc {
+		return "-" + page.Sort
+	}
+	return page.Sort
+}
+
+// sortByColumn возвращает true, если кроме id сортировка идет по другой колонке
+func (page PageRequest) sortByColumn() bool {
+	return page.column != "" && page.column != "id"
+}
+
+// OrderBy возвращает выражения сортировки. alias - псевдоним таблицы, может быть пустым
+func (page PageRequest) OrderBy(alias string) []string {
+	prefix, direction := columnPrefix(alias), " ASC"
+	if page.Desc {
+		direction = " DESC"
+	}
+	if page.sortByColumn() {
+		return []string{prefix + page.column + direction, prefix + "id" + direction}
+	}
+	return []string{prefix + "id" + direction}
+}
+
+// Condition возвращает условие выборки записей после курсора или nil для первой страницы
+func (page PageRequest) Condition(alias string) dbx.Expression {
+	if page.after == nil {
+		return nil
+	}
+	prefix, operator := columnPrefix(alias), " > "
+	if page.Desc {
+		operator = " < "
+	}
+	params := dbx.Params{"cursor_id": page.after.Id}
+	if !page.sortByColumn() {
+		return dbx.NewExp(prefix+"id"+operator+"{:cursor_id}", params)
+	}
+	params["cursor_value"] = page.after.Value
+	column := prefix + page.column
+	return dbx.NewExp("("+column+operator+"{:cursor_value} OR ("+column+" = {:cursor_value} AND "+prefix+"id"+operator+"{:cursor_id}))", params)
+}
+
+// Apply добавляет к запросу условие курсора, сортировку и ограничение. Выбирается на одну запись больше,
+// чтобы NewPage могла определить, есть ли следующая страница
+func (page PageRequest) Apply(q *dbx.SelectQuery, alias string) *dbx.SelectQuery {
+	if condition := page.Condition(alias); condition != nil {
+		q.AndWhere(condition)
+	}
+	q.OrderBy(page.OrderBy(alias)...)
+	if page.Limit > 0 {
+		q.Limit(int64(page.Limit + 1))
+	}
+	return q
+}
+
+// NewPage формирует страницу из выбранных через Apply записей. key возвращает значение колонки сортировки и id записи
+func NewPage[T any](page PageRequest, items []T, key func(T) (string, int)) Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+	result := Page[T]{Items: items}
+	if page.Limit > 0 && len(items) > page.Limit {
+		result.Items = items[:page.Limit]
+		value, id := key(result.Items[page.Limit-1])
+		data, _ := json.Marshal(pageCursor{Sort: page.sortKey(), Value: value, Id: id})
+		cursor := base64.RawURLEncoding.EncodeToString(data)
+		result.NextCursor = &cursor
+	}
+	return result
+}
+
+func columnPrefix(alias string) string {
+	if alias == "" {
+		return ""
+	}
+	return alias + "."
+}
+
+//endregion постраничная выдача
+
+//region фильтры
+
+// CursorTime форматирует время для курсора без потери точности
+func CursorTime(value time.Time) string {
+	return value.Format(time.RFC3339Nano)
+}
+
+// ContainsPattern возвращает шаблон ILIKE для поиска подстроки, экранируя спецсимволы
+func ContainsPattern(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(value) + "%"
+}
+
+// ParseBoolParam разбирает необязательный логический параметр запроса
+func ParseBoolParam(query url.Values, name string) (*bool, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	result, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, response.Field(name, response.RuleOneOf, "true, false")
+	}
+	return &result, nil
+}
+
+// ParseTimeParam разбирает необязательный параметр запроса с датой (2006-01-02) или временем в формате RFC3339
+func ParseTimeParam(query url.Values, name string) (*time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if result, err := time.Parse(layout, value); err == nil {
+			return &result, nil
+		}
+	}
+	return nil, response.Field(name, response.RuleDateTime)
+}
+
+//endregion фильтры
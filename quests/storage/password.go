@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Формат хранимого пароля: "<схема>$<хэш>". Схема позволяет менять алгоритм хэширования,
+// не ломая уже сохраненные пароли: при успешном входе пароль в устаревшем формате перехэшируется.
+const (
+	passwordSchemeBcrypt = "bcrypt-v1"
+	passwordBcryptCost   = 12
+)
+
+// dummyPasswordHash используется для сравнения, когда пользователь не найден, чтобы время ответа
+// не выдавало существование логина.
+var dummyPasswordHash, _ = HashPassword("dummy-password")
+
+// HashPassword возвращает соленый хэш пароля в текущем формате
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordBcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return passwordSchemeBcrypt + "$" + string(hash), nil
+}
+
+// CheckPassword проверяет пароль по сохраненному хэшу.
+// needsRehash = true, если пароль верный, но хранится в устаревшем формате и его нужно перехэшировать.
+func CheckPassword(storedHash, password string) (ok bool, needsRehash bool) {
+	scheme, hash, found := strings.Cut(storedHash, "$")
+	if found && scheme == passwordSchemeBcrypt {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return false, false
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return true, err != nil || cost != passwordBcryptCost
+	}
+
+	// Пароль в старом обратимом формате, сравниваем за постоянное время
+	legacy := legacyEncodePassword(password)
+	if subtle.ConstantTimeCompare([]byte(storedHash), []byte(legacy)) == 1 {
+		return true, true
+	}
+	return false, false
+}
+
+// CheckDummyPassword выполняет проверку пароля с фиктивным хэшем, выравнивая время ответа для несуществующих пользователей
+func CheckDummyPassword(password string) {
+	CheckPassword(dummyPasswordHash, password)
+}
+
+// legacyEncodePassword старый формат хранения пароля. Используется только для проверки паролей,
+// которые еще не были перехэшированы.
+func legacyEncodePassword(password string) string {
+	passwordNew := password + "@1"
+	bs := []byte(passwordNew)
+	for i := range bs {
+		bs[i] = bs[i] + 1
+	}
+	return string(bs)
+}
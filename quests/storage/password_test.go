@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCheckPasswordBcrypt(t *testing.T) {
+	hash, err := HashPassword("s3cret")
+	if err != nil {
+		t.Fatalf("HashPassword: %s", err)
+	}
+	if hash == "s3cret" || hash[:len(passwordSchemeBcrypt)+1] != passwordSchemeBcrypt+"$" {
+		t.Fatalf("unexpected hash format %q", hash)
+	}
+
+	if ok, needsRehash := CheckPassword(hash, "s3cret"); !ok || needsRehash {
+		t.Fatalf("correct password: ok=%v needsRehash=%v, want ok without rehash", ok, needsRehash)
+	}
+	if ok, _ := CheckPassword(hash, "wrong"); ok {
+		t.Fatal("wrong password accepted")
+	}
+
+	//одинаковые пароли дают разные хэши за счет соли
+	other, err := HashPassword("s3cret")
+	if err != nil {
+		t.Fatalf("HashPassword: %s", err)
+	}
+	if other == hash {
+		t.Fatal("hashes of the same password are equal, salt is not used")
+	}
+}
+
+func TestCheckPasswordLegacyNeedsRehash(t *testing.T) {
+	legacy := legacyEncodePassword("s3cret")
+
+	if ok, needsRehash := CheckPassword(legacy, "s3cret"); !ok || !needsRehash {
+		t.Fatalf("legacy password: ok=%v needsRehash=%v, want ok with rehash", ok, needsRehash)
+	}
+	if ok, _ := CheckPassword(legacy, "wrong"); ok {
+		t.Fatal("wrong legacy password accepted")
+	}
+}
+
+func TestCheckPasswordOutdatedCostNeedsRehash(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %s", err)
+	}
+
+	if ok, needsRehash := CheckPassword(passwordSchemeBcrypt+"$"+string(hash), "s3cret"); !ok || !needsRehash {
+		t.Fatalf("outdated cost: ok=%v needsRehash=%v, want ok with rehash", ok, needsRehash)
+	}
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// Коды ошибок PostgreSQL, которые обрабатываются как конфликт данных
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+	pgCheckViolation      = "23514"
+)
+
+// IsUniqueViolation возвращает true, если ошибка вызвана нарушением ограничения уникальности
+func IsUniqueViolation(err error) bool {
+	return pgErrorCode(err) == pgUniqueViolation
+}
+
+// IsForeignKeyViolation возвращает true, если ошибка вызвана нарушением внешнего ключа
+func IsForeignKeyViolation(err error) bool {
+	return pgErrorCode(err) == pgForeignKeyViolation
+}
+
+// IsCheckViolation возвращает true, если ошибка вызвана нарушением ограничения CHECK
+func IsCheckViolation(err error) bool {
+	return pgErrorCode(err) == pgCheckViolation
+}
+
+// ConstraintName возвращает имя нарушенного ограничения или пустую строку
+func ConstraintName(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Constraint
+	}
+	return ""
+}
+
+func pgErrorCode(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code)
+	}
+	return ""
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"slices"
+)
+
+// Principal авторизованный пользователь, выполняющий запрос
+type Principal struct {
+	UserId      int
+	Username    string
+	Roles       []string
+	Permissions []string
+}
+
+// HasPermission возвращает true, если у пользователя есть разрешение
+func (principal Principal) HasPermission(permission string) bool {
+	return slices.Contains(principal.Permissions, permission)
+}
+
+type principalKey struct{}
+
+// WithPrincipal возвращает контекст с информацией об авторизованном пользователе
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext возвращает авторизованного пользователя из контекста запроса
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
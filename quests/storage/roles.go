@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/lib/pq"
+)
+
+// Разрешения, которые проверяются при доступе к методам API
+const (
+	PermSelf          = "self"           //свой профиль, история и выполнение шагов от своего имени
+	PermUsersRead     = "users.read"     //просмотр пользователей
+	PermUsersWrite    = "users.write"    //создание, изменение и удаление пользователей
+	PermRolesManage   = "roles.manage"   //назначение ролей
+	PermQuestsRead    = "quests.read"    //просмотр заданий
+	PermQuestsWrite   = "quests.write"   //создание и изменение заданий
+	PermHistoryRead   = "history.read"   //просмотр истории любого пользователя
+	PermHistoryWrite  = "history.write"  //выполнение шагов за любого пользователя
+	PermBonusWrite    = "bonus.write"    //списание, корректировка и отмена начислений бонусов
+	PermRewardsManage = "rewards.manage" //управление каталогом наград и заявками
+	PermTeamsManage   = "teams.manage"   //создание и удаление команд, управление составом
+	PermBadgesManage  = "badges.manage"  //создание и архивирование значков, проверка правил
+)
+
+// Встроенные роли
+const (
+	RoleAdmin       = "admin"
+	RoleQuestEditor = "quest-editor"
+	RoleOperator    = "operator"
+	RolePlayer      = "player"
+)
+
+// Role model info
+// @Description Role роль и её разрешения
+type Role struct {
+	Id          int      `json:"id" db:"id"`                   //Идентификатор роли
+	Name        string   `json:"name" db:"name"`               //Имя роли
+	Description string   `json:"description" db:"description"` //Описание роли
+	Permissions []string `json:"permissions" db:"-"`           //Разрешения роли
+}
+
+// UserAccess роли и разрешения пользователя
+type UserAccess struct {
+	Roles       []string
+	Permissions []string
+}
+
+// GetRoles возвращает все роли с разрешениями
+func GetRoles(db dbx.Builder) ([]Role, error) {
+	var rows []struct {
+		Role
+		Permission string `db:"permission"`
+	}
+	err := db.NewQuery(`SELECT r.id, r.name, r.description, coalesce(rp.permission, '') AS permission
+						FROM roles AS r
+						LEFT JOIN role_permissions AS rp ON rp.role_id = r.id
+						ORDER BY r.id, rp.permission`).All(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("select script 'roles' complete with error: %s", err)
+	}
+
+	var roles []Role
+	for _, row := range rows {
+		if len(roles) == 0 || roles[len(roles)-1].Id != row.Id {
+			role := row.Role
+			role.Permissions = []string{}
+			roles = append(roles, role)
+		}
+		if row.Permission != "" {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, row.Permission)
+		}
+	}
+	return roles, nil
+}
+
+// GetUserAccess возвращает роли и разрешения пользователя
+func GetUserAccess(db dbx.Builder, userId int) (UserAccess, error) {
+	access := UserAccess{Roles: []string{}, Permissions: []string{}}
+
+	err := db.NewQuery(`SELECT r.name
+						FROM user_roles AS ur
+						JOIN roles AS r ON r.id = ur.role_id
+						WHERE ur.user_id = {:userid}
+						ORDER BY r.name`).Bind(dbx.Params{"userid": userId}).Column(&access.Roles)
+	if err != nil {
+		return access, fmt.Errorf("select script 'user_roles' complete with error: %s", err)
+	}
+
+	err = db.NewQuery(`SELECT DISTINCT rp.permission
+						FROM user_roles AS ur
+						JOIN role_permissions AS rp ON rp.role_id = ur.role_id
+						WHERE ur.user_id = {:userid}
+						ORDER BY rp.permission`).Bind(dbx.Params{"userid": userId}).Column(&access.Permissions)
+	if err != nil {
+		return access, fmt.Errorf("select script 'role_permissions' complete with error: %s", err)
+	}
+	return access, nil
+}
+
+// SetUserRoles заменяет роли пользователя и синхронизирует признак администратора.
+// Возвращает список ролей, которых не существует; в этом случае роли не меняются.
+// Если у пользователя забирается роль последнего администратора, возвращает ErrLastAdmin.
+// Выданные пользователю access токены отзываются, новые роли действуют после входа или обновления токена.
+// Вызывается внутри транзакции
+func SetUserRoles(db dbx.Builder, userId int, roles []string) ([]string, error) {
+	var known []struct {
+		Id   int    `db:"id"`
+		Name string `db:"name"`
+	}
+	err := db.NewQuery("SELECT id, name FROM roles WHERE name = ANY({:roles})").
+		Bind(dbx.Params{"roles": pq.Array(roles)}).All(&known)
+	if err != nil {
+		return nil, fmt.Errorf("select script 'roles' complete with error: %s", err)
+	}
+	knownNames := make(map[string]bool, len(known))
+	for _, role := range known {
+		knownNames[role.Name] = true
+	}
+	var unknown []string
+	for _, role := range roles {
+		if !knownNames[role] && !slices.Contains(unknown, role) {
+			unknown = append(unknown, role)
+		}
+	}
+	if len(unknown) > 0 {
+		return unknown, nil
+	}
+
+	if !slices.Contains(roles, RoleAdmin) {
+		if err = ensureAdminRemains(db, userId); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = db.Delete("user_roles", dbx.HashExp{"user_id": userId}).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("delete script 'user_roles' complete with error: %s", err)
+	}
+	for _, role := range known {
+		_, err = db.Insert("user_roles", dbx.Params{"user_id": userId, "role_id": role.Id}).Execute()
+		if err != nil {
+			return nil, fmt.Errorf("insert script 'user_roles' complete with error: %s", err)
+		}
+	}
+
+	_, err = db.Update("users", dbx.Params{"isadmin": slices.Contains(roles, RoleAdmin)}, dbx.HashExp{"id": userId}).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("update script 'users' complete with error: %s", err)
+	}
+	return nil, RevokeAccessTokens(db, userId)
+}
+
+// DefaultRoles роли нового пользователя
+func DefaultRoles(isAdmin bool) []string {
+	if isAdmin {
+		return []string{RoleAdmin, RolePlayer}
+	}
+	return []string{RolePlayer}
+}
@@ -1,17 +1,18 @@
 package storage
 
 import (
-	"encoding/json"
 	"fmt"
 	dbx "github.com/go-ozzo/ozzo-dbx"
 	"log/slog"
 	"net/http"
-	"strings"
+	"techno-test_quests/quests/response"
 	"time"
+	"unicode/utf8"
 )
 
 type Storage struct {
-	DB *dbx.DB
+	DB     *dbx.DB
+	Logger *slog.Logger //журнал для сообщений миграций, если не задан - slog.Default()
 }
 
 // region типы для выполнения шагов
@@ -23,23 +24,36 @@ type NewCompleteSteps struct {
 }
 
 type CompleteStep struct {
-	//TODO вообще правильно ИД пользователя не передавать, а брать из авторизации, но тогда будет сложно тестировать, с учетом того, что это тестовое задание, то будем передавать
-	Stepid int `json:"stepid"` //Идентификатор шага
-	Userid int `json:"userid"` //Идентификатор пользователя выполневшего шаг
+	Stepid         int    `json:"stepid"`          //Идентификатор шага
+	Userid         int    `json:"userid"`          //Идентификатор пользователя выполневшего шаг. В /me/CompleteSteps игнорируется и берется из авторизации
+	Source         string `json:"source"`          //Источник отметки о выполнении, необязательно
+	IdempotencyKey string `json:"idempotency_key"` //Ключ идемпотентности в пределах пользователя, повторный запрос с тем же ключом не создает новую запись. Необязательно
 }
 
-func (complete *CompleteStep) ConvertToDB() (CompleteStepDB, []ErrorList) {
-	var errlist []ErrorList
+func (complete *CompleteStep) ConvertToDB() (CompleteStepDB, []response.FieldError) {
+	var errlist []response.FieldError
 
 	completeDB := CompleteStepDB{}
 	if complete.Stepid == 0 {
-		errlist = append(errlist, ErrorList{"Идентификатор шага должен быть больше 0"})
+		errlist = append(errlist, response.Field("stepid", response.RulePositive))
 	}
 	if complete.Userid == 0 {
-		errlist = append(errlist, ErrorList{"Идентификатор пользователя должен быть больше 0"})
+		errlist = append(errlist, response.Field("userid", response.RulePositive))
+	}
+	if utf8.RuneCountInString(complete.Source) > 50 {
+		errlist = append(errlist, response.Field("source", response.RuleMaxLength, 50))
+	}
+	if utf8.RuneCountInString(complete.IdempotencyKey) > 100 {
+		errlist = append(errlist, response.Field("idempotency_key", response.RuleMaxLength, 100))
 	}
 	completeDB.Stepid = complete.Stepid
 	completeDB.Userid = complete.Userid
+	if complete.Source != "" {
+		completeDB.Source = &complete.Source
+	}
+	if complete.IdempotencyKey != "" {
+		completeDB.IdempotencyKey = &complete.IdempotencyKey
+	}
 
 	if len(errlist) > 0 {
 		return completeDB, errlist
@@ -49,8 +63,13 @@ func (complete *CompleteStep) ConvertToDB() (CompleteStepDB, []ErrorList) {
 }
 
 type CompleteStepDB struct {
-	Stepid int `json:"stepid"` //Идентификатор шага
-	Userid int `json:"userid"` //Идентификатор пользователя выполневшего шаг
+	Id             int     `json:"id" db:"id"`                           //Идентификатор записи истории
+	Stepid         int     `json:"stepid" db:"stepid"`                   //Идентификатор шага
+	Userid         int     `json:"userid" db:"userid"`                   //Идентификатор пользователя выполневшего шаг
+	RecordedBy     *int    `json:"recorded_by" db:"recorded_by"`         //Идентификатор пользователя, записавшего выполнение
+	TeamId         *int    `json:"team_id" db:"team_id"`                 //Команда пользователя на момент выполнения
+	Source         *string `json:"source" db:"source"`                   //Источник отметки о выполнении
+	IdempotencyKey *string `json:"idempotency_key" db:"idempotency_key"` //Ключ идемпотентности
 }
 
 func (quest *CompleteStepDB) TableName() string {
@@ -61,24 +80,65 @@ func (quest *CompleteStepDB) TableName() string {
 
 //region типы для создания новых заданий
 
+// Периодичность задания: у периодического задания однократные шаги можно выполнить один раз за период
+const (
+	RecurrenceNone   = "none"
+	RecurrenceDaily  = "daily"
+	RecurrenceWeekly = "weekly"
+)
+
+// ValidRecurrence возвращает true для допустимого значения периодичности
+func ValidRecurrence(recurrence string) bool {
+	return recurrence == RecurrenceNone || recurrence == RecurrenceDaily || recurrence == RecurrenceWeekly
+}
+
 // NewQuest model info
 // @Description NewQuest json для создания задания с шагами
 type NewQuest struct {
-	Id         int            `json:"id"`         //Идентификатор задания
-	Name       string         `json:"Name"`       //Имя задания
-	QuestSteps []NewQuestStep `json:"QuestSteps"` //Шаги задания
+	Id          int            `json:"id"`          //Идентификатор задания
+	Name        string         `json:"Name"`        //Имя задания
+	Description string         `json:"Description"` //Описание задания
+	StartsAt    *time.Time     `json:"StartsAt"`    //Начало проведения задания, если не указано - задание доступно сразу
+	EndsAt      *time.Time     `json:"EndsAt"`      //Окончание проведения задания, если не указано - задание доступно бессрочно
+	Recurrence  string         `json:"Recurrence"`  //Периодичность: none (по умолчанию), daily или weekly
+	Cost        int            `json:"Cost"`        //Бонус за завершение задания, начисляется один раз после выполнения всех шагов
+	TeamScoped  bool           `json:"TeamScoped"`  //Командное задание: выполнение шага участником засчитывается всей его команде
+	QuestSteps  []NewQuestStep `json:"QuestSteps"`  //Шаги задания
+
+	RequiresQuests []int `json:"RequiresQuests"` //Идентификаторы заданий, которые пользователь должен завершить перед выполнением шагов задания
 }
 
-func (quest *NewQuest) ConvertToDB() (NewQuestDB, []ErrorList) {
-	var errlist []ErrorList
+func (quest *NewQuest) ConvertToDB() (NewQuestDB, []response.FieldError) {
+	var errlist []response.FieldError
 
 	questdb := NewQuestDB{}
 	questdb.Id = quest.Id
 
 	if quest.Name == "" {
-		errlist = append(errlist, ErrorList{"Имя задания должно содержать от 1 до 200 символов"})
+		errlist = append(errlist, response.Field("Name", response.RuleLength, 1, 200))
 	}
 	questdb.Name = quest.Name
+	questdb.Description = quest.Description
+
+	if quest.StartsAt != nil && quest.EndsAt != nil && !quest.EndsAt.After(*quest.StartsAt) {
+		errlist = append(errlist, response.Field("EndsAt", response.RuleAfter, "StartsAt"))
+	}
+	questdb.StartsAt = quest.StartsAt
+	questdb.EndsAt = quest.EndsAt
+
+	if quest.Cost < 0 {
+		errlist = append(errlist, response.Field("Cost", response.RuleNotNegative))
+	}
+	questdb.Cost = quest.Cost
+	questdb.TeamScoped = quest.TeamScoped
+
+	questdb.Recurrence = quest.Recurrence
+	if questdb.Recurrence == "" {
+		questdb.Recurrence = RecurrenceNone
+	}
+	if !ValidRecurrence(questdb.Recurrence) {
+		errlist = append(errlist, response.Field("Recurrence", response.RuleOneOf, "none, daily, weekly"))
+	}
 
 	if len(errlist) > 0 {
 		return questdb, errlist
@@ -88,9 +148,14 @@ func (quest *NewQuest) ConvertToDB() (NewQuestDB, []ErrorList) {
 }
 
 type NewQuestDB struct {
-	Id   int    `json:"id" db:"id"`          //идентификатор задания
-	Name string `json:"Name" db:"questname"` //Имя задания
-	Cost int    `json:"Cost" db:"cost"`      //Стоимость задания
+	Id          int        `json:"id" db:"id"`                   //идентификатор задания
+	Name        string     `json:"Name" db:"questname"`          //Имя задания
+	Description string     `json:"Description" db:"description"` //Описание задания
+	StartsAt    *time.Time `json:"StartsAt" db:"starts_at"`      //Начало проведения задания
+	EndsAt      *time.Time `json:"EndsAt" db:"ends_at"`          //Окончание проведения задания
+	Recurrence  string     `json:"Recurrence" db:"recurrence"`   //Периодичность задания
+	Cost        int        `json:"Cost" db:"cost"`               //Бонус за завершение задания
+	TeamScoped  bool       `json:"TeamScoped" db:"team_scoped"`  //Командное задание
 }
 
 func (quest *NewQuestDB) TableName() string {
@@ -107,34 +172,110 @@ type NewQuestSteps struct {
 	QuestSteps []NewQuestStep `json:"QuestSteps"` //Идентификатор задания
 }
 
+// StepLimits правила выполнения шага. Значение 0 означает отсутствие ограничения
+type StepLimits struct {
+	MaxCompletions  *int `json:"MaxCompletions"`  //Сколько раз пользователь может выполнить шаг (в периодическом задании - за период). Задает и IsMulti: IsMulti = MaxCompletions != 1
+	CooldownSeconds *int `json:"CooldownSeconds"` //Минимальный интервал между выполнениями шага одним пользователем в секундах
+	GlobalLimit     *int `json:"GlobalLimit"`     //Сколько раз шаг могут выполнить все пользователи вместе
+}
+
+// UpdateParams проверяет правила и возвращает изменяемые колонки шага. isMulti - переданный признак многократного выполнения,
+// без MaxCompletions false означает одно выполнение, true снимает ограничение в одно выполнение
+func (limits StepLimits) UpdateParams(isMulti *bool) (dbx.Params, []response.FieldError) {
+	var errlist []response.FieldError
+	params := dbx.Params{}
+	for _, limit := range []struct {
+		value  *int
+		column string
+		field  string
+	}{
+		{limits.MaxCompletions, "max_completions", "MaxCompletions"},
+		{limits.CooldownSeconds, "cooldown_seconds", "CooldownSeconds"},
+		{limits.GlobalLimit, "global_limit", "GlobalLimit"},
+	} {
+		switch {
+		case limit.value == nil:
+		case *limit.value < 0:
+			errlist = append(errlist, response.Field(limit.field, response.RuleNotNegative))
+		case *limit.value == 0:
+			params[limit.column] = nil
+		default:
+			params[limit.column] = *limit.value
+		}
+	}
+
+	switch {
+	case limits.MaxCompletions != nil:
+		multi := *limits.MaxCompletions != 1
+		if isMulti != nil && *isMulti != multi {
+			errlist = append(errlist, response.Field("IsMulti", response.RuleConflicts, "MaxCompletions"))
+		}
+		params["ismulti"] = multi
+	case isMulti != nil && *isMulti:
+		params["ismulti"] = true
+		params["max_completions"] = dbx.NewExp("NULLIF(max_completions, 1)")
+	case isMulti != nil:
+		params["ismulti"] = false
+		params["max_completions"] = 1
+	}
+	return params, errlist
+}
+
+// values возвращает значения правил для нового шага. Без MaxCompletions однократный шаг получает ограничение в одно выполнение
+func (limits StepLimits) values(isMulti *bool) (maxCompletions, cooldown, global *int, multi bool) {
+	positive := func(value *int) *int {
+		if value == nil || *value <= 0 {
+			return nil
+		}
+		return value
+	}
+	maxCompletions, cooldown, global = positive(limits.MaxCompletions), positive(limits.CooldownSeconds), positive(limits.GlobalLimit)
+	if limits.MaxCompletions == nil && (isMulti == nil || !*isMulti) {
+		one := 1
+		maxCompletions = &one
+	}
+	return maxCompletions, cooldown, global, maxCompletions == nil || *maxCompletions != 1
+}
+
 type NewQuestStep struct {
 	Id       int    `json:"id"`       //Идентификатор задания
 	QuestId  int    `json:"QuestId"`  //Идентификатор задания. При создании методом CreateQuest, значение будет проигнорировано, т.к. будет подставляться идентификатор создаваемого задания
 	StepName string `json:"StepName"` //Описание шага
 	Bonus    int    `json:"Bonus"`    //Бонус за задание
 	IsMulti  *bool  `json:"IsMulti"`  //Признак того, что шаг можно выполнять несколько раз
+	StepLimits
+
+	Position      *int     `json:"Position"`      //Порядковый номер шага в задании, если не указан - шаг добавляется в конец
+	RequiresSteps []string `json:"RequiresSteps"` //Имена шагов того же задания, которые нужно выполнить перед этим шагом
 }
 
-func (questStep *NewQuestStep) ConvertToDB() (NewQuestStepDB, []ErrorList) {
-	var errlist []ErrorList
+func (questStep *NewQuestStep) ConvertToDB() (NewQuestStepDB, []response.FieldError) {
+	var errlist []response.FieldError
 
 	questStepDB := NewQuestStepDB{}
 	questStepDB.Id = questStep.Id
 
 	if questStep.StepName == "" {
-		errlist = append(errlist, ErrorList{"Не указано описание шага"})
+		errlist = append(errlist, response.Field("StepName", response.RuleRequired))
 	}
 	if questStep.QuestId <= 0 {
-		errlist = append(errlist, ErrorList{"Не указан идентификатор задания, к которому относится шаг"})
+		errlist = append(errlist, response.Field("QuestId", response.RuleRequired))
 	}
 
 	if questStep.Bonus < 0 {
-		errlist = append(errlist, ErrorList{"Бонус не может быть меньше 0"})
+		errlist = append(errlist, response.Field("Bonus", response.RuleNotNegative))
 	}
 
-	if questStep.IsMulti == nil {
-		questStepDB.IsMulti = false
+	if questStep.Position != nil && *questStep.Position <= 0 {
+		errlist = append(errlist, response.Field("Position", response.RulePositive))
 	}
+	if questStep.Position != nil {
+		questStepDB.Position = *questStep.Position
+	}
+	if _, limitErrors := questStep.UpdateParams(questStep.IsMulti); len(limitErrors) > 0 {
+		errlist = append(errlist, limitErrors...)
+	}
+	questStepDB.MaxCompletions, questStepDB.CooldownSeconds, questStepDB.GlobalLimit, questStepDB.IsMulti = questStep.values(questStep.IsMulti)
 
 	questStepDB.QuestId = questStep.QuestId
 	questStepDB.Bonus = questStep.Bonus
@@ -157,22 +298,25 @@ type UpdateQuestStep struct {
 	Id      int   `json:"id"`      //Идентификатор задания
 	Bonus   int   `json:"Bonus"`   //Бонус за задание
 	IsMulti *bool `json:"IsMulti"` //Признак того, что шаг можно выполнять несколько раз
+	StepLimits
 }
 
-func (questStep *UpdateQuestStep) ConvertToDB() (NewQuestStepDB, []ErrorList) {
-	var errlist []ErrorList
+func (questStep *UpdateQuestStep) ConvertToDB() (NewQuestStepDB, []response.FieldError) {
+	var errlist []response.FieldError
 
 	questStepDB := NewQuestStepDB{}
 	questStepDB.Id = questStep.Id
 
 	if questStep.Id == 0 {
-		errlist = append(errlist, ErrorList{"Не указан идентификатор шага, который необходимо обновить"})
+		errlist = append(errlist, response.Field("id", response.RuleRequired))
 	}
-	if questStep.IsMulti == nil {
-		errlist = append(errlist, ErrorList{"Укажите признак многократного выполнения"})
+	if questStep.IsMulti == nil && questStep.MaxCompletions == nil {
+		errlist = append(errlist, response.Field("IsMulti", response.RuleRequiredOneOf, "IsMulti", "MaxCompletions"))
 	}
+	limits, limitErrors := questStep.UpdateParams(questStep.IsMulti)
+	errlist = append(errlist, limitErrors...)
 	questStepDB.Bonus = questStep.Bonus
-	questStepDB.IsMulti = *questStep.IsMulti
+	questStepDB.limits = limits
 
 	if len(errlist) > 0 {
 		return questStepDB, errlist
@@ -187,6 +331,13 @@ type NewQuestStepDB struct {
 	StepName string `json:"StepName" db:"stepname"`
 	Bonus    int    `json:"Bonus" db:"bonus"`
 	IsMulti  bool   `json:"IsMulti" db:"ismulti"`
+
+	MaxCompletions  *int `json:"MaxCompletions" db:"max_completions"`
+	CooldownSeconds *int `json:"CooldownSeconds" db:"cooldown_seconds"`
+	GlobalLimit     *int `json:"GlobalLimit" db:"global_limit"`
+	Position        int  `json:"Position" db:"position"`
+
+	limits dbx.Params //изменяемые правила выполнения шага, заполняются UpdateQuestStep.ConvertToDB
 }
 
 func (quest *NewQuestStepDB) TableName() string {
@@ -199,16 +350,14 @@ func (questStep *NewQuestStepDB) GetUpdatesData() map[string]interface{} {
 	if questStep.Bonus > 0 {
 		data["bonus"] = questStep.Bonus
 	}
-	data["ismulti"] = questStep.IsMulti
+	for column, value := range questStep.limits {
+		data[column] = value
+	}
 	return data
 }
 
 //endregion типы для создания и обновления шагов заданий
 
-type ErrorList struct {
-	Error string
-}
-
 //region инициализация
 
 // New возвращает соединение с БД
@@ -222,24 +371,16 @@ func New(storagePath string) (*Storage, error) {
 	return &Storage{DB: db}, nil
 }
 
-// Init инициализирует БД
+// Init инициализирует БД: применяет миграции и создает пользователя администратора
 func (storage *Storage) Init() error {
-	//region Создаем таблицу Пользователей, индекс и пользователя администратора
-	queryText := `CREATE TABLE IF NOT EXISTS users (
-								id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
-								userName varchar(20) NOT NULL,
-								password varchar(20) NOT NULL,
-								isAdmin boolean	 NOT NULL
-								)`
-	q := storage.DB.NewQuery(queryText)
-	_, err := q.Execute()
+	err := storage.MigrateUp()
 	if err != nil {
-		return fmt.Errorf("create table 'Users' complete with error: %s", err.Error())
+		return err
 	}
 
 	//проверяем существует ли администратор, если нет - создаем.
-	queryText = `Select count(*) as count from USERS where username ='admin'`
-	q = storage.DB.NewQuery(queryText)
+	queryText := `Select count(*) as count from USERS where username ='admin'`
+	q := storage.DB.NewQuery(queryText)
 	resultRows, err := q.Rows()
 	if err != nil {
 		return fmt.Errorf("check Admin user complete with error: %s", err)
@@ -250,55 +391,25 @@ func (storage *Storage) Init() error {
 	}
 
 	if count == 0 {
-		queryText = "INSERT INTO USERS(username, password, isAdmin) VALUES ( 'admin', " + "'" + EncodePassword("123") + "', true )"
-		q = storage.DB.NewQuery(queryText)
-		_, err = q.Execute()
+		hashPass, err := HashPassword("123")
+		if err != nil {
+			return fmt.Errorf("create Admin user complete with error: %s", err)
+		}
+		err = storage.DB.Transactional(func(tx *dbx.Tx) error {
+			var adminId int
+			queryText := "INSERT INTO USERS(username, password, isAdmin) VALUES ( 'admin', {:password}, true ) RETURNING id"
+			err := tx.NewQuery(queryText).Bind(dbx.Params{"password": hashPass}).Row(&adminId)
+			if err != nil {
+				return err
+			}
+			_, err = SetUserRoles(tx, adminId, DefaultRoles(true))
+			return err
+		})
 		if err != nil {
 			return fmt.Errorf("create Admin user complete with error: %s", err)
 		}
 	}
 
-	//endregion
-
-	//region TODO Создаем таблицу Заданий
-	queryText = `CREATE TABLE IF NOT EXISTS quests (
-								id integer GENERATED BY DEFAULT AS IDENTITY,
-								questName varchar(200) NOT NULL,
-    							PRIMARY KEY (questName)
-								)`
-	q = storage.DB.NewQuery(queryText)
-	_, err = q.Execute()
-	if err != nil {
-		return fmt.Errorf("create table 'quests' complete with error: %s", err.Error())
-	}
-	//endregion
-
-	//region TODO Создаем таблицу questSteps
-	queryText = `CREATE TABLE IF NOT EXISTS questSteps (
-								id integer PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
-								questID integer NOT NULL,
-								stepName varchar(200) NOT NULL,
-								bonus integer,
-    							isMulti bool NOT NULL
-								)`
-	q = storage.DB.NewQuery(queryText)
-	_, err = q.Execute()
-	if err != nil {
-		return fmt.Errorf("create questSteps 'films' complete with error: %s", err.Error())
-	}
-
-	//region TODO Создаем таблицу history
-	queryText = `CREATE TABLE IF NOT EXISTS history (
-								stepId integer NOT NULL,
-								userId integer NOT NULL
-								)`
-	q = storage.DB.NewQuery(queryText)
-	_, err = q.Execute()
-	if err != nil {
-		return fmt.Errorf("create table 'history' complete with error: %s", err.Error())
-	}
-	//endregion
-
 	return nil
 }
 
@@ -306,51 +417,31 @@ func (storage *Storage) Init() error {
 
 //region Системные методы
 
-// EncodePassword функция возвращает хэш для пароля
-func EncodePassword(password string) string {
-	// Для пэт проекта сделаем просто, добавим в конец к паролю @1 и сдвинем каждый символы на 1
-	passwordNew := password + "@1"
-	bs := []byte(passwordNew)
-	for i := range bs {
-		bs[i] = bs[i] + 1
-	}
-	return string(bs)
-}
-
-// DecodePassword Функция возвращает пароль по хэшу
-func DecodePassword(password string) string {
-	bs := []byte(password)
-	for i := range bs {
-		bs[i] = bs[i] - 1
-	}
-	pass := string(bs)
-	return pass[0:strings.LastIndex(pass, "@1")]
-}
-
 // TODO Тело запроса
 func RequestTolog(r *http.Request, logger *slog.Logger) {
-	username, _, ok := r.BasicAuth()
-	if !ok {
-		username = ""
+	username := ""
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		username = principal.Username
+	} else if basicUser, _, ok := r.BasicAuth(); ok {
+		username = basicUser
 	}
 	logger.Debug(
 		"incoming request",
 		"url", r.URL.Path,
 		"method", r.Method,
 		"user", username,
+		"request_id", response.RequestId(r.Context()),
 		"time", time.Now().Format("02-01-2006 15:04:05"),
 	)
 }
 
-func HttpResponse(w http.ResponseWriter, status int, text string) {
-	w.WriteHeader(status)
-	result, _ := json.MarshalIndent(text, "", "\t")
-	w.Write([]byte(result))
-}
-
-func HttpResponseObject(w http.ResponseWriter, status int, text []byte) {
-	w.WriteHeader(status)
-	w.Write(text)
+// DeprecatedRoute помечает устаревший маршрут заголовками Deprecation и Link на маршрут, который его заменяет
+func DeprecatedRoute(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		next(w, r)
+	}
 }
 
 //endregion Системные методы
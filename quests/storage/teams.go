@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// UserTeamId возвращает команду пользователя или nil, если пользователь не состоит в команде
+func UserTeamId(db dbx.Builder, userId int) (*int, error) {
+	var teamId int
+	err := db.NewQuery("SELECT team_id FROM team_members WHERE user_id = {:userid}").
+		Bind(dbx.Params{"userid": userId}).Row(&teamId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("select script 'team_members' complete with error: %w", err)
+	}
+	return &teamId, nil
+}
+
+// TeamBonus возвращает сумму бонусов, заработанных участниками команды в ее составе, с учетом отмен начислений
+func TeamBonus(db dbx.Builder, teamId int) (int, error) {
+	var total int
+	err := db.NewQuery("SELECT coalesce(sum(amount), 0) FROM bonus_ledger WHERE team_id = {:teamid}").
+		Bind(dbx.Params{"teamid": teamId}).Row(&total)
+	if err != nil {
+		return 0, fmt.Errorf("select script 'bonus_ledger' complete with error: %w", err)
+	}
+	return total, nil
+}
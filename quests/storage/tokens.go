@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"fmt"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// RevokeRefreshTokens отзывает все действующие refresh токены пользователя
+func RevokeRefreshTokens(db dbx.Builder, userId int) error {
+	_, err := db.NewQuery("UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = {:userid} AND revoked_at IS NULL").
+		Bind(dbx.Params{"userid": userId}).Execute()
+	if err != nil {
+		return fmt.Errorf("update script 'refresh_tokens' complete with error: %s", err)
+	}
+	return nil
+}
+
+// RevokeAccessTokens отзывает все действующие access токены пользователя, добавляя их в revoked_tokens.
+// Роли и разрешения берутся из токена, поэтому токены отзываются при изменении ролей, имени и удалении пользователя.
+// Сервис перестает принимать токены после синхронизации списка отозванных токенов
+func RevokeAccessTokens(db dbx.Builder, userId int) error {
+	_, err := db.NewQuery(`INSERT INTO revoked_tokens (jti, expires_at)
+						SELECT access_jti, access_expires_at FROM refresh_tokens
+						WHERE user_id = {:userid} AND access_jti IS NOT NULL AND access_expires_at > now()
+						ON CONFLICT (jti) DO NOTHING`).
+		Bind(dbx.Params{"userid": userId}).Execute()
+	if err != nil {
+		return fmt.Errorf("insert script 'revoked_tokens' complete with error: %s", err)
+	}
+	return nil
+}